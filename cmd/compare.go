@@ -0,0 +1,61 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/CondeNast/resplit-tar/internal/tarsplit"
+	"github.com/spf13/cobra"
+)
+
+var compareCmd = &cobra.Command{
+	Use:   "compare a b",
+	Short: "Compare the members of two tars",
+	Long: `Compare the members of two tars, like an original and one merged back
+together from its shards, by name, size and a SHA-256 digest of their data.
+
+Each member only b has is printed with a leading +, each only a has with a
+leading -, and each both have with different content with a leading ~. Members
+are matched by name as stored, so ./a and a are different members. The command
+fails when the tars differ.
+`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		comparison, err := tarsplit.Compare(args[0], args[1], opts)
+		if err != nil {
+			return err
+		}
+		for _, member := range comparison.Added {
+			fmt.Printf("+ %v\t%s\n", member.Size, member.Name)
+		}
+		for _, member := range comparison.Removed {
+			fmt.Printf("- %v\t%s\n", member.Size, member.Name)
+		}
+		for _, member := range comparison.Changed {
+			if member.SizeA != member.SizeB {
+				fmt.Printf("~ %v -> %v\t%s\n", member.SizeA, member.SizeB, member.Name)
+			} else {
+				fmt.Printf("~ %v\t%s\t%s -> %s\n", member.SizeA, member.Name, member.DigestA, member.DigestB)
+			}
+		}
+		if !comparison.Same() {
+			return fmt.Errorf("%s and %s differ in %v members", args[0], args[1], len(comparison.Added)+len(comparison.Removed)+len(comparison.Changed))
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(compareCmd)
+}
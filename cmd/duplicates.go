@@ -0,0 +1,59 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/CondeNast/resplit-tar/internal/tarsplit"
+	"github.com/spf13/cobra"
+)
+
+var duplicatesCmd = &cobra.Command{
+	Use:     "duplicates tar...",
+	Aliases: []string{"report-duplicates"},
+	Short:   "Find members of tar files with identical content",
+	Long: `Find the regular members of tar files that hold identical content, going by
+the SHA-256 digest of their data, to see what deduplicating them would save.
+
+Each group is printed as a line with its digest, the size of each member and
+the bytes wasted on the extra copies, followed by its members indented, the
+most wasteful group first. Only members the same size as another are read to
+compute a digest, but finding those still means reading the whole tar.
+`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		filenames, err := expandGlobs(args)
+		if err != nil {
+			return err
+		}
+		groups, err := tarsplit.Duplicates(filenames, opts)
+		if err != nil {
+			return err
+		}
+		var wasted int64
+		for _, group := range groups {
+			fmt.Printf("%s\t%v\t%v wasted\n", group.Digest, group.Size, group.Wasted())
+			for _, member := range group.Members {
+				fmt.Printf("\t%s\n", member)
+			}
+			wasted += group.Wasted()
+		}
+		fmt.Printf("%v groups of duplicates wasting %v bytes\n", len(groups), wasted)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(duplicatesCmd)
+}
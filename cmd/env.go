@@ -0,0 +1,63 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"os"
+	"strings"
+)
+
+// envPrefix starts the environment variables flags can be set with
+const envPrefix = "TARLAYER_"
+
+// exclusiveFlags are flags only one of which may be used. One given on the
+// command line keeps the others from being set from the environment
+var exclusiveFlags = []string{"targetsize", "targets", "num-shards"}
+
+// envName is the environment variable that sets the flag called name, like
+// TARLAYER_TMP_DIR for --tmp-dir
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyEnv sets every flag of cmd not given on the command line from its
+// environment variable, when that is set. Flags given win over the
+// environment, which wins over the defaults
+func applyEnv(cmd *cobra.Command) error {
+	flags := cmd.Flags()
+	skip := map[string]bool{"help": true}
+	for _, name := range exclusiveFlags {
+		if flags.Changed(name) {
+			for _, other := range exclusiveFlags {
+				skip[other] = true
+			}
+		}
+	}
+	var err error
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if err != nil || flag.Changed || skip[flag.Name] {
+			return
+		}
+		value, ok := os.LookupEnv(envName(flag.Name))
+		if !ok {
+			return
+		}
+		if setErr := flags.Set(flag.Name, value); setErr != nil {
+			err = fmt.Errorf("Could not set --%s from %s=%q, got error %w", flag.Name, envName(flag.Name), value, setErr)
+		}
+	})
+	return err
+}
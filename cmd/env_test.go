@@ -0,0 +1,76 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"strings"
+	"testing"
+)
+
+func TestApplyEnv(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		args []string
+		env  map[string]string
+		want map[string]string
+		err  string
+	}{
+		{"defaults", nil, nil, map[string]string{"targetsize": "", "tmp-dir": "/tmp", "quiet": "false", "num-shards": "0"}, ""},
+		{"from the environment", nil, map[string]string{"TARLAYER_TMP_DIR": "/scratch", "TARLAYER_QUIET": "true", "TARLAYER_TARGETSIZE": "64MB"},
+			map[string]string{"tmp-dir": "/scratch", "quiet": "true", "targetsize": "64MB"}, ""},
+		{"flag wins", []string{"--tmp-dir", "/given"}, map[string]string{"TARLAYER_TMP_DIR": "/scratch"}, map[string]string{"tmp-dir": "/given"}, ""},
+		{"one sizing flag given", []string{"-n", "4"}, map[string]string{"TARLAYER_TARGETSIZE": "64MB", "TARLAYER_TMP_DIR": "/scratch"},
+			map[string]string{"num-shards": "4", "targetsize": "", "tmp-dir": "/scratch"}, ""},
+		{"sizing flags all from the environment", nil, map[string]string{"TARLAYER_NUM_SHARDS": "3", "TARLAYER_TARGETSIZE": "64MB"},
+			map[string]string{"num-shards": "3", "targetsize": "64MB"}, ""},
+		{"bad value", nil, map[string]string{"TARLAYER_NUM_SHARDS": "many"}, nil, "TARLAYER_NUM_SHARDS"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cmd := &cobra.Command{Use: "test", RunE: func(*cobra.Command, []string) error { return nil }}
+			cmd.Flags().String("targetsize", "", "")
+			cmd.Flags().IntP("num-shards", "n", 0, "")
+			cmd.Flags().String("tmp-dir", "/tmp", "")
+			cmd.Flags().Bool("quiet", false, "")
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			t.Setenv("TARLAYER_HELP", "true")
+			if err := cmd.ParseFlags(test.args); err != nil {
+				t.Fatal(err)
+			}
+			err := applyEnv(cmd)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("Expected an error naming %s, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range test.want {
+				if got := cmd.Flags().Lookup(name).Value.String(); got != want {
+					t.Errorf("Expected --%s %q, got %q", name, want, got)
+				}
+			}
+			if help, _ := cmd.Flags().GetBool("help"); help {
+				t.Errorf("Expected --help never taken from the environment")
+			}
+		})
+	}
+
+	if name := envName("exclude-from0"); name != "TARLAYER_EXCLUDE_FROM0" {
+		t.Errorf("Expected TARLAYER_EXCLUDE_FROM0, got %s", name)
+	}
+}
@@ -0,0 +1,76 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/CondeNast/resplit-tar/internal/tarsplit"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+)
+
+var estimateCmd = &cobra.Command{
+	Use:   "estimate tar...",
+	Short: "Show how many shards each of several target sizes would need",
+	Long: `Show how many shards each of several target sizes would need, to help pick
+one. The targets are the --targets list, like --targets 2GB,4GB,6GB,8GB, taken
+here as alternatives rather than a target for each shard in turn.
+
+The tars are scanned once and each target planned from that, nothing is
+written. Options that shape planning, like --pack-order or --pin, apply as they
+would to a split.
+`,
+	Args: cobra.MinimumNArgs(1),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := parsePacking(cmd); err != nil {
+			return err
+		}
+		return parsePatterns()
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(targets) == 0 {
+			return fmt.Errorf("Give the target sizes to estimate with --targets")
+		}
+		sizes := make([]int64, len(targets))
+		for i, target := range targets {
+			size, err := parseSize(target)
+			if err != nil {
+				return err
+			}
+			if err := tarsplit.ValidateTargetSize(size); err != nil {
+				return err
+			}
+			sizes[i] = size
+		}
+		cmd.SilenceUsage = true
+		filenames, err := expandGlobs(args)
+		if err != nil {
+			return err
+		}
+		estimates, err := tarsplit.EstimateTargets(filenames, sizes, opts)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "target\tshards\toversize\taverage fill\t")
+		for _, estimate := range estimates {
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%.1f%%\t\n", estimate.Target, estimate.Shards, estimate.Oversize, estimate.AverageFill*100)
+		}
+		return tw.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(estimateCmd)
+}
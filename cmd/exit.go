@@ -0,0 +1,79 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"github.com/CondeNast/resplit-tar/internal/tarsplit"
+	"github.com/spf13/cobra"
+	"io/fs"
+	"net"
+	"os"
+	"syscall"
+)
+
+// The codes the process exits with, see the exit codes in the root command's
+// help
+const (
+	exitOK = 0
+	// exitFailure is any failure not classified as one of the others
+	exitFailure  = 1
+	exitBadInput = 2
+	exitIO       = 3
+)
+
+// badInput are the errors caused by the sources or the options rather than
+// by anything failing while they were read or written
+var badInput = []error{
+	tarsplit.ErrInvalidTarget,
+	tarsplit.ErrOversizeFile,
+	tarsplit.ErrUnknownMember,
+	tarsplit.ErrCaseCollision,
+	tarsplit.ErrUnsafeName,
+	tarsplit.ErrXattrsLost,
+	tarsplit.ErrMembersChanged,
+	tarsplit.ErrDuplicateMember,
+	tarsplit.ErrSymlinkLoop,
+}
+
+// exitCode classifies err, returned running cmd, as an exit code. An error
+// from before the command started running, with its usage still to be shown,
+// means the command line was wrong. Otherwise an error from the filesystem,
+// the network or the OS is an I/O failure, even partway through a source, and
+// any other error reading a source means it is not a valid tar
+func exitCode(cmd *cobra.Command, err error) int {
+	if err == nil {
+		return exitOK
+	}
+	if cmd != nil && !cmd.SilenceUsage {
+		return exitBadInput
+	}
+	for _, target := range badInput {
+		if errors.Is(err, target) {
+			return exitBadInput
+		}
+	}
+	var pathErr *fs.PathError
+	var linkErr *os.LinkError
+	var syscallErr *os.SyscallError
+	var errno syscall.Errno
+	var netErr net.Error
+	if errors.As(err, &pathErr) || errors.As(err, &linkErr) || errors.As(err, &syscallErr) || errors.As(err, &errno) || errors.As(err, &netErr) {
+		return exitIO
+	}
+	var sourceErr *tarsplit.SourceError
+	if errors.As(err, &sourceErr) {
+		return exitBadInput
+	}
+	return exitFailure
+}
@@ -0,0 +1,54 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/CondeNast/resplit-tar/internal/tarsplit"
+	"github.com/spf13/cobra"
+	"io/fs"
+	"syscall"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"success", nil, exitOK},
+		{"duplicate member", &tarsplit.DuplicateMemberError{Name: "a", Source: "in.tar", Other: "in.tar", First: 0, Second: 3}, exitBadInput},
+		{"duplicate across sources", fmt.Errorf("Could not split, got error %w", &tarsplit.DuplicateMemberError{Name: "a", Source: "a.tar", Other: "b.tar"}), exitBadInput},
+		{"unsafe name", &tarsplit.UnsafeNameError{Name: "../a", Source: "0-in.tar"}, exitBadInput},
+		{"invalid tar", &tarsplit.SourceError{Source: "in.tar", Err: errors.New("archive/tar: invalid tar header")}, exitBadInput},
+		{"missing file", &fs.PathError{Op: "open", Path: "in.tar", Err: fs.ErrNotExist}, exitIO},
+		{"full disk", &tarsplit.SourceError{Source: "in.tar", Err: syscall.ENOSPC}, exitIO},
+		{"target too small", tarsplit.ValidateTargetSize(0), exitBadInput},
+		{"case collision", &tarsplit.CaseCollisionError{Collisions: []tarsplit.CaseCollision{{Name: "A", Other: "a"}}}, exitBadInput},
+		{"xattrs lost", &tarsplit.XattrError{Name: "a", Xattrs: []string{"system.posix_acl_access"}, Format: "USTAR"}, exitBadInput},
+		{"symlink loop", &tarsplit.SymlinkLoopError{Link: "dir/sub/up", Target: "dir"}, exitBadInput},
+		{"interrupted", tarsplit.ErrInterrupted, exitFailure},
+	}
+	running := &cobra.Command{}
+	running.SilenceUsage = true
+	for _, test := range tests {
+		if code := exitCode(running, test.err); code != test.code {
+			t.Errorf("Expected %s to exit %v, got %v", test.name, test.code, code)
+		}
+	}
+	if code := exitCode(&cobra.Command{}, errors.New("unknown flag")); code != exitBadInput {
+		t.Errorf("Expected a wrong command line to exit %v, got %v", exitBadInput, code)
+	}
+}
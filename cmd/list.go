@@ -0,0 +1,92 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/CondeNast/resplit-tar/internal/tarsplit"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+var histogram bool
+var tree bool
+var listCmd = &cobra.Command{
+	Use:   "list tar...",
+	Short: "List the members of tar files and their sizes",
+	Long: `List the members a split would plan, one per line with its size in bytes.
+
+With --histogram the sizes are summarised in power of two ranges instead, to
+help choose a target size.
+
+With --tree the members are shown as an indented directory tree, each
+directory with the total size of everything under it.
+`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if histogram && tree {
+			return fmt.Errorf("Only one of --histogram and --tree can be used")
+		}
+		cmd.SilenceUsage = true
+		filenames, err := expandGlobs(args)
+		if err != nil {
+			return err
+		}
+		data, err := tarsplit.List(filenames, opts)
+		if err != nil {
+			return err
+		}
+		if histogram {
+			return printHistogram(os.Stdout, tarsplit.Histogram(data))
+		}
+		if tree {
+			printTree(os.Stdout, tarsplit.Tree(data), 0)
+			return nil
+		}
+		for _, member := range data {
+			fmt.Printf("%v\t%s\n", member.Size, member.Name)
+		}
+		return nil
+	},
+}
+
+func init() {
+	listCmd.Flags().BoolVar(&histogram, "histogram", false, "count the members and their bytes in power of two size ranges")
+	listCmd.Flags().BoolVar(&tree, "tree", false, "show the members as a directory tree, with the total size under each directory")
+	rootCmd.AddCommand(listCmd)
+}
+
+// printHistogram writes one line per bucket with its member count, its bytes
+// and the bytes of it and every smaller bucket
+func printHistogram(w io.Writer, buckets []tarsplit.Bucket) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "size from\tsize to\tmembers\tbytes\tcumulative bytes\t")
+	var cumulative int64
+	for _, bucket := range buckets {
+		cumulative += bucket.Bytes
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t\n", bucket.Min, bucket.Max, bucket.Count, bucket.Bytes, cumulative)
+	}
+	return tw.Flush()
+}
+
+// printTree writes node and everything under it, a line each with the size
+// then the name indented by its depth
+func printTree(w io.Writer, node *tarsplit.TreeNode, depth int) {
+	fmt.Fprintf(w, "%v\t%s%s\n", node.Size, strings.Repeat("  ", depth), node.Name)
+	for _, child := range node.Children {
+		printTree(w, child, depth+1)
+	}
+}
@@ -0,0 +1,33 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/CondeNast/resplit-tar/internal/tarsplit"
+	"strings"
+	"testing"
+)
+
+func TestPrintTree(t *testing.T) {
+	tree := tarsplit.Tree(tarsplit.NameAndSizes{
+		{Name: "usr/bin/sh", Size: 100},
+		{Name: "usr/lib/libc.so", Size: 2000},
+		{Name: "hosts", Size: 20},
+	})
+	var out strings.Builder
+	printTree(&out, tree, 0)
+	want := "2120\t./\n20\t  hosts\n2100\t  usr/\n100\t    bin/\n100\t      sh\n2000\t    lib/\n2000\t      libc.so\n"
+	if out.String() != want {
+		t.Errorf("Expected the tree printed as %q, got %q", want, out.String())
+	}
+}
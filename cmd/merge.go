@@ -0,0 +1,74 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/CondeNast/resplit-tar/internal/tarsplit"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+)
+
+var mergeOutput string
+var mergeCmd = &cobra.Command{
+	Use:   "merge [shard...]",
+	Short: "Merge the shards of a split back into one tar",
+	Long: `Merge the shards of a split back into one tar, shard by shard in index order.
+
+Without --manifest the index is taken from the number each shard's name starts
+with. With --manifest the shards are checked against it as they are merged, and
+when no shards are given every shard it lists is merged.
+
+Files cut into parts by --split-large-files are joined back together at the
+end of the merged tar, so every shard holding one of their parts is needed.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 && opts.Manifest == "" {
+			return fmt.Errorf("Give the shards to merge, or --manifest to merge every shard it lists")
+		}
+		cmd.SilenceUsage = true
+		filenames, err := expandGlobs(args)
+		if err != nil {
+			return err
+		}
+		var manifest *tarsplit.Manifest
+		if opts.Manifest != "" {
+			if manifest, err = tarsplit.ReadManifest(opts.Manifest); err != nil {
+				return err
+			}
+		}
+
+		var w io.Writer = os.Stdout
+		if mergeOutput != tarsplit.Stdout {
+			file, err := os.Create(mergeOutput)
+			if err != nil {
+				return fmt.Errorf("Could not create %s, got error %w", mergeOutput, err)
+			}
+			defer file.Close()
+			w = file
+		}
+		if err := tarsplit.Merge(filenames, manifest, w); err != nil {
+			return err
+		}
+		if file, ok := w.(*os.File); ok && file != os.Stdout {
+			return file.Close()
+		}
+		return nil
+	},
+}
+
+func init() {
+	mergeCmd.Flags().StringVarP(&mergeOutput, "output", "o", "-", "path to write the merged tar to, - for stdout")
+	rootCmd.AddCommand(mergeCmd)
+}
@@ -0,0 +1,61 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/CondeNast/resplit-tar/internal/tarsplit"
+	"github.com/spf13/cobra"
+)
+
+var peekHead int
+var peekTail int
+var peekCmd = &cobra.Command{
+	Use:   "peek tar",
+	Short: "Show the first and last members of a tar",
+	Long: `Show the first and last members of a tar, one per line with its size in
+bytes like list, with a ... line between them when some were passed over.
+
+The first members only need the start of the tar read, so --tail 0 is quick on
+any tar. Finding the last members means reading through the whole tar. For a
+plain tar that is only its headers, as member data is seeked past, but a gzipped
+tar has to be decompressed in full.
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		result, err := tarsplit.Peek(args[0], peekHead, peekTail, opts)
+		if err != nil {
+			return err
+		}
+		for _, member := range result.First {
+			fmt.Printf("%v\t%s\n", member.Size, member.Name)
+		}
+		if result.Total < 0 || result.Total > len(result.First)+len(result.Last) {
+			fmt.Println("...")
+		}
+		for _, member := range result.Last {
+			fmt.Printf("%v\t%s\n", member.Size, member.Name)
+		}
+		if result.Total >= 0 {
+			fmt.Printf("%v members\n", result.Total)
+		}
+		return nil
+	},
+}
+
+func init() {
+	peekCmd.Flags().IntVar(&peekHead, "head", 10, "how many of the first members to show")
+	peekCmd.Flags().IntVar(&peekTail, "tail", 10, "how many of the last members to show, 0 to read no further than the first")
+	rootCmd.AddCommand(peekCmd)
+}
@@ -0,0 +1,51 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPeekCommand(t *testing.T) {
+	var data bytes.Buffer
+	tw := tar.NewWriter(&data)
+	for i := 0; i < 6; i++ {
+		body := bytes.Repeat([]byte{'x'}, 100*i)
+		tw.WriteHeader(&tar.Header{Name: fmt.Sprintf("f%v", i), Typeflag: tar.TypeReg, Size: int64(len(body)), Mode: 0644})
+		tw.Write(body)
+	}
+	tw.Close()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "in.tar"), data.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { peekHead, peekTail = 10, 10 }()
+	for _, test := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--head", "2", "--tail", "0"}, "0\tf0\n100\tf1\n...\n"},
+		{[]string{"--head", "2", "--tail", "1"}, "0\tf0\n100\tf1\n...\n500\tf5\n6 members\n"},
+		{[]string{"--head", "3", "--tail", "3"}, "0\tf0\n100\tf1\n200\tf2\n300\tf3\n400\tf4\n500\tf5\n6 members\n"},
+	} {
+		stdout, _ := captureOutput(t, dir, append(append([]string{"peek"}, test.args...), "in.tar")...)
+		if stdout != test.want {
+			t.Errorf("Expected peek %v to show %q, got %q", test.args, test.want, stdout)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/CondeNast/resplit-tar/internal/tarsplit"
+	"io"
+	"strings"
+	"time"
+)
+
+// printInterval is how often progress is rendered at most
+const printInterval = 500 * time.Millisecond
+
+// smoothing is the weight the latest throughput sample gets in the average
+const smoothing = 0.3
+
+// eta estimates the time left from a moving average of the throughput
+type eta struct {
+	rate     float64 // bytes per second
+	lastDone int64
+	lastTime time.Duration
+	sampled  bool
+}
+
+// update takes done bytes out of total copied after elapsed, returning the
+// estimated time left, or -1 while there is no estimate yet
+func (e *eta) update(done, total int64, elapsed time.Duration) time.Duration {
+	interval := elapsed - e.lastTime
+	if interval > 0 {
+		sample := float64(done-e.lastDone) / interval.Seconds()
+		if e.sampled {
+			e.rate = smoothing*sample + (1-smoothing)*e.rate
+		} else {
+			e.rate = sample
+			e.sampled = true
+		}
+		e.lastDone, e.lastTime = done, elapsed
+	}
+	if e.rate <= 0 {
+		return -1
+	}
+	return time.Duration(float64(total-done) / e.rate * float64(time.Second))
+}
+
+// progressPrinter renders progress with an ETA and the member being copied on
+// a single line of w
+type progressPrinter struct {
+	w       io.Writer
+	start   time.Time
+	printed time.Time
+	eta     eta
+	// width is how long the last line printed was, to blank out what's left
+	// of it when the next is shorter
+	width int
+}
+
+func newProgressPrinter(w io.Writer) *progressPrinter {
+	return &progressPrinter{w: w, start: time.Now()}
+}
+
+func (p *progressPrinter) print(progress tarsplit.Progress) {
+	now := time.Now()
+	finished := progress.Done >= progress.Total
+	if now.Sub(p.printed) < printInterval && !finished {
+		return
+	}
+	p.printed = now
+	left := p.eta.update(progress.Done, progress.Total, now.Sub(p.start))
+
+	percent := 100.0
+	if progress.Total > 0 {
+		percent = float64(progress.Done) / float64(progress.Total) * 100
+	}
+	estimate := "ETA unknown"
+	if left >= 0 {
+		estimate = "ETA " + left.Round(time.Second).String()
+	}
+	line := fmt.Sprintf("%5.1f%% of %v bytes, %s", percent, progress.Total, estimate)
+	if progress.Member != "" && !finished {
+		line += ", copying " + progress.Member
+	}
+	width := len(line)
+	if len(line) < p.width {
+		line += strings.Repeat(" ", p.width-len(line))
+	}
+	p.width = width
+	fmt.Fprint(p.w, "\r"+line)
+	if finished {
+		fmt.Fprintln(p.w)
+	}
+}
@@ -0,0 +1,60 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"github.com/CondeNast/resplit-tar/internal/tarsplit"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestETA(t *testing.T) {
+	var e eta
+	if left := e.update(0, 1000, 0); left != -1 {
+		t.Errorf("Expected no estimate before any time has passed, got %v", left)
+	}
+	previous := time.Duration(-1)
+	for second := 1; second <= 9; second++ {
+		//Steady at 100 bytes a second
+		left := e.update(int64(second)*100, 1000, time.Duration(second)*time.Second)
+		if previous >= 0 && left >= previous {
+			t.Errorf("Expected the estimate to fall after %vs, got %v then %v", second, previous, left)
+		}
+		previous = left
+	}
+	if previous != time.Second {
+		t.Errorf("Expected 1s left at a steady rate, got %v", previous)
+	}
+	if left := e.update(1000, 1000, 10*time.Second); left != 0 {
+		t.Errorf("Expected nothing left once done, got %v", left)
+	}
+}
+
+func TestProgressPrinter(t *testing.T) {
+	var out bytes.Buffer
+	p := newProgressPrinter(&out)
+	p.print(tarsplit.Progress{Done: 0, Total: 100, Member: "a"})
+	p.print(tarsplit.Progress{Done: 100, Total: 100, Member: "b"})
+	lines := strings.Split(strings.TrimPrefix(out.String(), "\r"), "\r")
+	if len(lines) != 2 {
+		t.Fatalf("Expected two lines drawn over each other, got %q", out.String())
+	}
+	if !strings.Contains(lines[0], "copying a") {
+		t.Errorf("Expected the member being copied shown, got %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "100.0% of 100 bytes") || strings.Contains(lines[1], "copying") || !strings.HasSuffix(lines[1], "\n") {
+		t.Errorf("Expected a finished line ending the output, got %q", lines[1])
+	}
+}
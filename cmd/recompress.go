@@ -0,0 +1,64 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"compress/gzip"
+	"fmt"
+	"github.com/CondeNast/resplit-tar/internal/tarsplit"
+	"github.com/spf13/cobra"
+	"log"
+)
+
+var codec string
+var level int
+var recompressCmd = &cobra.Command{
+	Use:   "recompress",
+	Short: "Rewrite the shards of a split with another compression",
+	Long: `Rewrite every shard the manifest given with --manifest lists with another
+compression, without splitting again. The tar inside each shard is kept byte
+for byte.
+
+Gzipped shards get .gz added to their name and uncompressed ones lose it. The
+manifest is updated with the new names, sizes and digests and written back in
+the format it is in, and with --sha256sums a SHA256SUMS covering the new shards
+is written too.
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if opts.Manifest == "" {
+			return fmt.Errorf("Give the manifest of the split to recompress with --manifest")
+		}
+		var c tarsplit.Codec
+		switch codec {
+		case "gzip":
+			c = tarsplit.CodecGzip
+		case "none":
+			c = tarsplit.CodecNone
+		default:
+			return fmt.Errorf("Unknown codec %q, expected gzip or none", codec)
+		}
+		cmd.SilenceUsage = true
+		shards, err := tarsplit.Recompress(c, level, opts)
+		for _, shard := range shards {
+			log.Printf("shard %v is now %s, %v bytes", shard.Index, shard.File, shard.Size)
+		}
+		return err
+	},
+}
+
+func init() {
+	recompressCmd.Flags().StringVar(&codec, "codec", "gzip", "compression to rewrite the shards with, gzip or none")
+	recompressCmd.Flags().IntVar(&level, "level", gzip.DefaultCompression, "gzip compression level, from 1 for the fastest to 9 for the smallest")
+	rootCmd.AddCommand(recompressCmd)
+}
@@ -14,237 +14,605 @@ package cmd
 
 import (
 	"archive/tar"
-	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
+	"github.com/CondeNast/resplit-tar/internal/tarsplit"
 	"github.com/spf13/cobra"
 	"io"
 	"log"
+	"math"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 var filename string
-var targetSize int64
+var tarFormat string
+var order string
+var naming string
+var layout string
+var manifestFormat string
+var strategy string
+var caseCheck string
+var packOrder string
+var mtime string
+var chown string
+var uidMaps []string
+var gidMaps []string
+var targets []string
+var includeFrom string
+var includeFrom0 string
+var metricsFile string
+var excludeFrom string
+var excludeFrom0 string
+var minFreeSpace string
+var memoryLimit string
+var showProgress bool
+var keepEmptyDirs bool
+var quiet bool
+var opts tarsplit.Options
 var rootCmd = &cobra.Command{
 	Use:   "tarlayer-split",
 	Short: "Split tar file into smaller files for docker larger docker files",
 	Long: `Use this application to split a large tar file into multiple files
 less than or equal to the target size provided. Default size 5GB
+
+Messages go to stderr, stdout only carries the manifest when it is written to -.
+
+Several tars, or quoted glob patterns, can be given to split their members
+together as if they were one tar. The shards are named after the first.
+
+Pass - to read the tar from stdin. Stdin, or any other source that is not a
+regular file, is first buffered whole into --tmp-dir, which needs room for it.
+An http:// or https:// URL is downloaded into --tmp-dir the same way.
+
+With --from-dir the arguments are directories, tarred up into --tmp-dir first.
+
+Any flag can also be set with an environment variable named after it, TARLAYER_
+then the flag name in capitals with dashes as underscores, like
+TARLAYER_TARGETSIZE or TARLAYER_TMP_DIR. A flag given on the command line wins
+over its variable, which wins over the default.
+
+Exit codes:
+  0  success
+  1  any other failure, including an interrupted split
+  2  bad input, a wrong command line or flag, a source that isn't a valid tar,
+     or sources the options can't split, like a member too big with
+     --strict-size, names differing only in case with
+     --case-insensitive-check=error, a file repeated in the sources or a
+     symlink loop under --from-dir
+  3  an I/O failure reading a source or writing the shards, like a missing
+     file, a full disk or a failed download
 `,
-	Args: cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		split(args[0])
+	Args: cobra.MinimumNArgs(1),
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return applyEnv(cmd)
+	},
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		sizing := 0
+		for _, flag := range []string{"targetsize", "targets", "num-shards"} {
+			if cmd.Flags().Changed(flag) {
+				sizing++
+			}
+		}
+		if sizing > 1 {
+			return fmt.Errorf("Only one of --targetsize, --targets and --num-shards can be used")
+		}
+		opts.Targets = nil
+		for _, target := range targets {
+			size, err := parseSize(target)
+			if err != nil {
+				return err
+			}
+			opts.Targets = append(opts.Targets, size)
+		}
+		if opts.NumShards == 0 {
+			if err := tarsplit.ValidateTargetSize(opts.TargetSize); err != nil {
+				return err
+			}
+		}
+		format, err := parseTarFormat(tarFormat)
+		if err != nil {
+			return err
+		}
+		opts.Format = format
+		opts.DropEmptyDirs = !keepEmptyDirs
+		switch order {
+		case "source":
+			opts.Order = tarsplit.OrderSource
+		case "name":
+			opts.Order = tarsplit.OrderName
+		default:
+			return fmt.Errorf("Unknown sort %q, expected source or name", order)
+		}
+		switch naming {
+		case "index":
+			opts.Naming = tarsplit.NameIndex
+		case "digest":
+			opts.Naming = tarsplit.NameDigest
+		default:
+			return fmt.Errorf("Unknown naming %q, expected index or digest", naming)
+		}
+		if opts.ModTime, err = parseTime(mtime); err != nil {
+			return err
+		}
+		if opts.Chown, err = parseOwnership(chown); err != nil {
+			return err
+		}
+		if opts.UidMap, err = parseIDMaps(uidMaps); err != nil {
+			return err
+		}
+		if opts.GidMap, err = parseIDMaps(gidMaps); err != nil {
+			return err
+		}
+		switch strategy {
+		case "auto":
+			opts.Strategy = tarsplit.StrategyAuto
+		case "single-pass":
+			opts.Strategy = tarsplit.StrategySinglePass
+		case "two-pass":
+			opts.Strategy = tarsplit.StrategyTwoPass
+		default:
+			return fmt.Errorf("Unknown strategy %q, expected auto, single-pass or two-pass", strategy)
+		}
+		if err := parsePacking(cmd); err != nil {
+			return err
+		}
+		switch caseCheck {
+		case "off":
+			opts.CaseCheck = tarsplit.CaseCheckOff
+		case "warn":
+			opts.CaseCheck = tarsplit.CaseCheckWarn
+		case "error":
+			opts.CaseCheck = tarsplit.CaseCheckError
+		default:
+			return fmt.Errorf("Unknown case check %q, expected off, warn or error", caseCheck)
+		}
+		if err := parsePatterns(); err != nil {
+			return err
+		}
+		if cmd.Flags().Changed("min-free-space") {
+			opts.CheckFreeSpace = true
+			if opts.MinFreeSpace, err = parseSize(minFreeSpace); err != nil {
+				return err
+			}
+		}
+		if memoryLimit != "" {
+			if opts.MemoryLimit, err = parseSize(memoryLimit); err != nil {
+				return err
+			}
+		}
+		switch layout {
+		case "flat":
+			opts.Layout = tarsplit.LayoutFlat
+		case "subdir":
+			opts.Layout = tarsplit.LayoutSubdir
+		case "container":
+			opts.Layout = tarsplit.LayoutContainer
+		default:
+			return fmt.Errorf("Unknown layout %q, expected flat, subdir or container", layout)
+		}
+		switch manifestFormat {
+		case "json":
+			opts.ManifestFormat = tarsplit.ManifestJSON
+		case "csv":
+			opts.ManifestFormat = tarsplit.ManifestCSV
+		case "yaml":
+			opts.ManifestFormat = tarsplit.ManifestYAML
+		default:
+			return fmt.Errorf("Unknown manifest format %q, expected json, csv or yaml", manifestFormat)
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		//Past argument checking, so a failure is not a usage problem
+		cmd.SilenceUsage = true
+		if quiet {
+			log.SetOutput(io.Discard)
+		}
+		if showProgress && !quiet {
+			opts.Progress = newProgressPrinter(os.Stderr).print
+		}
+		filenames, err := expandGlobs(args)
+		if err != nil {
+			return err
+		}
+		ctx, stop := interruptContext()
+		defer stop()
+		opts.Context = ctx
+		result, err := tarsplit.SplitAll(filenames, opts)
+		if errors.Is(err, tarsplit.ErrInterrupted) && result != nil {
+			log.Printf("interrupted after completing %v shards, unfinished shards were removed", len(result.Shards))
+			if opts.Manifest != "" && opts.Layout != tarsplit.LayoutContainer {
+				log.Printf("pass --resume %s to finish the split", opts.Manifest)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if result.Partial {
+			log.Printf("partial split, only the first %v members of the sources are in the shards", opts.Limit)
+		}
+		if result.Excluded > 0 {
+			log.Printf("left out %v members not matching the include and exclude patterns", result.Excluded)
+		}
+		if len(result.Census) > 0 {
+			log.Println(result.Census)
+		}
+		for _, clamped := range result.ClampedTimes {
+			log.Printf("clamped the time of %s from %v to %v", clamped.Name, clamped.From, clamped.To)
+		}
+		for _, collision := range result.CaseCollisions {
+			log.Printf("%s and %s differ only in case and collide on a case-insensitive filesystem", collision.Name, collision.Other)
+		}
+		if len(result.Skipped) > 0 {
+			log.Println(result.Skipped)
+		}
+		if warning := result.OversizeWarning(opts); warning != "" {
+			log.Println(warning)
+		}
+		if opts.NumShards == 0 {
+			printFill(result)
+		}
+		log.Printf("wrote %v bytes in %v shards in %v, %.1f MB/s", result.Bytes(), len(result.Shards), result.Elapsed.Round(time.Millisecond), result.Throughput()/(1<<20))
+		if metricsFile != "" {
+			if err := tarsplit.WriteMetrics(metricsFile, result); err != nil {
+				return err
+			}
+		}
+		for _, failed := range result.Failed {
+			log.Println(failed)
+		}
+		if len(result.Failed) > 0 {
+			return fmt.Errorf("%v members could not be copied and were left out", len(result.Failed))
+		}
+		return nil
 	},
+	SilenceErrors: true,
 }
 
 func init() {
-	rootCmd.PersistentFlags().Int64VarP(&targetSize, "targetsize", "s", 5368709120, "target tar size in bytes")
+	rootCmd.Flags().Int64VarP(&opts.TargetSize, "targetsize", "s", 5368709120, "target tar size in bytes")
+	rootCmd.Flags().StringSliceVar(&targets, "targets", nil, "target size for each shard in turn, like 2GB,5GB, the last repeating for any more shards")
+	rootCmd.Flags().Float64Var(&opts.CompressionRatio, "compression-ratio", 0, "estimated compressed to uncompressed size ratio, e.g. 0.4, so the target size applies to the shards once compressed")
+	rootCmd.Flags().Int64Var(&opts.OverheadBytes, "overhead-bytes", 0, "bytes to leave free in every shard for anything appended afterwards, taken off the target size")
+	rootCmd.Flags().BoolVar(&opts.StrictSize, "strict-size", false, "fail when a member is too big for the target size, rather than giving it a shard of its own over the target")
+	rootCmd.Flags().Int64Var(&opts.MinShardSize, "min-shard-size", 0, "merge shards smaller than this many bytes into a neighbour, even past the target size")
+	rootCmd.Flags().Float64Var(&opts.OverfillTolerance, "overfill-tolerance", 0.1, "how far past the target size merging a small shard may go, as a fraction of it")
+	rootCmd.Flags().IntVarP(&opts.NumShards, "num-shards", "n", 0, "split into this many roughly equal tars instead of by target size")
+	rootCmd.Flags().StringArrayVar(&opts.Pin, "pin", nil, "plan members matching this glob, or under a directory matching it, into the first shard whatever their size, repeatable")
+	rootCmd.Flags().BoolVar(&opts.WhiteoutAware, "whiteout-aware", false, "plan each overlayfs whiteout .wh.<name> into the same shard as <name>, and opaque markers with their directory")
+	rootCmd.Flags().IntVar(&opts.AffinityDepth, "affinity-depth", 0, "keep members sharing this many leading path components in the same shard where they fit, e.g. 1 for each top level directory")
+	rootCmd.Flags().StringVar(&order, "sort", "source", "order of members within each shard, source or name")
+	rootCmd.Flags().StringVar(&packOrder, "pack-order", "size-desc", "order members are packed into shards in, size-desc usually needs the fewest shards, size-asc or name")
+	rootCmd.Flags().BoolVar(&opts.NoSort, "no-sort", false, "pack members in the order the sources hold them rather than sorting them first, quicker for sources already roughly biggest first but may take more shards")
+	rootCmd.Flags().StringVar(&strategy, "strategy", "auto", "how members are copied, single-pass streams each source once with every shard open, two-pass writes one shard at a time reading members by offset, auto picks two-pass for plain tars that can be read at any offset")
+	rootCmd.Flags().IntVar(&opts.CopyBuffer, "copy-buffer", tarsplit.DefaultCopyBuffer, "size in bytes of the buffer member data is copied through, smaller bounds memory on constrained hosts")
+	rootCmd.Flags().IntVar(&opts.RecordSize, "record-size", 0, "pad every shard to a multiple of this many bytes, e.g. 10240 like classic tar, a multiple of 512")
+	rootCmd.Flags().IntVar(&opts.IndexStart, "index-start", 0, "index of the first shard")
+	rootCmd.Flags().IntVar(&opts.IndexWidth, "index-width", 0, "zero pad shard indexes in file names to this many digits, e.g. 4 for 0001-<source>")
+	rootCmd.Flags().StringVar(&naming, "name", "index", "how shards are named, index for 0-<source> or digest for sha256:<hex>.tar")
+	rootCmd.Flags().StringVar(&mtime, "mtime", "", "set every member's modification time to this, as RFC 3339 or seconds since the epoch like SOURCE_DATE_EPOCH (default keeps the originals)")
+	rootCmd.Flags().BoolVar(&opts.ClampMTime, "clamp-mtime", false, "bring member times before 1970 or after 2242, which not every tar format can hold, to the nearest end of that range and log them, rather than failing on a bad header")
+	rootCmd.Flags().StringVar(&chown, "chown", "", "make every member owned by uid:gid, or uid:gid:user:group to set the names too")
+	rootCmd.Flags().StringSliceVar(&uidMaps, "map-uid", nil, "remap member uids old:new, or old:new:count for a range like 0:100000:65536, repeatable")
+	rootCmd.Flags().StringSliceVar(&gidMaps, "map-gid", nil, "remap member gids like --map-uid")
+	rootCmd.Flags().StringVar(&layout, "layout", "flat", "how shards are arranged, flat, subdir for shard-0000/<source> or container for one shards-<source> tar holding them")
+	rootCmd.Flags().StringVar(&tarFormat, "tar-format", "", "force the output format, one of ustar, pax or gnu (default keeps each member's source format)")
+	rootCmd.Flags().BoolVar(&opts.PreserveXattrs, "preserve-acls", false, "fail before writing anything when --tar-format can't hold the xattrs or POSIX ACLs members carry, where otherwise those members fail as they are copied")
+	rootCmd.Flags().StringVar(&caseCheck, "case-insensitive-check", "off", "warn about members whose names differ only in case, which collide when extracted on Windows or macOS, or =error to fail on them")
+	rootCmd.Flags().Lookup("case-insensitive-check").NoOptDefVal = "warn"
+	rootCmd.PersistentFlags().BoolVar(&opts.Concatenated, "no-trailer-check", false, "read on past the end of each tar into any tars concatenated after it, like tar --ignore-zeros")
+	rootCmd.Flags().StringArrayVar(&opts.Include, "include", nil, "only split members matching this glob, or under a directory matching it, repeatable")
+	rootCmd.Flags().StringArrayVar(&opts.Exclude, "exclude", nil, "leave out members matching this glob, or under a directory matching it, repeatable")
+	rootCmd.Flags().StringVar(&includeFrom, "include-from", "", "file of --include globs, one per line, # starts a comment")
+	rootCmd.Flags().StringVar(&excludeFrom, "exclude-from", "", "file of --exclude globs, one per line, # starts a comment")
+	rootCmd.Flags().StringVar(&includeFrom0, "include-from0", "", "file of --include globs separated by NUL bytes, like find -print0 writes, for names holding newlines")
+	rootCmd.Flags().StringVar(&excludeFrom0, "exclude-from0", "", "file of --exclude globs separated by NUL bytes, like --include-from0")
+	rootCmd.Flags().BoolVar(&opts.HardlinkCopies, "follow-hardlinks-as-copies", false, "write each hardlink as a regular file with a copy of the data it links to, for consumers that don't handle hardlinks")
+	rootCmd.Flags().BoolVar(&opts.DedupeAcrossShards, "dedupe-across-shards", false, "write members with the same content as an earlier one in their shard as hardlinks to it, and list those matching one in another shard in the manifest, reading every member's data first")
+	rootCmd.Flags().BoolVar(&opts.SplitLargeFiles, "split-large-files", false, "cut members too big for a shard into parts across shards, which merge joins back together")
+	rootCmd.Flags().BoolVar(&opts.MultiVolume, "multi-volume", false, "write one GNU tar cut into volumes of the target size, members running on from one volume to the next, for tar --multi-volume to read")
+	rootCmd.Flags().IntVar(&opts.Limit, "limit", 0, "only split the first this many members, for a quick trial run (default all)")
+	rootCmd.Flags().BoolVar(&opts.EmbedIndex, "embed-index", false, "start each shard with an INDEX member listing the name and size of every member in it")
+	rootCmd.Flags().StringToStringVar(&opts.Labels, "label", nil, "stamp every shard's global records and the manifest with key=value, like build=1234, repeatable")
+	rootCmd.Flags().BoolVar(&opts.GlobalRecords, "global-records", false, "start each shard with PAX global records of its index, the shard total and the source name")
+	rootCmd.Flags().BoolVar(&opts.SourceHash, "source-hash", false, "record the SHA-256 digest of each source in the manifest and global records, reading the sources whole")
+	rootCmd.Flags().BoolVar(&opts.SkipErrors, "skip-errors", false, "leave out members that fail to copy and carry on, still exiting with an error")
+	rootCmd.Flags().StringVar(&opts.Manifest, "manifest", "", "write a manifest of the shards and their members to this path, - for stdout, or with merge the manifest to check against")
+	rootCmd.Flags().StringVar(&manifestFormat, "manifest-format", "json", "format the manifest and --export-plan are written in, json, yaml, or csv for a row per member with only the shards and members, which is write only")
+	rootCmd.Flags().StringVar(&opts.Checksums, "sha256sums", "", "write a SHA256SUMS file covering every shard to this path, for sha256sum -c")
+	rootCmd.Flags().StringVar(&metricsFile, "metrics-file", "", "write the shards, bytes and duration of the split as Prometheus gauges to this path, e.g. for the node_exporter textfile collector")
+	rootCmd.Flags().StringVar(&opts.ExportPlan, "export-plan", "", "only plan the shards, writing the plan to this path, - for stdout")
+	rootCmd.Flags().StringVar(&opts.ImportPlan, "plan", "", "copy the members by a plan from --export-plan instead of planning them, it must match the sources")
+	rootCmd.Flags().StringVar(&opts.Resume, "resume", "", "manifest or --export-plan plan of an interrupted split, only its shards that aren't complete are written again")
+	rootCmd.Flags().StringVar(&opts.AppendTo, "append-to", "", "manifest of an earlier split, only members it lacks are split into new shards and it is updated")
+	rootCmd.Flags().BoolVar(&opts.FromDir, "from-dir", false, "split the contents of directories instead of tars")
+	rootCmd.Flags().BoolVar(&opts.FollowSymlinks, "follow-symlinks", false, "with --from-dir, store what symlinks point to instead of the links")
+	rootCmd.Flags().BoolVar(&keepEmptyDirs, "keep-empty-dirs", true, "with --from-dir, keep directories with nothing in them, like mount points")
+	rootCmd.Flags().BoolVar(&opts.VerifyMembers, "verify-members-exist", false, "read the sources' headers again before writing, failing and listing any members missing, new or resized since planning")
+	rootCmd.Flags().StringVar(&minFreeSpace, "min-free-space", "", "fail before writing anything unless the shards fit on the filesystem they go to, with this much more to spare, like 1GB")
+	rootCmd.Flags().Lookup("min-free-space").NoOptDefVal = "0"
+	rootCmd.Flags().BoolVar(&opts.InMemory, "in-memory", false, "read each source whole into memory once, serving every pass from there instead of the disk")
+	rootCmd.Flags().StringVar(&memoryLimit, "memory-limit", "", "read sources no bigger than this into memory as --in-memory does, like 256MB")
+	rootCmd.Flags().StringVar(&opts.TmpDir, "tmp-dir", "", "directory to buffer stdin or a pipe in, needs room for the whole source (default "+os.TempDir()+")")
+	rootCmd.Flags().DurationVar(&opts.HTTPTimeout, "timeout", 30*time.Second, "how long to wait to connect and for a response when a source is an http(s) URL, 0 to wait as long as it takes")
+	rootCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "only report errors")
+	rootCmd.Flags().BoolVar(&showProgress, "progress", false, "show progress and the estimated time left on stderr")
+	rootCmd.Flags().IntVar(&opts.Retries, "retries", 3, "number of retries for transient errors creating or flushing a shard")
+	rootCmd.Flags().DurationVar(&opts.RetryBackoff, "retry-backoff", 500*time.Millisecond, "initial wait between retries, doubled on each attempt")
+
+	//The subcommands reading sources take the split's flags for reading them,
+	//and estimate those shaping the plans too. Only --no-trailer-check applies
+	//to every command and is persistent
+	for _, cmd := range []*cobra.Command{listCmd, compareCmd, duplicatesCmd, estimateCmd} {
+		shareFlags(cmd, "tmp-dir", "timeout", "from-dir", "follow-symlinks")
+	}
+	for _, cmd := range []*cobra.Command{listCmd, duplicatesCmd, estimateCmd} {
+		shareFlags(cmd, "limit")
+	}
+	shareFlags(estimateCmd, "targets", "compression-ratio", "overhead-bytes", "min-shard-size", "overfill-tolerance", "pin", "whiteout-aware", "affinity-depth", "pack-order", "no-sort", "include", "exclude", "include-from", "exclude-from", "include-from0", "exclude-from0")
+	shareFlags(mergeCmd, "manifest")
+	shareFlags(recompressCmd, "manifest", "sha256sums")
 }
 
-func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+// shareFlags adds the root command's flags called names to cmd as well
+func shareFlags(cmd *cobra.Command, names ...string) {
+	for _, name := range names {
+		cmd.Flags().AddFlag(rootCmd.Flags().Lookup(name))
 	}
 }
 
-type NameAndSize struct {
-	Name string
-	Size int64
+func Execute() {
+	cmd, err := rootCmd.ExecuteC()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	os.Exit(exitCode(cmd, err))
 }
 
-type NameAndSizes []NameAndSize
-
-type Plan struct {
-	Pool   NameAndSizes
-	Writer *tar.Writer
+// interruptContext is done on the first SIGINT or SIGTERM, so the split can
+// stop cleanly. A second signal is left to end the process as usual
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			signal.Stop(signals)
+			log.Println("interrupted, stopping at the next member or shard, interrupt again to quit now")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
 }
 
-func split(filename string) {
-	data, err := generateSlice(filename)
-	if err != nil {
-		log.Fatal(err)
+// parsePacking sets the order members are packed in from --pack-order, or
+// leaves them unsorted with --no-sort
+func parsePacking(cmd *cobra.Command) error {
+	if opts.NoSort && cmd.Flags().Changed("pack-order") {
+		return fmt.Errorf("Only one of --pack-order and --no-sort can be used")
 	}
-	sort.Sort(sort.Reverse(data))
-	targetSize := int64(8600000000)
-	plans := buildTarPlan(data, targetSize)
-	err = createNewTars(filename, &plans)
-	if err != nil {
-		log.Fatal(err)
+	switch packOrder {
+	case "size-desc":
+		//The default, left nil to plan exactly as without the flag
+		opts.PackLess = nil
+	case "size-asc":
+		opts.PackLess = tarsplit.PackBySizeAsc
+	case "name":
+		opts.PackLess = tarsplit.PackByName
+	default:
+		return fmt.Errorf("Unknown pack order %q, expected size-desc, size-asc or name", packOrder)
 	}
+	return nil
 }
 
-func generateSlice(filename string) (NameAndSizes, error) {
-
-	var tarreader io.Reader
-
-	filereader, err := os.Open(filename)
-	if err != nil {
-		return NameAndSizes{}, err
+// parsePatterns adds the patterns in the --include-from and --exclude-from
+// files, or their NUL separated forms, to the --include and --exclude ones
+func parsePatterns() error {
+	if includeFrom != "" && includeFrom0 != "" || excludeFrom != "" && excludeFrom0 != "" {
+		return fmt.Errorf("Only one of --include-from and --include-from0, or --exclude-from and --exclude-from0, can be used")
 	}
-	defer filereader.Close()
-
-	if filepath.Ext(filename) == ".gz" {
-		tarreader, err := gzip.NewReader(filereader)
-		if err != nil {
-			return NameAndSizes{}, err
-		}
-		defer tarreader.Close()
-	} else {
-		tarreader = filereader
+	var err error
+	if opts.Include, err = readPatterns(includeFrom, false, opts.Include); err != nil {
+		return err
 	}
+	if opts.Include, err = readPatterns(includeFrom0, true, opts.Include); err != nil {
+		return err
+	}
+	if opts.Exclude, err = readPatterns(excludeFrom, false, opts.Exclude); err != nil {
+		return err
+	}
+	opts.Exclude, err = readPatterns(excludeFrom0, true, opts.Exclude)
+	return err
+}
 
-	tr := tar.NewReader(tarreader)
-	info := make(NameAndSizes, 0)
-
-	for {
-		header, err := tr.Next()
-		switch {
-		case err == io.EOF:
-			return info, nil
+// printFill logs how full each shard is relative to the target size
+func printFill(result *tarsplit.Result) {
+	shards := append([]tarsplit.ShardResult(nil), result.Shards...)
+	sort.Slice(shards, func(i, j int) bool {
+		return shards[i].Index < shards[j].Index
+	})
+	for _, shard := range shards {
+		log.Printf("shard %v holds %v bytes of members, %.1f%% of the target", shard.Index, shard.Planned, shard.Fill*100)
+	}
+	if len(shards) > 0 {
+		log.Printf("shards are %.1f%% full on average", result.AverageFill()*100)
+	}
+}
 
-		case err != nil:
-			return NameAndSizes{}, err
+// sizeUnits are the suffixes parseSize understands, in powers of 1024 as the
+// default target size is
+var sizeUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+	{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+	{"B", 1},
+}
 
-		case header == nil:
-			continue
-		}
-		fi := header.FileInfo()
-		info = append(info, NameAndSize{header.Name, fi.Size()})
-	}
-}
-
-func buildTarPlan(data NameAndSizes, targetSize int64) []Plan {
-	//Since I can't think of any other way, going to start with the biggest and once
-	//the next biggest can't fit, going to top it off with the bottom up till we get all
-	plans := make([]Plan, 0)
-
-	var currentPlanTotalSize int64
-	currentPlan := &Plan{}
-	endIndex := len(data) - 1
-	finished := false
-	addToNext := false
-	canAddSmall := true
-
-	for i := 0; i <= endIndex; i++ {
-		if currentPlanTotalSize+data[i].Size <= targetSize {
-			currentPlan.Pool = append(currentPlan.Pool, data[i])
-			currentPlanTotalSize = currentPlanTotalSize + data[i].Size
-		} else {
-			//Time to fill up from reverse
-			for endIndex >= i {
-				if currentPlanTotalSize+data[endIndex].Size < targetSize {
-					currentPlan.Pool = append(currentPlan.Pool, data[endIndex])
-					currentPlanTotalSize += data[endIndex].Size
-					endIndex = endIndex - 1
-				} else {
-					canAddSmall = false
-					break
-				}
-			}
-			addToNext = true
-		}
-		if i == endIndex {
-			finished = true
-		}
-
-		if finished || !canAddSmall {
-			plans = append(plans, *currentPlan)
-			//Need a new plan to add to and reset counters
-			currentPlan = &Plan{}
-			currentPlanTotalSize = 0
-			canAddSmall = true
-			if addToNext {
-				currentPlan.Pool = append(currentPlan.Pool, data[i])
-				currentPlanTotalSize += data[i].Size
-				addToNext = false
-				if finished {
-					plans = append(plans, *currentPlan)
-				}
-			}
-		}
-		if finished {
+// parseSize reads a size in bytes, optionally with a unit like 5GB
+func parseSize(text string) (int64, error) {
+	number := strings.ToUpper(strings.TrimSpace(text))
+	unit := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(number, u.suffix) {
+			number = strings.TrimSpace(strings.TrimSuffix(number, u.suffix))
+			unit = u.size
 			break
 		}
 	}
-	return plans
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size < 0 || size > math.MaxInt64/unit {
+		return 0, fmt.Errorf("Could not read size %q, expected a number of bytes like 5368709120 or 5GB", text)
+	}
+	return size * unit, nil
 }
 
-func createNewTars(filename string, plans *[]Plan) error {
-
-	var genericReader io.Reader
-	//Create a map to define pointer for each name
-	filenamePtrMap := make(map[string]*tar.Writer)
+func parseTarFormat(name string) (tar.Format, error) {
+	switch name {
+	case "":
+		return tar.FormatUnknown, nil
+	case "ustar":
+		return tar.FormatUSTAR, nil
+	case "pax":
+		return tar.FormatPAX, nil
+	case "gnu":
+		return tar.FormatGNU, nil
+	}
+	return tar.FormatUnknown, fmt.Errorf("Unknown tar format %q, expected ustar, pax or gnu", name)
+}
 
-	osReader, err := os.Open(filename)
+// parseTime reads a time as RFC 3339 or seconds since the epoch, the zero time
+// when text is empty
+func parseTime(text string) (time.Time, error) {
+	if text == "" {
+		return time.Time{}, nil
+	}
+	if seconds, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, text)
 	if err != nil {
-		return err
+		return time.Time{}, fmt.Errorf("Could not read time %q, expected RFC 3339 like 2024-01-02T15:04:05Z or seconds since the epoch", text)
 	}
-	defer osReader.Close()
+	return t, nil
+}
 
-	if filepath.Ext(filename) == ".gz" {
-		tarReader, err := gzip.NewReader(osReader)
-		if err != nil {
-			return err
-		}
-		defer tarReader.Close()
-	} else {
-		genericReader = osReader
+// parseOwnership reads uid:gid or uid:gid:user:group, nil when text is empty
+func parseOwnership(text string) (*tarsplit.Ownership, error) {
+	if text == "" {
+		return nil, nil
 	}
-
-	tarReader := tar.NewReader(genericReader)
-
-	fn := filepath.Base(filename)
-
-	for i, plan := range *plans {
-		file, err := os.Create(fmt.Sprintf("%v-%s", i, fn))
-		if err != nil {
-			return fmt.Errorf("Could not create tarball file %v-%s, got error %s", i, fn, err.Error())
-		}
-		defer file.Close()
-		tw := tar.NewWriter(file)
-		defer tw.Close()
-		for _, fn := range plan.Pool {
-			filenamePtrMap[fn.Name] = tw
-		}
+	parts := strings.Split(text, ":")
+	if len(parts) != 2 && len(parts) != 4 {
+		return nil, fmt.Errorf("Could not read owner %q, expected uid:gid or uid:gid:user:group", text)
 	}
+	uid, err := parseID(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	gid, err := parseID(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	owner := &tarsplit.Ownership{Uid: uid, Gid: gid}
+	if len(parts) == 4 {
+		owner.Uname, owner.Gname = parts[2], parts[3]
+	}
+	return owner, nil
+}
 
-	for {
-		header, err := tarReader.Next()
-		switch {
-		case err == io.EOF:
-			return nil
-		case err != nil:
-			return err
-		case header == nil:
-			continue
+// parseIDMaps reads id maps given as old:new or old:new:count
+func parseIDMaps(texts []string) ([]tarsplit.IDMap, error) {
+	var maps []tarsplit.IDMap
+	for _, text := range texts {
+		parts := strings.Split(text, ":")
+		if len(parts) != 2 && len(parts) != 3 {
+			return nil, fmt.Errorf("Could not read id map %q, expected old:new or old:new:count", text)
 		}
-		switch header.Typeflag {
-		case tar.TypeReg:
-			mw := filenamePtrMap[header.Name]
-			if mw != nil {
-				if err := mw.WriteHeader(header); err != nil {
-					return err
-				}
-			} else {
-				return fmt.Errorf("Missing writer ptr for file %s", header.Name)
-			}
-			if _, err := io.Copy(mw, tarReader); err != nil {
-				return err
+		ids := []int{0, 0, 1}
+		for i, part := range parts {
+			id, err := parseID(part)
+			if err != nil {
+				return nil, err
 			}
+			ids[i] = id
 		}
+		maps = append(maps, tarsplit.IDMap{From: ids[0], To: ids[1], Count: ids[2]})
 	}
+	return maps, nil
 }
 
-func (s NameAndSizes) Len() int {
-	return len(s)
+// parseID reads a uid, gid or count
+func parseID(text string) (int, error) {
+	id, err := strconv.Atoi(text)
+	if err != nil || id < 0 {
+		return 0, fmt.Errorf("Could not read id %q, expected a number", text)
+	}
+	return id, nil
 }
 
-func (s NameAndSizes) Less(i, j int) bool {
-	return s[i].Size < s[j].Size
+// readPatterns adds the globs in the file at path, one a line, to patterns.
+// Blank lines and lines starting with # are passed over. With nul set the
+// globs are separated by NUL bytes instead, as find -print0 writes them, and
+// taken exactly as they are, so they can hold newlines or start with #
+func readPatterns(path string, nul bool, patterns []string) ([]string, error) {
+	if path == "" {
+		return patterns, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read patterns from %s, got error %w", path, err)
+	}
+	if nul {
+		for _, entry := range strings.Split(string(data), "\x00") {
+			if entry != "" {
+				patterns = append(patterns, entry)
+			}
+		}
+		return patterns, nil
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, nil
 }
 
-func (s NameAndSizes) Swap(i, j int) {
-	s[i], s[j] = s[j], s[i]
+// expandGlobs expands any argument that is a glob pattern, for shells that
+// don't or when the pattern is quoted
+func expandGlobs(args []string) ([]string, error) {
+	var filenames []string
+	for _, arg := range args {
+		//A URL's query is no pattern
+		if !strings.ContainsAny(arg, "*?[") || strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://") {
+			filenames = append(filenames, arg)
+			continue
+		}
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, fmt.Errorf("Bad pattern %s, got error %w", arg, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("No tars match %s", arg)
+		}
+		filenames = append(filenames, matches...)
+	}
+	return filenames, nil
 }
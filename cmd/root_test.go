@@ -0,0 +1,304 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"github.com/CondeNast/resplit-tar/internal/tarsplit"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSubcommandFlags(t *testing.T) {
+	if flags := rootCmd.PersistentFlags(); flags.Lookup("no-trailer-check") == nil || flags.Lookup("num-shards") != nil {
+		t.Errorf("Expected only the flags every command shares to be persistent")
+	}
+	tests := []struct {
+		cmd     string
+		has     []string
+		hasNone []string
+	}{
+		{"peek", []string{"head", "tail", "no-trailer-check"}, []string{"num-shards", "targetsize", "tmp-dir", "manifest"}},
+		{"list", []string{"histogram", "tmp-dir", "limit", "no-trailer-check"}, []string{"num-shards", "pin", "manifest"}},
+		{"compare", []string{"tmp-dir", "from-dir"}, []string{"limit", "strategy", "manifest"}},
+		{"duplicates", []string{"tmp-dir", "limit"}, []string{"strategy", "manifest"}},
+		{"estimate", []string{"targets", "pin", "pack-order", "include-from"}, []string{"targetsize", "num-shards", "strategy", "manifest"}},
+		{"merge", []string{"output", "manifest"}, []string{"targetsize", "sha256sums"}},
+		{"recompress", []string{"codec", "manifest", "sha256sums"}, []string{"targetsize", "retries"}},
+	}
+	for _, test := range tests {
+		cmd, _, err := rootCmd.Find([]string{test.cmd})
+		if err != nil {
+			t.Fatal(err)
+		}
+		//Parsing merges the persistent flags in
+		if err := cmd.ParseFlags(nil); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range test.has {
+			if cmd.Flags().Lookup(name) == nil {
+				t.Errorf("Expected %s to take --%s", test.cmd, name)
+			}
+		}
+		for _, name := range test.hasNone {
+			if cmd.Flags().Lookup(name) != nil {
+				t.Errorf("Expected %s not to take --%s, which only applies to split", test.cmd, name)
+			}
+		}
+	}
+	peek, _, _ := rootCmd.Find([]string{"peek"})
+	if err := peek.ParseFlags([]string{"-n", "2"}); err == nil {
+		t.Errorf("Expected peek to reject -n")
+	}
+}
+
+// captureOutput runs the command line args in dir, returning what it wrote to
+// stdout and to stderr, the log included
+func captureOutput(t *testing.T, dir string, args ...string) (string, string) {
+	t.Helper()
+	stdout, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	savedOut, savedErr, savedLog := os.Stdout, os.Stderr, log.Writer()
+	os.Stdout, os.Stderr = stdout, stderr
+	log.SetOutput(stderr)
+	defer func() {
+		os.Stdout, os.Stderr = savedOut, savedErr
+		log.SetOutput(savedLog)
+		os.Chdir(wd)
+	}()
+	rootCmd.SetArgs(args)
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	out, _ := os.ReadFile(stdout.Name())
+	errOut, _ := os.ReadFile(stderr.Name())
+	return string(out), string(errOut)
+}
+
+func TestQuietManifestOnStdout(t *testing.T) {
+	var data bytes.Buffer
+	tw := tar.NewWriter(&data)
+	for _, name := range []string{"a", "b"} {
+		tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Size: 1, Mode: 0644})
+		tw.Write([]byte(name))
+	}
+	tw.Close()
+	for _, quiet := range []bool{false, true} {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "in.tar"), data.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		args := []string{"--manifest", "-", "in.tar"}
+		if quiet {
+			args = append([]string{"--quiet"}, args...)
+		}
+		stdout, stderr := captureOutput(t, dir, args...)
+		var manifest struct {
+			Shards []json.RawMessage `json:"shards"`
+		}
+		if err := json.Unmarshal([]byte(stdout), &manifest); err != nil || len(manifest.Shards) != 1 {
+			t.Errorf("Expected stdout to hold only the manifest with quiet %v, got %q", quiet, stdout)
+		}
+		if quiet && stderr != "" {
+			t.Errorf("Expected nothing on stderr with quiet, got %q", stderr)
+		}
+		if !quiet && !strings.Contains(stderr, "wrote") {
+			t.Errorf("Expected the log on stderr, got %q", stderr)
+		}
+	}
+	quiet = false
+}
+
+func TestExpandGlobs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"layer-1.tar", "layer-2.tar", "other.tar"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pattern := filepath.Join(dir, "layer-*.tar")
+	got, err := expandGlobs([]string{pattern, "plain.tar", "https://example.com/a.tar?x=*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "layer-1.tar"), filepath.Join(dir, "layer-2.tar"), "plain.tar", "https://example.com/a.tar?x=*"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if _, err := expandGlobs([]string{filepath.Join(dir, "none-*.tar")}); err == nil || !strings.Contains(err.Error(), "No tars match") {
+		t.Errorf("Expected a pattern matching nothing to be an error, got %v", err)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	for _, test := range []struct {
+		text string
+		want time.Time
+		err  bool
+	}{
+		{"", time.Time{}, false},
+		{"1700000000", time.Unix(1700000000, 0), false},
+		{"0", time.Unix(0, 0), false},
+		{"2024-01-02T15:04:05Z", time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC), false},
+		{"2024-01-02T15:04:05+02:00", time.Date(2024, 1, 2, 13, 4, 5, 0, time.UTC), false},
+		{"2024-01-02", time.Time{}, true},
+		{"yesterday", time.Time{}, true},
+	} {
+		got, err := parseTime(test.text)
+		if (err != nil) != test.err || !got.Equal(test.want) {
+			t.Errorf("Expected %q to read as %v, got %v and error %v", test.text, test.want, got, err)
+		}
+	}
+}
+
+func TestParseOwnership(t *testing.T) {
+	for _, test := range []struct {
+		text string
+		want *tarsplit.Ownership
+		err  bool
+	}{
+		{"", nil, false},
+		{"0:0", &tarsplit.Ownership{}, false},
+		{"1000:100", &tarsplit.Ownership{Uid: 1000, Gid: 100}, false},
+		{"1000:100:user:users", &tarsplit.Ownership{Uid: 1000, Gid: 100, Uname: "user", Gname: "users"}, false},
+		{"1000", nil, true},
+		{"1000:100:user", nil, true},
+		{"-1:0", nil, true},
+		{"root:root", nil, true},
+	} {
+		got, err := parseOwnership(test.text)
+		if (err != nil) != test.err || (got == nil) != (test.want == nil) || got != nil && *got != *test.want {
+			t.Errorf("Expected %q to read as %+v, got %+v and error %v", test.text, test.want, got, err)
+		}
+	}
+}
+
+func TestParseIDMaps(t *testing.T) {
+	got, err := parseIDMaps([]string{"0:100000:65536", "1000:0"})
+	want := []tarsplit.IDMap{{From: 0, To: 100000, Count: 65536}, {From: 1000, To: 0, Count: 1}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v and error %v", want, got, err)
+	}
+	for _, text := range []string{"0", "0:1:2:3", "a:1", "0:-1"} {
+		if _, err := parseIDMaps([]string{text}); err == nil {
+			t.Errorf("Expected %q to be rejected", text)
+		}
+	}
+}
+
+func TestParsePatterns(t *testing.T) {
+	dir := t.TempDir()
+	includes := filepath.Join(dir, "includes")
+	if err := os.WriteFile(includes, []byte("# what to keep\netc\n\n  usr/*/*  \n#var\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	excludes := filepath.Join(dir, "excludes")
+	if err := os.WriteFile(excludes, []byte("*.bak\x00name\nwith newline\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		opts.Include, opts.Exclude = nil, nil
+		includeFrom, excludeFrom0 = "", ""
+	}()
+	opts.Include, opts.Exclude = []string{"opt"}, []string{"*.tmp"}
+	includeFrom, excludeFrom0 = includes, excludes
+	if err := parsePatterns(); err != nil {
+		t.Fatal(err)
+	}
+	//The same as given inline, after them
+	include := []string{"opt", "etc", "usr/*/*"}
+	exclude := []string{"*.tmp", "*.bak", "name\nwith newline"}
+	if !reflect.DeepEqual(opts.Include, include) || !reflect.DeepEqual(opts.Exclude, exclude) {
+		t.Errorf("Expected include %q and exclude %q, got %q and %q", include, exclude, opts.Include, opts.Exclude)
+	}
+
+	excludeFrom = excludes
+	defer func() { excludeFrom = "" }()
+	if err := parsePatterns(); err == nil {
+		t.Error("Expected --exclude-from and --exclude-from0 together refused")
+	}
+	excludeFrom, excludeFrom0 = filepath.Join(dir, "missing"), ""
+	if err := parsePatterns(); err == nil {
+		t.Error("Expected a missing patterns file to be an error")
+	}
+}
+
+func TestExcludeFrom0(t *testing.T) {
+	var data bytes.Buffer
+	tw := tar.NewWriter(&data)
+	for _, name := range []string{"keep", "odd\nname", "odd", "name", " spaced ", "#hash"} {
+		tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Size: 1, Mode: 0644})
+		tw.Write([]byte("x"))
+	}
+	tw.Close()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "in.tar"), data.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	//Taken exactly, so neither trimmed nor a comment
+	if err := os.WriteFile(filepath.Join(dir, "excludes"), []byte("odd\nname\x00 spaced \x00#hash\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		opts.Exclude = nil
+		excludeFrom0 = ""
+	}()
+	captureOutput(t, dir, "--quiet", "--exclude-from0", "excludes", "in.tar")
+	shards, err := filepath.Glob(filepath.Join(dir, "*in.tar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, shard := range shards {
+		if filepath.Base(shard) == "in.tar" {
+			continue
+		}
+		file, err := os.Open(shard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(file)
+		for {
+			header, err := tr.Next()
+			if err != nil {
+				break
+			}
+			names = append(names, header.Name)
+		}
+		file.Close()
+	}
+	want := []string{"keep", "odd", "name"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected only %q left in the shards, got %q", want, names)
+	}
+}
@@ -0,0 +1,233 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"testing"
+)
+
+var benchLarge = flag.Bool("tarsplit.large", false, "also run the benchmarks over a large generated archive, of about 1GB")
+
+// benchArchive is the shape of a generated tar benchmarked over
+type benchArchive struct {
+	name    string
+	members int
+	size    int64
+}
+
+// benchArchives are the tars benchmarked over, many small members where the
+// scan dominates and fewer big ones where the copy does
+func benchArchives() []benchArchive {
+	archives := []benchArchive{
+		{"small", 10000, 512},
+		{"big", 64, 1 << 20},
+	}
+	if *benchLarge {
+		archives = append(archives, benchArchive{"large", 250000, 4096})
+	}
+	return archives
+}
+
+// benchSource is a source over a generated tar of a
+func benchSource(b *testing.B, a benchArchive) ([]byte, source) {
+	data := genTar(b, a.members, a.size)
+	return data, source{r: bytes.NewReader(data), name: "bench.tar"}
+}
+
+func BenchmarkGenerateSlice(b *testing.B) {
+	for _, a := range benchArchives() {
+		b.Run(a.name, func(b *testing.B) {
+			data, src := benchSource(b, a)
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := generateSlice(src, nil, make(Census), -1, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkGenerateSliceUnsized scans sources that can't say how big they
+// are, so the list of members isn't sized up front, for comparing with
+// BenchmarkGenerateSlice
+func BenchmarkGenerateSliceUnsized(b *testing.B) {
+	for _, a := range benchArchives() {
+		b.Run(a.name, func(b *testing.B) {
+			data, src := benchSource(b, a)
+			src.r = struct{ io.ReadSeeker }{src.r}
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := generateSlice(src, nil, make(Census), -1, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// infoSink keeps the FileInfo BenchmarkHeaderSize makes from being optimised
+// away
+var infoSink fs.FileInfo
+
+// BenchmarkHeaderSize compares reading a member's size straight from its
+// header, as generateSlice does, with going through FileInfo as it once did
+func BenchmarkHeaderSize(b *testing.B) {
+	header := &tar.Header{Name: "f", Typeflag: tar.TypeReg, Size: 1024}
+	var total int64
+	b.Run("header", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			total += header.Size
+		}
+	})
+	b.Run("fileinfo", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			infoSink = header.FileInfo()
+			total += infoSink.Size()
+		}
+	})
+	if total < 0 {
+		b.Fatal("Sizes can't add up to less than nothing")
+	}
+}
+
+func BenchmarkBuildTarPlan(b *testing.B) {
+	for _, a := range benchArchives() {
+		b.Run(a.name, func(b *testing.B) {
+			data, src := benchSource(b, a)
+			members, _, err := generateSlice(src, nil, make(Census), -1, false)
+			if err != nil {
+				b.Fatal(err)
+			}
+			target := int64(len(data)) / 8
+			sorted := make(NameAndSizes, len(members))
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				copy(sorted, members)
+				sort.Sort(sort.Reverse(sorted))
+				if _, err := buildTarPlan(sorted, target); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCreateNewTars(b *testing.B) {
+	for _, a := range benchArchives() {
+		b.Run(a.name, func(b *testing.B) {
+			data, src := benchSource(b, a)
+			members, _, err := generateSlice(src, nil, make(Census), -1, false)
+			if err != nil {
+				b.Fatal(err)
+			}
+			sort.Sort(sort.Reverse(members))
+			plans, err := buildTarPlan(members, int64(len(data))/8)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for i := range plans {
+				plans[i].Index = i
+			}
+			opts := testOptions(b)
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := createNewTars([]source{src}, "bench.tar", &plans, nil, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkSplit runs the whole split, scan, plan and copy, with each strategy
+func BenchmarkSplit(b *testing.B) {
+	for _, a := range benchArchives() {
+		for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+			b.Run(a.name+"/"+name, func(b *testing.B) {
+				data := genTar(b, a.members, a.size)
+				opts := testOptions(b)
+				opts.TargetSize = int64(len(data)) / 8
+				opts.Strategy = strategy
+				b.SetBytes(int64(len(data)))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := SplitReader(bytes.NewReader(data), "bench.tar", opts); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkCopyBuffer splits big members through copy buffers of different
+// sizes, to see what bounding memory with CopyBuffer costs
+func BenchmarkCopyBuffer(b *testing.B) {
+	data := genTar(b, 64, 1<<20)
+	for _, size := range []int{4 << 10, 32 << 10, DefaultCopyBuffer, 1 << 20} {
+		b.Run(fmt.Sprintf("%vKB", size>>10), func(b *testing.B) {
+			opts := testOptions(b)
+			opts.TargetSize = int64(len(data)) / 8
+			opts.CopyBuffer = size
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := SplitReader(bytes.NewReader(data), "bench.tar", opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkSortForPacking orders members already roughly biggest first,
+// as --no-sort expects, with and without sorting them
+func BenchmarkSortForPacking(b *testing.B) {
+	members := make(NameAndSizes, 200000)
+	for i := range members {
+		//Biggest first, give or take a few
+		members[i] = NameAndSize{Name: fmt.Sprintf("f%07d", i), Size: int64(len(members)-i)*16 + int64(i%7)}
+	}
+	for name, noSort := range map[string]bool{"sorted": false, "no-sort": true} {
+		b.Run(name, func(b *testing.B) {
+			opts := Options{NoSort: noSort}
+			data := make(NameAndSizes, len(members))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				copy(data, members)
+				sortForPacking(data, opts)
+			}
+		})
+	}
+}
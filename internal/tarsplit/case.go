@@ -0,0 +1,56 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"strings"
+)
+
+// CaseCheck is what to do about members whose names differ only in case
+type CaseCheck int
+
+const (
+	// CaseCheckOff doesn't look for them
+	CaseCheckOff CaseCheck = iota
+	// CaseCheckWarn lists them in Result.CaseCollisions
+	CaseCheckWarn
+	// CaseCheckError fails the split with a CaseCollisionError
+	CaseCheckError
+)
+
+// CaseCollision is a pair of members that would land on the same path when
+// extracted onto a case-insensitive filesystem, as on Windows or macOS
+type CaseCollision struct {
+	// Name is the member seen first, Other the one clashing with it
+	Name  string
+	Other string
+}
+
+// caseCollisions finds the members of data whose names, without a leading ./
+// or trailing /, match one seen earlier in all but case
+func caseCollisions(data NameAndSizes) []CaseCollision {
+	var collisions []CaseCollision
+	seen := make(map[string]string, len(data))
+	for _, member := range data {
+		name := strings.TrimSuffix(strings.TrimPrefix(member.Name, "./"), "/")
+		folded := strings.ToLower(strings.ToUpper(name))
+		first, ok := seen[folded]
+		switch {
+		case !ok:
+			seen[folded] = member.Name
+		case strings.TrimSuffix(strings.TrimPrefix(first, "./"), "/") != name:
+			collisions = append(collisions, CaseCollision{Name: first, Other: member.Name})
+		}
+	}
+	return collisions
+}
@@ -0,0 +1,67 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCaseCollisions(t *testing.T) {
+	data := makeTar(t,
+		testMember{Name: "Foo.txt", Body: "upper"},
+		testMember{Name: "foo.txt", Body: "lower"},
+		testMember{Name: "./Docs/", Typeflag: tar.TypeDir},
+		testMember{Name: "docs/", Typeflag: tar.TypeDir},
+		testMember{Name: "docs/readme", Body: "readme"},
+		testMember{Name: "straße", Body: "sharp s"},
+		testMember{Name: "other", Body: "no clash"},
+	)
+	want := []CaseCollision{{Name: "Foo.txt", Other: "foo.txt"}, {Name: "./Docs/", Other: "docs/"}}
+	for _, test := range []struct {
+		check      CaseCheck
+		collisions []CaseCollision
+		fails      bool
+	}{
+		{CaseCheckOff, nil, false},
+		{CaseCheckWarn, want, false},
+		{CaseCheckError, nil, true},
+	} {
+		opts := testOptions(t)
+		opts.CaseCheck = test.check
+		result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+		if test.fails {
+			var collision *CaseCollisionError
+			if !errors.As(err, &collision) || !errors.Is(err, ErrCaseCollision) || !reflect.DeepEqual(collision.Collisions, want) {
+				t.Errorf("Check %v: expected a CaseCollisionError for %v, got %v", test.check, want, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Check %v: %v", test.check, err)
+		}
+		if !reflect.DeepEqual(result.CaseCollisions, test.collisions) {
+			t.Errorf("Check %v: expected collisions %v, got %v", test.check, test.collisions, result.CaseCollisions)
+		}
+		if entries := readShards(t, result); entries["Foo.txt"].Body != "upper" || entries["foo.txt"].Body != "lower" {
+			t.Errorf("Check %v: expected both case variants copied", test.check)
+		}
+	}
+
+	if collisions := caseCollisions(NameAndSizes{{Name: "a"}, {Name: "b"}, {Name: "A/"}, {Name: "./a"}}); len(collisions) != 1 || collisions[0] != (CaseCollision{Name: "a", Other: "A/"}) {
+		t.Errorf("Expected only a and A/ to collide, got %v", collisions)
+	}
+}
@@ -0,0 +1,57 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// writeChecksums writes the SHA-256 digest of every one of shards to path in
+// the format sha256sum -c checks, with the shard paths relative to the
+// directory path is in. Shards whose digest wasn't computed while writing are
+// read back for it
+func writeChecksums(path string, shards []ShardResult) error {
+	shards = append([]ShardResult(nil), shards...)
+	sort.SliceStable(shards, func(i, j int) bool {
+		return shards[i].Index < shards[j].Index
+	})
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("Could not write checksums %s, got error %w", path, err)
+	}
+	var text strings.Builder
+	for _, shard := range shards {
+		digest := shard.Digest
+		if digest == "" {
+			if digest, err = fileDigest(shard.File); err != nil {
+				return fmt.Errorf("Could not compute digest of %s, got error %w", shard.File, err)
+			}
+		}
+		name, err := filepath.Abs(shard.File)
+		if err != nil {
+			return fmt.Errorf("Could not write checksums %s, got error %w", path, err)
+		}
+		if rel, err := filepath.Rel(dir, name); err == nil {
+			name = rel
+		}
+		fmt.Fprintf(&text, "%s  %s\n", strings.TrimPrefix(digest, "sha256:"), filepath.ToSlash(name))
+	}
+	if err := os.WriteFile(path, []byte(text.String()), 0644); err != nil {
+		return fmt.Errorf("Could not write checksums %s, got error %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// verifyChecksums checks every shard listed in the SHA256SUMS file at path
+// as sha256sum -c run from its directory would, returning the files listed
+func verifyChecksums(t *testing.T, path string) map[string]bool {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	listed := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		digest, name, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			t.Errorf("Expected a digest and a file name, got %q", scanner.Text())
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(filepath.Dir(path), filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("Expected %s next to the checksums, got error %v", name, err)
+			continue
+		}
+		if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != digest {
+			t.Errorf("Expected %s to check out against %s", name, digest)
+		}
+		listed[name] = true
+	}
+	return listed
+}
+
+func TestChecksums(t *testing.T) {
+	data := genTar(t, 30, 700)
+	for _, test := range []struct {
+		name   string
+		modify func(*Options)
+		failed int
+	}{
+		{"single-pass", func(opts *Options) { opts.Strategy = StrategySinglePass }, 0},
+		{"two-pass", func(opts *Options) { opts.Strategy = StrategyTwoPass }, 0},
+		{"records", func(opts *Options) { opts.RecordSize = 10240 }, 0},
+		{"digest names", func(opts *Options) { opts.Naming = NameDigest }, 0},
+		{"subdir layout", func(opts *Options) { opts.Layout = LayoutSubdir }, 0},
+		//A member failing partway is cut back out of its shard's digest too
+		{"failed member", func(opts *Options) {
+			opts.Strategy = StrategyTwoPass
+			opts.SkipErrors = true
+		}, 1},
+	} {
+		opts := testOptions(t)
+		opts.TargetSize = 4096
+		opts.Checksums = filepath.Join(opts.outDir, "SHA256SUMS")
+		test.modify(&opts)
+		//From partway into the body of the fourth member to the fifth's header
+		var source io.ReadSeeker = bytes.NewReader(data)
+		if test.failed > 0 {
+			source = &failingReaderAt{Reader: bytes.NewReader(data), bad: 7*blockSize + 100, good: 8 * blockSize}
+		}
+		result, err := SplitReader(source, "in.tar", opts)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if len(result.Failed) != test.failed {
+			t.Fatalf("%s: expected %v members failed, got %v", test.name, test.failed, result.Failed)
+		}
+		listed := verifyChecksums(t, opts.Checksums)
+		if len(listed) != len(result.Shards) {
+			t.Errorf("%s: expected all %v shards listed, got %v", test.name, len(result.Shards), len(listed))
+		}
+		for _, shard := range result.Shards {
+			rel, _ := filepath.Rel(opts.outDir, shard.File)
+			if !listed[filepath.ToSlash(rel)] {
+				t.Errorf("%s: expected %s listed", test.name, rel)
+			}
+			if opts.Naming == NameDigest && filepath.Base(shard.File) != digestName(shard.Digest) {
+				t.Errorf("%s: expected %s named after its digest %s", test.name, shard.File, shard.Digest)
+			}
+		}
+	}
+}
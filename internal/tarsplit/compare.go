@@ -0,0 +1,140 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"crypto/sha256"
+	"io"
+	"sort"
+)
+
+// Comparison is how the members of one tar differ from another's
+type Comparison struct {
+	// Added are the members only the second tar has, Removed those only the
+	// first has, both in the order of the tar holding them
+	Added   NameAndSizes
+	Removed NameAndSizes
+	// Changed are the members both have that differ, sorted by name
+	Changed []ChangedMember
+}
+
+// ChangedMember is a member two tars both have but with different content.
+// The digests are of its data, and only set when the sizes match
+type ChangedMember struct {
+	Name             string
+	SizeA, SizeB     int64
+	DigestA, DigestB string
+}
+
+// Same reports whether the tars hold the same members with the same content
+func (c *Comparison) Same() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Changed) == 0
+}
+
+// Compare lists the members of the tars at a and b by name, size and kind, and
+// then reads the data of each member both hold at the same size to compare its
+// SHA-256 digest. Members are matched by name as stored, so ./a and a differ
+func Compare(a, b string, opts Options) (*Comparison, error) {
+	opts.Order = OrderSource
+	opts.Strategy = StrategySinglePass
+	opts.HardlinkCopies = false
+	sources, cleanup, err := openSources([]string{a, b}, opts)
+	defer cleanup()
+	if err != nil {
+		return nil, err
+	}
+	var lists [2]NameAndSizes
+	for i, source := range sources {
+		if lists[i], _, err = generateSlice(source, nil, make(Census), -1, opts.Concatenated); err != nil {
+			return nil, err
+		}
+	}
+	inA := make(map[string]NameAndSize, len(lists[0]))
+	for _, member := range lists[0] {
+		inA[member.Name] = member
+	}
+	inB := make(map[string]bool, len(lists[1]))
+	comparison := &Comparison{}
+	//Members whose digests are needed to tell whether they changed
+	same := make(map[string]bool)
+	for _, member := range lists[1] {
+		inB[member.Name] = true
+		old, ok := inA[member.Name]
+		switch {
+		case !ok:
+			comparison.Added = append(comparison.Added, member)
+		case old.Size != member.Size || old.Typeflag != member.Typeflag || old.Linkname != member.Linkname:
+			comparison.Changed = append(comparison.Changed, ChangedMember{Name: member.Name, SizeA: old.Size, SizeB: member.Size})
+		default:
+			same[member.Name] = true
+		}
+	}
+	for _, member := range lists[0] {
+		if !inB[member.Name] {
+			comparison.Removed = append(comparison.Removed, member)
+		}
+	}
+	if len(same) > 0 {
+		var digests [2]map[string]string
+		for i, source := range sources {
+			if digests[i], err = memberDigests(source, same, opts.Concatenated); err != nil {
+				return nil, err
+			}
+		}
+		for name := range same {
+			if digests[0][name] != digests[1][name] {
+				size := inA[name].Size
+				comparison.Changed = append(comparison.Changed, ChangedMember{Name: name, SizeA: size, SizeB: size, DigestA: digests[0][name], DigestB: digests[1][name]})
+			}
+		}
+	}
+	sort.Slice(comparison.Changed, func(i, j int) bool {
+		return comparison.Changed[i].Name < comparison.Changed[j].Name
+	})
+	return comparison, nil
+}
+
+// memberDigests streams src, computing the SHA-256 digest of the data of each
+// member in names
+func memberDigests(src source, names map[string]bool, concatenated bool) (map[string]string, error) {
+	r, closeSource, err := src.stream()
+	if err != nil {
+		return nil, err
+	}
+	defer closeSource()
+
+	position := newPositionReader(r)
+	tr := newTarStream(position, concatenated)
+	digests := make(map[string]string, len(names))
+	var last string
+	for {
+		header, err := tr.Next()
+		switch {
+		case err == io.EOF:
+			return digests, nil
+		case err != nil:
+			return nil, &SourceError{Source: src.name, Member: last, After: true, Offset: position.pos, Err: err}
+		case header == nil:
+			continue
+		}
+		last = header.Name
+		if !names[header.Name] || isLongLink(header) {
+			continue
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, tr); err != nil {
+			return nil, &SourceError{Source: src.name, Member: header.Name, Offset: position.pos, Err: err}
+		}
+		digests[header.Name] = formatDigest(h)
+	}
+}
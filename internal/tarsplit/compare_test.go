@@ -0,0 +1,74 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	dir := t.TempDir()
+	original := []testMember{
+		{Name: "dir/", Typeflag: tar.TypeDir},
+		{Name: "dir/same", Body: "unchanged"},
+		{Name: "dir/edited", Body: "version 1"},
+		{Name: "dir/grown", Body: "short"},
+		{Name: "dir/gone", Body: "removed"},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "dir/same"},
+	}
+	repacked := []testMember{
+		{Name: "dir/", Typeflag: tar.TypeDir},
+		{Name: "dir/new", Body: "added"},
+		{Name: "dir/edited", Body: "version 2"},
+		{Name: "dir/grown", Body: "much longer"},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "dir/edited"},
+		{Name: "dir/same", Body: "unchanged"},
+	}
+	a := writeFile(t, dir, "a.tar", makeTar(t, original...))
+	b := writeFile(t, dir, "b.tar.gz", gzipBytes(t, makeTar(t, repacked...)))
+
+	comparison, err := Compare(a, b, Options{TmpDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comparison.Same() {
+		t.Fatal("Expected the tars to differ")
+	}
+	if len(comparison.Added) != 1 || comparison.Added[0].Name != "dir/new" {
+		t.Errorf("Expected dir/new added, got %v", comparison.Added)
+	}
+	if len(comparison.Removed) != 1 || comparison.Removed[0].Name != "dir/gone" {
+		t.Errorf("Expected dir/gone removed, got %v", comparison.Removed)
+	}
+	digest := func(body string) string {
+		return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(body)))
+	}
+	changed := []ChangedMember{
+		{Name: "dir/edited", SizeA: 9, SizeB: 9, DigestA: digest("version 1"), DigestB: digest("version 2")},
+		{Name: "dir/grown", SizeA: 5, SizeB: 11},
+		{Name: "link"},
+	}
+	if !reflect.DeepEqual(comparison.Changed, changed) {
+		t.Errorf("Expected changed %+v, got %+v", changed, comparison.Changed)
+	}
+
+	//Order doesn't matter, only names, kinds and content
+	c := writeFile(t, dir, "c.tar", makeTar(t, original[5], original[4], original[3], original[2], original[1], original[0]))
+	if comparison, err = Compare(a, c, Options{}); err != nil || !comparison.Same() {
+		t.Errorf("Expected the same members in another order to compare the same, got %+v and error %v", comparison, err)
+	}
+}
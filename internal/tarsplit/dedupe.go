@@ -0,0 +1,157 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// dedupe is what DedupeAcrossShards found among the planned members
+type dedupe struct {
+	// links are the members written as hardlinks, by name, to the identical
+	// member before them in the same shard
+	links map[string]string
+	// elsewhere are the members identical to one in an earlier shard, by
+	// name, to that member
+	elsewhere map[string]string
+}
+
+// checkDedupe makes sure DedupeAcrossShards can be used with opts
+func checkDedupe(opts Options) error {
+	switch {
+	case opts.MultiVolume:
+		return fmt.Errorf("Members can't be deduplicated in volumes, only in shards")
+	case opts.MemberTransform != nil:
+		return fmt.Errorf("Members can't be deduplicated when they are transformed, their content may no longer match")
+	case opts.SkipErrors:
+		return fmt.Errorf("Members can't be deduplicated with failed members skipped, a link could be left without its file")
+	}
+	return nil
+}
+
+// findDuplicates reads the data of the regular members of plans, finding
+// those with the same content. Within a shard the first written of them is
+// kept and the rest become hardlinks to it, those in different shards are
+// only noted
+func findDuplicates(sources []source, plans []Plan, opts Options) (*dedupe, error) {
+	names := make([]map[string]bool, len(sources))
+	for i := range names {
+		names[i] = make(map[string]bool)
+	}
+	for _, plan := range plans {
+		for _, member := range plan.Pool {
+			//Where members with unknown offsets fall in the shard isn't known
+			if member.Typeflag == tar.TypeReg && member.Size > 0 && member.Offset >= 0 {
+				names[member.Source][member.Name] = true
+			}
+		}
+	}
+	digests := make(map[string]string)
+	for i, src := range sources {
+		if len(names[i]) == 0 {
+			continue
+		}
+		found, err := memberDigests(src, names[i], opts.Concatenated)
+		if err != nil {
+			return nil, err
+		}
+		for name, digest := range found {
+			digests[name] = digest
+		}
+	}
+
+	d := &dedupe{links: make(map[string]string), elsewhere: make(map[string]string)}
+	first := make(map[string]string)
+	for _, plan := range plans {
+		members := append(NameAndSizes(nil), plan.Pool...)
+		if opts.Order == OrderName {
+			sort.Sort(byName(members))
+		} else {
+			sort.Sort(bySource(members))
+		}
+		kept := make(map[string]string)
+		for _, member := range members {
+			digest, ok := digests[member.Name]
+			if !ok {
+				continue
+			}
+			//The size is part of the key so a digest collision alone can't
+			//link members of different sizes
+			key := digest + "/" + strconv.FormatInt(member.Size, 10)
+			if target, ok := kept[key]; ok {
+				d.links[member.Name] = target
+				continue
+			}
+			kept[key] = member.Name
+			if target, ok := first[key]; ok {
+				d.elsewhere[member.Name] = target
+				continue
+			}
+			first[key] = member.Name
+		}
+	}
+	return d, nil
+}
+
+// link is the member that name is written as a hardlink to, if any
+func (d *dedupe) link(name string) (string, bool) {
+	if d == nil {
+		return "", false
+	}
+	target, ok := d.links[name]
+	return target, ok
+}
+
+// shardLinks are the links among the members of plan, nil when there are none
+func (d *dedupe) shardLinks(plan Plan) map[string]string {
+	if d == nil {
+		return nil
+	}
+	var links map[string]string
+	for _, member := range plan.Pool {
+		if target, ok := d.links[member.Name]; ok {
+			if links == nil {
+				links = make(map[string]string)
+			}
+			links[member.Name] = target
+		}
+	}
+	return links
+}
+
+// isDedupeLink reports whether header is a hardlink DedupeAcrossShards wrote
+// in shard
+func isDedupeLink(header *tar.Header, shard ManifestShard) bool {
+	target, ok := shard.Linked[header.Name]
+	return ok && header.Typeflag == tar.TypeLink && header.Linkname == target
+}
+
+// dedupeHeader is header turned into a hardlink to target, with no data
+func dedupeHeader(header *tar.Header, target string) *tar.Header {
+	link := *header
+	link.Typeflag = tar.TypeLink
+	link.Linkname = target
+	link.Size = 0
+	if _, ok := header.PAXRecords["size"]; ok {
+		link.PAXRecords = make(map[string]string, len(header.PAXRecords))
+		for key, value := range header.PAXRecords {
+			if key != "size" {
+				link.PAXRecords[key] = value
+			}
+		}
+	}
+	return &link
+}
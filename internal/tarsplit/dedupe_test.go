@@ -0,0 +1,146 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDedupeAcrossShards(t *testing.T) {
+	same := strings.Repeat("same", 250)
+	data := makeTar(t,
+		testMember{Name: "a", Body: same},
+		testMember{Name: "b", Body: strings.Repeat("diff", 250)},
+		testMember{Name: "c", Body: same},
+		testMember{Name: "d", Body: same},
+		testMember{Name: "empty1"},
+		testMember{Name: "empty2"},
+	)
+	for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+		opts := testOptions(t)
+		opts.Strategy = strategy
+		opts.DedupeAcrossShards = true
+		opts.Manifest = filepath.Join(t.TempDir(), "manifest.json")
+		result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(result.Shards) != 1 {
+			t.Fatalf("%s: expected one shard, got %v", name, len(result.Shards))
+		}
+		entries := readShards(t, result)
+		if entries["a"].Typeflag != tar.TypeReg || entries["a"].Body != same {
+			t.Errorf("%s: expected the first copy written whole, got %+v", name, entries["a"].Header)
+		}
+		for _, member := range []string{"c", "d"} {
+			if entry := entries[member]; entry.Typeflag != tar.TypeLink || entry.Linkname != "a" || entry.Size != 0 {
+				t.Errorf("%s: expected %s a hardlink to a, got %+v", name, member, entry.Header)
+			}
+		}
+		//Different content, and empty members aren't worth linking
+		for _, member := range []string{"b", "empty1", "empty2"} {
+			if entries[member].Typeflag != tar.TypeReg {
+				t.Errorf("%s: expected %s left a regular file, got %c", name, member, entries[member].Typeflag)
+			}
+		}
+		manifest, err := ReadManifest(opts.Manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := map[string]string{"c": "a", "d": "a"}; !reflect.DeepEqual(manifest.Shards[0].Linked, want) {
+			t.Errorf("%s: expected the manifest to list links %v, got %v", name, want, manifest.Shards[0].Linked)
+		}
+		if len(manifest.Duplicates) != 0 {
+			t.Errorf("%s: expected no duplicates across shards, got %v", name, manifest.Duplicates)
+		}
+
+		//The links extract as copies of a
+		if _, err := exec.LookPath("tar"); err != nil {
+			continue
+		}
+		dir := t.TempDir()
+		if out, err := exec.Command("tar", "-xf", result.Shards[0].File, "-C", dir).CombinedOutput(); err != nil {
+			t.Fatalf("%s: could not extract, got error %v: %s", name, err, out)
+		}
+		for _, member := range []string{"a", "c", "d"} {
+			if body, err := os.ReadFile(filepath.Join(dir, member)); err != nil || string(body) != same {
+				t.Errorf("%s: expected %s extracted with the shared content, got error %v", name, member, err)
+			}
+		}
+	}
+}
+
+func TestDedupeAcrossShardsSpread(t *testing.T) {
+	//Each fills a shard of its own, so they can't be linked
+	same := strings.Repeat("s", 3000)
+	data := makeTar(t,
+		testMember{Name: "a", Body: same},
+		testMember{Name: "b", Body: same},
+		testMember{Name: "c", Body: same},
+	)
+	opts := testOptions(t)
+	opts.TargetSize = 4096
+	opts.DedupeAcrossShards = true
+	opts.Manifest = filepath.Join(t.TempDir(), "manifest.json")
+	result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Shards) != 3 {
+		t.Fatalf("Expected a shard each, got %v", len(result.Shards))
+	}
+	for name, entry := range readShards(t, result) {
+		if entry.Typeflag != tar.TypeReg || entry.Body != same {
+			t.Errorf("Expected %s written whole, got %+v", name, entry.Header)
+		}
+	}
+	manifest, err := ReadManifest(opts.Manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Duplicates) != 2 {
+		t.Fatalf("Expected two members listed as duplicates, got %v", manifest.Duplicates)
+	}
+	//Both point at the one in the first shard, which isn't listed itself
+	var first string
+	for _, shard := range manifest.Shards {
+		if shard.Index == 0 {
+			first = shard.Members[0].Name
+		}
+	}
+	for member, target := range manifest.Duplicates {
+		if target != first || member == first {
+			t.Errorf("Expected %s listed as a duplicate of %s, got %s", member, first, target)
+		}
+	}
+
+	for name, set := range map[string]func(*Options){
+		"multi-volume": func(opts *Options) { opts.MultiVolume = true },
+		"transform":    func(opts *Options) { opts.MemberTransform = upperTransform },
+		"skip errors":  func(opts *Options) { opts.SkipErrors = true },
+	} {
+		opts := testOptions(t)
+		opts.DedupeAcrossShards = true
+		set(&opts)
+		if _, err := SplitReader(bytes.NewReader(data), "in.tar", opts); err == nil {
+			t.Errorf("Expected dedupe with %s refused", name)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"path/filepath"
+)
+
+// Destination is somewhere other than the local filesystem to write the
+// shards to, like memory in tests or an object store
+type Destination interface {
+	// Create starts the shard called name, a slash separated path like the
+	// shard would have in the current directory. The shard is complete once
+	// the writer is closed without error
+	Create(name string) (io.WriteCloser, error)
+}
+
+// checkDestination makes sure the split can write to opts.Destination, which
+// can only be written straight through, never read back, renamed or cut
+func checkDestination(opts Options) error {
+	switch {
+	case opts.Naming == NameDigest:
+		return fmt.Errorf("Shards can't be named by digest in a destination, that needs them renamed once written")
+	case opts.Layout == LayoutContainer:
+		return fmt.Errorf("Shards can't be put in a container in a destination, that needs them read back")
+	case opts.SkipErrors:
+		return fmt.Errorf("Failed members can't be skipped in a destination, that needs shards cut back")
+	case opts.Resume != "":
+		return fmt.Errorf("A split to a destination can't be resumed, its shards can't be checked")
+	case opts.CheckFreeSpace:
+		return fmt.Errorf("Free space can't be checked in a destination, it isn't a local filesystem")
+	}
+	return nil
+}
+
+// destWriter is a shard being written to a Destination, counting the bytes
+// written and computing their digest, as the shard can't be read back
+type destWriter struct {
+	w    io.WriteCloser
+	name string
+	n    int64
+	sum  hash.Hash
+}
+
+func (d *destWriter) Write(b []byte) (int, error) {
+	n, err := d.w.Write(b)
+	d.n += int64(n)
+	d.sum.Write(b[:n])
+	return n, err
+}
+
+// createDestShard starts shard i of the source named fn in opts.Destination
+func createDestShard(i int, fn string, opts Options) (*destWriter, error) {
+	name := filepath.ToSlash(shardName(i, fn, opts))
+	w, err := opts.Destination.Create(name)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create tarball %s, got error %w", name, err)
+	}
+	return &destWriter{w: w, name: name, sum: sha256.New()}, nil
+}
+
+// finishDest completes a shard written to a Destination and reports it to
+// the tally
+func (s *shard) finishDest(opts Options, t *tally) error {
+	if err := s.dest.w.Close(); err != nil {
+		return fmt.Errorf("Could not close tarball %v, got error %w", s.index, err)
+	}
+	s.closed = true
+	result := ShardResult{
+		Index:   s.index,
+		File:    s.dest.name,
+		Members: s.members,
+		Size:    s.dest.n,
+		Planned: s.planned,
+		Fill:    s.fill,
+	}
+	if opts.Checksums != "" {
+		result.Digest = formatDigest(s.dest.sum)
+	}
+	t.finish(result)
+	return nil
+}
@@ -0,0 +1,129 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// memDest is a Destination keeping the shards in memory
+type memDest struct {
+	shards map[string]*memShard
+}
+
+func (d *memDest) Create(name string) (io.WriteCloser, error) {
+	if _, ok := d.shards[name]; ok {
+		return nil, fmt.Errorf("%s created twice", name)
+	}
+	shard := &memShard{}
+	d.shards[name] = shard
+	return shard, nil
+}
+
+// memShard is a shard written to a memDest
+type memShard struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (s *memShard) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestDestination(t *testing.T) {
+	var members []testMember
+	for i := 0; i < 12; i++ {
+		members = append(members, testMember{Name: fmt.Sprintf("f%02d", i), Body: strings.Repeat(string(rune('a'+i)), 1000)})
+	}
+	data := makeTar(t, members...)
+	for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+		dest := &memDest{shards: make(map[string]*memShard)}
+		opts := testOptions(t)
+		opts.TargetSize = 4096
+		opts.Strategy = strategy
+		//Beside the shards rather than in the destination, like the manifest
+		opts.Checksums = filepath.Join(t.TempDir(), "SHA256SUMS")
+		opts.Destination = dest
+		result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if files, _ := os.ReadDir(opts.outDir); len(files) != 0 {
+			t.Errorf("%s: expected nothing written to the filesystem, got %v files", name, len(files))
+		}
+		if len(dest.shards) != len(result.Shards) || len(result.Shards) < 3 {
+			t.Fatalf("%s: expected every one of the %v shards in the destination, got %v", name, len(result.Shards), len(dest.shards))
+		}
+		bodies := make(map[string]string)
+		for _, shardResult := range result.Shards {
+			shard := dest.shards[shardResult.File]
+			if shard == nil || !shard.closed {
+				t.Fatalf("%s: expected %s written and closed in the destination", name, shardResult.File)
+			}
+			if int64(shard.Len()) != shardResult.Size {
+				t.Errorf("%s: expected %s to be %v bytes, got %v", name, shardResult.File, shardResult.Size, shard.Len())
+			}
+			sum := sha256.Sum256(shard.Bytes())
+			if want := "sha256:" + hex.EncodeToString(sum[:]); shardResult.Digest != want {
+				t.Errorf("%s: expected %s to have digest %s, got %s", name, shardResult.File, want, shardResult.Digest)
+			}
+			tr := tar.NewReader(bytes.NewReader(shard.Bytes()))
+			for {
+				header, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("%s: could not read %s, got error %v", name, shardResult.File, err)
+				}
+				body, _ := io.ReadAll(tr)
+				bodies[header.Name] = string(body)
+			}
+		}
+		for _, member := range members {
+			if bodies[member.Name] != member.Body {
+				t.Errorf("%s: expected %s in the destination whole, got %v bytes", name, member.Name, len(bodies[member.Name]))
+			}
+		}
+	}
+
+	refused := map[string]func(*Options){
+		"digest naming": func(opts *Options) { opts.Naming = NameDigest },
+		"container":     func(opts *Options) { opts.Layout = LayoutContainer },
+		"skip errors":   func(opts *Options) { opts.SkipErrors = true },
+		"resume":        func(opts *Options) { opts.Resume = "manifest.json" },
+		"free space":    func(opts *Options) { opts.CheckFreeSpace = true },
+	}
+	for name, set := range refused {
+		dest := &memDest{shards: make(map[string]*memShard)}
+		opts := testOptions(t)
+		opts.Destination = dest
+		set(&opts)
+		if _, err := SplitReader(bytes.NewReader(data), "in.tar", opts); err == nil {
+			t.Errorf("Expected %s refused with a destination", name)
+		}
+		if len(dest.shards) != 0 {
+			t.Errorf("Expected nothing written with %s refused, got %v shards", name, len(dest.shards))
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// archiveDir tars up the tree under dir into a temporary file in tmpDir, so a
+// directory can be split like any other source. Members are named relative to
+// dir. The returned cleanup removes the file and must always be called
+func archiveDir(dir string, opts Options) (string, func(), error) {
+	noop := func() {}
+
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return "", noop, fmt.Errorf("Could not read source directory %s, got error %w", dir, err)
+	}
+	if !fi.IsDir() {
+		return "", noop, fmt.Errorf("Source %s is not a directory", dir)
+	}
+	tmp, err := os.CreateTemp(opts.TmpDir, "tarlayer-split-*-"+filepath.Base(dir)+".tar")
+	if err != nil {
+		return "", noop, fmt.Errorf("Could not create buffer for %s, got error %w", dir, err)
+	}
+	cleanup := func() {
+		os.Remove(tmp.Name())
+	}
+
+	a := &dirArchiver{tw: tar.NewWriter(tmp), follow: opts.FollowSymlinks}
+	err = a.addTree(dir, "", nil)
+	if err == nil {
+		err = a.tw.Close()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return "", noop, fmt.Errorf("Could not archive %s to %s, got error %w", dir, tmp.Name(), err)
+	}
+	return tmp.Name(), cleanup, nil
+}
+
+// dirArchiver writes a directory tree into a tar
+type dirArchiver struct {
+	tw *tar.Writer
+	// follow stores what symlinks point to in place of the links
+	follow bool
+}
+
+// addTree writes everything under root with names starting with prefix.
+// links holds the real directories of the symlinks followed to reach root
+func (a *dirArchiver) addTree(root, prefix string, links []string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(filepath.Join(prefix, rel))
+		if rel == "." {
+			//The root itself is only named when it was reached through a link
+			if prefix == "" {
+				return nil
+			}
+			name = prefix
+		}
+		if d.Type()&fs.ModeSymlink != 0 && a.follow {
+			return a.followLink(path, name, links)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return a.add(path, name, info)
+	})
+}
+
+// followLink writes what the symlink at path points to under its name, walking
+// into it when it's a directory. A directory that holds the link, or any link
+// followed on the way to it, would be walked forever, so it is a
+// SymlinkLoopError
+func (a *dirArchiver) followLink(path, name string, links []string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("Could not follow symlink %s, got error %w", path, err)
+	}
+	if !info.IsDir() {
+		return a.add(path, name, info)
+	}
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return fmt.Errorf("Could not follow symlink %s, got error %w", path, err)
+	}
+	parent, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	links = append(links[:len(links):len(links)], parent)
+	for _, dir := range links {
+		if isWithin(dir, target) {
+			return &SymlinkLoopError{Link: path, Target: target}
+		}
+	}
+	return a.addTree(path+string(filepath.Separator), name, links)
+}
+
+// isWithin reports whether path is dir or somewhere under it
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// add writes the entry at path described by info, and its content when it
+// is a regular file
+func (a *dirArchiver) add(path, name string, info fs.FileInfo) error {
+	var link string
+	if info.Mode()&fs.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(path); err != nil {
+			return err
+		}
+	}
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return fmt.Errorf("Could not archive %s, got error %w", path, err)
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	if err := a.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(a.tw, file)
+	return err
+}
@@ -0,0 +1,128 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testDir is a directory holding sub/file and link, a symlink to it
+func testDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "sub"), "file", []byte("hello"))
+	if err := os.Symlink("sub/file", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestFromDirKeepsSymlinks(t *testing.T) {
+	for _, strategy := range []Strategy{StrategySinglePass, StrategyTwoPass} {
+		opts := testOptions(t)
+		opts.FromDir = true
+		opts.Strategy = strategy
+		result, err := Split(testDir(t), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries := readShards(t, result)
+		link, ok := entries["link"]
+		switch {
+		case !ok:
+			t.Errorf("Strategy %v left the symlink out of the shards", strategy)
+		case link.Typeflag != tar.TypeSymlink || link.Linkname != "sub/file":
+			t.Errorf("Strategy %v wrote the symlink as type %c to %q, expected a symlink to sub/file", strategy, link.Typeflag, link.Linkname)
+		}
+		if entries["sub/file"].Body != "hello" {
+			t.Errorf("Strategy %v wrote sub/file as %q", strategy, entries["sub/file"].Body)
+		}
+		if result.Skipped[tar.TypeSymlink] != 0 {
+			t.Errorf("Strategy %v skipped %v symlinks", strategy, result.Skipped[tar.TypeSymlink])
+		}
+	}
+}
+
+func TestFromDirFollowsSymlinks(t *testing.T) {
+	opts := testOptions(t)
+	opts.FromDir = true
+	opts.FollowSymlinks = true
+	result, err := Split(testDir(t), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := readShards(t, result)["link"]
+	if link.Header == nil || link.Typeflag != tar.TypeReg || link.Body != "hello" {
+		t.Errorf("Expected the symlink stored as a copy of sub/file, got %+v", link)
+	}
+}
+
+func TestFromDirSymlinkLoop(t *testing.T) {
+	dir := testDir(t)
+	if err := os.Symlink("..", filepath.Join(dir, "sub", "up")); err != nil {
+		t.Fatal(err)
+	}
+	opts := testOptions(t)
+	opts.FromDir = true
+	opts.FollowSymlinks = true
+	_, err := Split(dir, opts)
+	var loopErr *SymlinkLoopError
+	if !errors.As(err, &loopErr) || !errors.Is(err, ErrSymlinkLoop) {
+		t.Fatalf("Expected a symlink loop error, got %v", err)
+	}
+	if loopErr.Link != filepath.Join(dir, "sub", "up") {
+		t.Errorf("Expected the loop blamed on sub/up, got %s", loopErr.Link)
+	}
+}
+
+func TestFromDirEmptyDirs(t *testing.T) {
+	dir := testDir(t)
+	if err := os.MkdirAll(filepath.Join(dir, "mnt", "data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, strategy := range []Strategy{StrategySinglePass, StrategyTwoPass} {
+		for _, drop := range []bool{false, true} {
+			opts := testOptions(t)
+			opts.FromDir = true
+			opts.Strategy = strategy
+			opts.DropEmptyDirs = drop
+			result, err := Split(dir, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries := readShards(t, result)
+			empty, ok := entries["mnt/data/"]
+			switch {
+			case drop && ok:
+				t.Errorf("Strategy %v kept the empty directory when told to drop it", strategy)
+			case !drop && !ok:
+				t.Errorf("Strategy %v left the empty directory out by default", strategy)
+			case !drop && empty.Typeflag != tar.TypeDir:
+				t.Errorf("Strategy %v wrote the empty directory as type %c", strategy, empty.Typeflag)
+			}
+			//Directories holding something are recreated by their members
+			for _, name := range []string{"sub/", "mnt/"} {
+				if _, ok := entries[name]; ok {
+					t.Errorf("Strategy %v copied %s, which isn't empty", strategy, name)
+				}
+			}
+		}
+	}
+}
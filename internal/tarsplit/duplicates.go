@@ -0,0 +1,101 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"sort"
+)
+
+// DuplicateGroup is members holding identical content
+type DuplicateGroup struct {
+	Digest string
+	// Size is of each member
+	Size    int64
+	Members []string
+}
+
+// Wasted is the bytes that keeping only one copy of the content would save
+func (g DuplicateGroup) Wasted() int64 {
+	return g.Size * int64(len(g.Members)-1)
+}
+
+// Duplicates finds the regular members of the tars at filenames that hold
+// identical content, going by the SHA-256 digest of their data, with the
+// groups wasting the most bytes first. Only members sharing their size with
+// another are read to compute a digest, empty ones never are
+func Duplicates(filenames []string, opts Options) ([]DuplicateGroup, error) {
+	opts.Order = OrderSource
+	opts.Strategy = StrategySinglePass
+	opts.HardlinkCopies = false
+	sources, cleanup, err := openSources(filenames, opts)
+	defer cleanup()
+	if err != nil {
+		return nil, err
+	}
+	opts.SourceHash = false
+	data, _, err := scanSources(sources, opts)
+	if err != nil {
+		return nil, err
+	}
+	bySize := make(map[int64]int)
+	for _, member := range data {
+		if member.Typeflag == tar.TypeReg && member.Size > 0 {
+			bySize[member.Size]++
+		}
+	}
+	//The members to read, from each source
+	candidates := make([]map[string]bool, len(sources))
+	for i := range candidates {
+		candidates[i] = make(map[string]bool)
+	}
+	for _, member := range data {
+		if member.Typeflag == tar.TypeReg && bySize[member.Size] > 1 {
+			candidates[member.Source][member.Name] = true
+		}
+	}
+	groups := make(map[string]*DuplicateGroup)
+	var digests []string
+	for i, source := range sources {
+		if len(candidates[i]) == 0 {
+			continue
+		}
+		found, err := memberDigests(source, candidates[i], opts.Concatenated)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range data {
+			digest, ok := found[member.Name]
+			if member.Source != i || !ok {
+				continue
+			}
+			group := groups[digest]
+			if group == nil {
+				group = &DuplicateGroup{Digest: digest, Size: member.Size}
+				groups[digest] = group
+				digests = append(digests, digest)
+			}
+			group.Members = append(group.Members, member.Name)
+		}
+	}
+	var duplicates []DuplicateGroup
+	for _, digest := range digests {
+		if group := groups[digest]; len(group.Members) > 1 {
+			duplicates = append(duplicates, *group)
+		}
+	}
+	sort.SliceStable(duplicates, func(i, j int) bool {
+		return duplicates[i].Wasted() > duplicates[j].Wasted()
+	})
+	return duplicates, nil
+}
@@ -0,0 +1,69 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDuplicates(t *testing.T) {
+	same := strings.Repeat("same", 250)
+	dir := t.TempDir()
+	first := writeFile(t, dir, "a.tar", makeTar(t,
+		testMember{Name: "a", Body: same},
+		testMember{Name: "b", Body: same},
+		//The same size as a and b, but different
+		testMember{Name: "c", Body: strings.Repeat("diff", 250)},
+		testMember{Name: "small1", Body: "tiny"},
+		testMember{Name: "empty1"},
+		testMember{Name: "link", Typeflag: '2', Linkname: "a"},
+	))
+	second := writeFile(t, dir, "b.tar.gz", gzipBytes(t, makeTar(t,
+		testMember{Name: "d", Body: same},
+		testMember{Name: "small2", Body: "tiny"},
+		testMember{Name: "empty2"},
+		testMember{Name: "unique", Body: "only one of these"},
+	)))
+	groups, err := Duplicates([]string{first, second}, testOptions(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(same))
+	want := []DuplicateGroup{
+		{Digest: "sha256:" + hex.EncodeToString(sum[:]), Size: 1000, Members: []string{"a", "b", "d"}},
+		{Size: 4, Members: []string{"small1", "small2"}},
+	}
+	if len(groups) != len(want) {
+		t.Fatalf("Expected %v groups of duplicates, got %+v", len(want), groups)
+	}
+	//The small group's digest isn't worth spelling out
+	want[1].Digest = groups[1].Digest
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("Expected duplicates %+v, got %+v", want, groups)
+	}
+	if wasted := groups[0].Wasted(); wasted != 2000 {
+		t.Errorf("Expected the extra copies to waste 2000 bytes, got %v", wasted)
+	}
+
+	groups, err = Duplicates([]string{writeFile(t, dir, "c.tar", makeTar(t, sourceMembers...))}, testOptions(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 0 {
+		t.Errorf("Expected no duplicates, got %+v", groups)
+	}
+}
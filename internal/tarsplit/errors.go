@@ -0,0 +1,322 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrInvalidTarget matches errors about a target size, or the options
+	// shaping it, that shards can't be planned to
+	ErrInvalidTarget = errors.New("invalid target size")
+	// ErrOversizeFile matches an OversizeError
+	ErrOversizeFile = errors.New("member too big for the target size")
+	// ErrUnknownMember matches an UnknownMemberError
+	ErrUnknownMember = errors.New("member not planned into any shard")
+	// ErrCaseCollision matches a CaseCollisionError
+	ErrCaseCollision = errors.New("member names differ only in case")
+	// ErrUnsafeName matches an UnsafeNameError
+	ErrUnsafeName = errors.New("member name escapes the directory it is extracted to")
+	// ErrInterrupted matches the error returned when Options.Context is done
+	// before the split is
+	ErrInterrupted = errors.New("split interrupted")
+	// ErrXattrsLost matches an XattrError
+	ErrXattrsLost = errors.New("tar format can't hold member xattrs")
+	// ErrMembersChanged matches a MembersChangedError
+	ErrMembersChanged = errors.New("source members changed since planning")
+	// ErrDuplicateMember matches a DuplicateMemberError
+	ErrDuplicateMember = errors.New("member name repeated")
+	// ErrSymlinkLoop matches a SymlinkLoopError
+	ErrSymlinkLoop = errors.New("symlink leads back to a directory holding it")
+)
+
+// invalidTarget is an error matching ErrInvalidTarget
+type invalidTarget struct {
+	msg string
+}
+
+func invalidTargetf(format string, args ...interface{}) error {
+	return invalidTarget{msg: fmt.Sprintf(format, args...)}
+}
+
+func (e invalidTarget) Error() string {
+	return e.msg
+}
+
+func (e invalidTarget) Is(target error) bool {
+	return target == ErrInvalidTarget
+}
+
+// interruptedError is an error matching ErrInterrupted
+type interruptedError struct {
+	err error
+}
+
+func (e interruptedError) Error() string {
+	return fmt.Sprintf("Split interrupted, got %s", e.err.Error())
+}
+
+func (e interruptedError) Is(target error) bool {
+	return target == ErrInterrupted
+}
+
+func (e interruptedError) Unwrap() error {
+	return e.err
+}
+
+// checkInterrupted returns an error matching ErrInterrupted once Context is
+// done
+func checkInterrupted(opts Options) error {
+	if opts.Context == nil {
+		return nil
+	}
+	select {
+	case <-opts.Context.Done():
+		return interruptedError{err: opts.Context.Err()}
+	default:
+		return nil
+	}
+}
+
+// OversizeError is returned with StrictSize when members are too big for a
+// shard of the target size
+type OversizeError struct {
+	// Name and Size are of the biggest member that doesn't fit
+	Name string
+	Size int64
+	// Minimum is the smallest target size that would fit it
+	Minimum int64
+	// Others is how many more members don't fit
+	Others int
+}
+
+func (e *OversizeError) Error() string {
+	text := fmt.Sprintf("Member %s is %v bytes, too big for a shard of the target size", e.Name, e.Size)
+	if e.Others > 0 {
+		text += fmt.Sprintf(", as are %v other members", e.Others)
+	}
+	return text + fmt.Sprintf(". A target of at least %v would fit it", e.Minimum)
+}
+
+func (e *OversizeError) Is(target error) bool {
+	return target == ErrOversizeFile
+}
+
+// UnknownMemberError is returned when copying comes across a member that
+// wasn't seen when planning, so it has no shard to go to
+type UnknownMemberError struct {
+	Name   string
+	Source string
+}
+
+func (e *UnknownMemberError) Error() string {
+	return fmt.Sprintf("Member %s of %s was not planned into any shard, has the source changed?", e.Name, e.Source)
+}
+
+func (e *UnknownMemberError) Is(target error) bool {
+	return target == ErrUnknownMember
+}
+
+// CaseCollisionError is returned with CaseCheckError when members' names
+// differ only in case
+type CaseCollisionError struct {
+	Collisions []CaseCollision
+}
+
+func (e *CaseCollisionError) Error() string {
+	first := e.Collisions[0]
+	text := fmt.Sprintf("Members %s and %s differ only in case and would collide on a case-insensitive filesystem", first.Name, first.Other)
+	if len(e.Collisions) > 1 {
+		text += fmt.Sprintf(", as do %v other pairs", len(e.Collisions)-1)
+	}
+	return text
+}
+
+func (e *CaseCollisionError) Is(target error) bool {
+	return target == ErrCaseCollision
+}
+
+// UnsafeNameError is returned when a member's name, or what it links to,
+// would land outside the directory it is extracted to, like ../../etc/passwd,
+// /etc/passwd or C:\Windows
+type UnsafeNameError struct {
+	Name   string
+	Source string
+	// Linkname is set when it is the link's target that lands outside
+	Linkname string
+}
+
+func (e *UnsafeNameError) Error() string {
+	if e.Linkname != "" {
+		return fmt.Sprintf("Member %s of %s links to %s, outside the target directory", e.Name, e.Source, e.Linkname)
+	}
+	return fmt.Sprintf("Member %s of %s would be extracted outside the target directory", e.Name, e.Source)
+}
+
+func (e *UnsafeNameError) Is(target error) bool {
+	return target == ErrUnsafeName
+}
+
+// DuplicateMemberError is returned when a member other than a directory is
+// found twice, in one source or in two split together. Shards are routed by
+// name, so one of them would be lost
+type DuplicateMemberError struct {
+	Name string
+	// Source and Other hold the two, the same source when it holds both, as
+	// entries First and Second of it
+	Source, Other string
+	First, Second int
+}
+
+func (e *DuplicateMemberError) Error() string {
+	if e.Source == e.Other {
+		return fmt.Sprintf("Member %s appears more than once in %s (entries %v and %v), splitting would lose one of them", e.Name, e.Source, e.First, e.Second)
+	}
+	return fmt.Sprintf("Member %s is in both %s and %s, splitting would lose one of them", e.Name, e.Source, e.Other)
+}
+
+func (e *DuplicateMemberError) Is(target error) bool {
+	return target == ErrDuplicateMember
+}
+
+// SourceError places an error that came partway through a source, for finding
+// corruption in a big one
+type SourceError struct {
+	Source string
+	// Member is the member being read, or with After set the last member read
+	// before the error came reading the next header. Empty before the first
+	Member string
+	After  bool
+	// Offset is about how far into the source, uncompressed, reading had got
+	Offset int64
+	Err    error
+}
+
+func (e *SourceError) Error() string {
+	where := "in member " + e.Member
+	switch {
+	case e.After && e.Member == "":
+		where = "before the first member"
+	case e.After:
+		where = "after member " + e.Member
+	}
+	return fmt.Sprintf("Failed at about byte %v of %s, %s, got error %s", e.Offset, e.Source, where, e.Err.Error())
+}
+
+func (e *SourceError) Unwrap() error {
+	return e.Err
+}
+
+// checkName returns an UnsafeNameError when name, a member of source, is
+// absolute, starts with a drive or climbs out of the directory it is
+// extracted to
+func checkName(name, source string) error {
+	if escapes(name) {
+		return &UnsafeNameError{Name: name, Source: source}
+	}
+	return nil
+}
+
+// checkHeader is checkName for the member header describes, also checking
+// what a hardlink or symlink points to. A hardlink names another member of
+// the tar, while a symlink is followed from the directory it is in
+func checkHeader(header *tar.Header, source string) error {
+	if err := checkName(header.Name, source); err != nil {
+		return err
+	}
+	target := header.Linkname
+	switch header.Typeflag {
+	case tar.TypeLink:
+	case tar.TypeSymlink:
+		target = strings.ReplaceAll(target, "\\", "/")
+		if !hasVolume(target) && !path.IsAbs(target) {
+			target = path.Join(path.Dir(strings.ReplaceAll(header.Name, "\\", "/")), target)
+		}
+	default:
+		return nil
+	}
+	if escapes(target) {
+		return &UnsafeNameError{Name: header.Name, Source: source, Linkname: header.Linkname}
+	}
+	return nil
+}
+
+// escapes reports whether name, slashes either way, is absolute, starts with
+// a drive or climbs out of the directory it is relative to
+func escapes(name string) bool {
+	if hasVolume(name) {
+		return true
+	}
+	clean := path.Clean(strings.ReplaceAll(name, "\\", "/"))
+	return path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../")
+}
+
+// hasVolume reports whether name starts with a Windows volume, like C: or
+// \\server\share. filepath.VolumeName only finds them on Windows, a drive
+// letter is looked for everywhere. A UNC path is absolute once its slashes
+// are turned, so escapes catches it anyway
+func hasVolume(name string) bool {
+	if filepath.VolumeName(name) != "" {
+		return true
+	}
+	if len(name) < 2 || name[1] != ':' {
+		return false
+	}
+	letter := name[0] | 0x20
+	return 'a' <= letter && letter <= 'z'
+}
+
+// XattrError is returned with PreserveXattrs when members carry extended
+// attributes, like POSIX ACLs, that the forced Format can't hold
+type XattrError struct {
+	// Name and Xattrs are of the first member that carries any
+	Name   string
+	Xattrs []string
+	Format string
+	// Others is how many more members carry some
+	Others int
+}
+
+func (e *XattrError) Error() string {
+	text := fmt.Sprintf("Member %s has xattrs %s that can't be written as %s", e.Name, strings.Join(e.Xattrs, ", "), e.Format)
+	if e.Others > 0 {
+		text += fmt.Sprintf(", as do %v other members", e.Others)
+	}
+	return text + ", only PAX can hold them"
+}
+
+func (e *XattrError) Is(target error) bool {
+	return target == ErrXattrsLost
+}
+
+// SymlinkLoopError is returned with FromDir and FollowSymlinks when a symlink
+// points to a directory it is itself under, which would be walked forever
+type SymlinkLoopError struct {
+	Link string
+	// Target is where Link resolves to
+	Target string
+}
+
+func (e *SymlinkLoopError) Error() string {
+	return fmt.Sprintf("Symlink loop, %s leads back to %s which contains it", e.Link, e.Target)
+}
+
+func (e *SymlinkLoopError) Is(target error) bool {
+	return target == ErrSymlinkLoop
+}
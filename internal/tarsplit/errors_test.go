@@ -0,0 +1,174 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCheckHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		typeflag byte
+		linkname string
+		unsafe   bool
+	}{
+		{"a/b", tar.TypeReg, "", false},
+		{"./a/../b", tar.TypeReg, "", false},
+		{"../a", tar.TypeReg, "", true},
+		{"a/../../b", tar.TypeReg, "", true},
+		{"..", tar.TypeDir, "", true},
+		{"/etc/passwd", tar.TypeReg, "", true},
+		{"a\\..\\..\\b", tar.TypeReg, "", true},
+		{"\\\\server\\share\\a", tar.TypeReg, "", true},
+		{"C:foo", tar.TypeReg, "", true},
+		{"c:\\windows\\a", tar.TypeReg, "", true},
+		{"ab:c", tar.TypeReg, "", false},
+		{"a/link", tar.TypeSymlink, "b", false},
+		{"a/link", tar.TypeSymlink, "../b", false},
+		{"a/link", tar.TypeSymlink, "../../b", true},
+		{"link", tar.TypeSymlink, "../b", true},
+		{"link", tar.TypeSymlink, "/etc/passwd", true},
+		{"link", tar.TypeSymlink, "C:\\Windows", true},
+		{"a/link", tar.TypeLink, "a/b", false},
+		{"a/link", tar.TypeLink, "../b", true},
+		{"a/link", tar.TypeLink, "/etc/passwd", true},
+		{"a/link", tar.TypeLink, "D:b", true},
+		//Only links are followed
+		{"a/file", tar.TypeReg, "../../b", false},
+	}
+	for _, test := range tests {
+		header := &tar.Header{Name: test.name, Typeflag: test.typeflag, Linkname: test.linkname}
+		err := checkHeader(header, "shard.tar")
+		if unsafe := errors.Is(err, ErrUnsafeName); unsafe != test.unsafe {
+			t.Errorf("Expected %s linking to %q unsafe %v, got error %v", test.name, test.linkname, test.unsafe, err)
+		}
+	}
+}
+
+func TestMergeRejectsUnsafeNames(t *testing.T) {
+	for _, member := range []testMember{
+		{Name: "../../etc/passwd", Body: "root"},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../../etc/passwd"},
+	} {
+		shard := writeFile(t, t.TempDir(), "0-in.tar", makeTar(t, testMember{Name: "a", Body: "safe"}, member))
+		err := Merge([]string{shard}, nil, io.Discard)
+		var unsafe *UnsafeNameError
+		if !errors.As(err, &unsafe) || unsafe.Name != member.Name {
+			t.Errorf("Expected merging %s to fail as unsafe, got %v", member.Name, err)
+		}
+	}
+}
+
+// changingSource is a source that holds other data when it is read again
+// from the start, once it was read past the offset after while planning
+type changingSource struct {
+	*bytes.Reader
+	after   int64
+	planned bool
+	other   []byte
+}
+
+func (s *changingSource) Read(p []byte) (int, error) {
+	n, err := s.Reader.Read(p)
+	if s.Size()-int64(s.Len()) > s.after {
+		s.planned = true
+	}
+	return n, err
+}
+
+func (s *changingSource) Seek(offset int64, whence int) (int64, error) {
+	if s.planned && offset == 0 && whence == io.SeekStart {
+		s.Reader = bytes.NewReader(s.other)
+	}
+	return s.Reader.Seek(offset, whence)
+}
+
+func TestTypedErrors(t *testing.T) {
+	data := makeTar(t, testMember{Name: "small", Body: "s"}, testMember{Name: "huge", Body: strings.Repeat("h", 5000)})
+	opts := testOptions(t)
+	opts.TargetSize = 4096
+	opts.StrictSize = true
+	_, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+	var oversize *OversizeError
+	if !errors.As(fmt.Errorf("Could not split, got error %w", err), &oversize) || oversize.Name != "huge" || oversize.Size != 5000 {
+		t.Errorf("Expected an OversizeError for huge, got %v", err)
+	}
+
+	if _, err := buildBalancedPlan(nil, -1); !errors.Is(err, ErrInvalidTarget) || errors.Is(err, ErrOversizeFile) {
+		t.Errorf("Expected only ErrInvalidTarget for a negative number of shards, got %v", err)
+	}
+
+	//Laid out the same but for the name of the second member
+	planned := makeTar(t, testMember{Name: "a", Body: strings.Repeat("a", 1500)}, testMember{Name: "b", Body: strings.Repeat("b", 1500)})
+	changed := makeTar(t, testMember{Name: "a", Body: strings.Repeat("a", 1500)}, testMember{Name: "new", Body: strings.Repeat("n", 1500)})
+	opts = testOptions(t)
+	opts.TargetSize = 2048
+	opts.Strategy = StrategySinglePass
+	source := &changingSource{Reader: bytes.NewReader(planned), after: 4 * blockSize, other: changed}
+	_, err = SplitReader(source, "in.tar", opts)
+	var unknown *UnknownMemberError
+	if !errors.As(err, &unknown) || !errors.Is(err, ErrUnknownMember) || unknown.Name != "new" || unknown.Source != "in.tar" {
+		t.Errorf("Expected an UnknownMemberError for new, got %v", err)
+	}
+}
+
+func TestSourceError(t *testing.T) {
+	data := makeTar(t, testMember{Name: "a", Body: "first"}, testMember{Name: "b", Body: strings.Repeat("b", 3000)}, testMember{Name: "c", Body: "last"})
+	//Partway into the body of b
+	cut := data[:3*blockSize+1000]
+	for _, test := range []struct {
+		name     string
+		source   io.ReadSeeker
+		strategy Strategy
+		after    bool
+	}{
+		//Planning skips over the body of b to reach the header after it
+		{"scan", bytes.NewReader(cut), StrategySinglePass, true},
+		//The source is whole when planned but cut when streamed again
+		{"copy", &changingSource{Reader: bytes.NewReader(data), after: int64(len(data)) - 1, other: cut}, StrategySinglePass, false},
+	} {
+		opts := testOptions(t)
+		opts.TargetSize = 2048
+		opts.Strategy = test.strategy
+		_, err := SplitReader(test.source, "in.tar", opts)
+		var sourceErr *SourceError
+		if !errors.As(err, &sourceErr) {
+			t.Errorf("%s: expected a SourceError, got %v", test.name, err)
+			continue
+		}
+		if sourceErr.Source != "in.tar" || sourceErr.Member != "b" || sourceErr.After != test.after || !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%s: expected the error placed at b, got %+v", test.name, sourceErr)
+		}
+		//Skipping a body seeks over it, so the scan fails at its end
+		if sourceErr.Offset < 2*blockSize || sourceErr.Offset > 9*blockSize {
+			t.Errorf("%s: expected the error placed within b, got byte %v", test.name, sourceErr.Offset)
+		}
+		if !strings.Contains(err.Error(), "member b") || !strings.Contains(err.Error(), "in.tar") {
+			t.Errorf("%s: expected the message to name b and the source, got %q", test.name, err)
+		}
+	}
+
+	//A tar cut in its very first header is placed before any member
+	_, err := SplitReader(bytes.NewReader(data[:100]), "in.tar", testOptions(t))
+	var sourceErr *SourceError
+	if !errors.As(err, &sourceErr) || sourceErr.Member != "" || !strings.Contains(err.Error(), "before the first member") {
+		t.Errorf("Expected the error placed before the first member, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+// Estimate is how a split to one target size would turn out
+type Estimate struct {
+	Target int64
+	Shards int
+	// Oversize is how many members are too big for the target
+	Oversize int
+	// AverageFill is how full the shards are relative to the target on average
+	AverageFill float64
+}
+
+// EstimateTargets plans the members of the tars at filenames to each of
+// targets in turn, to help pick one. The tars are only scanned once, and
+// nothing is written. The other options shape the plans as they would a split
+func EstimateTargets(filenames []string, targets []int64, opts Options) ([]Estimate, error) {
+	opts.Order = OrderSource
+	opts.Strategy = StrategySinglePass
+	opts.HardlinkCopies = false
+	sources, cleanup, err := openSources(filenames, opts)
+	defer cleanup()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPatterns(opts); err != nil {
+		return nil, err
+	}
+	if err := checkPacking(opts); err != nil {
+		return nil, err
+	}
+	opts.SourceHash = false
+	data, _, err := scanSources(sources, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.Include) > 0 || len(opts.Exclude) > 0 {
+		data = filterMembers(data, opts, make(map[string]bool))
+	}
+	sortForPacking(data, opts)
+
+	estimates := make([]Estimate, len(targets))
+	for i, target := range targets {
+		opts.TargetSize, opts.Targets, opts.NumShards = target, nil, 0
+		plans, err := buildPlans(data, opts)
+		if err != nil {
+			return nil, err
+		}
+		estimate := Estimate{Target: target, Shards: len(plans), Oversize: len(oversizeMembers(plans))}
+		for _, plan := range plans {
+			estimate.AverageFill += fillRatio(plan)
+		}
+		if len(plans) > 0 {
+			estimate.AverageFill /= float64(len(plans))
+		}
+		estimates[i] = estimate
+	}
+	return estimates, nil
+}
@@ -0,0 +1,74 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestEstimateTargets(t *testing.T) {
+	var members []testMember
+	for i := 0; i < 60; i++ {
+		members = append(members, testMember{Name: fmt.Sprintf("f%02d", i), Body: strings.Repeat("x", 137*(i%17)+1)})
+	}
+	//Too big for all but the largest targets
+	members = append(members, testMember{Name: "big", Body: strings.Repeat("b", 20000)})
+	path := writeFile(t, t.TempDir(), "in.tar", makeTar(t, members...))
+
+	targets := []int64{64 << 10, 32 << 10, 16 << 10, 8192, 4096, 2048}
+	estimates, err := EstimateTargets([]string{path}, targets, testOptions(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(estimates) != len(targets) {
+		t.Fatalf("Expected an estimate per target, got %v", len(estimates))
+	}
+	for i, estimate := range estimates {
+		if estimate.Target != targets[i] {
+			t.Errorf("Expected estimate %v for target %v, got %v", i, targets[i], estimate.Target)
+		}
+		if estimate.AverageFill <= 0 {
+			t.Errorf("Expected shards for target %v to hold something, got fill %v", estimate.Target, estimate.AverageFill)
+		}
+		if i > 0 && estimate.Shards < estimates[i-1].Shards {
+			t.Errorf("Expected no fewer shards as the target shrinks, got %v for %v after %v for %v", estimate.Shards, estimate.Target, estimates[i-1].Shards, estimates[i-1].Target)
+		}
+	}
+	if first, last := estimates[0], estimates[len(estimates)-1]; first.Shards >= last.Shards {
+		t.Errorf("Expected more shards for the smallest target than the largest, got %v and %v", last.Shards, first.Shards)
+	}
+	//big is 20000 bytes, the rest up to 2193
+	for i, want := range []int{0, 0, 1, 1, 1} {
+		if estimates[i].Oversize != want {
+			t.Errorf("Expected %v members oversize for target %v, got %v", want, estimates[i].Target, estimates[i].Oversize)
+		}
+	}
+	if estimates[5].Oversize <= 1 {
+		t.Errorf("Expected the largest of the rest oversize for target %v too, got %v", estimates[5].Target, estimates[5].Oversize)
+	}
+
+	//As many shards as splitting to the target makes
+	for _, estimate := range estimates {
+		opts := testOptions(t)
+		opts.TargetSize = estimate.Target
+		result, err := Split(path, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Shards) != estimate.Shards {
+			t.Errorf("Expected %v shards splitting to %v as estimated, got %v", estimate.Shards, estimate.Target, len(result.Shards))
+		}
+	}
+}
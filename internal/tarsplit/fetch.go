@@ -0,0 +1,71 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// isURL reports whether filename is an http or https URL to fetch the source
+// from rather than a path
+func isURL(filename string) bool {
+	return strings.HasPrefix(filename, "http://") || strings.HasPrefix(filename, "https://")
+}
+
+// urlName is the file name at the end of the path of the URL rawURL, without
+// any query, or download.tar when the path has none
+func urlName(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "download.tar"
+	}
+	name := path.Base(u.Path)
+	if name == "." || name == "/" {
+		return "download.tar"
+	}
+	return name
+}
+
+// fetchSource downloads the source at rawURL into a temporary file in TmpDir,
+// as bufferSource does for stdin. HTTPTimeout bounds connecting and waiting for
+// the response to start, not the download itself, which can take as long as
+// the source is big. The returned cleanup removes the file and must always be
+// called
+func fetchSource(rawURL string, opts Options) (string, func(), error) {
+	noop := func() {}
+	timeout := opts.HTTPTimeout
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: timeout}).DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+	}}
+	start := time.Now()
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return "", noop, fmt.Errorf("Could not fetch %s, got error %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", noop, fmt.Errorf("Could not fetch %s, got HTTP status %s after %v", rawURL, resp.Status, time.Since(start).Round(time.Millisecond))
+	}
+	//Keep the name at the end so the extension can still say if it's gzipped
+	//when sniffing can't
+	return bufferTo(resp.Body, rawURL, opts.TmpDir, "tarlayer-split-*-"+urlName(rawURL))
+}
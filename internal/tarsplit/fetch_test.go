@@ -0,0 +1,96 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetchSource(t *testing.T) {
+	data := genTar(t, 20, 700)
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/layers/base.tar", func(w http.ResponseWriter, r *http.Request) { w.Write(data) })
+	mux.HandleFunc("/layers/base.tar.gz", func(w http.ResponseWriter, r *http.Request) { w.Write(gzipBytes(t, data)) })
+	mux.HandleFunc("/slow.tar", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	defer close(release)
+
+	for _, name := range []string{"base.tar", "base.tar.gz"} {
+		opts := testOptions(t)
+		opts.TargetSize = 4096
+		opts.TmpDir = t.TempDir()
+		opts.HTTPTimeout = 5 * time.Second
+		result, err := Split(server.URL+"/layers/"+name+"?token=x", opts)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if entries := readShards(t, result); len(entries) != 20 {
+			t.Errorf("%s: expected the 20 members split, got %v", name, len(entries))
+		}
+		for _, shard := range result.Shards {
+			//Shards are plain tars whatever the source
+			if !strings.HasSuffix(shard.File, "-base.tar") {
+				t.Errorf("%s: expected shards named after the URL path, got %s", name, shard.File)
+			}
+		}
+		if files, _ := os.ReadDir(opts.TmpDir); len(files) != 0 {
+			t.Errorf("%s: expected the download removed, got %v files left", name, len(files))
+		}
+	}
+
+	for _, test := range []struct {
+		path    string
+		timeout time.Duration
+		message string
+	}{
+		{"/missing.tar", 5 * time.Second, "404"},
+		{"/slow.tar", 100 * time.Millisecond, "timeout"},
+	} {
+		opts := testOptions(t)
+		opts.TmpDir = t.TempDir()
+		opts.HTTPTimeout = test.timeout
+		_, err := Split(server.URL+test.path, opts)
+		if err == nil || !strings.Contains(err.Error(), test.message) || !strings.Contains(err.Error(), server.URL+test.path) {
+			t.Errorf("%s: expected an error naming the URL and %q, got %v", test.path, test.message, err)
+		}
+		if files, _ := os.ReadDir(opts.TmpDir); len(files) != 0 {
+			t.Errorf("%s: expected nothing left in the tmp dir, got %v files", test.path, len(files))
+		}
+	}
+}
+
+func TestURLName(t *testing.T) {
+	for rawURL, want := range map[string]string{
+		"https://example.com/images/layer.tar.gz": "layer.tar.gz",
+		"https://example.com/layer.tar?sig=abc":   "layer.tar",
+		"https://example.com/":                    "download.tar",
+		"https://example.com":                     "download.tar",
+		"http://[::1":                             "download.tar",
+	} {
+		if got := urlName(rawURL); got != want {
+			t.Errorf("Expected %s named %s, got %s", rawURL, want, got)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// checkPatterns makes sure every include, exclude and pin pattern is a valid
+// glob
+func checkPatterns(opts Options) error {
+	for _, pattern := range append(append(append([]string(nil), opts.Include...), opts.Exclude...), opts.Pin...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Invalid pattern %q, got error %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchesAny reports whether any of patterns matches name, or a directory
+// name is under, so that a pattern naming a directory covers its contents.
+// A leading ./ is ignored on either
+func matchesAny(patterns []string, name string) bool {
+	name = strings.Trim(strings.TrimPrefix(name, "./"), "/")
+	for _, pattern := range patterns {
+		pattern = strings.Trim(strings.TrimPrefix(pattern, "./"), "/")
+		for dir := name; ; {
+			if ok, _ := path.Match(pattern, dir); ok {
+				return true
+			}
+			i := strings.LastIndex(dir, "/")
+			if i < 0 {
+				break
+			}
+			dir = dir[:i]
+		}
+	}
+	return false
+}
+
+// filterMembers keeps the members of data that Include and Exclude let
+// through, marking the ones left out in dropped so they aren't copied
+func filterMembers(data NameAndSizes, opts Options, dropped map[string]bool) NameAndSizes {
+	kept := data[:0]
+	for _, member := range data {
+		if len(opts.Include) > 0 && !matchesAny(opts.Include, member.Name) || matchesAny(opts.Exclude, member.Name) {
+			dropped[member.Name] = true
+			continue
+		}
+		kept = append(kept, member)
+	}
+	return kept
+}
+
+// buildPinnedPlan plans the members matching Pin into the first shard, tops
+// it off with other members that fit and packs the rest into the shards after
+func buildPinnedPlan(data NameAndSizes, opts Options) ([]Plan, error) {
+	if opts.NumShards > 0 {
+		return nil, fmt.Errorf("Pinning members to the first shard needs a target size rather than a number of shards")
+	}
+	targets, err := planTargets(opts)
+	if err != nil {
+		return nil, err
+	}
+	first := Plan{Target: targets[0]}
+	var rest NameAndSizes
+	for _, member := range data {
+		if matchesAny(opts.Pin, member.Name) {
+			first.Pool = append(first.Pool, member)
+		} else {
+			rest = append(rest, member)
+		}
+	}
+	if len(first.Pool) == 0 {
+		return packPlans(data, opts)
+	}
+	if size := first.Size(); size > first.Target && opts.StrictSize {
+		return nil, invalidTargetf("Pinned members hold %v bytes, more than the first shard's target of %v", size, first.Target)
+	}
+	left := rest[:0]
+	for _, member := range rest {
+		if member.Size <= first.Target-first.Size() {
+			first.Pool = append(first.Pool, member)
+		} else {
+			left = append(left, member)
+		}
+	}
+	if len(opts.Targets) > 1 {
+		opts.Targets = opts.Targets[1:]
+	}
+	plans, err := packPlans(left, opts)
+	if err != nil {
+		return nil, err
+	}
+	return append([]Plan{first}, plans...), nil
+}
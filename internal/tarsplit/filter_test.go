@@ -0,0 +1,142 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	data := makeTar(t,
+		testMember{Name: "./etc/", Typeflag: tar.TypeDir},
+		testMember{Name: "./etc/hosts", Body: "hosts"},
+		testMember{Name: "./etc/hosts.bak", Body: "old hosts"},
+		testMember{Name: "./etc/ssl/cert.pem", Body: "cert"},
+		testMember{Name: "./usr/bin/sh", Body: "sh"},
+		testMember{Name: "./usr/lib/libc.so", Body: "libc"},
+		testMember{Name: "./var/log/messages", Body: "log"},
+	)
+	for _, test := range []struct {
+		name    string
+		include []string
+		exclude []string
+		want    []string
+	}{
+		{"everything", nil, nil, []string{"./etc/hosts", "./etc/hosts.bak", "./etc/ssl/cert.pem", "./usr/bin/sh", "./usr/lib/libc.so", "./var/log/messages"}},
+		{"directory covers its contents", []string{"etc"}, nil, []string{"./etc/hosts", "./etc/hosts.bak", "./etc/ssl/cert.pem"}},
+		{"glob", []string{"usr/*/*"}, nil, []string{"./usr/bin/sh", "./usr/lib/libc.so"}},
+		{"with ./ and trailing /", []string{"./var/"}, nil, []string{"./var/log/messages"}},
+		{"exclude", nil, []string{"*/*.bak", "usr"}, []string{"./etc/hosts", "./etc/ssl/cert.pem", "./var/log/messages"}},
+		{"exclude beats include", []string{"etc"}, []string{"etc/ssl", "*/*.bak"}, []string{"./etc/hosts"}},
+		{"globs match whole paths", nil, []string{"*.bak"}, []string{"./etc/hosts", "./etc/hosts.bak", "./etc/ssl/cert.pem", "./usr/bin/sh", "./usr/lib/libc.so", "./var/log/messages"}},
+		{"nothing matches", []string{"opt"}, nil, nil},
+	} {
+		opts := testOptions(t)
+		opts.Include, opts.Exclude = test.include, test.exclude
+		result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		entries := readShards(t, result)
+		for _, name := range test.want {
+			if _, ok := entries[name]; !ok {
+				t.Errorf("%s: expected %s split", test.name, name)
+			}
+		}
+		if len(entries) != len(test.want) {
+			t.Errorf("%s: expected %v members split, got %v", test.name, len(test.want), len(entries))
+		}
+	}
+
+	opts := testOptions(t)
+	opts.Exclude = []string{"[a-"}
+	if _, err := SplitReader(bytes.NewReader(data), "in.tar", opts); err == nil {
+		t.Error("Expected an invalid pattern refused")
+	}
+}
+
+func TestPin(t *testing.T) {
+	members := []testMember{
+		{Name: "layer/big1", Body: strings.Repeat("1", 3000)},
+		{Name: "layer/big2", Body: strings.Repeat("2", 3000)},
+		{Name: "etc/config.json", Body: strings.Repeat("c", 500)},
+		{Name: "layer/big3", Body: strings.Repeat("3", 3000)},
+		{Name: "etc/base", Body: strings.Repeat("b", 800)},
+		{Name: "small", Body: "s"},
+	}
+	data := makeTar(t, members...)
+	firstShard := func(t *testing.T, result *Result) map[string]bool {
+		t.Helper()
+		for _, shard := range result.Shards {
+			if shard.Index == 0 {
+				names := make(map[string]bool)
+				for _, entry := range readTar(t, shard.File) {
+					names[entry.Name] = true
+				}
+				return names
+			}
+		}
+		t.Fatal("Expected a shard 0")
+		return nil
+	}
+	for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+		opts := testOptions(t)
+		opts.TargetSize = 4096
+		opts.Strategy = strategy
+		opts.Pin = []string{"etc"}
+		result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		first := firstShard(t, result)
+		if !first["etc/config.json"] || !first["etc/base"] {
+			t.Errorf("%s: expected the pinned members in shard 0, got %v", name, first)
+		}
+		//The pinned members take 2560 bytes, leaving room for small but
+		//none of the big members
+		if len(first) != 3 || !first["small"] {
+			t.Errorf("%s: expected shard 0 topped off with what fits, got %v", name, first)
+		}
+		if entries := readShards(t, result); len(entries) != len(members) {
+			t.Errorf("%s: expected all %v members split, got %v", name, len(members), len(entries))
+		}
+	}
+
+	//Pinned members bigger than the target overflow shard 0 rather than
+	//being spread out
+	opts := testOptions(t)
+	opts.TargetSize = 4096
+	opts.Pin = []string{"layer/*"}
+	result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first := firstShard(t, result); len(first) != 3 || !first["layer/big1"] || !first["layer/big2"] || !first["layer/big3"] {
+		t.Errorf("Expected every pinned member in shard 0, got %v", first)
+	}
+
+	opts.StrictSize = true
+	if _, err := SplitReader(bytes.NewReader(data), "in.tar", opts); !errors.Is(err, ErrInvalidTarget) {
+		t.Errorf("Expected pinned members over the target refused with strict sizes, got %v", err)
+	}
+	opts = testOptions(t)
+	opts.NumShards = 2
+	opts.Pin = []string{"etc"}
+	if _, err := SplitReader(bytes.NewReader(data), "in.tar", opts); err == nil {
+		t.Error("Expected pinning with a number of shards refused")
+	}
+}
@@ -0,0 +1,96 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"math"
+)
+
+// resolveHardlinks finds the file each hardlink in data links to, keyed by
+// the link's name, and plans the link as a regular file of that file's size
+func resolveHardlinks(data NameAndSizes) (map[string]NameAndSize, error) {
+	type key struct {
+		source int
+		name   string
+	}
+	members := make(map[key]NameAndSize, len(data))
+	for _, member := range data {
+		members[key{member.Source, member.Name}] = member
+	}
+	targets := make(map[string]NameAndSize)
+	for i, member := range data {
+		if member.Typeflag != tar.TypeLink {
+			continue
+		}
+		target, ok := members[key{member.Source, member.Linkname}]
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("Hardlink %s points to %s, which is not in the source", member.Name, member.Linkname)
+		case target.Typeflag != tar.TypeReg:
+			return nil, fmt.Errorf("Hardlink %s points to %s, which is not a regular file", member.Name, member.Linkname)
+		case target.Offset < 0 || member.Offset < 0:
+			return nil, fmt.Errorf("Could not copy hardlink %s, it follows a sparse file so its offset is unknown", member.Name)
+		}
+		targets[member.Name] = target
+		data[i].Size = target.Size
+		data[i].Typeflag = tar.TypeReg
+	}
+	return targets, nil
+}
+
+// memberData reads the header of member at its offset in source, returning it
+// along with a reader of just the member's data
+func memberData(source io.ReaderAt, member NameAndSize) (*tar.Header, *io.SectionReader, error) {
+	position := newPositionReader(io.NewSectionReader(source, member.Offset, math.MaxInt64-member.Offset))
+	header, err := tar.NewReader(position).Next()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not read %s at offset %v, got error %w", member.Name, member.Offset, err)
+	}
+	if header.Name != member.Name || header.Size != member.Size {
+		return nil, nil, fmt.Errorf("Expected %s at offset %v but found %s, has the source changed?", member.Name, member.Offset, header.Name)
+	}
+	//The tar reader has read the headers and not a byte more
+	return header, io.NewSectionReader(source, member.Offset+position.pos, header.Size), nil
+}
+
+// linkCopy turns header, a hardlink to target, into the header of a regular
+// file of its own, returning it with target's data read from source
+func linkCopy(header *tar.Header, target NameAndSize, source io.ReaderAt) (*tar.Header, io.Reader, error) {
+	_, data, err := memberData(source, target)
+	if err != nil {
+		return nil, nil, err
+	}
+	file := *header
+	file.Typeflag = tar.TypeReg
+	file.Linkname = ""
+	file.Size = target.Size
+	return &file, data, nil
+}
+
+// writeLinkCopyAt reads the hardlink member at its offset in source and writes
+// it into s as a copy of target
+func writeLinkCopyAt(s *shard, source io.ReaderAt, member NameAndSize, target NameAndSize, opts Options, t *tally) error {
+	//The link is planned at the target's size, its header declares none
+	header, _, err := memberData(source, NameAndSize{Name: member.Name, Offset: member.Offset})
+	if err != nil {
+		return t.fail(member.Name, err, opts)
+	}
+	header, data, err := linkCopy(header, target, source)
+	if err != nil {
+		return t.fail(member.Name, err, opts)
+	}
+	return writeMember(s, header, data, opts, t)
+}
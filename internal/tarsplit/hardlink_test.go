@@ -0,0 +1,82 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestHardlinkCopies(t *testing.T) {
+	body := strings.Repeat("target data ", 300)
+	data := makeTar(t,
+		testMember{Name: "bin/busybox", Body: body},
+		testMember{Name: "filler", Body: strings.Repeat("f", 3000)},
+		testMember{Name: "bin/sh", Typeflag: tar.TypeLink, Linkname: "bin/busybox"},
+		testMember{Name: "bin/ls", Typeflag: tar.TypeLink, Linkname: "bin/busybox"},
+	)
+	dir := t.TempDir()
+	plain := writeFile(t, dir, "in.tar", data)
+	gzipped := writeFile(t, dir, "in.tar.gz", gzipBytes(t, data))
+	for _, filename := range []string{plain, gzipped} {
+		for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+			opts := testOptions(t)
+			opts.TargetSize = 4096
+			opts.TmpDir = t.TempDir()
+			opts.Strategy = strategy
+			opts.HardlinkCopies = true
+			result, err := Split(filename, opts)
+			if err != nil {
+				t.Fatalf("%s %s: %v", filename, name, err)
+			}
+			if len(result.Shards) < 3 {
+				t.Errorf("%s %s: expected each copy planned at the size of its target, got %v shards", filename, name, len(result.Shards))
+			}
+			entries := readShards(t, result)
+			for _, link := range []string{"bin/sh", "bin/ls"} {
+				entry, ok := entries[link]
+				switch {
+				case !ok:
+					t.Errorf("%s %s: expected %s in the shards", filename, name, link)
+				case entry.Typeflag != tar.TypeReg || entry.Linkname != "" || entry.Size != int64(len(body)):
+					t.Errorf("%s %s: expected %s a regular file of %v bytes, got type %q linked to %q of %v bytes", filename, name, link, len(body), entry.Typeflag, entry.Linkname, entry.Size)
+				case entry.Body != body:
+					t.Errorf("%s %s: expected %s holding the data of bin/busybox", filename, name, link)
+				}
+			}
+			if entries["bin/busybox"].Body != body {
+				t.Errorf("%s %s: expected bin/busybox copied as it was", filename, name)
+			}
+		}
+	}
+}
+
+func TestHardlinkCopiesUnresolved(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		members []testMember
+		message string
+	}{
+		{"missing target", []testMember{{Name: "link", Typeflag: tar.TypeLink, Linkname: "gone"}}, "not in the source"},
+		{"target not a file", []testMember{{Name: "dir/", Typeflag: tar.TypeDir}, {Name: "link", Typeflag: tar.TypeLink, Linkname: "dir/"}}, "not a regular file"},
+	} {
+		opts := testOptions(t)
+		opts.HardlinkCopies = true
+		_, err := SplitReader(bytes.NewReader(makeTar(t, test.members...)), "in.tar", opts)
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%s: expected an error saying %q, got %v", test.name, test.message, err)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testMember is a member of a tar built by makeTar, a regular file holding
+// Body unless Typeflag says otherwise
+type testMember struct {
+	Name     string
+	Body     string
+	Typeflag byte
+	Linkname string
+	// Devmajor and Devminor number a device node
+	Devmajor, Devminor int64
+}
+
+// makeTar is a tar holding members, in order
+func makeTar(tb testing.TB, members ...testMember) []byte {
+	tb.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, member := range members {
+		header := &tar.Header{Name: member.Name, Typeflag: member.Typeflag, Linkname: member.Linkname, Mode: 0644, Devmajor: member.Devmajor, Devminor: member.Devminor}
+		switch member.Typeflag {
+		case 0:
+			header.Typeflag = tar.TypeReg
+			header.Size = int64(len(member.Body))
+		case tar.TypeDir:
+			header.Mode = 0755
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			tb.Fatalf("Could not write header for %s, got error %v", member.Name, err)
+		}
+		if _, err := io.WriteString(tw, member.Body); err != nil {
+			tb.Fatalf("Could not write %s, got error %v", member.Name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		tb.Fatalf("Could not close tar, got error %v", err)
+	}
+	return buf.Bytes()
+}
+
+// genTar is a tar of n regular files of size bytes each, spread over
+// directories of a hundred, for benchmarks and anything else needing bulk
+func genTar(tb testing.TB, n int, size int64) []byte {
+	tb.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	body := bytes.Repeat([]byte{'x'}, int(size))
+	for i := 0; i < n; i++ {
+		header := &tar.Header{Name: fmt.Sprintf("d%04d/f%07d", i/100, i), Typeflag: tar.TypeReg, Size: size, Mode: 0644}
+		if err := tw.WriteHeader(header); err != nil {
+			tb.Fatalf("Could not write header %v, got error %v", i, err)
+		}
+		if _, err := tw.Write(body); err != nil {
+			tb.Fatalf("Could not write member %v, got error %v", i, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		tb.Fatalf("Could not close tar, got error %v", err)
+	}
+	return buf.Bytes()
+}
+
+// gzipBytes is data gzipped
+func gzipBytes(tb testing.TB, data []byte) []byte {
+	tb.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		tb.Fatalf("Could not gzip, got error %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		tb.Fatalf("Could not gzip, got error %v", err)
+	}
+	return buf.Bytes()
+}
+
+// writeFile writes data to name in dir, returning its path
+func writeFile(tb testing.TB, dir, name string, data []byte) string {
+	tb.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		tb.Fatalf("Could not write %s, got error %v", path, err)
+	}
+	return path
+}
+
+// tarEntry is a header read back from a tar with the data that follows it
+type tarEntry struct {
+	*tar.Header
+	Body string
+}
+
+// readTar reads back every entry of the tar at path, gunzipping it first when
+// it is gzipped
+func readTar(tb testing.TB, path string) []tarEntry {
+	tb.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		tb.Fatalf("Could not open %s, got error %v", path, err)
+	}
+	defer file.Close()
+	src, err := newSource(file, path)
+	if err != nil {
+		tb.Fatalf("Could not read %s, got error %v", path, err)
+	}
+	r, closeStream, err := src.stream()
+	if err != nil {
+		tb.Fatalf("Could not read %s, got error %v", path, err)
+	}
+	defer closeStream()
+	var entries []tarEntry
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			tb.Fatalf("Could not read %s, got error %v", path, err)
+		}
+		body, err := io.ReadAll(tr)
+		if err != nil {
+			tb.Fatalf("Could not read %s from %s, got error %v", header.Name, path, err)
+		}
+		entries = append(entries, tarEntry{Header: header, Body: string(body)})
+	}
+}
+
+// readShards reads back the entries of every shard in result, by name
+func readShards(tb testing.TB, result *Result) map[string]tarEntry {
+	tb.Helper()
+	entries := make(map[string]tarEntry)
+	for _, shard := range result.Shards {
+		for _, entry := range readTar(tb, shard.File) {
+			if _, ok := entries[entry.Name]; ok {
+				tb.Errorf("Member %s is in more than one shard", entry.Name)
+			}
+			entries[entry.Name] = entry
+		}
+	}
+	return entries
+}
+
+// countOpens counts how many times path is opened through openFile until the
+// test ends
+func countOpens(tb testing.TB, path string) *int {
+	tb.Helper()
+	opens := 0
+	open := openFile
+	openFile = func(name string) (*os.File, error) {
+		if name == path {
+			opens++
+		}
+		return open(name)
+	}
+	tb.Cleanup(func() {
+		openFile = open
+	})
+	return &opens
+}
+
+// testOptions are the options a split run by a test starts from, writing the
+// shards to a fresh temporary directory
+func testOptions(tb testing.TB) Options {
+	tb.Helper()
+	return Options{TargetSize: 1 << 20, CopyBuffer: DefaultCopyBuffer, outDir: tb.TempDir()}
+}
+
+// chdir changes the working directory to dir until the test ends, for what is
+// written relative to it
+func chdir(tb testing.TB, dir string) {
+	tb.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		os.Chdir(wd)
+	})
+}
@@ -0,0 +1,50 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"math/bits"
+)
+
+// Bucket counts the members with a size from Min up to, but not including, Max
+type Bucket struct {
+	Min   int64
+	Max   int64
+	Count int
+	// Bytes is the total size of the members in the bucket
+	Bytes int64
+}
+
+// Histogram buckets the members of data by size in power of two ranges, so
+// the first holds the empty members, then 1 byte, 2-3, 4-7 and so on. Every
+// range up to the biggest member is included, even when nothing falls in it
+func Histogram(data NameAndSizes) []Bucket {
+	var buckets []Bucket
+	for _, member := range data {
+		i := bits.Len64(uint64(member.Size))
+		for len(buckets) <= i {
+			buckets = append(buckets, bucketRange(len(buckets)))
+		}
+		buckets[i].Count++
+		buckets[i].Bytes += member.Size
+	}
+	return buckets
+}
+
+// bucketRange is the empty bucket i of a Histogram
+func bucketRange(i int) Bucket {
+	if i == 0 {
+		return Bucket{Min: 0, Max: 1}
+	}
+	return Bucket{Min: 1 << (i - 1), Max: 1 << i}
+}
@@ -0,0 +1,62 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	data := NameAndSizes{{Name: "empty"}, {Name: "one", Size: 1}, {Name: "two", Size: 2}, {Name: "three", Size: 3}, {Name: "four", Size: 4}}
+	for i := 0; i < 500; i++ {
+		data = append(data, NameAndSize{Name: fmt.Sprintf("f%03d", i), Size: r.Int63n(1 << uint(r.Intn(30)))})
+	}
+	buckets := Histogram(data)
+	var count int
+	var bytes, total int64
+	for i, bucket := range buckets {
+		if want := bucketRange(i); bucket.Min != want.Min || bucket.Max != want.Max {
+			t.Errorf("Expected bucket %v to run from %v to %v, got %v to %v", i, want.Min, want.Max, bucket.Min, bucket.Max)
+		}
+		count += bucket.Count
+		bytes += bucket.Bytes
+	}
+	for _, member := range data {
+		total += member.Size
+		bucket := buckets[bucketOf(member.Size)]
+		if member.Size < bucket.Min || member.Size >= bucket.Max {
+			t.Errorf("Expected %s of %v bytes in a bucket holding it, got %v to %v", member.Name, member.Size, bucket.Min, bucket.Max)
+		}
+	}
+	if count != len(data) || bytes != total {
+		t.Errorf("Expected the buckets to total %v members of %v bytes, got %v of %v", len(data), total, count, bytes)
+	}
+	if counts := []int{buckets[0].Count, buckets[1].Count, buckets[2].Count}; counts[0] < 1 || counts[1] < 1 || counts[2] < 2 {
+		t.Errorf("Expected the empty, 1 and 2-3 byte members in the first buckets, got %v", counts)
+	}
+	if len(Histogram(nil)) != 0 {
+		t.Error("Expected no buckets for no members")
+	}
+}
+
+// bucketOf is the index of the bucket a member of size bytes falls in
+func bucketOf(size int64) int {
+	i := 0
+	for ; size > 0; size >>= 1 {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,90 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// indexName is the member EmbedIndex writes at the head of each shard
+const indexName = "INDEX"
+
+// recordEmbedded marks the index member as written by the split rather than
+// being part of the source
+const recordEmbedded = "tarlayer.embedded"
+
+// checkEmbedIndex makes sure an index can be embedded in shards of data
+func checkEmbedIndex(data NameAndSizes, opts Options) error {
+	if opts.Format == tar.FormatUSTAR || opts.Format == tar.FormatGNU {
+		return fmt.Errorf("The embedded index is marked with a PAX record, it can't be written as %v", opts.Format)
+	}
+	for _, member := range data {
+		if strings.TrimPrefix(member.Name, "./") == indexName {
+			return fmt.Errorf("The sources already have a member %s, an embedded index would clash with it", member.Name)
+		}
+	}
+	return nil
+}
+
+// indexMembers are the members of plan that will be written to its shard, in
+// the order they will be written
+func indexMembers(plan Plan, keep map[string]bool, opts Options) NameAndSizes {
+	var members NameAndSizes
+	for _, member := range plan.Pool {
+		if isCarried(member.Typeflag) || keep[member.Name] {
+			members = append(members, member)
+		}
+	}
+	if opts.Order == OrderName {
+		sort.Sort(byName(members))
+	} else {
+		sort.Sort(bySource(members))
+	}
+	return members
+}
+
+// writeIndex writes the index member listing members, one name and size a
+// line with the size after the last space
+func writeIndex(tw *tar.Writer, members NameAndSizes, opts Options) error {
+	var text strings.Builder
+	for _, member := range members {
+		fmt.Fprintf(&text, "%s %v\n", member.Name, member.Size)
+	}
+	header := &tar.Header{
+		Typeflag:   tar.TypeReg,
+		Name:       indexName,
+		Mode:       0644,
+		Size:       int64(text.Len()),
+		ModTime:    time.Unix(0, 0),
+		Format:     tar.FormatPAX,
+		PAXRecords: map[string]string{recordEmbedded: "index"},
+	}
+	if !opts.ModTime.IsZero() {
+		header.ModTime = opts.ModTime
+	}
+	rewriteOwner(header, opts)
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write([]byte(text.String()))
+	return err
+}
+
+// isEmbeddedIndex reports whether header is an index written by EmbedIndex
+func isEmbeddedIndex(header *tar.Header) bool {
+	return header.PAXRecords[recordEmbedded] == "index"
+}
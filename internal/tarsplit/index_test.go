@@ -0,0 +1,117 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEmbedIndex(t *testing.T) {
+	members := []testMember{
+		{Name: "etc/", Typeflag: tar.TypeDir},
+		{Name: "etc/motd", Body: "hello"},
+		{Name: "lib", Typeflag: tar.TypeSymlink, Linkname: "usr/lib"},
+		{Name: "name with spaces", Body: strings.Repeat("s", 300)},
+	}
+	for i := 0; i < 12; i++ {
+		members = append(members, testMember{Name: fmt.Sprintf("usr/f%02d", i), Body: strings.Repeat("x", 500*(i%4+1))})
+	}
+	data := makeTar(t, members...)
+	for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+		for _, order := range []Order{OrderSource, OrderName} {
+			if order == OrderName && strategy == StrategySinglePass {
+				continue
+			}
+			opts := testOptions(t)
+			opts.TargetSize = 4096
+			opts.Strategy = strategy
+			opts.Order = order
+			opts.EmbedIndex = true
+			result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if len(result.Shards) < 2 {
+				t.Fatalf("%s: expected several shards, got %v", name, len(result.Shards))
+			}
+			for _, shard := range result.Shards {
+				entries := readTar(t, shard.File)
+				if len(entries) == 0 || entries[0].Name != indexName || !isEmbeddedIndex(entries[0].Header) {
+					t.Errorf("%s: expected shard %v to start with its index", name, shard.Index)
+					continue
+				}
+				var listed strings.Builder
+				for _, entry := range entries[1:] {
+					fmt.Fprintf(&listed, "%s %v\n", entry.Name, entry.Size)
+				}
+				if entries[0].Body != listed.String() {
+					t.Errorf("%s: expected shard %v indexed as\n%s\ngot\n%s", name, shard.Index, listed.String(), entries[0].Body)
+				}
+			}
+		}
+	}
+}
+
+func TestEmbedIndexRejected(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		members []testMember
+		format  tar.Format
+	}{
+		{"clash", []testMember{{Name: "INDEX", Body: "mine"}}, tar.FormatUnknown},
+		{"clash under ./", []testMember{{Name: "./INDEX", Body: "mine"}}, tar.FormatUnknown},
+		{"ustar", []testMember{{Name: "a", Body: "a"}}, tar.FormatUSTAR},
+		{"gnu", []testMember{{Name: "a", Body: "a"}}, tar.FormatGNU},
+	} {
+		opts := testOptions(t)
+		opts.EmbedIndex = true
+		opts.Format = test.format
+		if _, err := SplitReader(bytes.NewReader(makeTar(t, test.members...)), "in.tar", opts); err == nil {
+			t.Errorf("%s: expected the embedded index refused", test.name)
+		}
+	}
+}
+
+func TestEmbedIndexLeftOutOfMerge(t *testing.T) {
+	dir := t.TempDir()
+	source := writeFile(t, dir, "in.tar", genTar(t, 20, 1000))
+	opts := testOptions(t)
+	opts.TargetSize = 4096
+	opts.EmbedIndex = true
+	opts.Manifest = filepath.Join(dir, "in.json")
+	if _, err := Split(source, opts); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := ReadManifest(opts.Manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var merged bytes.Buffer
+	if err := Merge(nil, manifest, &merged); err != nil {
+		t.Fatal(err)
+	}
+	entries := readTar(t, writeFile(t, dir, "merged.tar", merged.Bytes()))
+	if len(entries) != 20 {
+		t.Errorf("Expected the 20 source members merged, got %v", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.Name == indexName {
+			t.Errorf("Expected no index in the merged tar")
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInterrupt(t *testing.T) {
+	dir := t.TempDir()
+	source := writeFile(t, dir, "in.tar", genTar(t, 30, 1000))
+	for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+		opts := testOptions(t)
+		opts.TargetSize = 4096
+		opts.Strategy = strategy
+		opts.Manifest = filepath.Join(t.TempDir(), "in.json")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		opts.Context = ctx
+		//As if interrupted once the first shard is done
+		opts.OnShardFinish = func(ShardResult) { cancel() }
+		if _, err := Split(source, opts); !errors.Is(err, ErrInterrupted) {
+			t.Fatalf("%s: expected the split interrupted, got %v", name, err)
+		}
+
+		manifest, err := ReadManifest(opts.Manifest)
+		if err != nil {
+			t.Fatalf("%s: expected the manifest written when interrupted, got error %v", name, err)
+		}
+		if !manifest.Interrupted {
+			t.Errorf("%s: expected the manifest marked interrupted", name)
+		}
+		done := 0
+		for _, shard := range manifest.Shards {
+			if shard.Size == 0 {
+				//Shards not written are named as in the current directory
+				if _, err := os.Stat(filepath.Join(opts.outDir, shard.File)); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("%s: expected no file for unfinished shard %v, got error %v", name, shard.Index, err)
+				}
+				continue
+			}
+			done++
+			entries := readTar(t, shard.File)
+			if len(entries) != len(shard.Members) {
+				t.Errorf("%s: expected finished shard %v to hold its %v members, got %v", name, shard.Index, len(shard.Members), len(entries))
+			}
+		}
+		if done == 0 || done == len(manifest.Shards) {
+			t.Errorf("%s: expected some but not all of %v shards finished, got %v", name, len(manifest.Shards), done)
+		}
+		files, _ := os.ReadDir(opts.outDir)
+		for _, file := range files {
+			if strings.HasSuffix(file.Name(), tmpSuffix) {
+				t.Errorf("%s: expected no half written shard left, got %s", name, file.Name())
+			}
+		}
+
+		//Resuming finishes the split
+		opts.Context, opts.OnShardFinish = nil, nil
+		opts.Resume, opts.Manifest = opts.Manifest, ""
+		if _, err := Split(source, opts); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if manifest, err = ReadManifest(opts.Resume); err != nil {
+			t.Fatal(err)
+		}
+		members := 0
+		for _, shard := range manifest.Shards {
+			members += len(readTar(t, shard.File))
+		}
+		if members != 30 {
+			t.Errorf("%s: expected all 30 members split once resumed, got %v", name, members)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// joiner collects the parts of split files met while merging, to join them
+// back together once every shard has been read
+type joiner struct {
+	// files are the parts found of each file, names the files in the order
+	// they were first met
+	files map[string][]mergePart
+	names []string
+}
+
+// mergePart is a part of a split file and the shard holding it
+type mergePart struct {
+	header *tar.Header
+	shard  string
+	offset int64
+	total  int64
+}
+
+func newJoiner() *joiner {
+	return &joiner{files: make(map[string][]mergePart)}
+}
+
+// add notes header when it is a part of a split file, held in shard, and
+// reports whether it was one
+func (j *joiner) add(header *tar.Header, shard string) (bool, error) {
+	of, offset, total, ok, err := partRecords(header)
+	if !ok || err != nil {
+		return ok, err
+	}
+	if _, seen := j.files[of]; !seen {
+		j.names = append(j.names, of)
+	}
+	j.files[of] = append(j.files[of], mergePart{header: header, shard: shard, offset: offset, total: total})
+	return true, nil
+}
+
+// join writes each split file into tw, copying its parts in order from the
+// shards they were found in
+func (j *joiner) join(tw *tar.Writer) error {
+	for _, name := range j.names {
+		parts := j.files[name]
+		sort.Slice(parts, func(a, b int) bool {
+			return parts[a].offset < parts[b].offset
+		})
+		var next int64
+		for _, part := range parts {
+			if part.offset != next || part.total != parts[0].total {
+				return fmt.Errorf("Parts of %s are missing or overlap at byte %v, merge every shard holding one", name, next)
+			}
+			next += part.header.Size
+		}
+		if next != parts[0].total {
+			return fmt.Errorf("Parts of %s are missing from byte %v, merge every shard holding one", name, next)
+		}
+
+		header := *parts[0].header
+		header.Name = name
+		header.Size = parts[0].total
+		header.PAXRecords = make(map[string]string)
+		for key, value := range parts[0].header.PAXRecords {
+			header.PAXRecords[key] = value
+		}
+		for _, key := range []string{recordPartOf, recordPartOffset, recordPartTotal} {
+			delete(header.PAXRecords, key)
+		}
+		if err := checkName(name, parts[0].shard); err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(&header); err != nil {
+			return fmt.Errorf("Could not write header for %s, got error %w", name, err)
+		}
+		for _, part := range parts {
+			if err := copyPart(tw, part); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// copyPart finds part in its shard again and copies its data into tw
+func copyPart(tw *tar.Writer, part mergePart) error {
+	file, err := openSource(part.shard)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	src, err := newSource(file, part.shard)
+	if err != nil {
+		return err
+	}
+	r, closeStream, err := src.stream()
+	if err != nil {
+		return err
+	}
+	defer closeStream()
+
+	tr := tar.NewReader(newPositionReader(r))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return fmt.Errorf("Could not find %s in shard %s again", part.header.Name, part.shard)
+		}
+		if err != nil {
+			return fmt.Errorf("Could not read shard %s, got error %w", part.shard, err)
+		}
+		if header.Name != part.header.Name {
+			continue
+		}
+		n, err := io.Copy(tw, io.LimitReader(tr, part.header.Size))
+		if err != nil {
+			return fmt.Errorf("Could not copy %s from shard %s, got error %w", header.Name, part.shard, err)
+		}
+		if n != part.header.Size {
+			return fmt.Errorf("Part %s in shard %s is short, %v of %v bytes", header.Name, part.shard, n, part.header.Size)
+		}
+		return nil
+	}
+}
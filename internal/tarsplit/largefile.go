@@ -0,0 +1,205 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// The PAX records marking a part of a split file, with the name of the file,
+// where in it the part starts and the size of the whole file
+const (
+	recordPartOf     = "tarlayer.part.of"
+	recordPartOffset = "tarlayer.part.offset"
+	recordPartTotal  = "tarlayer.part.total"
+)
+
+// SplitFile is a member too big for any shard that SplitLargeFiles cut into
+// parts, each a member of its own
+type SplitFile struct {
+	Name  string     `json:"name"`
+	Size  int64      `json:"size"`
+	Parts []FilePart `json:"parts"`
+}
+
+// FilePart is the bytes of a SplitFile from Offset for Size bytes, stored as
+// the member Name
+type FilePart struct {
+	Name   string `json:"name"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+}
+
+// partName is the name of part i of the member name
+func partName(name string, i int) string {
+	return fmt.Sprintf("%s.part%04d", name, i)
+}
+
+// splitLargeFiles replaces each regular member of data bigger than the
+// smallest target with parts small enough for a shard of their own, keyed by
+// the member's name in the map returned
+func splitLargeFiles(data NameAndSizes, opts Options) (NameAndSizes, map[string]*SplitFile, error) {
+	if opts.NumShards > 0 {
+		return nil, nil, fmt.Errorf("Splitting large files needs a target size rather than a number of shards")
+	}
+	if opts.Format == tar.FormatUSTAR || opts.Format == tar.FormatGNU {
+		return nil, nil, fmt.Errorf("The parts of a split file are marked with PAX records, they can't be written as %v", opts.Format)
+	}
+	targets, err := planTargets(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	//Chunks are sized to the smallest target so a part fits in every shard,
+	//leaving room for the part's own header, its PAX records and the trailer
+	smallest := targets[0]
+	for _, target := range targets {
+		if target < smallest {
+			smallest = target
+		}
+	}
+	chunk := (smallest - 4*blockSize) / blockSize * blockSize
+	if chunk < blockSize {
+		return nil, nil, invalidTargetf("Target size of %v bytes is too small to split large files into", smallest)
+	}
+
+	names := make(map[string]bool, len(data))
+	for _, member := range data {
+		names[member.Name] = true
+	}
+	files := make(map[string]*SplitFile)
+	var split NameAndSizes
+	for _, member := range data {
+		if member.Typeflag != tar.TypeReg || member.Size <= smallest {
+			split = append(split, member)
+			continue
+		}
+		if member.Offset < 0 {
+			return nil, nil, fmt.Errorf("Could not split %s, it follows a sparse file so its offset is unknown", member.Name)
+		}
+		file := &SplitFile{Name: member.Name, Size: member.Size}
+		for offset := int64(0); offset < member.Size; offset += chunk {
+			part := FilePart{Name: partName(member.Name, len(file.Parts)), Offset: offset, Size: chunk}
+			if part.Size > member.Size-offset {
+				part.Size = member.Size - offset
+			}
+			if names[part.Name] {
+				return nil, nil, fmt.Errorf("The sources already have a member %s, a part of %s would clash with it", part.Name, member.Name)
+			}
+			file.Parts = append(file.Parts, part)
+			split = append(split, NameAndSize{Name: part.Name, Size: part.Size, Offset: member.Offset, Source: member.Source, Typeflag: member.Typeflag})
+		}
+		files[member.Name] = file
+	}
+	return split, files, nil
+}
+
+// partHeader is the header for part of the member header describes
+func partHeader(header *tar.Header, part FilePart) *tar.Header {
+	h := *header
+	h.Name = part.Name
+	h.Size = part.Size
+	h.Format = tar.FormatPAX
+	h.PAXRecords = make(map[string]string, len(header.PAXRecords)+3)
+	for key, value := range header.PAXRecords {
+		h.PAXRecords[key] = value
+	}
+	h.PAXRecords[recordPartOf] = header.Name
+	h.PAXRecords[recordPartOffset] = strconv.FormatInt(part.Offset, 10)
+	h.PAXRecords[recordPartTotal] = strconv.FormatInt(header.Size, 10)
+	return &h
+}
+
+// writeParts copies the data of the member header describes, read from r, into
+// the shards each of its parts is planned for, passing over any part that
+// isn't planned, like one an earlier split already has
+func writeParts(shards map[string]*shard, header *tar.Header, r io.Reader, file *SplitFile, opts Options, t *tally) error {
+	for _, part := range file.Parts {
+		data := io.LimitReader(r, part.Size)
+		if s := shards[part.Name]; s != nil {
+			if err := writeMember(s, partHeader(header, part), data, opts, t); err != nil {
+				return err
+			}
+			if s.remaining--; s.remaining == 0 {
+				if err := s.close(opts, t); err != nil {
+					return err
+				}
+			}
+		}
+		//Whatever of the part wasn't copied, so the next starts where it should
+		if _, err := io.Copy(io.Discard, data); err != nil {
+			return fmt.Errorf("Could not read %s, got error %w", part.Name, err)
+		}
+	}
+	return nil
+}
+
+// splitPart is a part along with the file it is a part of
+type splitPart struct {
+	file *SplitFile
+	part FilePart
+}
+
+// partsByName indexes the parts of files by their member name
+func partsByName(files map[string]*SplitFile) map[string]splitPart {
+	parts := make(map[string]splitPart)
+	for _, file := range files {
+		for _, part := range file.Parts {
+			parts[part.Name] = splitPart{file: file, part: part}
+		}
+	}
+	return parts
+}
+
+// writePartAt copies part of the member at offset in source into s. It seeks
+// straight to the part rather than reading through the data before it
+func writePartAt(s *shard, source io.ReaderAt, offset int64, p splitPart, opts Options, t *tally) error {
+	header, data, err := memberData(source, NameAndSize{Name: p.file.Name, Size: p.file.Size, Offset: offset})
+	if err != nil {
+		return t.fail(p.part.Name, err, opts)
+	}
+	part := io.NewSectionReader(data, p.part.Offset, p.part.Size)
+	return writeMember(s, partHeader(header, p.part), part, opts, t)
+}
+
+// partRecords reads the part records of header, ok is false when it isn't a
+// part of a split file
+func partRecords(header *tar.Header) (of string, offset, total int64, ok bool, err error) {
+	of, ok = header.PAXRecords[recordPartOf]
+	if !ok {
+		return "", 0, 0, false, nil
+	}
+	offset, err = strconv.ParseInt(header.PAXRecords[recordPartOffset], 10, 64)
+	if err == nil {
+		total, err = strconv.ParseInt(header.PAXRecords[recordPartTotal], 10, 64)
+	}
+	if err != nil || offset < 0 || total < offset+header.Size {
+		return "", 0, 0, true, fmt.Errorf("Part %s has malformed records for %s", header.Name, of)
+	}
+	return of, offset, total, true, nil
+}
+
+// splitFileList is files sorted by name, for the manifest
+func splitFileList(files map[string]*SplitFile) []SplitFile {
+	var list []SplitFile
+	for _, file := range files {
+		list = append(list, *file)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
@@ -0,0 +1,56 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitLargeFilesRoundTrip(t *testing.T) {
+	big := strings.Repeat("0123456789", 2000)
+	data := makeTar(t, testMember{Name: "small", Body: "tiny"}, testMember{Name: "big", Body: big})
+	for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+		t.Run(name, func(t *testing.T) {
+			opts := testOptions(t)
+			opts.TargetSize = 8 * 1024
+			opts.SplitLargeFiles = true
+			opts.Strategy = strategy
+			opts.Manifest = filepath.Join(t.TempDir(), "manifest.json")
+			result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Shards) < 3 {
+				t.Errorf("Expected the big member cut over several shards, got %v", len(result.Shards))
+			}
+			manifest, err := ReadManifest(opts.Manifest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var merged bytes.Buffer
+			if err := Merge(nil, manifest, &merged); err != nil {
+				t.Fatal(err)
+			}
+			entries := make(map[string]string)
+			for _, entry := range readTar(t, writeFile(t, t.TempDir(), "merged.tar", merged.Bytes())) {
+				entries[entry.Name] = entry.Body
+			}
+			if entries["big"] != big || entries["small"] != "tiny" {
+				t.Errorf("Expected the members back as they were, got %v bytes of big and %q", len(entries["big"]), entries["small"])
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Layout is how the shard files are arranged
+type Layout int
+
+const (
+	// LayoutFlat writes the shards side by side, 0-layer.tar, 1-layer.tar
+	LayoutFlat Layout = iota
+	// LayoutSubdir writes each shard into a directory of its own,
+	// shard-0000/layer.tar, shard-0001/layer.tar
+	LayoutSubdir
+	// LayoutContainer wraps the shards up as the members of one outer tar,
+	// shards-layer.tar. They are written to TmpDir first, which needs room for
+	// all of them
+	LayoutContainer
+)
+
+// subdirWidth is how many digits LayoutSubdir pads the shard directories to
+// when IndexWidth doesn't ask for more
+const subdirWidth = 4
+
+// containerName is the outer tar LayoutContainer writes the shards of the
+// source named fn into
+func containerName(fn string) string {
+	return "shards-" + fn
+}
+
+// writeContainer writes the shards, which were written under dir, into a tar
+// named name as members named by their path within dir. The shards' File
+// becomes that member name
+func writeContainer(name, dir string, shards []ShardResult) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("Could not create container %s, got error %w", name, err)
+	}
+	defer file.Close()
+	tw := tar.NewWriter(file)
+	for i := range shards {
+		member, err := filepath.Rel(dir, shards[i].File)
+		if err != nil {
+			return err
+		}
+		member = filepath.ToSlash(member)
+		if err := addContainerMember(tw, member, shards[i].File); err != nil {
+			return fmt.Errorf("Could not add shard %v to container %s, got error %w", shards[i].Index, name, err)
+		}
+		shards[i].File = member
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("Could not write trailer for container %s, got error %w", name, err)
+	}
+	return file.Close()
+}
+
+// addContainerMember copies the shard at path into tw as member
+func addContainerMember(tw *tar.Writer, member, path string) error {
+	shard, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer shard.Close()
+	fi, err := shard.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return err
+	}
+	header.Name = member
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, shard)
+	return err
+}
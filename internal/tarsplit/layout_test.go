@@ -0,0 +1,89 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLayoutSubdir(t *testing.T) {
+	opts := testOptions(t)
+	opts.TargetSize = 4096
+	opts.Layout = LayoutSubdir
+	result, err := SplitReader(bytes.NewReader(genTar(t, 9, 1000)), "layer.tar", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Shards) < 2 {
+		t.Fatalf("Expected several shards, got %v", len(result.Shards))
+	}
+	for _, shard := range result.Shards {
+		want := filepath.Join(opts.outDir, fmt.Sprintf("shard-%04d", shard.Index), "layer.tar")
+		if shard.File != want {
+			t.Errorf("Expected shard %v at %s, got %s", shard.Index, want, shard.File)
+		}
+		if entries := readTar(t, shard.File); len(entries) != shard.Members {
+			t.Errorf("Expected shard %v to hold %v members, got %v", shard.Index, shard.Members, len(entries))
+		}
+	}
+	entries, err := os.ReadDir(opts.outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(result.Shards) {
+		t.Errorf("Expected only the %v shard directories, got %v entries", len(result.Shards), len(entries))
+	}
+}
+
+func TestLayoutContainer(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	opts := testOptions(t)
+	opts.TargetSize = 4096
+	opts.Layout = LayoutContainer
+	opts.TmpDir = t.TempDir()
+	result, err := SplitReader(bytes.NewReader(genTar(t, 9, 1000)), "layer.tar", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Shards) < 2 {
+		t.Fatalf("Expected several shards, got %v", len(result.Shards))
+	}
+	outer := readTar(t, filepath.Join(dir, "shards-layer.tar"))
+	if len(outer) != len(result.Shards) {
+		t.Fatalf("Expected the container to hold the %v shards, got %v members", len(result.Shards), len(outer))
+	}
+	members := make(map[string]tarEntry)
+	for _, entry := range outer {
+		members[entry.Name] = entry
+	}
+	for _, shard := range result.Shards {
+		inner, ok := members[shard.File]
+		if !ok {
+			t.Errorf("Expected shard %v in the container as %s", shard.Index, shard.File)
+			continue
+		}
+		path := writeFile(t, t.TempDir(), "shard.tar", []byte(inner.Body))
+		if entries := readTar(t, path); len(entries) != shard.Members {
+			t.Errorf("Expected shard %v to hold %v members, got %v", shard.Index, shard.Members, len(entries))
+		}
+	}
+	//The shards were only written to TmpDir on the way
+	if entries, _ := os.ReadDir(opts.TmpDir); len(entries) > 0 {
+		t.Errorf("Expected nothing left in the temporary directory, got %v entries", len(entries))
+	}
+}
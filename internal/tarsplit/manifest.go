@@ -0,0 +1,213 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"fmt"
+	"os"
+)
+
+// Manifest records which members went into which shard of a split
+type Manifest struct {
+	Sources []string `json:"sources"`
+	// SourceDigests are the digests of Sources, when recorded
+	SourceDigests []string `json:"source_digests,omitempty"`
+	// AverageFill is the mean Fill of the shards that have one
+	AverageFill float64 `json:"average_fill,omitempty"`
+	// Container is the tar the shards are members of, when they were written
+	// into one, their File being the member name
+	Container string `json:"container,omitempty"`
+	// Partial is set when Limit left members of the sources out of the split
+	Partial bool `json:"partial,omitempty"`
+	// Census counts the entries of the sources by kind
+	Census map[string]int `json:"census,omitempty"`
+	// Labels are the labels every shard was stamped with
+	Labels map[string]string `json:"labels,omitempty"`
+	// Interrupted is set when the split was stopped before it was done, the
+	// shards with no Size weren't written
+	Interrupted bool `json:"interrupted,omitempty"`
+	// SplitFiles lists the members cut into parts by SplitLargeFiles and
+	// where each part goes in them
+	SplitFiles []SplitFile `json:"split_files,omitempty"`
+	// Duplicates are the members with the same content as a member in an
+	// earlier shard, by name, to that member, with DedupeAcrossShards
+	Duplicates map[string]string `json:"duplicates,omitempty"`
+	// Run describes the copying done by the split that last wrote the
+	// manifest, only the shards it added when appending
+	Run    *RunStats       `json:"run,omitempty"`
+	Shards []ManifestShard `json:"shards"`
+}
+
+// RunStats is how much a split wrote and how long it took
+type RunStats struct {
+	Bytes          int64   `json:"bytes"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+}
+
+type ManifestShard struct {
+	Index int    `json:"index"`
+	File  string `json:"file"`
+	// Digest is the shard's content digest, when it was computed
+	Digest string `json:"digest,omitempty"`
+	// Size is the size of the shard's file, once it was written
+	Size int64 `json:"size,omitempty"`
+	// Fill is how full the shard was planned relative to the target size
+	Fill    float64      `json:"fill,omitempty"`
+	Members NameAndSizes `json:"members"`
+	// Linked are the members DedupeAcrossShards wrote as hardlinks, by name,
+	// to the member in the shard with the same content. They are listed in
+	// Members with the size of that content
+	Linked map[string]string `json:"linked,omitempty"`
+}
+
+// newManifest describes the shards written for plans, as shards reports them
+func newManifest(filenames []string, fn string, plans []Plan, shards []ShardResult, opts Options) *Manifest {
+	written := make(map[int]ShardResult)
+	for _, shard := range shards {
+		written[shard.Index] = shard
+	}
+	manifest := &Manifest{Sources: filenames}
+	for _, plan := range plans {
+		shard, ok := written[plan.Index]
+		if !ok {
+			shard.File = shardName(plan.Index, fn, opts)
+		}
+		manifest.Shards = append(manifest.Shards, ManifestShard{
+			Index:   plan.Index,
+			File:    shard.File,
+			Digest:  shard.Digest,
+			Size:    shard.Size,
+			Fill:    fillRatio(plan),
+			Members: plan.Pool,
+			Linked:  opts.dedupe.shardLinks(plan),
+		})
+	}
+	if opts.dedupe != nil && len(opts.dedupe.elsewhere) > 0 {
+		manifest.Duplicates = opts.dedupe.elsewhere
+	}
+	return manifest
+}
+
+// plansFromManifest rebuilds the plans m records for the members scanned in
+// data, which must be exactly the members it lists
+func plansFromManifest(m *Manifest, data NameAndSizes) ([]Plan, error) {
+	scanned := make(map[string]NameAndSize, len(data))
+	for _, member := range data {
+		scanned[member.Name] = member
+	}
+	planned := make(map[string]bool, len(data))
+	plans := make([]Plan, 0, len(m.Shards))
+	for _, shard := range m.Shards {
+		plan := Plan{Index: shard.Index}
+		for _, listed := range shard.Members {
+			member, ok := scanned[listed.Name]
+			switch {
+			case !ok:
+				return nil, fmt.Errorf("Member %s is planned but not in the sources", listed.Name)
+			case member.Size != listed.Size:
+				return nil, fmt.Errorf("Member %s is planned with %v bytes but has %v in the sources", listed.Name, listed.Size, member.Size)
+			case planned[listed.Name]:
+				return nil, fmt.Errorf("Member %s is planned more than once", listed.Name)
+			}
+			planned[listed.Name] = true
+			plan.Pool = append(plan.Pool, member)
+		}
+		plans = append(plans, plan)
+	}
+	for _, member := range data {
+		if !planned[member.Name] {
+			return nil, fmt.Errorf("Member %s is in the sources but not planned", member.Name)
+		}
+	}
+	return plans, nil
+}
+
+// averageFill is the mean Fill of the shards that have one
+func (m *Manifest) averageFill() float64 {
+	var total float64
+	var n int
+	for _, shard := range m.Shards {
+		if shard.Fill > 0 {
+			total += shard.Fill
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return total / float64(n)
+}
+
+// nextIndex is the index the first shard added to the set should get, first
+// when the set is empty
+func (m *Manifest) nextIndex(first int) int {
+	next := first
+	for _, shard := range m.Shards {
+		if shard.Index >= next {
+			next = shard.Index + 1
+		}
+	}
+	return next
+}
+
+// shardResults describes the shards m lists as a split would report them
+func (m *Manifest) shardResults() []ShardResult {
+	shards := make([]ShardResult, len(m.Shards))
+	for i, shard := range m.Shards {
+		shards[i] = ShardResult{Index: shard.Index, File: shard.File, Members: len(shard.Members), Size: shard.Size, Fill: shard.Fill, Digest: shard.Digest}
+	}
+	return shards
+}
+
+// members is the set of member names already in the split set
+func (m *Manifest) members() map[string]bool {
+	names := make(map[string]bool)
+	for _, shard := range m.Shards {
+		for _, member := range shard.Members {
+			names[member.Name] = true
+		}
+	}
+	return names
+}
+
+// ReadManifest reads the manifest at path, in whichever ManifestFormat it was
+// written
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read manifest %s, got error %w", path, err)
+	}
+	manifest, err := decodeManifest(data)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse manifest %s, got error %w", path, err)
+	}
+	return manifest, nil
+}
+
+// writeManifest saves manifest to path in format, or prints it to stdout for
+// Stdout
+func writeManifest(path string, manifest *Manifest, format ManifestFormat) error {
+	data, err := encodeManifest(manifest, format)
+	if err != nil {
+		return err
+	}
+	if path == Stdout {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("Could not write manifest %s, got error %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestAppendTo(t *testing.T) {
+	dir := t.TempDir()
+	first := []testMember{{Name: "a", Body: "first a"}, {Name: "b", Body: "first b"}}
+	opts := testOptions(t)
+	opts.Manifest = filepath.Join(dir, "in.json")
+	before, err := Split(writeFile(t, dir, "in.tar", makeTar(t, first...)), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := make(map[string][]byte)
+	for _, shard := range before.Shards {
+		if old[shard.File], err = os.ReadFile(shard.File); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//The source gained c and d, a and b are as they were
+	added := append(first, testMember{Name: "c", Body: "added c"}, testMember{Name: "d", Body: "added d"})
+	appendOpts := opts
+	appendOpts.Manifest = ""
+	appendOpts.AppendTo = opts.Manifest
+	after, err := Split(writeFile(t, dir, "in.tar", makeTar(t, added...)), appendOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, data := range old {
+		if now, err := os.ReadFile(path); err != nil || !bytes.Equal(now, data) {
+			t.Errorf("Expected %s untouched, got error %v", path, err)
+		}
+	}
+	entries := readShards(t, after)
+	if len(entries) != 2 || entries["c"].Body != "added c" || entries["d"].Body != "added d" {
+		t.Errorf("Expected only the added members in new shards, got %v", entries)
+	}
+	for _, shard := range after.Shards {
+		if shard.Index < len(before.Shards) {
+			t.Errorf("Expected new shards numbered after the %v existing, got %v", len(before.Shards), shard.Index)
+		}
+	}
+	manifest, err := ReadManifest(opts.Manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := len(before.Shards) + len(after.Shards); len(manifest.Shards) != want {
+		t.Errorf("Expected the manifest to list all %v shards, got %v", want, len(manifest.Shards))
+	}
+}
+
+func TestExportImportPlan(t *testing.T) {
+	dir := t.TempDir()
+	var members []testMember
+	for i := 0; i < 10; i++ {
+		members = append(members, testMember{Name: fmt.Sprintf("f%v", i), Body: strings.Repeat("a", 500*(i+1))})
+	}
+	source := writeFile(t, dir, "in.tar", makeTar(t, members...))
+	opts := testOptions(t)
+	opts.TargetSize = 8000
+	opts.ExportPlan = filepath.Join(dir, "plan.json")
+	if _, err := Split(source, opts); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(opts.outDir); len(entries) > 0 {
+		t.Errorf("Expected exporting the plan to write no shards, got %v files", len(entries))
+	}
+	plan, err := ReadManifest(opts.ExportPlan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Shards) < 2 {
+		t.Fatalf("Expected a plan of several shards, got %v", len(plan.Shards))
+	}
+
+	//The plan wins over the target size it is copied with
+	opts = testOptions(t)
+	opts.ImportPlan = filepath.Join(dir, "plan.json")
+	result, err := Split(source, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Shards) != len(plan.Shards) {
+		t.Fatalf("Expected the %v planned shards, got %v", len(plan.Shards), len(result.Shards))
+	}
+	byIndex := make(map[int]ShardResult)
+	for _, shard := range result.Shards {
+		byIndex[shard.Index] = shard
+	}
+	for _, planned := range plan.Shards {
+		var want, got []string
+		for _, member := range planned.Members {
+			want = append(want, member.Name)
+		}
+		for _, entry := range readTar(t, byIndex[planned.Index].File) {
+			got = append(got, entry.Name)
+		}
+		sort.Strings(want)
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("Expected shard %v to hold %v as planned, got %v", planned.Index, want, got)
+		}
+	}
+
+	//A source the plan doesn't match is refused before anything is written
+	other := writeFile(t, dir, "other.tar", makeTar(t, append(members, testMember{Name: "extra", Body: "x"})...))
+	opts = testOptions(t)
+	opts.ImportPlan = filepath.Join(dir, "plan.json")
+	if _, err := Split(other, opts); err == nil || !strings.Contains(err.Error(), "Member extra is in the sources but not planned") {
+		t.Errorf("Expected a member missing from the plan to be an error, got %v", err)
+	}
+	if entries, _ := os.ReadDir(opts.outDir); len(entries) > 0 {
+		t.Errorf("Expected no shards written for a plan that doesn't match, got %v files", len(entries))
+	}
+}
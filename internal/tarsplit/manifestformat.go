@@ -0,0 +1,413 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ManifestFormat is how a manifest is written out
+type ManifestFormat int
+
+const (
+	// ManifestJSON writes the manifest as indented JSON
+	ManifestJSON ManifestFormat = iota
+	// ManifestCSV writes a row for every member, with the shard it is in.
+	// Only the shards and their members fit in rows, so the rest of the
+	// manifest, like the sources and any split files, is left out. It is for
+	// other tools to read and can't be read back
+	ManifestCSV
+	// ManifestYAML writes the manifest as YAML, with the same fields as JSON
+	ManifestYAML
+)
+
+// csvHeader is the first row of a CSV manifest, a shard without members has
+// a row of its own with the member columns empty
+var csvHeader = []string{"index", "file", "digest", "size", "fill", "member", "member_size"}
+
+// manifestFormatOf tells which format the manifest data is in, JSON starting
+// with a brace, CSV with csvHeader, and YAML otherwise
+func manifestFormatOf(data []byte) ManifestFormat {
+	text := bytes.TrimLeft(data, " \t\r\n")
+	switch {
+	case bytes.HasPrefix(text, []byte("{")):
+		return ManifestJSON
+	case bytes.HasPrefix(text, []byte(strings.Join(csvHeader, ",")+"\n")), bytes.HasPrefix(text, []byte(strings.Join(csvHeader, ",")+"\r\n")):
+		return ManifestCSV
+	}
+	return ManifestYAML
+}
+
+// encodeManifest writes out manifest in format
+func encodeManifest(manifest *Manifest, format ManifestFormat) ([]byte, error) {
+	if format == ManifestCSV {
+		return encodeManifestCSV(manifest)
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if format == ManifestYAML {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		node, err := decodeJSONNode(dec)
+		if err != nil {
+			return nil, err
+		}
+		var b strings.Builder
+		node.writeYAML(&b, 0)
+		return []byte(b.String()), nil
+	}
+	return append(data, '\n'), nil
+}
+
+// decodeManifest reads a manifest written as JSON or YAML, telling which by
+// how it starts. A CSV manifest is refused, it leaves too much out to split
+// by or append to
+func decodeManifest(data []byte) (*Manifest, error) {
+	manifest := &Manifest{}
+	switch manifestFormatOf(data) {
+	case ManifestJSON:
+		err := json.Unmarshal(data, manifest)
+		return manifest, err
+	case ManifestCSV:
+		return nil, fmt.Errorf("Manifest is CSV, which only has the shards and their members, write it as json or yaml to read it back")
+	}
+	node, err := parseYAML(string(data))
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	node.writeJSON(&b)
+	err = json.Unmarshal(b.Bytes(), manifest)
+	return manifest, err
+}
+
+func encodeManifestCSV(manifest *Manifest) ([]byte, error) {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Write(csvHeader)
+	for _, shard := range manifest.Shards {
+		row := []string{
+			strconv.Itoa(shard.Index),
+			shard.File,
+			shard.Digest,
+			strconv.FormatInt(shard.Size, 10),
+			strconv.FormatFloat(shard.Fill, 'g', -1, 64),
+		}
+		if len(shard.Members) == 0 {
+			w.Write(append(row, "", ""))
+		}
+		for _, member := range shard.Members {
+			w.Write(append(row[:5:5], member.Name, strconv.FormatInt(member.Size, 10)))
+		}
+	}
+	w.Flush()
+	return b.Bytes(), w.Error()
+}
+
+// yamlNode is a JSON value kept in the order it was written, to carry a
+// manifest between JSON and YAML without losing the order of its fields
+type yamlNode struct {
+	// scalar is the JSON text of a string, number, boolean or null
+	scalar string
+	isMap  bool
+	isList bool
+	keys   []string
+	values []*yamlNode
+}
+
+// decodeJSONNode reads the next value from dec, which must use numbers
+func decodeJSONNode(dec *json.Decoder) (*yamlNode, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token := token.(type) {
+	case json.Delim:
+		node := &yamlNode{isMap: token == '{', isList: token == '['}
+		for dec.More() {
+			if node.isMap {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				node.keys = append(node.keys, key.(string))
+			}
+			value, err := decodeJSONNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			node.values = append(node.values, value)
+		}
+		//The closing delimiter
+		_, err := dec.Token()
+		return node, err
+	case string:
+		text, err := json.Marshal(token)
+		return &yamlNode{scalar: string(text)}, err
+	case json.Number:
+		return &yamlNode{scalar: token.String()}, nil
+	case bool:
+		return &yamlNode{scalar: strconv.FormatBool(token)}, nil
+	}
+	return &yamlNode{scalar: "null"}, nil
+}
+
+// inline is how the node is written on the same line as its key or dash, or
+// empty when it takes lines of its own
+func (n *yamlNode) inline() string {
+	switch {
+	case n.isMap && len(n.values) == 0:
+		return "{}"
+	case n.isList && len(n.values) == 0:
+		return "[]"
+	case n.isMap || n.isList:
+		return ""
+	}
+	return n.scalar
+}
+
+// plainKey matches the map keys written without quotes. Words YAML 1.1 reads
+// as booleans or null are quoted as well
+var plainKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+func yamlKey(key string) string {
+	switch strings.ToLower(key) {
+	case "y", "n", "yes", "no", "on", "off", "true", "false", "null":
+	default:
+		if plainKey.MatchString(key) {
+			return key
+		}
+	}
+	text, _ := json.Marshal(key)
+	return string(text)
+}
+
+// writeYAML writes the node as block YAML indented by indent spaces. Strings
+// are double quoted the way JSON quotes them, which YAML reads the same
+func (n *yamlNode) writeYAML(b *strings.Builder, indent int) {
+	pad := strings.Repeat(" ", indent)
+	if !n.isMap && !n.isList {
+		b.WriteString(pad + n.scalar + "\n")
+		return
+	}
+	for i, value := range n.values {
+		var lead string
+		if n.isMap {
+			lead = pad + yamlKey(n.keys[i]) + ":"
+		} else {
+			lead = pad + "-"
+		}
+		if text := value.inline(); text != "" {
+			b.WriteString(lead + " " + text + "\n")
+			continue
+		}
+		if n.isMap {
+			b.WriteString(lead + "\n")
+			value.writeYAML(b, indent+2)
+			continue
+		}
+		//The item's first line goes after the dash
+		var item strings.Builder
+		value.writeYAML(&item, indent+2)
+		b.WriteString(lead + " " + item.String()[indent+2:])
+	}
+}
+
+func (n *yamlNode) writeJSON(b *bytes.Buffer) {
+	switch {
+	case n.isMap:
+		b.WriteByte('{')
+		for i, value := range n.values {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			key, _ := json.Marshal(n.keys[i])
+			b.Write(key)
+			b.WriteByte(':')
+			value.writeJSON(b)
+		}
+		b.WriteByte('}')
+	case n.isList:
+		b.WriteByte('[')
+		for i, value := range n.values {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			value.writeJSON(b)
+		}
+		b.WriteByte(']')
+	default:
+		b.WriteString(n.scalar)
+	}
+}
+
+// yamlLine is a line of YAML with its indentation taken off
+type yamlLine struct {
+	indent int
+	text   string
+	number int
+}
+
+// parseYAML reads the block YAML writeYAML writes: maps, lists, and scalars
+// that are double quoted strings or plain numbers, booleans, null or words.
+// Flow collections other than empty ones, anchors and multi-line strings
+// aren't understood
+func parseYAML(text string) (*yamlNode, error) {
+	var lines []yamlLine
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || trimmed == "---" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		lines = append(lines, yamlLine{indent: len(line) - len(trimmed), text: trimmed, number: i + 1})
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("Manifest is empty")
+	}
+	node, next, err := parseYAMLBlock(lines, 0, lines[0].indent)
+	if err == nil && next < len(lines) {
+		err = fmt.Errorf("Line %v is indented wrongly", lines[next].number)
+	}
+	return node, err
+}
+
+// parseYAMLBlock reads the node starting at lines[i], indented by indent,
+// returning it and the index of the line after it
+func parseYAMLBlock(lines []yamlLine, i, indent int) (*yamlNode, int, error) {
+	line := lines[i]
+	if line.text == "-" || strings.HasPrefix(line.text, "- ") {
+		node := &yamlNode{isList: true}
+		for i < len(lines) && lines[i].indent == indent && (lines[i].text == "-" || strings.HasPrefix(lines[i].text, "- ")) {
+			var value *yamlNode
+			var err error
+			if lines[i].text == "-" {
+				value, i, err = parseYAMLNested(lines, i, indent)
+			} else {
+				//Read what follows the dash as if it were on a line of its own
+				text := strings.TrimLeft(lines[i].text[1:], " ")
+				nested := indent + len(lines[i].text) - len(text)
+				lines[i] = yamlLine{indent: nested, text: text, number: lines[i].number}
+				value, i, err = parseYAMLBlock(lines, i, nested)
+			}
+			if err != nil {
+				return nil, i, err
+			}
+			node.values = append(node.values, value)
+		}
+		return node, i, nil
+	}
+	if _, _, ok := splitYAMLKey(line.text); !ok {
+		value, err := parseYAMLScalar(line.text, line.number)
+		return value, i + 1, err
+	}
+	node := &yamlNode{isMap: true}
+	for i < len(lines) && lines[i].indent == indent {
+		key, rest, ok := splitYAMLKey(lines[i].text)
+		if !ok {
+			return nil, i, fmt.Errorf("Line %v is not a key and value", lines[i].number)
+		}
+		var value *yamlNode
+		var err error
+		if rest == "" {
+			value, i, err = parseYAMLNested(lines, i, indent)
+		} else {
+			value, err = parseYAMLScalar(rest, lines[i].number)
+			i++
+		}
+		if err != nil {
+			return nil, i, err
+		}
+		node.keys = append(node.keys, key)
+		node.values = append(node.values, value)
+	}
+	return node, i, nil
+}
+
+// parseYAMLNested reads the node on the lines after lines[i], which has only
+// a key or a dash, null when nothing is nested under it
+func parseYAMLNested(lines []yamlLine, i, indent int) (*yamlNode, int, error) {
+	i++
+	if i == len(lines) || lines[i].indent < indent {
+		return &yamlNode{scalar: "null"}, i, nil
+	}
+	//A list may sit at the same indentation as the key it belongs to
+	isList := lines[i].text == "-" || strings.HasPrefix(lines[i].text, "- ")
+	if lines[i].indent == indent && !isList {
+		return &yamlNode{scalar: "null"}, i, nil
+	}
+	return parseYAMLBlock(lines, i, lines[i].indent)
+}
+
+// splitYAMLKey splits a line of a map into its key and the rest of the line
+// after the colon
+func splitYAMLKey(text string) (string, string, bool) {
+	if strings.HasPrefix(text, `"`) {
+		dec := json.NewDecoder(strings.NewReader(text))
+		var key string
+		if err := dec.Decode(&key); err != nil {
+			return "", "", false
+		}
+		rest := strings.TrimLeft(text[dec.InputOffset():], " ")
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", false
+		}
+		return key, strings.TrimLeft(rest[1:], " "), true
+	}
+	if strings.HasSuffix(text, ":") {
+		return text[:len(text)-1], "", true
+	}
+	i := strings.Index(text, ": ")
+	if i < 0 {
+		return "", "", false
+	}
+	return text[:i], strings.TrimLeft(text[i+2:], " "), true
+}
+
+// parseYAMLScalar reads a value written on the same line as its key or dash
+func parseYAMLScalar(text string, number int) (*yamlNode, error) {
+	switch text {
+	case "{}":
+		return &yamlNode{isMap: true}, nil
+	case "[]":
+		return &yamlNode{isList: true}, nil
+	case "~", "null", "Null", "NULL":
+		return &yamlNode{scalar: "null"}, nil
+	case "true", "True", "TRUE":
+		return &yamlNode{scalar: "true"}, nil
+	case "false", "False", "FALSE":
+		return &yamlNode{scalar: "false"}, nil
+	}
+	if strings.HasPrefix(text, `"`) {
+		var value string
+		if err := json.Unmarshal([]byte(text), &value); err != nil {
+			return nil, fmt.Errorf("Line %v has a badly quoted string, got error %w", number, err)
+		}
+		return &yamlNode{scalar: text}, nil
+	}
+	if strings.HasPrefix(text, "'") && strings.HasSuffix(text, "'") && len(text) > 1 {
+		text = strings.ReplaceAll(text[1:len(text)-1], "''", "'")
+	} else if strings.ContainsAny(text[:1], "-0123456789") && json.Valid([]byte(text)) {
+		return &yamlNode{scalar: text}, nil
+	}
+	quoted, _ := json.Marshal(text)
+	return &yamlNode{scalar: string(quoted)}, nil
+}
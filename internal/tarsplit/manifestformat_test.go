@@ -0,0 +1,88 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fullManifest has every field of a Manifest set
+func fullManifest() *Manifest {
+	return &Manifest{
+		Sources:       []string{"a.tar", "b \"quoted\".tar"},
+		SourceDigests: []string{"sha256:aa", "sha256:bb"},
+		AverageFill:   0.75,
+		Container:     "shards.tar",
+		Partial:       true,
+		Census:        map[string]int{"regular": 3, "directory": 1},
+		Labels:        map[string]string{"team": "build", "yes": "no"},
+		Interrupted:   true,
+		SplitFiles: []SplitFile{{Name: "big", Size: 30, Parts: []FilePart{
+			{Name: "big.part0000", Offset: 0, Size: 20},
+			{Name: "big.part0001", Offset: 20, Size: 10},
+		}}},
+		Duplicates: map[string]string{"copy": "orig"},
+		Run:        &RunStats{Bytes: 4096, ElapsedSeconds: 1.5, BytesPerSecond: 2730.5},
+		Shards: []ManifestShard{
+			{
+				Index:   0,
+				File:    "in-0.tar",
+				Digest:  "sha256:cc",
+				Size:    10240,
+				Fill:    0.5,
+				Members: NameAndSizes{{Name: "dir/"}, {Name: "big.part0000", Size: 20}, {Name: "orig", Size: 7}},
+				Linked:  map[string]string{"link": "orig"},
+			},
+			{Index: 1, File: "in-1.tar", Members: NameAndSizes{{Name: "big.part0001", Size: 10}, {Name: "copy", Size: 7}}},
+			{Index: 2, File: "in-2.tar", Members: NameAndSizes{}},
+		},
+	}
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		format ManifestFormat
+		err    string
+	}{
+		{name: "json", format: ManifestJSON},
+		{name: "yaml", format: ManifestYAML},
+		{name: "csv", format: ManifestCSV, err: "Manifest is CSV"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			want := fullManifest()
+			data, err := encodeManifest(want, test.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format := manifestFormatOf(data); format != test.format {
+				t.Errorf("Expected the manifest taken for format %v, got %v", test.format, format)
+			}
+			got, err := decodeManifest(data)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("Expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Expected %+v back, got %+v", want, got)
+			}
+		})
+	}
+}
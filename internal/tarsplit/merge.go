@@ -0,0 +1,169 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Merge puts a split back together, writing the members of every shard at
+// filenames into one tar on w, shard by shard in index order. Shards are
+// indexed by their manifest entry when manifest is set, otherwise by the
+// number their name starts with. With no filenames every shard the manifest
+// lists is merged.
+//
+// With manifest set each shard's members are checked against it as they are
+// copied. Entries a split leaves out, like directories and symlinks, can't be
+// told apart from missing members, so only missing members with data count.
+//
+// The parts of files cut up by SplitLargeFiles are joined back together after
+// every other member, so every shard holding one has to be merged
+func Merge(filenames []string, manifest *Manifest, w io.Writer) error {
+	shards, err := mergeOrder(filenames, manifest)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	j := newJoiner()
+	for _, shard := range shards {
+		if err := mergeShard(tw, shard, manifest != nil, j); err != nil {
+			return err
+		}
+	}
+	if err := j.join(tw); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("Could not write merged tar trailer, got error %w", err)
+	}
+	return nil
+}
+
+// mergeOrder works out which shard each of filenames is and sorts them by
+// index
+func mergeOrder(filenames []string, manifest *Manifest) ([]ManifestShard, error) {
+	if manifest == nil {
+		shards := make([]ManifestShard, len(filenames))
+		for i, filename := range filenames {
+			index, err := strconv.Atoi(strings.SplitN(filepath.Base(filename), "-", 2)[0])
+			if err != nil {
+				return nil, fmt.Errorf("Could not tell the index of shard %s from its name, give the manifest to merge by", filename)
+			}
+			shards[i] = ManifestShard{Index: index, File: filename}
+		}
+		sort.SliceStable(shards, func(i, j int) bool {
+			return shards[i].Index < shards[j].Index
+		})
+		return shards, nil
+	}
+
+	listed := make(map[string]ManifestShard)
+	for _, shard := range manifest.Shards {
+		listed[filepath.Base(shard.File)] = shard
+	}
+	var shards []ManifestShard
+	if len(filenames) == 0 {
+		shards = append(shards, manifest.Shards...)
+	}
+	for _, filename := range filenames {
+		shard, ok := listed[filepath.Base(filename)]
+		if !ok {
+			return nil, fmt.Errorf("Shard %s is not in the manifest", filename)
+		}
+		shard.File = filename
+		shards = append(shards, shard)
+	}
+	sort.SliceStable(shards, func(i, j int) bool {
+		return shards[i].Index < shards[j].Index
+	})
+	return shards, nil
+}
+
+// mergeShard copies every member of shard into tw, checking them against its
+// manifest entry when verify is set. Parts of split files are left to j
+func mergeShard(tw *tar.Writer, shard ManifestShard, verify bool, j *joiner) error {
+	file, err := openSource(shard.File)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	src, err := newSource(file, shard.File)
+	if err != nil {
+		return err
+	}
+	r, closeStream, err := src.stream()
+	if err != nil {
+		return err
+	}
+	defer closeStream()
+
+	expected := make(map[string]int64)
+	for _, member := range shard.Members {
+		expected[member.Name] = member.Size
+	}
+	tr := tar.NewReader(newPositionReader(r))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Could not read shard %s, got error %w", shard.File, err)
+		}
+		//Records about the shard itself, not part of the original
+		if header.Typeflag == tar.TypeXGlobalHeader || isEmbeddedIndex(header) {
+			continue
+		}
+		//The merged tar is meant to be extracted, a name escaping it is an attack
+		if err := checkHeader(header, shard.File); err != nil {
+			return err
+		}
+		if verify {
+			size, ok := expected[header.Name]
+			switch {
+			case !ok:
+				return fmt.Errorf("Shard %s holds %s which the manifest doesn't list for it", shard.File, header.Name)
+			case isDedupeLink(header, shard):
+				//Listed with the size of the content it links to
+			case size != header.Size:
+				return fmt.Errorf("Shard %s holds %s with %v bytes but the manifest lists %v", shard.File, header.Name, header.Size, size)
+			}
+			delete(expected, header.Name)
+		}
+		isPart, err := j.add(header, shard.File)
+		if err != nil {
+			return err
+		}
+		if isPart {
+			continue
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("Could not write header for %s, got error %w", header.Name, err)
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return fmt.Errorf("Could not copy %s from shard %s, got error %w", header.Name, shard.File, err)
+		}
+	}
+	for name, size := range expected {
+		if size > 0 {
+			return fmt.Errorf("Shard %s is missing %s which the manifest lists for it", shard.File, name)
+		}
+	}
+	return nil
+}
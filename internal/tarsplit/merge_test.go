@@ -0,0 +1,93 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// bodyDigests is the SHA-256 digest of the data of every entry of a tar, by
+// name
+func bodyDigests(tb testing.TB, path string) map[string]string {
+	tb.Helper()
+	digests := make(map[string]string)
+	for _, entry := range readTar(tb, path) {
+		digests[entry.Name] = fmt.Sprintf("%x", sha256.Sum256([]byte(entry.Body)))
+	}
+	return digests
+}
+
+func TestMergeRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	var members []testMember
+	for i := 0; i < 15; i++ {
+		members = append(members, testMember{Name: fmt.Sprintf("dir%v/f%02d", i%3, i), Body: strings.Repeat(string(rune('a'+i)), 300*(i+1))})
+	}
+	source := writeFile(t, dir, "in.tar", makeTar(t, members...))
+	opts := testOptions(t)
+	opts.TargetSize = 8000
+	opts.Manifest = filepath.Join(dir, "in.json")
+	result, err := Split(source, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Shards) < 3 {
+		t.Fatalf("Expected several shards, got %v", len(result.Shards))
+	}
+	manifest, err := ReadManifest(opts.Manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reversed []string
+	for i := len(result.Shards) - 1; i >= 0; i-- {
+		reversed = append(reversed, result.Shards[i].File)
+	}
+	want := bodyDigests(t, source)
+	for _, test := range []struct {
+		name      string
+		filenames []string
+		manifest  *Manifest
+	}{
+		{"manifest", nil, manifest},
+		{"names", reversed, nil},
+		{"names and manifest", reversed, manifest},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var merged bytes.Buffer
+			if err := Merge(test.filenames, test.manifest, &merged); err != nil {
+				t.Fatal(err)
+			}
+			got := bodyDigests(t, writeFile(t, t.TempDir(), "merged.tar", merged.Bytes()))
+			if len(got) != len(want) {
+				t.Errorf("Expected %v members merged, got %v", len(want), len(got))
+			}
+			for name, digest := range want {
+				if got[name] != digest {
+					t.Errorf("Expected %s merged with digest %s, got %q", name, digest, got[name])
+				}
+			}
+		})
+	}
+
+	//A manifest that doesn't match the shards fails the merge
+	manifest.Shards[0].Members = manifest.Shards[0].Members[1:]
+	var merged bytes.Buffer
+	if err := Merge(nil, manifest, &merged); err == nil || !strings.Contains(err.Error(), "which the manifest doesn't list") {
+		t.Errorf("Expected a member missing from the manifest to fail the merge, got %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WriteMetrics writes r to path as gauges in the Prometheus text format, for
+// node_exporter's textfile collector. The file is written under a temporary
+// name and renamed into place so the collector never reads it half written
+func WriteMetrics(path string, r *Result) error {
+	var skipped int
+	for _, count := range r.Skipped {
+		skipped += count
+	}
+	var text strings.Builder
+	for _, metric := range []struct {
+		name, help string
+		value      float64
+	}{
+		{"tarlayer_shards_total", "Shards written by the last split.", float64(len(r.Shards))},
+		{"tarlayer_bytes_total", "Bytes of shards written by the last split.", float64(r.Bytes())},
+		{"tarlayer_duration_seconds", "Time the last split spent copying members.", r.Elapsed.Seconds()},
+		{"tarlayer_failed_members", "Members the last split could not copy and left out.", float64(len(r.Failed))},
+		{"tarlayer_skipped_entries", "Entries the last split skipped as not carried over.", float64(skipped)},
+		{"tarlayer_oversize_members", "Members too big for a shard of the target size.", float64(len(r.Oversize))},
+		{"tarlayer_last_run_timestamp_seconds", "When the last split finished.", float64(time.Now().Unix())},
+	} {
+		value := strconv.FormatFloat(metric.value, 'f', -1, 64)
+		fmt.Fprintf(&text, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", metric.name, metric.help, metric.name, metric.name, value)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*"+tmpSuffix)
+	if err != nil {
+		return fmt.Errorf("Could not write metrics %s, got error %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(text.String()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Could not write metrics %s, got error %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("Could not write metrics %s, got error %w", path, err)
+	}
+	//CreateTemp makes the file readable by its owner only
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("Could not write metrics %s, got error %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("Could not write metrics %s, got error %w", path, err)
+	}
+	return nil
+}
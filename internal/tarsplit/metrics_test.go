@@ -0,0 +1,93 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	result := &Result{
+		Shards:   []ShardResult{{Index: 0, Size: 10240}, {Index: 1, Size: 2048}},
+		Elapsed:  1500 * time.Millisecond,
+		Failed:   []MemberError{{Name: "bad"}},
+		Skipped:  Skipped{tar.TypeDir: 2, tar.TypeSymlink: 1},
+		Oversize: NameAndSizes{{Name: "huge", Size: 1 << 30}},
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tarlayer.prom")
+	before := time.Now().Unix()
+	if err := WriteMetrics(path, result); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	values := make(map[string]float64)
+	typed := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) == 4 && fields[0] == "#" && fields[1] == "TYPE":
+			typed[fields[2]] = fields[3] == "gauge"
+		case len(fields) > 2 && fields[0] == "#" && fields[1] == "HELP":
+		case len(fields) == 2:
+			if !typed[fields[0]] {
+				t.Errorf("Expected %s typed as a gauge before its value", fields[0])
+			}
+			value, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				t.Errorf("Expected a number for %s, got %q", fields[0], fields[1])
+			}
+			values[fields[0]] = value
+		default:
+			t.Errorf("Expected only help, type and sample lines, got %q", scanner.Text())
+		}
+	}
+	want := map[string]float64{
+		"tarlayer_shards_total":     2,
+		"tarlayer_bytes_total":      12288,
+		"tarlayer_duration_seconds": 1.5,
+		"tarlayer_failed_members":   1,
+		"tarlayer_skipped_entries":  3,
+		"tarlayer_oversize_members": 1,
+	}
+	for name, value := range want {
+		if got, ok := values[name]; !ok || got != value {
+			t.Errorf("Expected %s %v, got %v", name, value, got)
+		}
+	}
+	if stamp := values["tarlayer_last_run_timestamp_seconds"]; stamp < float64(before) || stamp > float64(time.Now().Unix()) {
+		t.Errorf("Expected the run stamped with the time it finished, got %v", stamp)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("Expected the metrics readable by the collector, got %v", info.Mode())
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("Expected only the metrics file left, got %v files", len(files))
+	}
+}
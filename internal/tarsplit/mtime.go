@@ -0,0 +1,60 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"time"
+)
+
+// ClampedTime is a member whose modification time ClampMTime changed
+type ClampedTime struct {
+	Name string
+	From time.Time
+	To   time.Time
+}
+
+var (
+	// minTime and maxTime bound the times every tar format can hold, ustar
+	// having room for 11 octal digits of seconds since the epoch
+	minTime = time.Unix(0, 0)
+	maxTime = time.Unix(1<<33-1, 0)
+)
+
+// clampTimes brings the times of header within minTime and maxTime,
+// returning the modification time it had if any time changed
+func clampTimes(header *tar.Header) (time.Time, bool) {
+	from := header.ModTime
+	clamped := false
+	for _, t := range []*time.Time{&header.ModTime, &header.AccessTime, &header.ChangeTime} {
+		//Access and change times are only there when the source had them
+		if t.IsZero() && t != &header.ModTime {
+			continue
+		}
+		switch {
+		case t.Before(minTime):
+			*t = minTime
+		case t.After(maxTime):
+			*t = maxTime
+		default:
+			continue
+		}
+		clamped = true
+	}
+	if clamped {
+		for _, key := range []string{"mtime", "atime", "ctime"} {
+			delete(header.PAXRecords, key)
+		}
+	}
+	return from, clamped
+}
@@ -0,0 +1,103 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestClampMTime(t *testing.T) {
+	old := time.Date(1901, 1, 1, 0, 0, 0, 0, time.UTC)
+	future := time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, member := range []struct {
+		name  string
+		mtime time.Time
+	}{{"old", old}, {"now", now}, {"future", future}} {
+		//GNU can hold times ustar can't
+		header := &tar.Header{Name: member.name, Typeflag: tar.TypeReg, Size: 1, Mode: 0644, ModTime: member.mtime, Format: tar.FormatGNU}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte("x"))
+	}
+	tw.Close()
+	data := buf.Bytes()
+
+	for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+		opts := testOptions(t)
+		opts.Strategy = strategy
+		opts.Format = tar.FormatUSTAR
+		if _, err := SplitReader(bytes.NewReader(data), "in.tar", opts); err == nil {
+			t.Errorf("%s: expected the times refused as ustar without clamping", name)
+		}
+
+		opts = testOptions(t)
+		opts.Strategy = strategy
+		opts.Format = tar.FormatUSTAR
+		opts.ClampMTime = true
+		result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		entries := readShards(t, result)
+		for member, want := range map[string]time.Time{"old": minTime, "now": now, "future": maxTime} {
+			if got := entries[member].ModTime; !got.Equal(want) {
+				t.Errorf("%s: expected %s dated %v, got %v", name, member, want, got)
+			}
+		}
+		clamped := make(map[string]ClampedTime)
+		for _, c := range result.ClampedTimes {
+			clamped[c.Name] = c
+		}
+		if len(clamped) != 2 || !clamped["old"].From.Equal(old) || !clamped["old"].To.Equal(minTime) || !clamped["future"].From.Equal(future) || !clamped["future"].To.Equal(maxTime) {
+			t.Errorf("%s: expected old and future listed as clamped, got %+v", name, result.ClampedTimes)
+		}
+	}
+}
+
+func TestClampTimes(t *testing.T) {
+	//A malformed header with no time at all
+	header := &tar.Header{Name: "zero"}
+	if _, clamped := clampTimes(header); !clamped || !header.ModTime.Equal(minTime) {
+		t.Errorf("Expected a zero time clamped to %v, got %v", minTime, header.ModTime)
+	}
+	if !header.AccessTime.IsZero() || !header.ChangeTime.IsZero() {
+		t.Errorf("Expected missing access and change times left out, got %v and %v", header.AccessTime, header.ChangeTime)
+	}
+
+	now := time.Unix(1700000000, 0)
+	header = &tar.Header{
+		Name:       "atime",
+		ModTime:    now,
+		AccessTime: time.Unix(-5, 0),
+		PAXRecords: map[string]string{"atime": "-5", "mtime": "1700000000", "path": "atime"},
+	}
+	from, clamped := clampTimes(header)
+	if !clamped || !from.Equal(now) || !header.ModTime.Equal(now) || !header.AccessTime.Equal(minTime) {
+		t.Errorf("Expected only the access time clamped, got %v, %v and %v", clamped, header.ModTime, header.AccessTime)
+	}
+	if _, ok := header.PAXRecords["atime"]; ok || header.PAXRecords["path"] != "atime" {
+		t.Errorf("Expected the stale time records dropped and the rest kept, got %v", header.PAXRecords)
+	}
+
+	header = &tar.Header{Name: "fine", ModTime: now}
+	if _, clamped := clampTimes(header); clamped {
+		t.Error("Expected a time in range left alone")
+	}
+}
@@ -0,0 +1,72 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+)
+
+// Ownership is the owner every member is given
+type Ownership struct {
+	Uid int
+	Gid int
+	// Uname and Gname are the owner's names, left empty when not given
+	Uname string
+	Gname string
+}
+
+// IDMap maps Count ids starting at From to the ids starting at To, so 0, 100000,
+// 65536 shifts every id up by 100000 as rootless containers do
+type IDMap struct {
+	From  int
+	To    int
+	Count int
+}
+
+// mapID is id after the first of maps that covers it, and whether one did
+func mapID(id int, maps []IDMap) (int, bool) {
+	for _, m := range maps {
+		if id >= m.From && id-m.From < m.Count {
+			return m.To + id - m.From, true
+		}
+	}
+	return id, false
+}
+
+// rewriteOwner changes the owner of header as opts asks. An owner name no
+// longer matching the id is dropped, extractors go by the name when there is
+// one
+func rewriteOwner(header *tar.Header, opts Options) {
+	uid, gid := header.Uid, header.Gid
+	uname, gname := header.Uname, header.Gname
+	if opts.Chown != nil {
+		uid, gid = opts.Chown.Uid, opts.Chown.Gid
+		uname, gname = opts.Chown.Uname, opts.Chown.Gname
+	}
+	if mapped, ok := mapID(uid, opts.UidMap); ok {
+		uid, uname = mapped, ""
+	}
+	if mapped, ok := mapID(gid, opts.GidMap); ok {
+		gid, gname = mapped, ""
+	}
+	if uid != header.Uid || uname != header.Uname {
+		header.Uid, header.Uname = uid, uname
+		delete(header.PAXRecords, "uid")
+		delete(header.PAXRecords, "uname")
+	}
+	if gid != header.Gid || gname != header.Gname {
+		header.Gid, header.Gname = gid, gname
+		delete(header.PAXRecords, "gid")
+		delete(header.PAXRecords, "gname")
+	}
+}
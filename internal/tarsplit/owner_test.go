@@ -0,0 +1,105 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+)
+
+func TestOwnership(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, header := range []*tar.Header{
+		{Name: "root", Uid: 0, Gid: 0, Uname: "root", Gname: "root"},
+		{Name: "user", Uid: 1000, Gid: 1000, Uname: "user", Gname: "user"},
+		{Name: "other", Uid: 70000, Gid: 5, Uname: "other", Gname: "tty"},
+	} {
+		header.Typeflag, header.Size, header.Mode = tar.TypeReg, 1000, 0644
+		tw.WriteHeader(header)
+		tw.Write(make([]byte, header.Size))
+	}
+	tw.Close()
+	shift := []IDMap{{From: 0, To: 100000, Count: 65536}}
+	type owner struct {
+		uid, gid     int
+		uname, gname string
+	}
+	for _, test := range []struct {
+		name   string
+		chown  *Ownership
+		uidMap []IDMap
+		gidMap []IDMap
+		want   map[string]owner
+	}{
+		{"untouched", nil, nil, nil, map[string]owner{
+			"root":  {0, 0, "root", "root"},
+			"user":  {1000, 1000, "user", "user"},
+			"other": {70000, 5, "other", "tty"},
+		}},
+		{"chown", &Ownership{Uid: 1, Gid: 2}, nil, nil, map[string]owner{
+			"root":  {1, 2, "", ""},
+			"user":  {1, 2, "", ""},
+			"other": {1, 2, "", ""},
+		}},
+		{"chown with names", &Ownership{Uid: 1, Gid: 2, Uname: "bin", Gname: "daemon"}, nil, nil, map[string]owner{
+			"root":  {1, 2, "bin", "daemon"},
+			"user":  {1, 2, "bin", "daemon"},
+			"other": {1, 2, "bin", "daemon"},
+		}},
+		{"shifted", nil, shift, shift, map[string]owner{
+			"root":  {100000, 100000, "", ""},
+			"user":  {101000, 101000, "", ""},
+			"other": {70000, 100005, "other", ""},
+		}},
+		{"single id", nil, []IDMap{{From: 1000, To: 0, Count: 1}}, nil, map[string]owner{
+			"root":  {0, 0, "root", "root"},
+			"user":  {0, 1000, "", "user"},
+			"other": {70000, 5, "other", "tty"},
+		}},
+		{"first map wins", nil, []IDMap{{From: 1000, To: 1, Count: 1}, {From: 0, To: 2000, Count: 2000}}, nil, map[string]owner{
+			"root":  {2000, 0, "", "root"},
+			"user":  {1, 1000, "", "user"},
+			"other": {70000, 5, "other", "tty"},
+		}},
+		{"chown then map", &Ownership{Uid: 0, Gid: 0, Uname: "root", Gname: "root"}, shift, nil, map[string]owner{
+			"root":  {100000, 0, "", "root"},
+			"user":  {100000, 0, "", "root"},
+			"other": {100000, 0, "", "root"},
+		}},
+	} {
+		for strategyName, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+			opts := testOptions(t)
+			opts.TargetSize = 2048
+			opts.Strategy = strategy
+			opts.Chown, opts.UidMap, opts.GidMap = test.chown, test.uidMap, test.gidMap
+			result, err := SplitReader(bytes.NewReader(buf.Bytes()), "in.tar", opts)
+			if err != nil {
+				t.Fatalf("%s %s: %v", test.name, strategyName, err)
+			}
+			entries := readShards(t, result)
+			for name, want := range test.want {
+				entry, ok := entries[name]
+				if !ok {
+					t.Errorf("%s %s: expected %s in the shards", test.name, strategyName, name)
+					continue
+				}
+				got := owner{entry.Uid, entry.Gid, entry.Uname, entry.Gname}
+				if got != want {
+					t.Errorf("%s %s: expected %s owned by %+v, got %+v", test.name, strategyName, name, want, got)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"fmt"
+	"io"
+)
+
+// PeekResult is the start and end of a tar's member list
+type PeekResult struct {
+	// First are the first members, Last the last ones after those
+	First NameAndSizes
+	Last  NameAndSizes
+	// Total is how many members the tar holds, or -1 when it wasn't read
+	// through to find out
+	Total int
+}
+
+// Peek lists the first head members of the tar at filename and the last tail
+// after those. The first ones only need the start of the tar read, but the
+// last ones can only be found by reading it through. For a plain tar that is
+// just its headers, as member data is seeked past, but a gzipped tar has to be
+// decompressed whole. With tail 0 reading stops after the first head members
+func Peek(filename string, head, tail int, opts Options) (*PeekResult, error) {
+	if head < 0 || tail < 0 {
+		return nil, fmt.Errorf("Member counts to peek at can't be negative, got %v and %v", head, tail)
+	}
+	r, closeStream, err := openTarStream(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer closeStream()
+
+	position := newPositionReader(r)
+	tr := newTarStream(position, opts.Concatenated)
+	result := &PeekResult{Total: -1}
+	var last string
+	count := 0
+	//The last tail members seen, in a ring starting at count % tail
+	ring := make(NameAndSizes, 0, tail)
+	for {
+		if count == head && tail == 0 {
+			return result, nil
+		}
+		header, err := tr.Next()
+		switch {
+		case err == io.EOF:
+			start := 0
+			if len(ring) == tail && tail > 0 {
+				start = (count - head) % tail
+			}
+			result.Last = append(ring[start:len(ring):len(ring)], ring[:start]...)
+			result.Total = count
+			return result, nil
+		case err != nil:
+			return nil, &SourceError{Source: filename, Member: last, After: true, Offset: position.pos, Err: err}
+		case header == nil || isLongLink(header):
+			continue
+		}
+		last = header.Name
+		member := NameAndSize{Name: header.Name, Size: header.Size, Offset: -1, Typeflag: header.Typeflag, Linkname: header.Linkname}
+		switch {
+		case count < head:
+			result.First = append(result.First, member)
+		case len(ring) < tail:
+			ring = append(ring, member)
+		default:
+			ring[(count-head)%tail] = member
+		}
+		count++
+	}
+}
@@ -0,0 +1,78 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPeek(t *testing.T) {
+	dir := t.TempDir()
+	data := genTar(t, 25, 700)
+	plain := writeFile(t, dir, "in.tar", data)
+	gzipped := writeFile(t, dir, "in.tar.gz", gzipBytes(t, data))
+	//Cut partway into the twentieth member's data
+	cut := writeFile(t, dir, "cut.tar", data[:20*1536-1000])
+	names := func(members NameAndSizes) []string {
+		var list []string
+		for _, member := range members {
+			list = append(list, member.Name)
+		}
+		return list
+	}
+	nth := func(from, to int) []string {
+		var list []string
+		for i := from; i < to; i++ {
+			list = append(list, fmt.Sprintf("d0000/f%07d", i))
+		}
+		return list
+	}
+	for _, test := range []struct {
+		name       string
+		filename   string
+		head, tail int
+		first      []string
+		last       []string
+		total      int
+	}{
+		{"head only", plain, 3, 0, nth(0, 3), nil, -1},
+		{"head and tail", plain, 3, 4, nth(0, 3), nth(21, 25), 25},
+		{"tail only", plain, 0, 2, nil, nth(23, 25), 25},
+		{"overlapping", plain, 20, 10, nth(0, 20), nth(20, 25), 25},
+		{"more than there are", plain, 30, 0, nth(0, 25), nil, 25},
+		{"gzipped", gzipped, 2, 3, nth(0, 2), nth(22, 25), 25},
+		//Only the start is read, so the rest being cut off doesn't matter
+		{"head of a cut tar", cut, 5, 0, nth(0, 5), nil, -1},
+	} {
+		result, err := Peek(test.filename, test.head, test.tail, Options{})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if fmt.Sprint(names(result.First)) != fmt.Sprint(test.first) || fmt.Sprint(names(result.Last)) != fmt.Sprint(test.last) || result.Total != test.total {
+			t.Errorf("%s: expected %v, %v of %v, got %v, %v of %v", test.name, test.first, test.last, test.total, names(result.First), names(result.Last), result.Total)
+		}
+		for _, member := range result.First {
+			if member.Size != 700 {
+				t.Errorf("%s: expected %s of 700 bytes, got %v", test.name, member.Name, member.Size)
+			}
+		}
+	}
+
+	if _, err := Peek(cut, 5, 1, Options{}); err == nil {
+		t.Error("Expected reading through a cut tar for its last members to fail")
+	}
+	if _, err := Peek(plain, -1, 0, Options{}); err == nil {
+		t.Error("Expected a negative count refused")
+	}
+}
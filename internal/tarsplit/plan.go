@@ -0,0 +1,403 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// MinTargetSize is the smallest shard that holds anything at all, one header
+// block and the two block trailer. Targets must be larger than this
+const MinTargetSize = 3 * blockSize
+
+// ValidateTargetSize checks a target size leaves room for at least some data
+func ValidateTargetSize(targetSize int64) error {
+	if targetSize <= MinTargetSize {
+		return invalidTargetf("Target size must be more than %v bytes, room for one header and the tar trailer, got %v", MinTargetSize, targetSize)
+	}
+	return nil
+}
+
+func buildTarPlan(data NameAndSizes, targetSize int64) ([]Plan, error) {
+	return buildTieredPlan(data, []int64{targetSize})
+}
+
+// buildTieredPlan is buildTarPlan with a target for each shard, shard i is
+// planned up to targets[i] and any after the last up to the last
+func buildTieredPlan(data NameAndSizes, targets []int64) ([]Plan, error) {
+	if len(targets) == 0 {
+		return nil, invalidTargetf("No target sizes to plan to")
+	}
+	for _, target := range targets {
+		if err := ValidateTargetSize(target); err != nil {
+			return nil, err
+		}
+	}
+	//Since I can't think of any other way, going to start with the biggest and once
+	//the next biggest can't fit, going to top it off with the bottom up till we get all
+	plans := make([]Plan, 0)
+
+	var currentPlanTotalSize int64
+	currentPlan := &Plan{}
+	endIndex := len(data) - 1
+	finished := false
+	addToNext := false
+	canAddSmall := true
+
+	for i := 0; i <= endIndex; i++ {
+		targetSize := tierTarget(targets, len(plans))
+		currentPlan.Target = targetSize
+		//A member bigger than the target starts a plan of its own rather than
+		//closing off an empty one. Sizes are compared against the room left so
+		//that sizes near math.MaxInt64 can't wrap the sum around
+		if data[i].Size <= targetSize-currentPlanTotalSize || len(currentPlan.Pool) == 0 {
+			currentPlan.Pool = append(currentPlan.Pool, data[i])
+			currentPlanTotalSize = currentPlanTotalSize + data[i].Size
+		} else {
+			//Time to fill up from reverse. data[i] goes to the next plan, so the
+			//reverse scan stops short of it, leaving each member claimed by
+			//exactly one of the two scans however the sizes are ordered
+			for endIndex > i && data[endIndex].Size < targetSize-currentPlanTotalSize {
+				currentPlan.Pool = append(currentPlan.Pool, data[endIndex])
+				currentPlanTotalSize += data[endIndex].Size
+				endIndex = endIndex - 1
+			}
+			canAddSmall = false
+			addToNext = true
+		}
+		if i == endIndex {
+			finished = true
+		}
+
+		if finished || !canAddSmall {
+			plans = append(plans, *currentPlan)
+			//Need a new plan to add to and reset counters
+			currentPlan = &Plan{}
+			currentPlanTotalSize = 0
+			canAddSmall = true
+			if addToNext {
+				currentPlan.Pool = append(currentPlan.Pool, data[i])
+				currentPlanTotalSize += data[i].Size
+				addToNext = false
+				if finished {
+					currentPlan.Target = tierTarget(targets, len(plans))
+					plans = append(plans, *currentPlan)
+				}
+			}
+		}
+		if finished {
+			break
+		}
+	}
+	return plans, nil
+}
+
+// tierTarget is the target of shard i out of targets, see buildTieredPlan
+func tierTarget(targets []int64, i int) int64 {
+	if i >= len(targets) {
+		return targets[len(targets)-1]
+	}
+	return targets[i]
+}
+
+// largestTarget is the largest target of shard i or any shard after it, see
+// tierTarget
+func largestTarget(targets []int64, i int) int64 {
+	if i >= len(targets) {
+		i = len(targets) - 1
+	}
+	var largest int64
+	for _, target := range targets[i:] {
+		if target > largest {
+			largest = target
+		}
+	}
+	return largest
+}
+
+// buildAffinityPlan is buildTieredPlan keeping members that share the first
+// depth components of their path together. Each group that fits in a shard is
+// packed whole, biggest group first, into the first shard with room for it.
+// Members of groups too big for any shard still to come are packed by
+// buildTieredPlan after
+func buildAffinityPlan(data NameAndSizes, targets []int64, depth int) ([]Plan, error) {
+	if len(targets) == 0 {
+		return nil, invalidTargetf("No target sizes to plan to")
+	}
+	for _, target := range targets {
+		if err := ValidateTargetSize(target); err != nil {
+			return nil, err
+		}
+	}
+	//Groups bigger than the largest target can't fit any shard
+	largest := largestTarget(targets, 0)
+	var prefixes []string
+	groups := make(map[string]NameAndSizes)
+	for _, member := range data {
+		prefix := pathPrefix(member.Name, depth)
+		if _, ok := groups[prefix]; !ok {
+			prefixes = append(prefixes, prefix)
+		}
+		groups[prefix] = append(groups[prefix], member)
+	}
+
+	var whole []Plan
+	var loose NameAndSizes
+	for _, prefix := range prefixes {
+		group := Plan{Pool: groups[prefix]}
+		if group.Size() <= largest {
+			whole = append(whole, group)
+		} else {
+			loose = append(loose, group.Pool...)
+		}
+	}
+	//Stable so that equal groups keep the order they appear in
+	sort.SliceStable(whole, func(i, j int) bool {
+		return whole[i].Size() > whole[j].Size()
+	})
+
+	var plans []Plan
+	var totals []int64
+	for _, group := range whole {
+		size := group.Size()
+		into := -1
+		for j, total := range totals {
+			if size <= plans[j].Target-total {
+				into = j
+				break
+			}
+		}
+		//A group too big for the next shard's target waits for a bigger one,
+		//as long as one is still to come
+		for into < 0 && size <= largestTarget(targets, len(plans)) {
+			plans = append(plans, Plan{Target: tierTarget(targets, len(plans))})
+			totals = append(totals, 0)
+			if size <= plans[len(plans)-1].Target {
+				into = len(plans) - 1
+			}
+		}
+		if into < 0 {
+			//Descending targets can leave no shard to come big enough for it
+			loose = append(loose, group.Pool...)
+			continue
+		}
+		plans[into].Pool = append(plans[into].Pool, group.Pool...)
+		totals[into] += size
+	}
+
+	if len(loose) == 0 {
+		return dropEmpty(plans), nil
+	}
+	//The loose members go into the shards after, with the targets for those
+	next := len(plans)
+	if next >= len(targets) {
+		next = len(targets) - 1
+	}
+	rest, err := buildTieredPlan(loose, targets[next:])
+	if err != nil {
+		return nil, err
+	}
+	return append(dropEmpty(plans), rest...), nil
+}
+
+// pathPrefix is the first depth components of name, or all of it when it has
+// fewer
+func pathPrefix(name string, depth int) string {
+	parts := strings.SplitN(strings.Trim(name, "/"), "/", depth+1)
+	if len(parts) > depth {
+		parts = parts[:depth]
+	}
+	return strings.Join(parts, "/")
+}
+
+// buildBalancedPlan deals the members, biggest first, onto whichever of the
+// numShards plans is lightest so far. Sizes include the tar header and padding
+// so that many small files weigh what they really cost on disk
+func buildBalancedPlan(data NameAndSizes, numShards int) ([]Plan, error) {
+	if numShards <= 0 {
+		return nil, invalidTargetf("Number of shards must be positive, got %v", numShards)
+	}
+	plans := make([]Plan, numShards)
+	totals := make([]int64, numShards)
+
+	for _, member := range data {
+		lightest := 0
+		for j := range totals {
+			if totals[j] < totals[lightest] {
+				lightest = j
+			}
+		}
+		plans[lightest].Pool = append(plans[lightest].Pool, member)
+		totals[lightest] += tarSize(member.Size)
+	}
+
+	//With fewer members than shards some plans stay empty, don't write those
+	return dropEmpty(plans), nil
+}
+
+// dropEmpty removes the plans with no members
+func dropEmpty(plans []Plan) []Plan {
+	filled := plans[:0]
+	for _, plan := range plans {
+		if len(plan.Pool) > 0 {
+			filled = append(filled, plan)
+		}
+	}
+	return filled
+}
+
+// tarSize is the space a member of the given size takes up in a tar, one
+// header block plus the data padded out to a whole block
+func tarSize(size int64) int64 {
+	return blockSize + (size+blockSize-1)/blockSize*blockSize
+}
+
+// checkTotalSize makes sure the members of data, with their headers, padding
+// and a tar trailer, add up to less than math.MaxInt64 bytes. Headers can
+// declare sizes close to it, and past it the running totals planning keeps
+// would wrap around to negative and let anything fit
+func checkTotalSize(data NameAndSizes) error {
+	total := int64(2 * blockSize)
+	for _, member := range data {
+		if member.Size < 0 || member.Size > math.MaxInt64-2*blockSize {
+			return fmt.Errorf("Member %s declares a size of %v bytes, which can't be right", member.Name, member.Size)
+		}
+		size := tarSize(member.Size)
+		if total > math.MaxInt64-size {
+			return fmt.Errorf("Members add up to more than %v bytes, too much to plan", int64(math.MaxInt64))
+		}
+		total += size
+	}
+	return nil
+}
+
+// checkPlanned makes sure plans hold exactly the members of data, as many of
+// them with as many bytes. Planning should never drop or repeat a member, so
+// a difference is a bug, but one that would otherwise only show as a file
+// missing or doubled after extraction
+func checkPlanned(data NameAndSizes, plans []Plan) error {
+	var members int
+	var size int64
+	for _, plan := range plans {
+		members += len(plan.Pool)
+		size += plan.Size()
+	}
+	if want := (Plan{Pool: data}).Size(); members != len(data) || size != want {
+		return fmt.Errorf("Planning went wrong, %v members of %v bytes were planned from %v members of %v bytes", members, size, len(data), want)
+	}
+	return nil
+}
+
+// fillRatio is how full plan is relative to its target size, or 0 when it
+// wasn't planned by target size
+func fillRatio(plan Plan) float64 {
+	if plan.Target <= 0 {
+		return 0
+	}
+	return float64(plan.Size()) / float64(plan.Target)
+}
+
+// oversizeMembers finds the members bigger than the target of the plan they
+// are in, biggest first
+func oversizeMembers(plans []Plan) NameAndSizes {
+	var oversize NameAndSizes
+	for _, plan := range plans {
+		for _, member := range plan.Pool {
+			if plan.Target > 0 && member.Size > plan.Target {
+				oversize = append(oversize, member)
+			}
+		}
+	}
+	sort.Stable(sort.Reverse(oversize))
+	return oversize
+}
+
+// MinimumTarget is the smallest target size a shard holding a member of size
+// bytes fits in, with its header, padding and the tar trailer, allowing for
+// the overhead and compression ratio of opts
+func MinimumTarget(size int64, opts Options) int64 {
+	//Header, padding and trailer come to less than 4 blocks
+	if size > math.MaxInt64-4*blockSize {
+		return math.MaxInt64
+	}
+	need := tarSize(size) + 2*blockSize
+	if opts.CompressionRatio > 0 {
+		compressed := math.Ceil(float64(need) * opts.CompressionRatio)
+		if compressed >= math.MaxInt64 {
+			return math.MaxInt64
+		}
+		need = int64(compressed)
+	}
+	if need > math.MaxInt64-opts.OverheadBytes {
+		return math.MaxInt64
+	}
+	return need + opts.OverheadBytes
+}
+
+// newOversizeError describes the members of oversize, biggest first, being
+// too big for the target
+func newOversizeError(oversize NameAndSizes, opts Options) *OversizeError {
+	return &OversizeError{
+		Name:    oversize[0].Name,
+		Size:    oversize[0].Size,
+		Minimum: MinimumTarget(oversize[0].Size, opts),
+		Others:  len(oversize) - 1,
+	}
+}
+
+// overfillLimit is how big a shard may grow when a small one is merged into it
+func overfillLimit(targetSize int64, tolerance float64) int64 {
+	limit := float64(targetSize) * (1 + tolerance)
+	if limit >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(limit)
+}
+
+// mergeSmallShards folds every plan holding less than minSize into whichever
+// neighbouring plan is smaller, as long as the result stays within the
+// neighbour's overfillLimit. A small plan with no neighbour it fits into is
+// left as it is
+func mergeSmallShards(plans []Plan, minSize int64, tolerance float64) []Plan {
+	for i := 0; i < len(plans); {
+		size := plans[i].Size()
+		if size >= minSize {
+			i++
+			continue
+		}
+		into := -1
+		for _, j := range []int{i - 1, i + 1} {
+			if j < 0 || j >= len(plans) || size > overfillLimit(plans[j].Target, tolerance)-plans[j].Size() {
+				continue
+			}
+			if into < 0 || plans[j].Size() < plans[into].Size() {
+				into = j
+			}
+		}
+		if into < 0 {
+			i++
+			continue
+		}
+		plans[into].Pool = append(plans[into].Pool, plans[i].Pool...)
+		plans = append(plans[:i], plans[i+1:]...)
+		//The merged plan may still be under the minimum, look at it again
+		if into > i {
+			into--
+		}
+		i = into
+	}
+	return plans
+}
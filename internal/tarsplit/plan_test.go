@@ -0,0 +1,798 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// checkEveryMember checks that plans hold each member of data exactly once
+func checkEveryMember(t *testing.T, data NameAndSizes, plans []Plan) {
+	t.Helper()
+	planned := make(map[string]int)
+	for _, plan := range plans {
+		for _, member := range plan.Pool {
+			planned[member.Name]++
+		}
+	}
+	for _, member := range data {
+		if planned[member.Name] != 1 {
+			t.Errorf("Expected %s planned once, got %v times", member.Name, planned[member.Name])
+		}
+	}
+	if len(planned) != len(data) {
+		t.Errorf("Expected %v members planned, got %v", len(data), len(planned))
+	}
+}
+
+func TestNoSortPlansEveryMember(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, order := range []string{"random", "ascending", "descending"} {
+		data := make(NameAndSizes, 500)
+		for i := range data {
+			size := r.Int63n(100000)
+			switch order {
+			case "ascending":
+				size = int64(i) * 200
+			case "descending":
+				size = int64(len(data)-i) * 200
+			}
+			data[i] = NameAndSize{Name: fmt.Sprintf("f%03d", i), Size: size}
+		}
+		opts := Options{TargetSize: 1 << 20, NoSort: true}
+		members := append(NameAndSizes(nil), data...)
+		sortForPacking(members, opts)
+		for i := range members {
+			if members[i].Name != data[i].Name {
+				t.Fatalf("Expected the %s members left in order, %s moved", order, data[i].Name)
+			}
+		}
+		plans, err := buildPlans(members, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkEveryMember(t, data, plans)
+	}
+}
+
+func TestNoSortSplitsEveryMember(t *testing.T) {
+	var members []testMember
+	for i := 0; i < 30; i++ {
+		members = append(members, testMember{Name: fmt.Sprintf("f%02d", i), Body: strings.Repeat(string(rune('a'+i%26)), 97*(i%11)+1)})
+	}
+	opts := testOptions(t)
+	opts.TargetSize = 4096
+	opts.NoSort = true
+	result, err := SplitReader(bytes.NewReader(makeTar(t, members...)), "in.tar", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := readShards(t, result)
+	if len(entries) != len(members) {
+		t.Errorf("Expected %v members in the shards, got %v", len(members), len(entries))
+	}
+	for _, member := range members {
+		if entries[member.Name].Body != member.Body {
+			t.Errorf("Expected %s copied whole, got %v of %v bytes", member.Name, len(entries[member.Name].Body), len(member.Body))
+		}
+	}
+}
+
+func TestCheckPackingOneShard(t *testing.T) {
+	data := makeTar(t, testMember{Name: "a", Body: "small"}, testMember{Name: "b", Body: "smaller"})
+	for _, test := range []struct {
+		name   string
+		modify func(*Options)
+		err    string
+	}{
+		{name: "affinity depth", modify: func(opts *Options) { opts.AffinityDepth = -1 }, err: "Affinity depth can't be negative, got -1"},
+		{name: "overfill tolerance", modify: func(opts *Options) { opts.MinShardSize, opts.OverfillTolerance = 1, -0.5 }, err: "Overfill tolerance can't be negative, got -0.5"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			opts := testOptions(t)
+			test.modify(&opts)
+			_, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("Expected error %q, got %v", test.err, err)
+			}
+			if entries, _ := os.ReadDir(opts.outDir); len(entries) > 0 {
+				t.Errorf("Expected no shards written, got %v files", len(entries))
+			}
+		})
+	}
+}
+
+func TestBalancedPlan(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	data := make(NameAndSizes, 200)
+	var largest int64
+	for i := range data {
+		data[i] = NameAndSize{Name: fmt.Sprintf("f%03d", i), Size: r.Int63n(1 << 20)}
+		if tarSize(data[i].Size) > largest {
+			largest = tarSize(data[i].Size)
+		}
+	}
+	sortForPacking(data, Options{})
+	for _, n := range []int{1, 2, 4, 7} {
+		plans, err := buildBalancedPlan(data, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(plans) != n {
+			t.Errorf("Expected %v shards, got %v", n, len(plans))
+		}
+		checkEveryMember(t, data, plans)
+		min, max := int64(math.MaxInt64), int64(0)
+		for _, plan := range plans {
+			var total int64
+			for _, member := range plan.Pool {
+				total += tarSize(member.Size)
+			}
+			if total < min {
+				min = total
+			}
+			if total > max {
+				max = total
+			}
+		}
+		//Dealing biggest first onto the lightest never leaves shards further
+		//apart than one member
+		if max-min > largest {
+			t.Errorf("Expected %v shards within %v bytes of each other, got %v to %v", n, largest, min, max)
+		}
+	}
+}
+
+func TestBalancedPlanFewMembers(t *testing.T) {
+	data := NameAndSizes{{Name: "a", Size: 10}, {Name: "b", Size: 5}}
+	plans, err := buildBalancedPlan(data, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plans) != 2 {
+		t.Errorf("Expected a shard for each of the 2 members, got %v", len(plans))
+	}
+	if _, err := buildBalancedPlan(data, 0); !errors.Is(err, ErrInvalidTarget) {
+		t.Errorf("Expected %v for no shards, got %v", ErrInvalidTarget, err)
+	}
+}
+
+func TestSplitNumShards(t *testing.T) {
+	var members []testMember
+	for i := 0; i < 30; i++ {
+		members = append(members, testMember{Name: fmt.Sprintf("f%02d", i), Body: strings.Repeat("x", 100*i)})
+	}
+	opts := testOptions(t)
+	opts.NumShards = 3
+	result, err := SplitReader(bytes.NewReader(makeTar(t, members...)), "in.tar", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Shards) != 3 {
+		t.Errorf("Expected 3 shards, got %v", len(result.Shards))
+	}
+	if entries := readShards(t, result); len(entries) != len(members) {
+		t.Errorf("Expected %v members in the shards, got %v", len(members), len(entries))
+	}
+}
+
+func TestCompressionRatio(t *testing.T) {
+	var members []testMember
+	for i := 0; i < 20; i++ {
+		members = append(members, testMember{Name: fmt.Sprintf("f%02d", i), Body: strings.Repeat("a", 1000)})
+	}
+	data := makeTar(t, members...)
+	perShard := make(map[float64]int)
+	for _, ratio := range []float64{0, 1, 0.4} {
+		opts := testOptions(t)
+		opts.TargetSize = 8192
+		opts.CompressionRatio = ratio
+		result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		most := 0
+		for _, shard := range result.Shards {
+			if shard.Members > most {
+				most = shard.Members
+			}
+		}
+		perShard[ratio] = most
+	}
+	if perShard[1] != perShard[0] {
+		t.Errorf("Expected a ratio of 1 to plan as none does, got %v members per shard against %v", perShard[1], perShard[0])
+	}
+	if perShard[0.4] <= perShard[0] {
+		t.Errorf("Expected more members per shard with a ratio of 0.4, got %v against %v", perShard[0.4], perShard[0])
+	}
+
+	for _, test := range []struct {
+		ratio float64
+		want  int64
+		err   bool
+	}{
+		{0, 8192, false},
+		{0.5, 16384, false},
+		{2, 4096, false},
+		{-0.5, 0, true},
+		{math.NaN(), 0, true},
+		{math.Inf(1), 0, true},
+	} {
+		got, err := planTarget(8192, Options{CompressionRatio: test.ratio})
+		if (err != nil) != test.err || got != test.want {
+			t.Errorf("Expected a ratio of %v to plan to %v, got %v and error %v", test.ratio, test.want, got, err)
+		}
+	}
+}
+
+func TestValidateTargetSize(t *testing.T) {
+	for _, size := range []int64{math.MinInt64, -1, 0, 1, blockSize, MinTargetSize - 1, MinTargetSize} {
+		if err := ValidateTargetSize(size); !errors.Is(err, ErrInvalidTarget) {
+			t.Errorf("Expected a target of %v bytes to be invalid, got %v", size, err)
+		}
+	}
+	if err := ValidateTargetSize(MinTargetSize + 1); err != nil {
+		t.Errorf("Expected a target of %v bytes to be valid, got %v", MinTargetSize+1, err)
+	}
+
+	data := makeTar(t, testMember{Name: "a", Body: "a"})
+	for _, size := range []int64{-1, 0, MinTargetSize} {
+		opts := testOptions(t)
+		opts.TargetSize = size
+		if _, err := SplitReader(bytes.NewReader(data), "in.tar", opts); !errors.Is(err, ErrInvalidTarget) {
+			t.Errorf("Expected splitting to a target of %v bytes to fail, got %v", size, err)
+		}
+		if entries, _ := os.ReadDir(opts.outDir); len(entries) > 0 {
+			t.Errorf("Expected no shards written for a target of %v bytes, got %v files", size, len(entries))
+		}
+	}
+}
+
+func TestMinShardSize(t *testing.T) {
+	data := NameAndSizes{{Name: "a", Size: 9000}, {Name: "b", Size: 9000}, {Name: "c", Size: 9000}, {Name: "d", Size: 1500}}
+	for _, test := range []struct {
+		name      string
+		tolerance float64
+		shards    int
+	}{
+		//d fits with none of the others
+		{"no tolerance", 0, 4},
+		{"tolerance", 0.1, 3},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			opts := Options{TargetSize: 10000, MinShardSize: 2000, OverfillTolerance: test.tolerance}
+			members := append(NameAndSizes(nil), data...)
+			sortForPacking(members, opts)
+			plans, err := packPlans(members, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkEveryMember(t, data, plans)
+			if len(plans) != test.shards {
+				t.Errorf("Expected %v shards, got %v", test.shards, len(plans))
+			}
+		})
+	}
+
+	r := rand.New(rand.NewSource(3))
+	data = make(NameAndSizes, 300)
+	for i := range data {
+		data[i] = NameAndSize{Name: fmt.Sprintf("f%03d", i), Size: r.Int63n(40000)}
+	}
+	opts := Options{TargetSize: 100000, MinShardSize: 60000, OverfillTolerance: 0.2}
+	sortForPacking(data, opts)
+	plans, err := packPlans(data, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEveryMember(t, data, plans)
+	limit := overfillLimit(opts.TargetSize, opts.OverfillTolerance)
+	for i, plan := range plans {
+		if plan.Size() > limit {
+			t.Errorf("Expected shard %v within %v bytes, got %v", i, limit, plan.Size())
+		}
+		if plan.Size() >= opts.MinShardSize {
+			continue
+		}
+		//A small shard is only left when no neighbour has room for it
+		for _, j := range []int{i - 1, i + 1} {
+			if j >= 0 && j < len(plans) && plans[j].Size()+plan.Size() <= limit {
+				t.Errorf("Expected shard %v of %v bytes merged into shard %v of %v", i, plan.Size(), j, plans[j].Size())
+			}
+		}
+	}
+}
+
+// shardsOf maps each prefix of the members planned to the shards holding them
+func shardsOf(plans []Plan, depth int) map[string]map[int]bool {
+	shards := make(map[string]map[int]bool)
+	for i, plan := range plans {
+		for _, member := range plan.Pool {
+			prefix := pathPrefix(member.Name, depth)
+			if shards[prefix] == nil {
+				shards[prefix] = make(map[int]bool)
+			}
+			shards[prefix][i] = true
+		}
+	}
+	return shards
+}
+
+func TestAffinityPlan(t *testing.T) {
+	var data NameAndSizes
+	//Sized so that packing biggest first mixes the trees
+	for i, size := range []int64{4000, 3500, 3000, 2500, 2000, 1500, 1000, 500} {
+		tree := []string{"app", "lib"}[i%2]
+		data = append(data, NameAndSize{Name: fmt.Sprintf("%s/sub/f%v", tree, i), Size: size})
+	}
+	data = append(data, NameAndSize{Name: "big/a", Size: 9000}, NameAndSize{Name: "big/b", Size: 9000})
+	opts := Options{TargetSize: 10000}
+	sortForPacking(data, opts)
+	mixed, err := packPlans(data, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shards := shardsOf(mixed, 1); len(shards["app"]) < 2 && len(shards["lib"]) < 2 {
+		t.Fatalf("Expected a tree split over shards without affinity, got %v", shards)
+	}
+
+	opts.AffinityDepth = 1
+	plans, err := packPlans(data, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEveryMember(t, data, plans)
+	shards := shardsOf(plans, 1)
+	for _, tree := range []string{"app", "lib"} {
+		if len(shards[tree]) != 1 {
+			t.Errorf("Expected %s together in one shard, got %v", tree, shards[tree])
+		}
+	}
+	//big is too big for one shard, so is packed as usual
+	if len(shards["big"]) != 2 {
+		t.Errorf("Expected big over two shards, got %v", shards["big"])
+	}
+	for i, plan := range plans {
+		if plan.Size() > opts.TargetSize {
+			t.Errorf("Expected shard %v within the target, got %v bytes", i, plan.Size())
+		}
+	}
+	//At depth 2 the groups are the same
+	opts.AffinityDepth = 2
+	if plans, err = packPlans(data, opts); err != nil {
+		t.Fatal(err)
+	}
+	if shards := shardsOf(plans, 2); len(shards["app/sub"]) != 1 || len(shards["lib/sub"]) != 1 {
+		t.Errorf("Expected each tree in one shard at depth 2, got %v", shards)
+	}
+}
+
+func TestFillRatio(t *testing.T) {
+	data := makeTar(t,
+		testMember{Name: "a", Body: strings.Repeat("a", 6000)},
+		testMember{Name: "b", Body: strings.Repeat("b", 3000)},
+		testMember{Name: "c", Body: strings.Repeat("c", 2000)},
+		testMember{Name: "d", Body: strings.Repeat("d", 500)},
+	)
+	opts := testOptions(t)
+	opts.TargetSize = 10000
+	opts.Manifest = filepath.Join(t.TempDir(), "manifest.json")
+	result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Shards) != 2 {
+		t.Fatalf("Expected 2 shards, got %v", len(result.Shards))
+	}
+	fills := make(map[int]float64)
+	var total float64
+	for _, shard := range result.Shards {
+		var size int
+		for _, entry := range readTar(t, shard.File) {
+			size += len(entry.Body)
+		}
+		fill := float64(size) / 10000
+		if shard.Fill != fill || shard.Planned != int64(size) {
+			t.Errorf("Expected shard %v filled %v with %v bytes, got %v with %v", shard.Index, fill, size, shard.Fill, shard.Planned)
+		}
+		fills[shard.Index] = fill
+		total += fill
+	}
+	if average := total / 2; result.AverageFill() != average {
+		t.Errorf("Expected an average fill of %v, got %v", average, result.AverageFill())
+	}
+	manifest, err := ReadManifest(opts.Manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, shard := range manifest.Shards {
+		if shard.Fill != fills[shard.Index] {
+			t.Errorf("Expected the manifest to record shard %v filled %v, got %v", shard.Index, fills[shard.Index], shard.Fill)
+		}
+	}
+	if manifest.AverageFill != result.AverageFill() {
+		t.Errorf("Expected the manifest's average fill %v, got %v", result.AverageFill(), manifest.AverageFill)
+	}
+
+	//Split by number of shards there is no target to fill
+	opts = testOptions(t)
+	opts.NumShards = 2
+	if result, err = SplitReader(bytes.NewReader(data), "in.tar", opts); err != nil {
+		t.Fatal(err)
+	}
+	if result.AverageFill() != 0 {
+		t.Errorf("Expected no fill without a target, got %v", result.AverageFill())
+	}
+}
+
+func TestOverheadBytes(t *testing.T) {
+	var members []testMember
+	for i := 0; i < 20; i++ {
+		members = append(members, testMember{Name: fmt.Sprintf("f%02d", i), Body: strings.Repeat("a", 700+100*(i%5))})
+	}
+	data := makeTar(t, members...)
+	for _, overhead := range []int64{0, 1000, 3000} {
+		opts := testOptions(t)
+		opts.TargetSize = 8000
+		opts.OverheadBytes = overhead
+		result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(readShards(t, result)) != len(members) {
+			t.Errorf("Expected every member split with %v bytes of overhead", overhead)
+		}
+		for _, shard := range result.Shards {
+			if shard.Planned > opts.TargetSize-overhead {
+				t.Errorf("Expected shard %v to leave %v bytes free, got %v of %v planned", shard.Index, overhead, shard.Planned, opts.TargetSize)
+			}
+		}
+	}
+
+	for _, overhead := range []int64{-1, 8000, 9000} {
+		opts := testOptions(t)
+		opts.TargetSize = 8000
+		opts.OverheadBytes = overhead
+		if _, err := SplitReader(bytes.NewReader(data), "in.tar", opts); !errors.Is(err, ErrInvalidTarget) {
+			t.Errorf("Expected an overhead of %v bytes to be invalid, got %v", overhead, err)
+		}
+	}
+}
+
+func TestTieredPlan(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	data := make(NameAndSizes, 200)
+	for i := range data {
+		data[i] = NameAndSize{Name: fmt.Sprintf("f%03d", i), Size: r.Int63n(3000)}
+	}
+	sortForPacking(data, Options{})
+	targets := []int64{4000, 8000, 12000}
+	plans, err := buildTieredPlan(data, targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEveryMember(t, data, plans)
+	if len(plans) < 4 {
+		t.Fatalf("Expected shards past the last target, got %v", len(plans))
+	}
+	for i, plan := range plans {
+		if want := tierTarget(targets, i); plan.Target != want {
+			t.Errorf("Expected shard %v planned to %v, got %v", i, want, plan.Target)
+		}
+		if plan.Size() > plan.Target {
+			t.Errorf("Expected shard %v within its target of %v, got %v bytes", i, plan.Target, plan.Size())
+		}
+	}
+}
+
+func TestAffinityPlanDescendingTargets(t *testing.T) {
+	data := NameAndSizes{
+		{Name: "a/1", Size: 4000}, {Name: "a/2", Size: 3000},
+		{Name: "b/1", Size: 3000}, {Name: "b/2", Size: 3000},
+		{Name: "c/1", Size: 2000},
+	}
+	sortForPacking(data, Options{})
+	for _, targets := range [][]int64{{8192, 4096}, {4096, 8192, 2048}, {8192, 4096, 2048}} {
+		t.Run(fmt.Sprint(targets), func(t *testing.T) {
+			done := make(chan struct{})
+			var plans []Plan
+			var err error
+			go func() {
+				defer close(done)
+				plans, err = buildAffinityPlan(data, targets, 1)
+			}()
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("Expected planning to finish")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkEveryMember(t, data, plans)
+			for i, plan := range plans {
+				//Only a member too big for its target goes over, alone
+				if plan.Size() > plan.Target && len(plan.Pool) > 1 {
+					t.Errorf("Expected shard %v within its target of %v, got %v bytes", i, plan.Target, plan.Size())
+				}
+			}
+			//c fits whatever the targets, so stays whole
+			if shards := shardsOf(plans, 1); len(shards["c"]) != 1 {
+				t.Errorf("Expected c in one shard, got %v", shards["c"])
+			}
+		})
+	}
+}
+
+func TestOversizeMinimum(t *testing.T) {
+	data := makeTar(t,
+		testMember{Name: "big", Body: strings.Repeat("b", 5000)},
+		testMember{Name: "bigger", Body: strings.Repeat("B", 7000)},
+		testMember{Name: "small", Body: "s"},
+	)
+	//Header, data padded to whole blocks and the trailer
+	minimum := int64(blockSize + 14*blockSize + 2*blockSize)
+	for _, test := range []struct {
+		name     string
+		overhead int64
+		want     int64
+	}{
+		{"no overhead", 0, minimum},
+		{"overhead", 100, minimum + 100},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			opts := testOptions(t)
+			opts.TargetSize = 4096
+			opts.OverheadBytes = test.overhead
+			opts.StrictSize = true
+			_, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+			var oversize *OversizeError
+			if !errors.As(err, &oversize) || !errors.Is(err, ErrOversizeFile) {
+				t.Fatalf("Expected an OversizeError, got %v", err)
+			}
+			want := OversizeError{Name: "bigger", Size: 7000, Minimum: test.want, Others: 1}
+			if *oversize != want {
+				t.Errorf("Expected %+v, got %+v", want, *oversize)
+			}
+			if !strings.Contains(err.Error(), fmt.Sprintf("A target of at least %v would fit it", test.want)) {
+				t.Errorf("Expected the error to suggest a target of %v, got %v", test.want, err)
+			}
+
+			opts.TargetSize = test.want
+			result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+			if err != nil || len(result.Oversize) > 0 {
+				t.Errorf("Expected the suggested target to fit every member, got error %v", err)
+			}
+		})
+	}
+
+	//Without StrictSize they are only warned about
+	opts := testOptions(t)
+	opts.TargetSize = 4096
+	result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if warning := result.OversizeWarning(opts); !strings.Contains(warning, fmt.Sprintf("A target of at least %v would fit it", minimum)) {
+		t.Errorf("Expected a warning suggesting %v, got %q", minimum, warning)
+	}
+}
+
+func TestTinyTarget(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		size     int64
+		target   int64
+		perShard int
+	}{
+		{"one per shard", 900, 1600, 1},
+		{"two per shard", 900, 1800, 2},
+		{"each oversize", 2000, 1600, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			data := make(NameAndSizes, 51)
+			for i := range data {
+				data[i] = NameAndSize{Name: fmt.Sprintf("f%02d", i), Size: test.size}
+			}
+			plans, err := buildTarPlan(data, test.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkEveryMember(t, data, plans)
+			if want := (len(data) + test.perShard - 1) / test.perShard; len(plans) != want {
+				t.Errorf("Expected %v shards, got %v", want, len(plans))
+			}
+			for i, plan := range plans {
+				if len(plan.Pool) == 0 || len(plan.Pool) > test.perShard {
+					t.Errorf("Expected shard %v to hold 1 to %v members, got %v", i, test.perShard, len(plan.Pool))
+				}
+			}
+		})
+	}
+}
+
+func TestHugeSizes(t *testing.T) {
+	huge := int64(math.MaxInt64 - 10)
+	data := NameAndSizes{{Name: "a", Size: huge}, {Name: "b", Size: huge}, {Name: "c", Size: 100}}
+	for name, build := range map[string]func() ([]Plan, error){
+		"tiered":   func() ([]Plan, error) { return buildTarPlan(data, math.MaxInt64) },
+		"affinity": func() ([]Plan, error) { return buildAffinityPlan(data, []int64{math.MaxInt64}, 1) },
+	} {
+		plans, err := build()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		checkEveryMember(t, data, plans)
+		for _, plan := range plans {
+			if size := plan.Size(); size < 0 || len(plan.Pool) > 1 && size > plan.Target {
+				t.Errorf("%s: expected no plan to wrap past its target, got %v bytes in %v", name, size, plan.Pool)
+			}
+		}
+		if len(plans) < 2 {
+			t.Errorf("%s: expected the huge members in shards of their own, got %v", name, plans)
+		}
+	}
+	merged := mergeSmallShards([]Plan{{Pool: data[:1], Target: math.MaxInt64}, {Pool: data[1:2], Target: math.MaxInt64}}, math.MaxInt64, 0.5)
+	if len(merged) != 2 {
+		t.Errorf("Expected huge plans left unmerged, got %v", merged)
+	}
+
+	for _, size := range []int64{0, 1, 1 << 40, math.MaxInt64 - 4*blockSize, math.MaxInt64 - 1, math.MaxInt64} {
+		for _, opts := range []Options{{}, {CompressionRatio: 1.5}, {OverheadBytes: math.MaxInt64 / 2}} {
+			if got := MinimumTarget(size, opts); got < size {
+				t.Errorf("Expected a minimum target of at least %v with %+v, got %v", size, opts, got)
+			}
+		}
+	}
+
+	for _, test := range []struct {
+		data NameAndSizes
+		ok   bool
+	}{
+		{NameAndSizes{{Name: "a", Size: 1 << 50}, {Name: "b", Size: 1 << 50}}, true},
+		{NameAndSizes{{Name: "a", Size: -1}}, false},
+		{NameAndSizes{{Name: "a", Size: math.MaxInt64}}, false},
+		{NameAndSizes{{Name: "a", Size: math.MaxInt64 / 2}, {Name: "b", Size: math.MaxInt64 / 2}}, false},
+	} {
+		if err := checkTotalSize(test.data); (err == nil) != test.ok {
+			t.Errorf("Expected %v accepted %v, got error %v", test.data, test.ok, err)
+		}
+	}
+}
+
+func TestPackOrder(t *testing.T) {
+	var members []testMember
+	for i, size := range []int{3000, 500, 2000, 1500, 700, 2600, 400, 1000, 2200, 300, 1800, 900} {
+		members = append(members, testMember{Name: fmt.Sprintf("f%02d", i), Body: strings.Repeat("x", size)})
+	}
+	data := makeTar(t, members...)
+	shards := make(map[string]int)
+	for name, less := range map[string]PackLess{"default": nil, "size-desc": PackBySizeDesc, "size-asc": PackBySizeAsc, "name": PackByName} {
+		opts := testOptions(t)
+		opts.TargetSize = 4096
+		opts.PackLess = less
+		result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if entries := readShards(t, result); len(entries) != len(members) {
+			t.Errorf("%s: expected %v members in the shards, got %v", name, len(members), len(entries))
+		}
+		shards[name] = len(result.Shards)
+	}
+	//17900 bytes of data need at least 5 shards of 4096
+	if shards["default"] != 5 || shards["size-desc"] != shards["default"] {
+		t.Errorf("Expected biggest first to pack into 5 shards, got %v", shards)
+	}
+	if shards["size-asc"] <= shards["size-desc"] || shards["name"] < shards["size-desc"] {
+		t.Errorf("Expected no order to pack tighter than biggest first, and smallest first looser, got %v", shards)
+	}
+}
+
+func TestPlanCrossover(t *testing.T) {
+	const target = 4096
+	for _, test := range []struct {
+		name  string
+		sizes []int64
+		want  [][]int
+	}{
+		{"largest at the target", []int64{target, 1000, 500}, [][]int{{0}, {1, 2}}},
+		{"every member at the target", []int64{target, target, target}, [][]int{{0}, {1}, {2}}},
+		{"largest one byte over", []int64{target + 1, 1000, 500}, [][]int{{0}, {1, 2}}},
+		{"only member one byte over", []int64{target + 1}, [][]int{{0}}},
+		{"remainder fills the target", []int64{2000, 1500, 596}, [][]int{{0, 1, 2}}},
+		{"remainder one byte over", []int64{2000, 1500, 597}, [][]int{{0, 1}, {2}}},
+		//The reverse fill takes every member after the one that didn't fit
+		{"reverse fill reaches it", []int64{3000, 2000, 300, 300, 300}, [][]int{{0, 4, 3, 2}, {1}}},
+		//The room left equals the next member, which the reverse fill only
+		//takes when it is strictly smaller
+		{"room equal to the member", []int64{3000, 2000, 596, 500}, [][]int{{0, 3}, {1, 2}}},
+		{"room one byte more", []int64{3000, 2000, 595, 500}, [][]int{{0, 3, 2}, {1}}},
+	} {
+		data := make(NameAndSizes, len(test.sizes))
+		for i, size := range test.sizes {
+			data[i] = NameAndSize{Name: fmt.Sprintf("f%v", i), Size: size}
+		}
+		plans, err := buildTarPlan(data, target)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		checkEveryMember(t, data, plans)
+		var got [][]int
+		for _, plan := range plans {
+			var members []int
+			for _, member := range plan.Pool {
+				var i int
+				fmt.Sscanf(member.Name, "f%d", &i)
+				members = append(members, i)
+			}
+			got = append(got, members)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: expected plans %v, got %v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestCheckPlanned(t *testing.T) {
+	data := NameAndSizes{{Name: "a", Size: 3000}, {Name: "b", Size: 2000}, {Name: "c", Size: 1000}, {Name: "d", Size: 0}}
+	plans, err := buildTarPlan(data, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkPlanned(data, plans); err != nil {
+		t.Fatalf("Expected the plans as built to check out, got %v", err)
+	}
+	for _, test := range []struct {
+		name    string
+		corrupt func(plans []Plan) []Plan
+	}{
+		{"member dropped", func(plans []Plan) []Plan {
+			plans[0].Pool = plans[0].Pool[1:]
+			return plans
+		}},
+		{"member planned twice", func(plans []Plan) []Plan {
+			plans[1].Pool = append(plans[1].Pool, plans[0].Pool[0])
+			return plans
+		}},
+		{"empty member planned twice", func(plans []Plan) []Plan {
+			plans[0].Pool = append(plans[0].Pool, NameAndSize{Name: "d"})
+			return plans
+		}},
+		{"size changed", func(plans []Plan) []Plan {
+			plans[0].Pool[0].Size++
+			return plans
+		}},
+		{"shard lost", func(plans []Plan) []Plan {
+			return plans[1:]
+		}},
+	} {
+		corrupted := make([]Plan, len(plans))
+		for i, plan := range plans {
+			corrupted[i] = plan
+			corrupted[i].Pool = append(NameAndSizes(nil), plan.Pool...)
+		}
+		if err := checkPlanned(data, test.corrupt(corrupted)); err == nil || !strings.Contains(err.Error(), "Planning went wrong") {
+			t.Errorf("%s: expected the corrupted plans refused, got %v", test.name, err)
+		}
+	}
+}
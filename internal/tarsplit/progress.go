@@ -0,0 +1,165 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"io"
+	"strings"
+)
+
+// Progress is how many bytes of member data have been copied into shards
+type Progress struct {
+	Done  int64
+	Total int64
+	// Member is the name of the member being copied
+	Member string
+}
+
+// tally keeps count of what has happened so far while writing the shards
+type tally struct {
+	skipped  Skipped
+	failed   []MemberError
+	clamped  []ClampedTime
+	shards   []ShardResult
+	progress Progress
+	report   func(Progress)
+	onShard  func(ShardResult)
+	onFinish func(ShardResult)
+	// keep are the entries copied into the shards though their kind isn't
+	// carried, the empty directories and symlinks of a FromDir split
+	keep map[string]bool
+	// buf is the buffer every member's data is copied through
+	buf []byte
+}
+
+// DefaultCopyBuffer is the size of the buffer member data is copied through
+// when Options.CopyBuffer isn't set
+const DefaultCopyBuffer = 128 * 1024
+
+func (t *tally) result() *Result {
+	return &Result{Skipped: t.skipped, Failed: t.failed, Shards: t.shards, ClampedTimes: t.clamped}
+}
+
+// finish notes a shard that is complete
+func (t *tally) finish(shard ShardResult) {
+	t.shards = append(t.shards, shard)
+	if t.onFinish != nil {
+		t.onFinish(shard)
+	}
+	if t.onShard != nil {
+		t.onShard(shard)
+	}
+}
+
+// fail notes that member name could not be copied because of err when
+// SkipErrors is set, otherwise it returns err to end the split
+func (t *tally) fail(name string, err error, opts Options) error {
+	if !opts.SkipErrors {
+		return err
+	}
+	t.failed = append(t.failed, MemberError{Name: name, Err: err})
+	return nil
+}
+
+func newTally(plans []Plan, opts Options) *tally {
+	t := &tally{skipped: make(Skipped), report: opts.Progress, onShard: opts.onShard, onFinish: opts.OnShardFinish}
+	if opts.CopyBuffer > 0 {
+		t.buf = make([]byte, opts.CopyBuffer)
+	} else {
+		t.buf = make([]byte, DefaultCopyBuffer)
+	}
+	for _, plan := range plans {
+		for _, member := range plan.Pool {
+			t.progress.Total += member.Size
+		}
+	}
+	if opts.FromDir {
+		t.keep = dirLinks(plans)
+		if !opts.DropEmptyDirs {
+			for name := range emptyDirs(plans) {
+				t.keep[name] = true
+			}
+		}
+	}
+	return t
+}
+
+// dirLinks finds the symlinks among the members of plans. A directory's
+// symlinks are kept as links unless FollowSymlinks stored what they point to
+func dirLinks(plans []Plan) map[string]bool {
+	links := make(map[string]bool)
+	for _, plan := range plans {
+		for _, member := range plan.Pool {
+			if member.Typeflag == tar.TypeSymlink {
+				links[member.Name] = true
+			}
+		}
+	}
+	return links
+}
+
+// emptyDirs finds the directories among the members of plans that no other
+// member is under
+func emptyDirs(plans []Plan) map[string]bool {
+	parents := make(map[string]bool)
+	for _, plan := range plans {
+		for _, member := range plan.Pool {
+			name := strings.TrimSuffix(member.Name, "/")
+			for i := strings.LastIndex(name, "/"); i >= 0; i = strings.LastIndex(name, "/") {
+				name = name[:i]
+				//Its own parents were marked along with it
+				if parents[name+"/"] {
+					break
+				}
+				parents[name+"/"] = true
+			}
+		}
+	}
+	empty := make(map[string]bool)
+	for _, plan := range plans {
+		for _, member := range plan.Pool {
+			if member.IsDir() && !parents[member.Name] {
+				empty[member.Name] = true
+			}
+		}
+	}
+	return empty
+}
+
+// progressReader reports the member data read through it to the tally
+type progressReader struct {
+	r io.Reader
+	t *tally
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.t.progress.Done += int64(n)
+		p.t.reportProgress()
+	}
+	return n, err
+}
+
+// startMember notes that copying member name has begun
+func (t *tally) startMember(name string) {
+	t.progress.Member = name
+	t.reportProgress()
+}
+
+func (t *tally) reportProgress() {
+	if t.report != nil {
+		t.report(t.progress)
+	}
+}
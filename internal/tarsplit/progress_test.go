@@ -0,0 +1,49 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestProgressMembers(t *testing.T) {
+	names := []string{"m", "c", "x", "a", "q"}
+	var members []testMember
+	for i, name := range names {
+		members = append(members, testMember{Name: name, Body: strings.Repeat(name, 1000*(i+1))})
+	}
+	opts := testOptions(t)
+	opts.Strategy = StrategySinglePass
+	var got []string
+	var last Progress
+	opts.Progress = func(progress Progress) {
+		if progress.Done < last.Done {
+			t.Errorf("Expected progress to only go forward, got %v after %v", progress.Done, last.Done)
+		}
+		if progress.Member != last.Member {
+			got = append(got, progress.Member)
+		}
+		last = progress
+	}
+	if _, err := SplitReader(bytes.NewReader(makeTar(t, members...)), "in.tar", opts); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != strings.Join(names, ",") {
+		t.Errorf("Expected members reported in the order copied, %v, got %v", names, got)
+	}
+	if last.Total != 15000 || last.Done != last.Total {
+		t.Errorf("Expected progress to end with all 15000 bytes done, got %v of %v", last.Done, last.Total)
+	}
+}
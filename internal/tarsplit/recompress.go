@@ -0,0 +1,178 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Codec is how the tar in a shard's file is compressed
+type Codec int
+
+const (
+	// CodecNone leaves the tar uncompressed, as a split writes it
+	CodecNone Codec = iota
+	// CodecGzip gzips the tar, adding .gz to the shard's name
+	CodecGzip
+)
+
+// Recompress rewrites every shard listed by the manifest at opts.Manifest
+// with codec, at the compress/gzip level for CodecGzip. The tar inside each
+// shard is kept byte for byte, only how it is compressed changes. Each shard
+// is renamed for its codec and gets its new size and digest in the manifest,
+// which is written back in the format it was read in once every shard is
+// done, or as far as the shards that were when one fails. With
+// opts.Checksums set a SHA256SUMS covering the new shards is written too.
+//
+// Shards a split in a container or one that was interrupted before writing
+// them can't be recompressed, the first are refused and the second skipped
+func Recompress(codec Codec, level int, opts Options) ([]ShardResult, error) {
+	if codec == CodecGzip && (level < gzip.HuffmanOnly || level > gzip.BestCompression) {
+		return nil, fmt.Errorf("Gzip level must be between %v and %v, got %v", gzip.HuffmanOnly, gzip.BestCompression, level)
+	}
+	data, err := os.ReadFile(opts.Manifest)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read manifest %s, got error %w", opts.Manifest, err)
+	}
+	manifest, err := decodeManifest(data)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse manifest %s, got error %w", opts.Manifest, err)
+	}
+	if manifest.Container != "" {
+		return nil, fmt.Errorf("The shards of manifest %s are members of %s and can't be recompressed in place", opts.Manifest, manifest.Container)
+	}
+
+	var shards []ShardResult
+	var failed error
+	for i := range manifest.Shards {
+		shard := &manifest.Shards[i]
+		//Never written, so there is nothing to recompress
+		if shard.Size == 0 {
+			continue
+		}
+		result, err := recompressShard(shard, codec, level)
+		if result.File != "" {
+			shards = append(shards, result)
+		}
+		if err != nil {
+			failed = err
+			break
+		}
+	}
+	//Shards already renamed have to be recorded whether or not the rest were
+	if err := writeManifest(opts.Manifest, manifest, manifestFormatOf(data)); err != nil {
+		return shards, err
+	}
+	if failed != nil {
+		return shards, failed
+	}
+	if opts.Checksums != "" {
+		if err := writeChecksums(opts.Checksums, shards); err != nil {
+			return shards, err
+		}
+	}
+	return shards, nil
+}
+
+// recompressShard rewrites shard with codec, updating its entry to the new
+// file. The new file is written under a temporary name and only replaces the
+// old one once complete
+func recompressShard(shard *ManifestShard, codec Codec, level int) (ShardResult, error) {
+	file, err := openSource(shard.File)
+	if err != nil {
+		return ShardResult{}, err
+	}
+	defer file.Close()
+	src, err := newSource(file, shard.File)
+	if err != nil {
+		return ShardResult{}, err
+	}
+	r, closeStream, err := src.stream()
+	if err != nil {
+		return ShardResult{}, err
+	}
+	defer closeStream()
+
+	name := codecName(shard.File, codec)
+	out, err := os.Create(name + tmpSuffix)
+	if err != nil {
+		return ShardResult{}, fmt.Errorf("Could not create %s, got error %w", name+tmpSuffix, err)
+	}
+	h := sha256.New()
+	err = compressTo(io.MultiWriter(out, h), r, codec, level)
+	var fi os.FileInfo
+	if err == nil {
+		fi, err = out.Stat()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return ShardResult{}, fmt.Errorf("Could not recompress shard %s, got error %w", shard.File, err)
+	}
+	digest := formatDigest(h)
+	if shard.Digest != "" && strings.TrimSuffix(filepath.Base(shard.File), gzipSuffix) == digestName(shard.Digest) {
+		//Named by NameDigest after its content, which has just changed
+		name = codecName(filepath.Join(filepath.Dir(shard.File), digestName(digest)), codec)
+	}
+	if err := os.Rename(out.Name(), name); err != nil {
+		os.Remove(out.Name())
+		return ShardResult{}, fmt.Errorf("Could not rename recompressed shard to %s, got error %w", name, err)
+	}
+	old := shard.File
+	shard.File = name
+	shard.Size = fi.Size()
+	shard.Digest = digest
+	result := ShardResult{Index: shard.Index, File: name, Members: len(shard.Members), Size: shard.Size, Fill: shard.Fill, Digest: digest}
+	if name != old {
+		if err := os.Remove(old); err != nil {
+			return result, fmt.Errorf("Could not remove %s after recompressing it to %s, got error %w", old, name, err)
+		}
+	}
+	return result, nil
+}
+
+// gzipSuffix ends the name of a gzipped shard
+const gzipSuffix = ".gz"
+
+// codecName is the name a shard at file has once compressed with codec
+func codecName(file string, codec Codec) string {
+	file = strings.TrimSuffix(file, gzipSuffix)
+	if codec == CodecGzip {
+		return file + gzipSuffix
+	}
+	return file
+}
+
+// compressTo copies the tar r onto w compressed with codec
+func compressTo(w io.Writer, r io.Reader, codec Codec, level int) error {
+	if codec == CodecNone {
+		_, err := io.Copy(w, r)
+		return err
+	}
+	gz, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(gz, r); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,129 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gunzipFile is the content of the gzipped file at path
+func gunzipFile(t *testing.T, path string) []byte {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("Expected %s gzipped, got error %v", path, err)
+	}
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestRecompress(t *testing.T) {
+	for _, naming := range []Naming{NameIndex, NameDigest} {
+		opts := testOptions(t)
+		opts.TargetSize = 8192
+		opts.Naming = naming
+		opts.Manifest = filepath.Join(opts.outDir, "manifest.json")
+		opts.Checksums = filepath.Join(opts.outDir, "SHA256SUMS")
+		result, err := SplitReader(bytes.NewReader(genTar(t, 20, 700)), "in.tar", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		original := make(map[int][]byte)
+		for _, shard := range result.Shards {
+			if original[shard.Index], err = os.ReadFile(shard.File); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		shards, err := Recompress(CodecGzip, gzip.BestCompression, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(shards) != len(result.Shards) {
+			t.Fatalf("Expected all %v shards recompressed, got %v", len(result.Shards), len(shards))
+		}
+		manifest, err := ReadManifest(opts.Manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, shard := range manifest.Shards {
+			if !strings.HasSuffix(shard.File, ".tar.gz") {
+				t.Errorf("Expected shard %v renamed .tar.gz, got %s", shard.Index, shard.File)
+			}
+			if got := gunzipFile(t, shard.File); !bytes.Equal(got, original[shard.Index]) {
+				t.Errorf("Expected shard %v to hold the same tar gzipped, got %v bytes for %v", shard.Index, len(got), len(original[shard.Index]))
+			}
+			data, err := os.ReadFile(shard.File)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sum := sha256.Sum256(data)
+			if shard.Size != int64(len(data)) || shard.Digest != "sha256:"+hex.EncodeToString(sum[:]) {
+				t.Errorf("Expected the manifest updated for shard %v, got size %v and digest %s", shard.Index, shard.Size, shard.Digest)
+			}
+			if naming == NameDigest && filepath.Base(shard.File) != digestName(shard.Digest)+".gz" {
+				t.Errorf("Expected shard %v named after its new digest, got %s", shard.Index, shard.File)
+			}
+		}
+		for _, shard := range result.Shards {
+			if _, err := os.Stat(shard.File); !os.IsNotExist(err) {
+				t.Errorf("Expected the plain shard %s removed, got error %v", shard.File, err)
+			}
+		}
+		if listed := verifyChecksums(t, opts.Checksums); len(listed) != len(shards) {
+			t.Errorf("Expected the checksums to list the %v new shards, got %v", len(shards), listed)
+		}
+
+		//And back again, to the very same shards
+		if _, err := Recompress(CodecNone, 0, opts); err != nil {
+			t.Fatal(err)
+		}
+		if manifest, err = ReadManifest(opts.Manifest); err != nil {
+			t.Fatal(err)
+		}
+		for _, shard := range manifest.Shards {
+			data, err := os.ReadFile(shard.File)
+			if err != nil || !bytes.Equal(data, original[shard.Index]) {
+				t.Errorf("Expected shard %v back as it was split, got error %v", shard.Index, err)
+			}
+		}
+		if files, _ := filepath.Glob(filepath.Join(opts.outDir, "*"+tmpSuffix)); len(files) != 0 {
+			t.Errorf("Expected no temporary files left, got %v", files)
+		}
+	}
+
+	opts := testOptions(t)
+	opts.Manifest = filepath.Join(opts.outDir, "manifest.json")
+	if _, err := Recompress(CodecGzip, 12, opts); err == nil {
+		t.Error("Expected a gzip level past 9 refused")
+	}
+	if _, err := Recompress(CodecGzip, gzip.DefaultCompression, opts); err == nil {
+		t.Error("Expected a missing manifest to be an error")
+	}
+}
@@ -0,0 +1,109 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	recordIndex  = "tarlayer.index"
+	recordTotal  = "tarlayer.total"
+	recordSource = "tarlayer.source"
+	//Comma separated, one for each source
+	recordSourceDigest = "tarlayer.source-digest"
+	//Followed by the label's key
+	recordLabel = "tarlayer.label."
+)
+
+// ShardInfo is what a shard written with GlobalRecords says about itself
+type ShardInfo struct {
+	Index  int
+	Total  int
+	Source string
+	// SourceDigests are the digests of the sources split, when recorded
+	SourceDigests []string
+	// Labels are the labels the shard was stamped with
+	Labels map[string]string
+}
+
+// checkLabels makes sure every key of labels can name a PAX record
+func checkLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if key == "" || strings.ContainsAny(key, "= \t\n\x00") {
+			return fmt.Errorf("Label key %q must be non-empty, with no spaces, = or NUL", key)
+		}
+		if strings.ContainsRune(value, 0) {
+			return fmt.Errorf("Label %s can't hold a NUL", key)
+		}
+	}
+	return nil
+}
+
+// writeGlobalRecords starts a shard with a PAX global header describing it
+func writeGlobalRecords(tw *tar.Writer, info ShardInfo) error {
+	records := map[string]string{
+		recordIndex:  strconv.Itoa(info.Index),
+		recordTotal:  strconv.Itoa(info.Total),
+		recordSource: info.Source,
+	}
+	if len(info.SourceDigests) > 0 {
+		records[recordSourceDigest] = strings.Join(info.SourceDigests, ",")
+	}
+	for key, value := range info.Labels {
+		records[recordLabel+key] = value
+	}
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag:   tar.TypeXGlobalHeader,
+		Name:       "pax_global_header",
+		PAXRecords: records,
+	})
+	if err != nil {
+		return fmt.Errorf("Could not write global records for tarball %v, got error %w", info.Index, err)
+	}
+	return nil
+}
+
+// ReadShardInfo reads the global records a shard starts with. It consumes the
+// first header of tr, which must be those records
+func ReadShardInfo(tr *tar.Reader) (*ShardInfo, error) {
+	header, err := tr.Next()
+	if err != nil {
+		return nil, err
+	}
+	if header.Typeflag != tar.TypeXGlobalHeader {
+		return nil, fmt.Errorf("Shard does not start with global records, found %s", header.Name)
+	}
+	info := &ShardInfo{Source: header.PAXRecords[recordSource]}
+	if digests, ok := header.PAXRecords[recordSourceDigest]; ok {
+		info.SourceDigests = strings.Split(digests, ",")
+	}
+	for key, value := range header.PAXRecords {
+		if strings.HasPrefix(key, recordLabel) {
+			if info.Labels == nil {
+				info.Labels = make(map[string]string)
+			}
+			info.Labels[strings.TrimPrefix(key, recordLabel)] = value
+		}
+	}
+	if info.Index, err = strconv.Atoi(header.PAXRecords[recordIndex]); err != nil {
+		return nil, fmt.Errorf("Shard has no valid %s record, got error %w", recordIndex, err)
+	}
+	if info.Total, err = strconv.Atoi(header.PAXRecords[recordTotal]); err != nil {
+		return nil, fmt.Errorf("Shard has no valid %s record, got error %w", recordTotal, err)
+	}
+	return info, nil
+}
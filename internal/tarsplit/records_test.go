@@ -0,0 +1,156 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGlobalRecords(t *testing.T) {
+	opts := testOptions(t)
+	opts.TargetSize = 4 * blockSize
+	opts.GlobalRecords = true
+	data := makeTar(t, testMember{Name: "a", Body: string(make([]byte, 3*blockSize))}, testMember{Name: "b", Body: string(make([]byte, 3*blockSize))}, testMember{Name: "c", Body: "c"})
+	result, err := SplitReader(bytes.NewReader(data), "layer.tar", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Shards) < 2 {
+		t.Fatalf("Expected more than one shard, got %v", len(result.Shards))
+	}
+	for _, shard := range result.Shards {
+		file, err := os.Open(shard.File)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := ReadShardInfo(tar.NewReader(file))
+		file.Close()
+		if err != nil {
+			t.Fatalf("Could not read the records of %s, got error %v", shard.File, err)
+		}
+		want := ShardInfo{Index: shard.Index, Total: len(result.Shards), Source: "layer.tar"}
+		if info.Index != want.Index || info.Total != want.Total || info.Source != want.Source {
+			t.Errorf("Expected %s to record %+v, got %+v", shard.File, want, *info)
+		}
+	}
+
+	//A shard written without them has no records to read
+	opts = testOptions(t)
+	result, err = SplitReader(bytes.NewReader(data), "layer.tar", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(result.Shards[0].File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := ReadShardInfo(tar.NewReader(file)); err == nil {
+		t.Error("Expected a shard with no global records to be an error")
+	}
+}
+
+func TestSourceHash(t *testing.T) {
+	dir := t.TempDir()
+	first := makeTar(t, testMember{Name: "a", Body: "first"})
+	second := gzipBytes(t, makeTar(t, testMember{Name: "b", Body: "second"}))
+	filenames := []string{writeFile(t, dir, "first.tar", first), writeFile(t, dir, "second.tar.gz", second)}
+	//The digest of the file as given, even when gzipped
+	want := []string{fmt.Sprintf("sha256:%x", sha256.Sum256(first)), fmt.Sprintf("sha256:%x", sha256.Sum256(second))}
+	for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+		t.Run(name, func(t *testing.T) {
+			opts := testOptions(t)
+			opts.Strategy = strategy
+			opts.SourceHash = true
+			opts.GlobalRecords = true
+			opts.Manifest = filepath.Join(t.TempDir(), "manifest.json")
+			result, err := SplitAll(filenames, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			manifest, err := ReadManifest(opts.Manifest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(manifest.SourceDigests, want) {
+				t.Errorf("Expected the manifest to record %v, got %v", want, manifest.SourceDigests)
+			}
+			for _, shard := range result.Shards {
+				file, err := os.Open(shard.File)
+				if err != nil {
+					t.Fatal(err)
+				}
+				info, err := ReadShardInfo(tar.NewReader(file))
+				file.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(info.SourceDigests, want) {
+					t.Errorf("Expected %s to record %v, got %v", shard.File, want, info.SourceDigests)
+				}
+			}
+		})
+	}
+}
+
+func TestLabels(t *testing.T) {
+	labels := map[string]string{"build": "1234", "commit": "9f2c1e0", "note": "two words, and = signs"}
+	data := genTar(t, 12, 1000)
+	for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+		opts := testOptions(t)
+		opts.TargetSize = 4096
+		opts.Strategy = strategy
+		opts.Labels = labels
+		opts.Manifest = filepath.Join(t.TempDir(), "in.json")
+		result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		for _, shard := range result.Shards {
+			file, err := os.Open(shard.File)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := ReadShardInfo(tar.NewReader(file))
+			file.Close()
+			if err != nil {
+				t.Fatalf("%s: could not read the labels of %s, got error %v", name, shard.File, err)
+			}
+			if !reflect.DeepEqual(info.Labels, labels) || info.Index != shard.Index {
+				t.Errorf("%s: expected %s labelled %v, got %+v", name, shard.File, labels, *info)
+			}
+		}
+		manifest, err := ReadManifest(opts.Manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(manifest.Labels, labels) {
+			t.Errorf("%s: expected the manifest labelled %v, got %v", name, labels, manifest.Labels)
+		}
+	}
+
+	for _, bad := range []map[string]string{{"": "empty"}, {"with space": "x"}, {"a=b": "x"}, {"nul": "a\x00b"}} {
+		opts := testOptions(t)
+		opts.Labels = bad
+		if _, err := SplitReader(bytes.NewReader(data), "in.tar", opts); err == nil {
+			t.Errorf("Expected label %q refused", bad)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+)
+
+// resumePlans splits plans, rebuilt from m, into those still to be written and
+// the shards m lists that are already complete. The members of those are
+// added to existing so copying passes over them
+func resumePlans(m *Manifest, plans []Plan, existing map[string]bool) ([]Plan, []ShardResult) {
+	listed := make(map[int]ManifestShard, len(m.Shards))
+	for _, shard := range m.Shards {
+		listed[shard.Index] = shard
+	}
+	var todo []Plan
+	var kept []ShardResult
+	for _, plan := range plans {
+		shard := listed[plan.Index]
+		size, ok := shardComplete(shard)
+		if !ok {
+			todo = append(todo, plan)
+			continue
+		}
+		kept = append(kept, ShardResult{
+			Index:   plan.Index,
+			File:    shard.File,
+			Members: len(plan.Pool),
+			Size:    size,
+			Planned: plan.Size(),
+			Fill:    fillRatio(plan),
+			Digest:  shard.Digest,
+		})
+		for _, member := range plan.Pool {
+			existing[member.Name] = true
+		}
+	}
+	return todo, kept
+}
+
+// shardComplete reports whether the shard described by shard is on disk in
+// full, and its size. It goes by the recorded size and digest when there are
+// any, and otherwise reads the shard back to check it holds all its members
+func shardComplete(shard ManifestShard) (int64, bool) {
+	fi, err := os.Stat(shard.File)
+	if err != nil || (shard.Size > 0 && fi.Size() != shard.Size) {
+		return 0, false
+	}
+	if shard.Digest != "" {
+		digest, err := fileDigest(shard.File)
+		return fi.Size(), err == nil && digest == shard.Digest
+	}
+	return fi.Size(), checkShard(shard) == nil
+}
+
+// checkShard reads the shard back, checking it holds just the members listed
+// for it, each with all its data
+func checkShard(shard ManifestShard) error {
+	file, err := os.Open(shard.File)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	expected := make(map[string]int64)
+	for _, member := range shard.Members {
+		expected[member.Name] = member.Size
+	}
+	tr := tar.NewReader(newPositionReader(file))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader || isEmbeddedIndex(header) {
+			continue
+		}
+		size, ok := expected[header.Name]
+		if !ok || (size != header.Size && !isDedupeLink(header, shard)) {
+			return fmt.Errorf("Shard %s holds %s which the manifest doesn't list for it", shard.File, header.Name)
+		}
+		if _, err := io.Copy(io.Discard, tr); err != nil {
+			return err
+		}
+		delete(expected, header.Name)
+	}
+	//Entries that aren't copied, like symlinks, are planned with no data
+	for name, size := range expected {
+		if size > 0 {
+			return fmt.Errorf("Shard %s is missing %s", shard.File, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResume(t *testing.T) {
+	dir := t.TempDir()
+	source := writeFile(t, dir, "in.tar", genTar(t, 12, 1000))
+	opts := testOptions(t)
+	opts.TargetSize = 4096
+	opts.Manifest = filepath.Join(dir, "in.json")
+	first, err := Split(source, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Shards) < 3 {
+		t.Fatalf("Expected several shards, got %v", len(first.Shards))
+	}
+	original := make(map[int][]byte)
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, shard := range first.Shards {
+		if original[shard.Index], err = os.ReadFile(shard.File); err != nil {
+			t.Fatal(err)
+		}
+		//A shard written again gets a new time
+		if err := os.Chtimes(shard.File, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//One shard is gone and another was cut short
+	missing, cut := first.Shards[1], first.Shards[2]
+	if err := os.Remove(missing.File); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(cut.File, cut.Size/2); err != nil {
+		t.Fatal(err)
+	}
+
+	opts.Manifest = ""
+	opts.Resume = filepath.Join(dir, "in.json")
+	resumed, err := Split(source, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := make(map[int]bool)
+	for _, shard := range resumed.Shards {
+		written[shard.Index] = true
+	}
+	if len(written) != 2 || !written[missing.Index] || !written[cut.Index] {
+		t.Errorf("Expected only shards %v and %v written again, got %v", missing.Index, cut.Index, written)
+	}
+	for _, shard := range first.Shards {
+		data, err := os.ReadFile(shard.File)
+		if err != nil || !bytes.Equal(data, original[shard.Index]) {
+			t.Errorf("Expected shard %v as first written, got error %v", shard.Index, err)
+		}
+		fi, err := os.Stat(shard.File)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rewritten := !fi.ModTime().Equal(old); rewritten != written[shard.Index] {
+			t.Errorf("Expected shard %v rewritten %v, got %v", shard.Index, written[shard.Index], rewritten)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Result describes what a split did
+type Result struct {
+	// Skipped counts the entries that were left out of the shards, by Typeflag
+	Skipped Skipped
+	// Failed lists the members left out because copying them failed, only
+	// when SkipErrors is set
+	Failed []MemberError
+	// Shards lists the shards written, in the order they were completed
+	Shards []ShardResult
+	// Oversize lists the members too big for a shard of the target size, each
+	// given a shard of its own over the target, biggest first
+	Oversize NameAndSizes
+	// Elapsed is the wall time spent copying members into the shards
+	Elapsed time.Duration
+	// Census counts every entry in the sources by Typeflag, up to Limit
+	Census Census
+	// Partial is set when Limit left members of the sources out
+	Partial bool
+	// Excluded is how many members Include and Exclude left out
+	Excluded int
+	// CaseCollisions lists the members whose names differ only in case, with
+	// CaseCheckWarn
+	CaseCollisions []CaseCollision
+	// ClampedTimes lists the members whose times ClampMTime brought into range
+	ClampedTimes []ClampedTime
+}
+
+// OversizeWarning describes the oversize members for opts and suggests a
+// target that would fit them, or is empty when there are none
+func (r *Result) OversizeWarning(opts Options) string {
+	if len(r.Oversize) == 0 {
+		return ""
+	}
+	return newOversizeError(r.Oversize, opts).Error() + ", it has a shard of its own over the target"
+}
+
+// ShardResult describes a shard once it is completely written
+type ShardResult struct {
+	Index int
+	// File is the path the shard was written to
+	File string
+	// Members is how many members were planned into the shard
+	Members int
+	// Size is the size of the shard's file
+	Size int64
+	// Planned is the member data planned into the shard
+	Planned int64
+	// Fill is Planned as a fraction of the target size it was planned to, 0
+	// when planned by number of shards
+	Fill float64
+	// Digest is the digest of the shard's content, as sha256:<hex>, when it
+	// was computed
+	Digest string
+}
+
+// Bytes is the total size of the shards written
+func (r *Result) Bytes() int64 {
+	var total int64
+	for _, shard := range r.Shards {
+		total += shard.Size
+	}
+	return total
+}
+
+// Throughput is the bytes of shards written per second of Elapsed
+func (r *Result) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Bytes()) / r.Elapsed.Seconds()
+}
+
+// AverageFill is the mean Fill of the shards written
+func (r *Result) AverageFill() float64 {
+	if len(r.Shards) == 0 {
+		return 0
+	}
+	var total float64
+	for _, shard := range r.Shards {
+		total += shard.Fill
+	}
+	return total / float64(len(r.Shards))
+}
+
+// Skipped counts entries by Typeflag
+type Skipped map[byte]int
+
+// Census counts entries by Typeflag
+type Census map[byte]int
+
+// MemberError is why a member could not be copied
+type MemberError struct {
+	Name string
+	Err  error
+}
+
+func (e MemberError) Error() string {
+	return e.Name + ": " + e.Err.Error()
+}
+
+func (e MemberError) Unwrap() error {
+	return e.Err
+}
+
+var typeflagNames = map[byte]string{
+	tar.TypeReg:     "regular file",
+	tar.TypeLink:    "hardlink",
+	tar.TypeSymlink: "symlink",
+	tar.TypeChar:    "character device",
+	tar.TypeBlock:   "block device",
+	tar.TypeDir:     "directory",
+	tar.TypeFifo:    "fifo",
+	tar.TypeCont:    "contiguous file",
+	//Passed through by archive/tar, so only a census ever sees these
+	tar.TypeXGlobalHeader: "global header",
+	tar.TypeGNUSparse:     "sparse file",
+}
+
+// typeflagName describes a Typeflag for humans, count picks singular or plural
+func typeflagName(flag byte, count int) string {
+	name, ok := typeflagNames[flag]
+	if !ok {
+		name = fmt.Sprintf("entry of type %q", flag)
+	}
+	if count == 1 {
+		return name
+	}
+	switch {
+	case strings.HasSuffix(name, "y"):
+		return strings.TrimSuffix(name, "y") + "ies"
+	case strings.HasPrefix(name, "entry"):
+		return "entries" + strings.TrimPrefix(name, "entry")
+	}
+	return name + "s"
+}
+
+func (s Skipped) String() string {
+	return "skipped " + describeCounts(s)
+}
+
+func (c Census) String() string {
+	return "sources hold " + describeCounts(c)
+}
+
+// byName is the census keyed by the name of each Typeflag
+func (c Census) byName() map[string]int {
+	names := make(map[string]int, len(c))
+	for flag, count := range c {
+		names[typeflagName(flag, 1)] += count
+	}
+	return names
+}
+
+// describeCounts lists counts of entries by Typeflag, in Typeflag order
+func describeCounts(counts map[byte]int) string {
+	flags := make([]int, 0, len(counts))
+	for flag := range counts {
+		flags = append(flags, int(flag))
+	}
+	sort.Ints(flags)
+	parts := make([]string, 0, len(flags))
+	for _, flag := range flags {
+		count := counts[byte(flag)]
+		parts = append(parts, fmt.Sprintf("%v %s", count, typeflagName(byte(flag), count)))
+	}
+	return strings.Join(parts, ", ")
+}
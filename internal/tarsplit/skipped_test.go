@@ -0,0 +1,106 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSkippedTally(t *testing.T) {
+	data := makeTar(t,
+		testMember{Name: "d/", Typeflag: tar.TypeDir},
+		testMember{Name: "d/a", Body: "alpha"},
+		testMember{Name: "d/b", Body: "bravo"},
+		testMember{Name: "d/e/", Typeflag: tar.TypeDir},
+		testMember{Name: "d/l1", Typeflag: tar.TypeSymlink, Linkname: "a"},
+		testMember{Name: "d/l2", Typeflag: tar.TypeSymlink, Linkname: "b"},
+		testMember{Name: "d/l3", Typeflag: tar.TypeSymlink, Linkname: "e"},
+		testMember{Name: "d/h", Typeflag: tar.TypeLink, Linkname: "d/a"},
+		testMember{Name: "d/p", Typeflag: tar.TypeFifo},
+		testMember{Name: "d/c", Typeflag: tar.TypeChar},
+	)
+	for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+		t.Run(name, func(t *testing.T) {
+			opts := testOptions(t)
+			opts.Strategy = strategy
+			result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := Skipped{tar.TypeDir: 2, tar.TypeSymlink: 3, tar.TypeLink: 1, tar.TypeFifo: 1}
+			if !reflect.DeepEqual(result.Skipped, want) {
+				t.Errorf("Expected skipped %v, got %v", want, result.Skipped)
+			}
+			if text := "skipped 1 hardlink, 3 symlinks, 2 directories, 1 fifo"; result.Skipped.String() != text {
+				t.Errorf("Expected %q, got %q", text, result.Skipped.String())
+			}
+			census := Census{tar.TypeReg: 2, tar.TypeDir: 2, tar.TypeSymlink: 3, tar.TypeLink: 1, tar.TypeFifo: 1, tar.TypeChar: 1}
+			if !reflect.DeepEqual(result.Census, census) {
+				t.Errorf("Expected census %v, got %v", census, result.Census)
+			}
+			entries := readShards(t, result)
+			if len(entries) != 3 || entries["d/c"].Header == nil {
+				t.Errorf("Expected the regular files and the device in the shards, got %v entries", len(entries))
+			}
+		})
+	}
+}
+
+func TestCensus(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeXGlobalHeader, PAXRecords: map[string]string{"comment": "census"}})
+	tw.Close()
+	global := bytes.TrimSuffix(buf.Bytes(), make([]byte, 2*blockSize))
+	dir := t.TempDir()
+	first := writeFile(t, dir, "first.tar", append(global, makeTar(t,
+		testMember{Name: "etc/", Typeflag: tar.TypeDir},
+		testMember{Name: "etc/hosts", Body: "localhost"},
+		testMember{Name: "etc/passwd", Body: "root"},
+		testMember{Name: "dev/sda", Typeflag: tar.TypeBlock, Devmajor: 8},
+		testMember{Name: "dev/null", Typeflag: tar.TypeChar, Devmajor: 1, Devminor: 3},
+	)...))
+	second := writeFile(t, dir, "second.tar.gz", gzipBytes(t, makeTar(t,
+		testMember{Name: "bin/", Typeflag: tar.TypeDir},
+		testMember{Name: "bin/sh", Body: "#!"},
+		testMember{Name: "bin/bash", Typeflag: tar.TypeLink, Linkname: "bin/sh"},
+		testMember{Name: "run/fifo", Typeflag: tar.TypeFifo},
+		testMember{Name: "lib", Typeflag: tar.TypeSymlink, Linkname: "usr/lib"},
+	)))
+	opts := testOptions(t)
+	opts.Manifest = filepath.Join(dir, "manifest.json")
+	result, err := SplitAll([]string{first, second}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Census{tar.TypeXGlobalHeader: 1, tar.TypeReg: 3, tar.TypeDir: 2, tar.TypeBlock: 1, tar.TypeChar: 1, tar.TypeLink: 1, tar.TypeFifo: 1, tar.TypeSymlink: 1}
+	if !reflect.DeepEqual(result.Census, want) {
+		t.Errorf("Expected census %v, got %v", want, result.Census)
+	}
+	text := "sources hold 3 regular files, 1 hardlink, 1 symlink, 1 character device, 1 block device, 2 directories, 1 fifo, 1 global header"
+	if result.Census.String() != text {
+		t.Errorf("Expected %q, got %q", text, result.Census.String())
+	}
+	manifest, err := ReadManifest(opts.Manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := map[string]int{"global header": 1, "regular file": 3, "directory": 2, "block device": 1, "character device": 1, "hardlink": 1, "fifo": 1, "symlink": 1}
+	if !reflect.DeepEqual(manifest.Census, byName) {
+		t.Errorf("Expected the manifest census %v, got %v", byName, manifest.Census)
+	}
+}
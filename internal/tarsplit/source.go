@@ -0,0 +1,403 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Stdin is the source filename that reads the tar from standard input
+const Stdin = "-"
+
+// Stdout is the manifest path that prints the manifest to standard output
+const Stdout = "-"
+
+// outputName is the base name the shards are numbered from
+func outputName(filename string) string {
+	if filename == Stdin {
+		return "stdin.tar"
+	}
+	if isURL(filename) {
+		return urlName(filename)
+	}
+	return filepath.Base(filename)
+}
+
+// plainName is name with any gzip extension taken off, .tgz becoming .tar, to
+// name plain tars after a gzipped one
+func plainName(name string) string {
+	switch ext := filepath.Ext(name); strings.ToLower(ext) {
+	case ".tgz":
+		return strings.TrimSuffix(name, ext) + ".tar"
+	case ".gz":
+		return strings.TrimSuffix(name, ext)
+	}
+	return name
+}
+
+// bufferSource makes sure the source can be read twice, once to plan and once
+// to copy. A regular file is used where it is, anything else, like stdin or a
+// pipe, is first copied whole into a temporary file in tmpDir. The returned
+// cleanup removes that copy and must always be called
+func bufferSource(filename, tmpDir string) (string, func(), error) {
+	noop := func() {}
+
+	var src io.Reader
+	if filename == Stdin {
+		src = os.Stdin
+	} else {
+		//Only looked at, so a regular file is opened just the once to read it.
+		//When it can't be looked at, opening it explains why
+		fi, err := os.Stat(filename)
+		if err != nil || fi.Mode().IsRegular() {
+			return filename, noop, nil
+		}
+		file, err := openSource(filename)
+		if err != nil {
+			return "", noop, err
+		}
+		defer file.Close()
+		src = file
+	}
+
+	//Keep the source name at the end so the extension can still say if it's
+	//gzipped when sniffing can't
+	return bufferTo(src, filename, tmpDir, "tarlayer-split-*-"+outputName(filename))
+}
+
+// loadSource is file as sources are read from it, its whole contents in
+// memory when InMemory is set or it is within MemoryLimit, and file itself
+// otherwise. A bytes.Reader seeks and reads at offsets as the file does, so
+// every pass works the same either way
+func loadSource(file *os.File, opts Options) (io.ReadSeeker, error) {
+	if !opts.InMemory && opts.MemoryLimit == 0 {
+		return file, nil
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !opts.InMemory && fi.Size() > opts.MemoryLimit {
+		return file, nil
+	}
+	buf := make([]byte, fi.Size())
+	if _, err := io.ReadFull(file, buf); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(buf), nil
+}
+
+// decompressSource makes the source open in file randomly accessible. When
+// it is gzipped the plain tar inside is written out to a temporary file in
+// tmpDir, which is returned open, otherwise file is already plain and is
+// returned itself. The returned cleanup closes and removes the temporary file
+// and must always be called
+func decompressSource(file *os.File, tmpDir string) (*os.File, func(), error) {
+	noop := func() {}
+	gzipped, err := isGzipped(file, file.Name())
+	if err != nil || !gzipped {
+		return file, noop, err
+	}
+	src, closeStream, err := source{r: file, name: file.Name(), gzipped: true}.stream()
+	if err != nil {
+		return nil, noop, err
+	}
+	defer closeStream()
+	path, cleanup, err := bufferTo(src, file.Name(), tmpDir, "tarlayer-split-*.tar")
+	if err != nil {
+		return nil, noop, err
+	}
+	plain, err := openSource(path)
+	if err != nil {
+		cleanup()
+		return nil, noop, err
+	}
+	return plain, func() {
+		plain.Close()
+		cleanup()
+	}, nil
+}
+
+// bufferTo copies src, read from filename, into a new temporary file in tmpDir
+// named after pattern. The returned cleanup removes the file
+func bufferTo(src io.Reader, filename, tmpDir, pattern string) (string, func(), error) {
+	noop := func() {}
+
+	tmp, err := os.CreateTemp(tmpDir, pattern)
+	if err != nil {
+		return "", noop, fmt.Errorf("Could not create buffer for %s, got error %w", filename, err)
+	}
+	cleanup := func() {
+		os.Remove(tmp.Name())
+	}
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		cleanup()
+		return "", noop, fmt.Errorf("Could not buffer %s to %s, got error %w", filename, tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		cleanup()
+		return "", noop, fmt.Errorf("Could not buffer %s to %s, got error %w", filename, tmp.Name(), err)
+	}
+	return tmp.Name(), cleanup, nil
+}
+
+// source is a source tar, opened once and read by every pass over it
+type source struct {
+	r io.ReadSeeker
+	// name is the source as it was given, for messages and naming the shards
+	name    string
+	gzipped bool
+	// digest is the source's own digest, once computed
+	digest string
+	// cut is set when Limit stopped the scan short of the end of the source,
+	// after its first members members, so copying stops there too
+	cut     bool
+	members int
+}
+
+// newSource is the source read from r, gzipped when isGzipped says so
+func newSource(r io.ReadSeeker, name string) (source, error) {
+	gzipped, err := isGzipped(r, name)
+	return source{r: r, name: name, gzipped: gzipped}, err
+}
+
+// The magic numbers compressed sources start with
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// isGzipped sniffs the start of r to tell whether it is gzipped, whatever
+// name says. Zstd and xz are recognised too, but can't be read so they are
+// an error. Only when the start is neither compressed nor a ustar, PAX or GNU
+// header, like an old v7 tar, does a .gz extension on name decide
+func isGzipped(r io.ReadSeeker, name string) (bool, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return false, fmt.Errorf("Could not rewind %s, got error %w", name, err)
+	}
+	block := make([]byte, blockSize)
+	n, err := io.ReadFull(r, block)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, fmt.Errorf("Could not read %s, got error %w", name, err)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return false, fmt.Errorf("Could not rewind %s, got error %w", name, err)
+	}
+	block = block[:n]
+	switch {
+	case bytes.HasPrefix(block, gzipMagic):
+		return true, nil
+	case bytes.HasPrefix(block, zstdMagic):
+		return false, fmt.Errorf("Source %s is zstd compressed, which can't be read, decompress it first with zstd -d", name)
+	case bytes.HasPrefix(block, xzMagic):
+		return false, fmt.Errorf("Source %s is xz compressed, which can't be read, decompress it first with xz -d", name)
+	case n == blockSize && bytes.HasPrefix(block[257:], []byte("ustar")):
+		return false, nil
+	}
+	return filepath.Ext(name) == ".gz", nil
+}
+
+// stream rewinds the source and undoes its gzip compression, if any, returning
+// the plain tar stream and a func closing what it opened on top of the source
+func (s source) stream() (io.Reader, func(), error) {
+	return s.streamTo(nil)
+}
+
+// streamTo is stream, also writing the source's bytes to w as they are read
+// when w is set. The stream then can't seek
+func (s source) streamTo(w io.Writer) (io.Reader, func(), error) {
+	if _, err := s.r.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, fmt.Errorf("Could not rewind %s, got error %w", s.name, err)
+	}
+	var r io.Reader = s.r
+	if w != nil {
+		r = io.TeeReader(s.r, w)
+	}
+	if !s.gzipped {
+		return r, func() {}, nil
+	}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not read %s as gzip, got error %w", s.name, err)
+	}
+	//Some producers write the tar as several gzip members one after another,
+	//which only reads whole with multistream on. It is the default, but set
+	//here so nothing comes to rely on it being off
+	gz.Multistream(true)
+	return gz, func() { gz.Close() }, nil
+}
+
+// openTarStream opens the source and undoes its gzip compression, if any,
+// returning the plain tar stream and a func closing everything it opened
+func openTarStream(filename string) (io.Reader, func(), error) {
+	file, err := openSource(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	src, err := newSource(file, filename)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	r, closeStream, err := src.stream()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return r, func() {
+		closeStream()
+		file.Close()
+	}, nil
+}
+
+// positionReader buffers reads from the tar stream, so that reading header
+// after header of small members doesn't cost a read call each, and counts how
+// far into the stream reading has got so that member offsets can be recorded.
+// When the underlying reader can seek, skipping forward seeks past whatever
+// isn't buffered rather than reading it
+type positionReader struct {
+	src io.Reader
+	r   *bufio.Reader
+	pos int64
+}
+
+func newPositionReader(src io.Reader) *positionReader {
+	return &positionReader{src: src, r: bufio.NewReaderSize(src, 64*1024)}
+}
+
+func (p *positionReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.pos += int64(n)
+	return n, err
+}
+
+// Seek only moves relative to the current position, which is all tar.Reader
+// asks for when skipping member data
+func (p *positionReader) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := p.src.(io.Seeker)
+	if !ok {
+		return 0, errors.New("source is not seekable")
+	}
+	if whence != io.SeekCurrent || offset < 0 {
+		return 0, errors.New("source can only be skipped forward")
+	}
+	if buffered := int64(p.r.Buffered()); offset > buffered {
+		if _, err := seeker.Seek(offset-buffered, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+		p.r.Reset(p.src)
+	} else if _, err := p.r.Discard(int(offset)); err != nil {
+		return 0, err
+	}
+	p.pos += offset
+	return p.pos, nil
+}
+
+// skipZeroBlocks passes over whole blocks of zeros, reporting whether anything
+// but zeros follows them
+func (p *positionReader) skipZeroBlocks() (bool, error) {
+	for {
+		block, err := p.r.Peek(blockSize)
+		if len(block) < blockSize {
+			if err == io.EOF {
+				return false, nil
+			}
+			return false, err
+		}
+		for _, b := range block {
+			if b != 0 {
+				return true, nil
+			}
+		}
+		p.r.Discard(blockSize)
+		p.pos += blockSize
+	}
+}
+
+// tarStream reads the members of a tar and, with concatenated set, those of
+// the tars after it too, as tar --ignore-zeros does. archive.Reader stops at
+// the first trailer
+type tarStream struct {
+	*tar.Reader
+	position     *positionReader
+	concatenated bool
+	// archive counts the tars read past, start is where the current one began
+	archive int
+	start   int64
+}
+
+func newTarStream(position *positionReader, concatenated bool) *tarStream {
+	return &tarStream{Reader: tar.NewReader(position), position: position, concatenated: concatenated}
+}
+
+// Next moves on to the next member, in the next tar when this one has ended
+func (s *tarStream) Next() (*tar.Header, error) {
+	for {
+		header, err := s.Reader.Next()
+		if err != io.EOF || !s.concatenated {
+			return header, err
+		}
+		more, err := s.position.skipZeroBlocks()
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			return nil, io.EOF
+		}
+		s.Reader = tar.NewReader(s.position)
+		s.archive++
+		s.start = s.position.pos
+	}
+}
+
+// sourceDigests lists the digest of each source
+func sourceDigests(sources []source) []string {
+	digests := make([]string, len(sources))
+	for i, source := range sources {
+		digests[i] = source.digest
+	}
+	return digests
+}
+
+// fileDigest computes the digest of the file at path
+func fileDigest(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return readDigest(file)
+}
+
+// estimateMembers guesses how many members a tar of size bytes holds, to
+// size the list of them up front. It errs low, as a tar of big files can
+// have very few
+func estimateMembers(size int64) int {
+	const maxEstimate = 1 << 20
+	estimate := size / (16 * blockSize)
+	if estimate > maxEstimate {
+		return maxEstimate
+	}
+	return int(estimate)
+}
@@ -0,0 +1,362 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sourceMembers are the members of the tars the source tests split
+var sourceMembers = []testMember{
+	{Name: "a", Body: strings.Repeat("alpha", 200)},
+	{Name: "b", Body: strings.Repeat("bravo", 300)},
+	{Name: "c/", Typeflag: '5'},
+	{Name: "c/d", Body: strings.Repeat("delta", 100)},
+}
+
+// checkSplit checks that result holds every regular file of sourceMembers
+func checkSplit(t *testing.T, result *Result) {
+	t.Helper()
+	entries := readShards(t, result)
+	for _, member := range sourceMembers {
+		if member.Typeflag != 0 {
+			continue
+		}
+		if entries[member.Name].Body != member.Body {
+			t.Errorf("Expected %s to hold %q, got %q", member.Name, member.Body, entries[member.Name].Body)
+		}
+	}
+}
+
+func TestSplitReader(t *testing.T) {
+	data := makeTar(t, sourceMembers...)
+	for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+		t.Run(name, func(t *testing.T) {
+			opts := testOptions(t)
+			opts.TargetSize = 5 * blockSize
+			opts.Strategy = strategy
+			result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Shards) < 2 {
+				t.Errorf("Expected the members spread over several shards, got %v", len(result.Shards))
+			}
+			checkSplit(t, result)
+		})
+	}
+}
+
+func TestSplitOpensSourceOnce(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		data     []byte
+		strategy Strategy
+	}{
+		{"single-pass", "in.tar", makeTar(t, sourceMembers...), StrategySinglePass},
+		{"two-pass", "in.tar", makeTar(t, sourceMembers...), StrategyTwoPass},
+		{"gzipped single-pass", "in.tar.gz", gzipBytes(t, makeTar(t, sourceMembers...)), StrategySinglePass},
+		//Decompressed to a temporary file, which is opened instead
+		{"gzipped two-pass", "in.tar.gz", gzipBytes(t, makeTar(t, sourceMembers...)), StrategyTwoPass},
+	}
+	for _, test := range tests {
+		strategy := test.strategy
+		t.Run(test.name, func(t *testing.T) {
+			path := writeFile(t, t.TempDir(), test.file, test.data)
+			opens := countOpens(t, path)
+			opts := testOptions(t)
+			opts.TargetSize = 5 * blockSize
+			opts.Strategy = strategy
+			result, err := Split(path, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *opens != 1 {
+				t.Errorf("Expected the source opened once, got %v", *opens)
+			}
+			checkSplit(t, result)
+		})
+	}
+}
+
+func TestInMemoryOpensSourceOnce(t *testing.T) {
+	data := makeTar(t, sourceMembers...)
+	tests := []struct {
+		name   string
+		modify func(*Options)
+	}{
+		{"in-memory", func(opts *Options) { opts.InMemory = true }},
+		{"within memory limit", func(opts *Options) { opts.MemoryLimit = int64(len(data)) }},
+		{"over memory limit", func(opts *Options) { opts.MemoryLimit = int64(len(data)) - 1 }},
+		{"in-memory two-pass", func(opts *Options) { opts.InMemory, opts.Strategy = true, StrategyTwoPass }},
+	}
+	for _, test := range tests {
+		modify := test.modify
+		t.Run(test.name, func(t *testing.T) {
+			path := writeFile(t, t.TempDir(), "in.tar", data)
+			opens := countOpens(t, path)
+			opts := testOptions(t)
+			opts.TargetSize = 5 * blockSize
+			modify(&opts)
+			result, err := Split(path, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *opens != 1 {
+				t.Errorf("Expected the source opened once, got %v", *opens)
+			}
+			checkSplit(t, result)
+		})
+	}
+}
+
+func TestSplitMissingSource(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.tar")
+	for name, fromDir := range map[string]bool{"tar": false, "directory": true} {
+		t.Run(name, func(t *testing.T) {
+			opts := testOptions(t)
+			opts.FromDir = fromDir
+			result, err := Split(missing, opts)
+			if !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("Expected an error for a missing source, got %v", err)
+			}
+			if !strings.Contains(err.Error(), missing) {
+				t.Errorf("Expected the error to name %s, got %q", missing, err)
+			}
+			if result != nil && len(result.Shards) > 0 {
+				t.Errorf("Expected no shards, got %v", len(result.Shards))
+			}
+			if entries, _ := os.ReadDir(opts.outDir); len(entries) > 0 {
+				t.Errorf("Expected nothing written, got %v files", len(entries))
+			}
+		})
+	}
+}
+
+func TestPlainName(t *testing.T) {
+	for name, want := range map[string]string{
+		"in.tar":       "in.tar",
+		"in.tar.gz":    "in.tar",
+		"in.tgz":       "in.tar",
+		"IN.TGZ":       "IN.tar",
+		"layer.gz":     "layer",
+		"layer":        "layer",
+		"stdin.tar":    "stdin.tar",
+		"in.tar.gz.7z": "in.tar.gz.7z",
+	} {
+		if got := plainName(name); got != want {
+			t.Errorf("Expected %s named %s, got %s", name, want, got)
+		}
+	}
+}
+
+// TestSplitPipeline runs the whole split of a tar file as the command does,
+// plain and gzipped, and checks the shards are plain tars named after it
+func TestSplitPipeline(t *testing.T) {
+	data := makeTar(t, sourceMembers...)
+	for _, test := range []struct {
+		file   string
+		data   []byte
+		prefix string
+	}{
+		{"in.tar", data, "-in.tar"},
+		{"in.tar.gz", gzipBytes(t, data), "-in.tar"},
+		{"in.tgz", gzipBytes(t, data), "-in.tar"},
+		//Gzipped whatever its name says
+		{"in.tar", gzipBytes(t, data), "-in.tar"},
+	} {
+		for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+			t.Run(test.file+"/"+name, func(t *testing.T) {
+				path := writeFile(t, t.TempDir(), test.file, test.data)
+				opts := testOptions(t)
+				opts.TargetSize = 5 * blockSize
+				opts.Strategy = strategy
+				result, err := Split(path, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, shard := range result.Shards {
+					if want := fmt.Sprintf("%v%s", shard.Index, test.prefix); filepath.Base(shard.File) != want {
+						t.Errorf("Expected shard %v named %s, got %s", shard.Index, want, filepath.Base(shard.File))
+					}
+					file, err := os.Open(shard.File)
+					if err != nil {
+						t.Fatal(err)
+					}
+					gzipped, err := isGzipped(file, "")
+					file.Close()
+					if err != nil || gzipped {
+						t.Errorf("Expected shard %s to be a plain tar, got gzipped %v and error %v", shard.File, gzipped, err)
+					}
+				}
+				checkSplit(t, result)
+			})
+		}
+	}
+}
+
+// pipeStdin makes data what os.Stdin reads, through a pipe, for the rest of
+// the test
+func pipeStdin(t *testing.T, data []byte) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = r
+	t.Cleanup(func() {
+		os.Stdin = stdin
+		r.Close()
+	})
+	go func() {
+		w.Write(data)
+		w.Close()
+	}()
+}
+
+func TestStdinBufferRemoved(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		data  []byte
+		fails bool
+	}{
+		{"tar", makeTar(t, sourceMembers...), false},
+		{"gzipped", gzipBytes(t, makeTar(t, sourceMembers...)), false},
+		{"not a tar", bytes.Repeat([]byte("not a tar "), 200), true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			pipeStdin(t, test.data)
+			opts := testOptions(t)
+			opts.TargetSize = 5 * blockSize
+			opts.TmpDir = t.TempDir()
+			result, err := Split(Stdin, opts)
+			if (err != nil) != test.fails {
+				t.Fatalf("Expected failure %v, got error %v", test.fails, err)
+			}
+			if !test.fails {
+				checkSplit(t, result)
+			}
+			if entries, _ := os.ReadDir(opts.TmpDir); len(entries) > 0 {
+				t.Errorf("Expected the buffer removed from the temporary directory, found %s", entries[0].Name())
+			}
+		})
+	}
+}
+
+func TestSniffCompression(t *testing.T) {
+	plain := makeTar(t, sourceMembers...)
+	gzipped := gzipBytes(t, plain)
+	tests := []struct {
+		name string
+		file string
+		data []byte
+	}{
+		{"gzip named .tar", "in.tar", gzipped},
+		{"plain named .tar.gz", "in.tar.gz", plain},
+		{"plain named .tgz", "in.tgz", plain},
+		{"gzip with no extension", "in", gzipped},
+	}
+	for _, test := range tests {
+		for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+			t.Run(test.name+" "+name, func(t *testing.T) {
+				path := writeFile(t, t.TempDir(), test.file, test.data)
+				opts := testOptions(t)
+				opts.TargetSize = 5 * blockSize
+				opts.Strategy = strategy
+				result, err := Split(path, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkSplit(t, result)
+			})
+		}
+		t.Run(test.name+" reader", func(t *testing.T) {
+			result, err := SplitReader(bytes.NewReader(test.data), test.file, testOptions(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSplit(t, result)
+		})
+	}
+
+	for _, test := range []struct {
+		name  string
+		magic []byte
+		want  string
+	}{
+		{"zstd", zstdMagic, "zstd -d"},
+		{"xz", xzMagic, "xz -d"},
+	} {
+		data := append(append([]byte{}, test.magic...), make([]byte, 2*blockSize)...)
+		path := writeFile(t, t.TempDir(), "in.tar", data)
+		if _, err := Split(path, testOptions(t)); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("Expected %s refused with a hint to run %s, got %v", test.name, test.want, err)
+		}
+	}
+
+	//Too short to sniff, so only the extension is left to go on
+	for name, want := range map[string]bool{"short.tar": false, "short.tar.gz": true} {
+		gz, err := isGzipped(bytes.NewReader([]byte("x")), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gz != want {
+			t.Errorf("Expected %s gzipped %v, got %v", name, want, gz)
+		}
+	}
+}
+
+func TestMultistreamGzip(t *testing.T) {
+	plain := makeTar(t, sourceMembers...)
+	//Cut partway through a member, as a producer flushing by size would
+	cut := len(plain)/2 + 100
+	data := append(gzipBytes(t, plain[:cut]), gzipBytes(t, plain[cut:])...)
+
+	src, err := newSource(bytes.NewReader(data), "in.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, closeStream, err := src.stream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := io.ReadAll(r)
+	closeStream()
+	if err != nil || !bytes.Equal(read, plain) {
+		t.Fatalf("Expected both gzip members read as the whole tar, got %v of %v bytes and error %v", len(read), len(plain), err)
+	}
+
+	for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+		t.Run(name, func(t *testing.T) {
+			path := writeFile(t, t.TempDir(), "in.tar.gz", data)
+			opts := testOptions(t)
+			opts.TargetSize = 5 * blockSize
+			opts.Strategy = strategy
+			result, err := Split(path, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSplit(t, result)
+		})
+	}
+}
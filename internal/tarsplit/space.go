@@ -0,0 +1,52 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// diskFree is how many bytes are free for an unprivileged user on the
+// filesystem holding dir. It is a variable so it can be swapped out
+var diskFree = statFree
+
+// plannedBytes is roughly how big the shards of plans come out, every member
+// with its header and padding and every shard with its trailer. Extended
+// headers for long names or PAX records aren't counted
+func plannedBytes(plans []Plan) int64 {
+	var total int64
+	for _, plan := range plans {
+		total += 2 * blockSize
+		for _, member := range plan.Pool {
+			total += tarSize(member.Size)
+		}
+	}
+	return total
+}
+
+// checkFreeSpace makes sure the filesystem holding dir has need bytes free,
+// with opts.MinFreeSpace more to spare, before anything is written there
+func checkFreeSpace(dir string, need int64, opts Options) error {
+	if dir == "" {
+		dir = "."
+	}
+	free, err := diskFree(dir)
+	if err != nil {
+		return fmt.Errorf("Could not check free space in %s, got error %w", dir, err)
+	}
+	if want := need + opts.MinFreeSpace; uint64(want) > free {
+		return fmt.Errorf("Shards need about %v bytes with %v left over but %s has %v free, got error %w", need, opts.MinFreeSpace, dir, free, syscall.ENOSPC)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// stubDiskFree makes the filesystem report free bytes free until the test
+// ends, noting the directories asked about in dirs
+func stubDiskFree(t *testing.T, free uint64, err error, dirs *[]string) {
+	t.Helper()
+	saved := diskFree
+	diskFree = func(dir string) (uint64, error) {
+		*dirs = append(*dirs, dir)
+		return free, err
+	}
+	t.Cleanup(func() { diskFree = saved })
+}
+
+func TestCheckFreeSpace(t *testing.T) {
+	var members []testMember
+	for i := 0; i < 10; i++ {
+		members = append(members, testMember{Name: fmt.Sprintf("f%v", i), Body: strings.Repeat("x", 700*i+1)})
+	}
+	data := makeTar(t, members...)
+
+	//What the shards come to, with the space unchecked
+	opts := testOptions(t)
+	opts.TargetSize = 8192
+	result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var need int64
+	for _, shard := range result.Shards {
+		need += shard.Size
+	}
+
+	tests := []struct {
+		name  string
+		free  uint64
+		min   int64
+		fails bool
+	}{
+		{"exactly enough", uint64(need), 0, false},
+		{"one byte short", uint64(need - 1), 0, true},
+		{"enough but none to spare", uint64(need), 1024, true},
+		{"enough to spare", uint64(need + 1024), 1024, false},
+		{"full disk", 0, 0, true},
+	}
+	for _, test := range tests {
+		var dirs []string
+		stubDiskFree(t, test.free, nil, &dirs)
+		opts := testOptions(t)
+		opts.TargetSize = 8192
+		opts.CheckFreeSpace = true
+		opts.MinFreeSpace = test.min
+		_, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+		if test.fails != (err != nil) {
+			t.Fatalf("%s: expected failure %v, got error %v", test.name, test.fails, err)
+		}
+		if len(dirs) != 1 || dirs[0] != opts.outDir {
+			t.Errorf("%s: expected the space checked where the shards go, %s, got %q", test.name, opts.outDir, dirs)
+		}
+		if !test.fails {
+			continue
+		}
+		if !errors.Is(err, syscall.ENOSPC) {
+			t.Errorf("%s: expected an error matching ENOSPC, got %v", test.name, err)
+		}
+		if files, _ := os.ReadDir(opts.outDir); len(files) != 0 {
+			t.Errorf("%s: expected nothing written, got %v files", test.name, len(files))
+		}
+	}
+
+	var dirs []string
+	stubDiskFree(t, 0, errors.New("no statfs here"), &dirs)
+	opts = testOptions(t)
+	opts.CheckFreeSpace = true
+	if _, err := SplitReader(bytes.NewReader(data), "in.tar", opts); err == nil || !strings.Contains(err.Error(), "Could not check free space") {
+		t.Errorf("Expected a failed query to fail the split, got %v", err)
+	}
+	opts.MinFreeSpace = -1
+	if _, err := SplitReader(bytes.NewReader(data), "in.tar", opts); err == nil {
+		t.Error("Expected negative free space to leave refused")
+	}
+}
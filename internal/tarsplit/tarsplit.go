@@ -0,0 +1,1164 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tarsplit splits a tar into several smaller tars, each holding whole
+// members and staying under a target size
+package tarsplit
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CondeNast/resplit-tar/tarplan"
+)
+
+const blockSize = 512
+
+// NameAndSize, NameAndSizes and Plan live in tarplan so that tools outside
+// the module can use them too
+type NameAndSize = tarplan.NameAndSize
+type NameAndSizes = tarplan.NameAndSizes
+type Plan = tarplan.Plan
+
+// byName sorts members lexically by name
+type byName NameAndSizes
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// bySource sorts members in the order they appear in the sources
+type bySource NameAndSizes
+
+func (s bySource) Len() int { return len(s) }
+func (s bySource) Less(i, j int) bool {
+	if s[i].Source != s[j].Source {
+		return s[i].Source < s[j].Source
+	}
+	return s[i].Offset < s[j].Offset
+}
+func (s bySource) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+// Order is the order members are written in within each shard
+type Order int
+
+const (
+	// OrderSource keeps members in the order they appear in the source
+	OrderSource Order = iota
+	// OrderName sorts members lexically by name, which needs StrategyTwoPass.
+	// A gzipped source is first
+	// decompressed into TmpDir so members can be read out of order
+	OrderName
+)
+
+// PackLess orders members before they are packed into shards, reporting
+// whether a goes before b
+type PackLess func(a, b NameAndSize) bool
+
+// PackBySizeDesc packs the biggest members first, topping shards off with the
+// smallest. It is the default and usually needs the fewest shards
+func PackBySizeDesc(a, b NameAndSize) bool {
+	return a.Size > b.Size
+}
+
+// PackBySizeAsc packs the smallest members first. The big members left for
+// last tend to need shards of their own, so it usually takes more shards
+func PackBySizeAsc(a, b NameAndSize) bool {
+	return a.Size < b.Size
+}
+
+// PackByName packs members in name order, keeping neighbouring paths in the
+// same shards at the cost of looser packing and often more shards
+func PackByName(a, b NameAndSize) bool {
+	return a.Name < b.Name
+}
+
+// Strategy is how the members are copied into the shards once planned
+type Strategy int
+
+const (
+	// StrategyAuto uses StrategyTwoPass when every source is a plain tar that
+	// can be read at any offset, or members are sorted by name, and
+	// StrategySinglePass otherwise. Everything fitting in one shard is always
+	// streamed with StrategySinglePass unless sorted by name, as there is only
+	// the one shard to write
+	StrategyAuto Strategy = iota
+	// StrategySinglePass streams each source once more, writing every member
+	// to its shard as it comes. All the shards are open at once, one file
+	// each, but nothing is read twice and gzipped sources are read as they are
+	StrategySinglePass
+	// StrategyTwoPass writes the shards one at a time, reading each member at
+	// the offset planning found it at. Only one shard is open at once, but the
+	// reads jump around and a gzipped source is first decompressed into TmpDir
+	StrategyTwoPass
+)
+
+// Naming is how shard files are named
+type Naming int
+
+const (
+	// NameIndex numbers the shards after the source, 0-layer.tar, 1-layer.tar
+	NameIndex Naming = iota
+	// NameDigest names each shard after the SHA-256 digest of its content,
+	// sha256:<hex>.tar, as OCI image layers are
+	NameDigest
+)
+
+// Options controls how the members get planned and written into shards
+type Options struct {
+	// TargetSize is the most bytes of member data planned into one shard
+	TargetSize int64
+	// Targets, when set, replaces TargetSize with a target for each shard,
+	// the first shard is planned up to the first and so on, with the last
+	// repeated for as many more shards as are needed
+	Targets []int64
+	// CompressionRatio, when set, makes TargetSize the size of a shard once
+	// compressed, estimated as its uncompressed size times this ratio, so a
+	// ratio of 0.4 plans 2.5 times the data into each shard. It is only an
+	// estimate, how well a shard really compresses depends on its content
+	CompressionRatio float64
+	// OverheadBytes is left free in every shard, taken off TargetSize before
+	// planning, for anything added to the shards afterwards
+	OverheadBytes int64
+	// StrictSize fails the split when a member is too big for any shard of
+	// the target size, rather than giving it a shard of its own over the
+	// target and listing it in Result.Oversize
+	StrictSize bool
+	// MinShardSize, when positive, merges any shard planned with less member
+	// data than this into a neighbouring shard, even if that takes it over
+	// TargetSize by up to OverfillTolerance
+	MinShardSize int64
+	// OverfillTolerance is how far over TargetSize merging a small shard may
+	// go, as a fraction of it, 0.1 allows 10% over
+	OverfillTolerance float64
+	// NumShards, when positive, ignores TargetSize and balances the members
+	// across exactly this many shards instead
+	NumShards int
+	// AffinityDepth, when set, keeps members sharing the first this many
+	// components of their path in the same shard where they fit, so 1 keeps
+	// each top level directory together. It doesn't apply with NumShards
+	AffinityDepth int
+	// Pin, when set, plans every member matching one of these patterns, as
+	// Include matches them, into the first shard whatever its size. The room
+	// they leave is filled with whichever other members fit, in packing order,
+	// and the rest are planned into the shards after. Pinned members bigger
+	// than the first shard's target take it over the target, or fail the
+	// split with StrictSize. It needs a target size
+	Pin []string
+	// WhiteoutAware plans each overlayfs whiteout, .wh.<name>, into the same
+	// shard as <name> when the sources have both, so every shard stays a
+	// coherent layer. An opaque marker, .wh..wh..opq, goes with the entry of
+	// the directory it is in, but not with everything under it
+	WhiteoutAware bool
+	// Order is the order members are written in within each shard. It does not
+	// change which shard a member is planned into
+	Order Order
+	// Format, when set, forces every member to be written in this tar format.
+	// Members the format cannot represent fail the split rather than being
+	// silently altered
+	Format tar.Format
+	// PreserveXattrs checks before anything is written that Format can hold
+	// the extended attributes and POSIX ACLs members carry, failing the split
+	// with an XattrError when it can't. Otherwise such members only fail when
+	// they are copied, or are left out with SkipErrors
+	PreserveXattrs bool
+	// Retries is how many extra attempts are made when creating or flushing a
+	// shard fails with a transient error, zero disables retrying
+	Retries int
+	// RetryBackoff is the wait before the first retry, doubled on each attempt
+	RetryBackoff time.Duration
+	// CopyBuffer is the size of the buffer member data is copied through, so
+	// memory use stays predictable, DefaultCopyBuffer when 0
+	CopyBuffer int
+	// RecordSize, when set, pads every shard with zeros after its trailer to
+	// a multiple of this many bytes, as classic tar does with its blocking
+	// factor, 10240 for the default of 20. It must be a multiple of 512
+	RecordSize int
+	// IndexStart is the index of the first shard. With AppendTo it should be
+	// what the earlier split started from
+	IndexStart int
+	// IndexWidth zero pads shard indexes in file names to this many digits
+	IndexWidth int
+	// ModTime, when set, replaces the modification time of every member, and
+	// its access and change times where it has them, for reproducible shards
+	ModTime time.Time
+	// ClampMTime brings member times outside what every tar format can hold,
+	// before 1970 or after 2242, to the nearest end of that range, so a bad
+	// header can't fail the split or trip up whatever reads the shards. The
+	// members are listed in Result.ClampedTimes. A zero time, left by a
+	// malformed header, goes to 1970
+	ClampMTime bool
+	// Chown, when set, makes every member owned by it
+	Chown *Ownership
+	// UidMap and GidMap remap member owner ids, after Chown, by the first
+	// IDMap covering each id. Ids no map covers are kept
+	UidMap []IDMap
+	GidMap []IDMap
+	// Strategy is how the members are copied into the shards
+	Strategy Strategy
+	// PackLess, when set, is the order members are taken in when packing
+	// them into shards, PackBySizeDesc when nil. Packing takes members in
+	// turn and tops a full shard off from the other end of the order, so the
+	// order changes how tightly shards are filled and how many there are
+	PackLess PackLess
+	// NoSort packs members in the order the sources hold them, skipping the
+	// sort by PackLess. That saves time on a huge source already roughly in
+	// size order, at the cost of looser packing when it isn't
+	NoSort bool
+	// Naming is how the shard files are named
+	Naming Naming
+	// Layout is how the shard files are arranged. LayoutContainer can't be
+	// used with AppendTo
+	Layout Layout
+	// Destination, when set, is where the shards are written instead of the
+	// current directory. The shards are written straight to their final
+	// names, so they can't be named by digest, put in a container or have a
+	// failed member cut out with SkipErrors, and one left unfinished by an
+	// error is closed but not removed
+	Destination Destination
+	// VerifyMembers reads the headers of the sources again once the shards
+	// are planned, failing with a MembersChangedError before anything is
+	// written if the members aren't the ones planned from. Otherwise a member
+	// gone from a streamed source can leave its shard short without an error
+	VerifyMembers bool
+	// CheckFreeSpace fails the split before any shard is written unless the
+	// filesystem they are written to has room for them, with MinFreeSpace
+	// bytes more to spare. With LayoutContainer the shards and the container
+	// are each checked for on their own, so two on the same filesystem may
+	// still not fit
+	CheckFreeSpace bool
+	MinFreeSpace   int64
+	// outDir is where the shards are written, the current directory when empty
+	outDir string
+	// total is how many shards there are in the set, including any from
+	// before an append or resume
+	total int
+	// Concatenated reads on past the trailer of each source into any tars
+	// concatenated after it, as tar --ignore-zeros does, where otherwise
+	// reading stops at the first trailer
+	Concatenated bool
+	// CaseCheck looks for members whose names differ only in case, which
+	// collide when extracted on a case-insensitive filesystem
+	CaseCheck CaseCheck
+	// HardlinkCopies writes each hardlink as a regular file holding a copy
+	// of the data of the file it links to, for consumers that don't handle
+	// hardlinks. The copies are planned at the size of that file. Gzipped
+	// sources are decompressed to TmpDir first so the file can be read again
+	HardlinkCopies bool
+	// linkTargets are the files the hardlinks HardlinkCopies copies link to,
+	// by the name of the link
+	linkTargets map[string]NameAndSize
+	// Include, when set, leaves out every member not matching one of these
+	// path.Match patterns. A pattern matching a directory covers everything
+	// under it
+	Include []string
+	// Exclude leaves out every member matching one of these patterns, as
+	// Include matches them, even one Include lets through
+	Exclude []string
+	// SplitLargeFiles cuts each regular member too big for a shard into parts
+	// that fit, each written as a member of its own named after it with a
+	// .partNNNN suffix and PAX records saying where in it the part goes.
+	// Merge puts them back together. It needs a target size
+	SplitLargeFiles bool
+	// MultiVolume writes the members in source order as one GNU tar cut into
+	// volumes of TargetSize, rather than planning them into shards of their
+	// own. A member the end of a volume cuts through is continued in the next,
+	// as GNU tar --multi-volume writes and reads them, so no volume is a tar
+	// that can be read alone
+	MultiVolume bool
+	// splitFiles are the members SplitLargeFiles cut up, by name
+	splitFiles map[string]*SplitFile
+	// DedupeAcrossShards reads the data of every regular member before
+	// copying, to find members with identical content. Of those that land in
+	// the same shard only the first is written whole, the rest are written as
+	// hardlinks to it. Those in different shards can't be linked, so are
+	// listed in the manifest's duplicates. It can't be used with MultiVolume,
+	// MemberTransform or SkipErrors
+	DedupeAcrossShards bool
+	// dedupe is what DedupeAcrossShards found
+	dedupe *dedupe
+	// Limit, when above 0, splits only the first Limit members of the sources
+	// and leaves out the rest, for a quick trial run. Result.Partial says
+	// whether anything was left out
+	Limit int
+	// EmbedIndex starts every shard with a member named INDEX listing the
+	// members written after it, a line each of the name, a space and the
+	// size. It is marked with a PAX record so merging leaves it out
+	EmbedIndex bool
+	// GlobalRecords starts every shard with a PAX global header recording its
+	// index, the number of shards and the source name, see ReadShardInfo
+	GlobalRecords bool
+	// Labels, when set, are key value pairs, like a build id, stamped on every
+	// shard as records in its global header, which they turn GlobalRecords on
+	// for, and recorded in the manifest
+	Labels map[string]string
+	// SkipErrors carries on past a member that can't be copied, leaving it out
+	// and listing it in Result.Failed, rather than ending the split. Errors
+	// reading the tar's own structure still end it
+	SkipErrors bool
+	// Manifest, when set, is the path a JSON record of the shards written and
+	// the members in each is saved to, or Stdout
+	Manifest string
+	// ManifestFormat is how Manifest and ExportPlan are written. Manifests
+	// are read back in whichever format they are in, except CSV which can't
+	// be read back
+	ManifestFormat ManifestFormat
+	// Checksums, when set, is the path a SHA256SUMS file covering every shard
+	// in the set is written to, for checking them with sha256sum -c. It can't
+	// be used with LayoutContainer
+	Checksums string
+	// AppendTo, when set, is the manifest of an earlier split of the same
+	// source. Only members it doesn't list are split, into shards numbered
+	// after its own, and it is updated to cover them unless Manifest is set.
+	// Members are matched by name only
+	AppendTo string
+	// Context, when set, interrupts the split once it is done. Shards written
+	// one at a time finish the shard being written first, otherwise copying
+	// stops after the member being copied and the unfinished shards are
+	// removed. Either way the complete shards are kept, and the manifest
+	// lists every planned shard for Resume to finish the split from. The
+	// split returns an error matching ErrInterrupted
+	Context context.Context
+	// MemberTransform, when set, changes the content of every regular member
+	// as it is copied. Members are planned by their size before the transform,
+	// so one that makes members bigger can take shards past their target, and
+	// the manifest lists the sizes from before
+	MemberTransform MemberTransform
+	// Progress, when set, is called as member data is copied into the shards.
+	// It is called often so it should be quick
+	Progress func(Progress)
+	// OnShardStart, when set, is called as each shard's file is created, with
+	// the member data planned into it. Shards written in source order are all
+	// started before any member is copied
+	OnShardStart func(index int, planned int64)
+	// OnShardFinish, when set, is called as each shard is completed, once its
+	// file is closed and has its final name, so it can be uploaded right away.
+	// With LayoutContainer the file is still in a temporary directory
+	OnShardFinish func(ShardResult)
+	// onShard is called as each shard is completed, see SplitStream
+	onShard func(ShardResult)
+	// FromDir takes the sources to be directories rather than tars. Each is
+	// tarred up into TmpDir first, which needs room for it
+	FromDir bool
+	// FollowSymlinks, with FromDir, stores what symlinks point to rather than
+	// the links themselves, which are otherwise copied into the shards as
+	// symlinks. A link leading back into a directory holding it is an error
+	FollowSymlinks bool
+	// DropEmptyDirs, with FromDir, leaves directories with nothing in them out
+	// of the shards, as every other directory is. They are copied by default,
+	// as they can matter, like mount points
+	DropEmptyDirs bool
+	// SourceHash records the SHA-256 digest of each source in the manifest,
+	// and in the global records with GlobalRecords. The source has to be read
+	// whole to compute it, including the member data planning would skip
+	SourceHash bool
+	// ExportPlan, when set, makes the split a dry run that only plans the
+	// shards, writing the plan as a manifest to this path, or Stdout
+	ExportPlan string
+	// ImportPlan, when set, is a plan written by ExportPlan to copy the
+	// members by instead of planning them. It must list exactly the members
+	// the sources hold, with the same sizes
+	ImportPlan string
+	// Resume, when set, is the manifest of a split that was interrupted, or
+	// the plan it was copied by. It is used as ImportPlan is, except that the
+	// shards it lists that are already complete are kept rather than written
+	// again. The manifest is rewritten to cover all of them unless Manifest
+	// is set
+	Resume string
+	// TmpDir is where a source that can't be read twice, like stdin or a
+	// pipe, is buffered. It needs room for the whole source. Defaults to
+	// os.TempDir
+	TmpDir string
+	// InMemory reads each source whole into memory once it is ready to be
+	// read, so every pass over it is served from there rather than the disk
+	InMemory bool
+	// MemoryLimit reads a source into memory as InMemory does when it is no
+	// bigger than this many bytes, zero never does
+	MemoryLimit int64
+	// HTTPTimeout bounds connecting to and waiting for the response from a
+	// source given as an http or https URL, which is downloaded into TmpDir.
+	// It doesn't bound the download itself. Zero waits as long as it takes
+	HTTPTimeout time.Duration
+}
+
+// Split plans the members of the tar at filename, or stdin when filename is
+// Stdin, into shards and writes them to the current directory. It reports the
+// entries it had to leave out
+func Split(filename string, opts Options) (*Result, error) {
+	return SplitAll([]string{filename}, opts)
+}
+
+// SplitAll is Split for several source tars at once. Their members are planned
+// together, as if they were one tar, and the shards are named after the first
+// source. A name found in more than one source is an error
+func SplitAll(filenames []string, opts Options) (*Result, error) {
+	previous := &Manifest{Sources: filenames}
+	if opts.AppendTo != "" {
+		var err error
+		if previous, err = ReadManifest(opts.AppendTo); err != nil {
+			return nil, err
+		}
+		if opts.Manifest == "" {
+			opts.Manifest = opts.AppendTo
+		}
+	}
+
+	sources, cleanup, err := openSources(filenames, opts)
+	defer cleanup()
+	if err != nil {
+		return nil, err
+	}
+	return splitSources(sources, previous, opts)
+}
+
+// SplitReader is Split for a tar that is already open. Both the planning and
+// the copying read from r, seeking back to its start in between, so it is
+// opened only once. name is the source's name, the shards are named after it.
+// r is read as gzip when it starts like gzip, see isGzipped. Sorting by name
+// also needs r to be an io.ReaderAt over a plain tar
+func SplitReader(r io.ReadSeeker, name string, opts Options) (*Result, error) {
+	previous := &Manifest{Sources: []string{name}}
+	if opts.AppendTo != "" {
+		var err error
+		if previous, err = ReadManifest(opts.AppendTo); err != nil {
+			return nil, err
+		}
+		if opts.Manifest == "" {
+			opts.Manifest = opts.AppendTo
+		}
+	}
+	src, err := newSource(r, name)
+	if err != nil {
+		return nil, err
+	}
+	return splitSources([]source{src}, previous, opts)
+}
+
+// splitSources plans and writes the shards of sources, numbering them on from
+// the shards of previous
+func splitSources(sources []source, previous *Manifest, opts Options) (*Result, error) {
+	if opts.IndexStart < 0 || opts.IndexWidth < 0 {
+		return nil, fmt.Errorf("Index start and width can't be negative, got %v and %v", opts.IndexStart, opts.IndexWidth)
+	}
+	if opts.Layout == LayoutContainer && opts.AppendTo != "" {
+		return nil, fmt.Errorf("Shards can't be appended to a container, it would have to be rewritten")
+	}
+	if opts.Resume != "" && (opts.AppendTo != "" || opts.ImportPlan != "" || opts.Layout == LayoutContainer) {
+		return nil, fmt.Errorf("Resuming a split can't be combined with appending, importing a plan or a container layout")
+	}
+	if opts.Checksums != "" && opts.Layout == LayoutContainer {
+		return nil, fmt.Errorf("Checksums can't be written with a container layout, the shards aren't files of their own")
+	}
+	if opts.Resume != "" && opts.Manifest == "" {
+		opts.Manifest = opts.Resume
+	}
+	if opts.Order == OrderName && opts.Strategy == StrategySinglePass {
+		return nil, fmt.Errorf("Sorting members by name needs the two-pass strategy")
+	}
+	if opts.RecordSize < 0 || opts.RecordSize%blockSize != 0 {
+		return nil, fmt.Errorf("Record size must be a multiple of %v bytes, got %v", blockSize, opts.RecordSize)
+	}
+	if opts.Limit < 0 {
+		return nil, fmt.Errorf("Limit can't be negative, got %v", opts.Limit)
+	}
+	if opts.CopyBuffer < 0 {
+		return nil, fmt.Errorf("Copy buffer size can't be negative, got %v", opts.CopyBuffer)
+	}
+	if opts.MemoryLimit < 0 {
+		return nil, fmt.Errorf("Memory limit can't be negative, got %v", opts.MemoryLimit)
+	}
+	if err := checkPatterns(opts); err != nil {
+		return nil, err
+	}
+	if err := checkPacking(opts); err != nil {
+		return nil, err
+	}
+	if err := checkLabels(opts.Labels); err != nil {
+		return nil, err
+	}
+	if opts.MinFreeSpace < 0 {
+		return nil, fmt.Errorf("Free space to leave can't be negative, got %v", opts.MinFreeSpace)
+	}
+	if opts.Destination != nil {
+		if err := checkDestination(opts); err != nil {
+			return nil, err
+		}
+	}
+	if opts.MultiVolume {
+		if err := checkVolumes(opts); err != nil {
+			return nil, err
+		}
+	}
+	if opts.DedupeAcrossShards {
+		if err := checkDedupe(opts); err != nil {
+			return nil, err
+		}
+	}
+	data, census, err := scanSources(sources, opts)
+	if err != nil {
+		return nil, err
+	}
+	var planned NameAndSizes
+	if opts.VerifyMembers {
+		planned = append(planned, data...)
+	}
+	if opts.HardlinkCopies {
+		if _, err := readersAt(sources, "Copying hardlinks"); err != nil {
+			return nil, err
+		}
+		if opts.linkTargets, err = resolveHardlinks(data); err != nil {
+			return nil, err
+		}
+	}
+	//Members filtered out are passed over while copying as an earlier
+	//split's are
+	existing := previous.members()
+	scanned := len(data)
+	if len(opts.Include) > 0 || len(opts.Exclude) > 0 {
+		data = filterMembers(data, opts, existing)
+	}
+	excluded := scanned - len(data)
+	if opts.SplitLargeFiles {
+		if data, opts.splitFiles, err = splitLargeFiles(data, opts); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkTotalSize(data); err != nil {
+		return nil, err
+	}
+	var collisions []CaseCollision
+	if opts.CaseCheck != CaseCheckOff {
+		collisions = caseCollisions(data)
+		if len(collisions) > 0 && opts.CaseCheck == CaseCheckError {
+			return nil, &CaseCollisionError{Collisions: collisions}
+		}
+	}
+	if opts.PreserveXattrs {
+		if err := checkXattrs(data, opts); err != nil {
+			return nil, err
+		}
+	}
+	if opts.EmbedIndex {
+		if err := checkEmbedIndex(data, opts); err != nil {
+			return nil, err
+		}
+	}
+	if len(existing) > 0 {
+		added := data[:0]
+		for _, member := range data {
+			if !existing[member.Name] {
+				added = append(added, member)
+			}
+		}
+		data = added
+	}
+	sortForPacking(data, opts)
+
+	filenames := make([]string, len(sources))
+	for i, source := range sources {
+		filenames[i] = source.name
+	}
+	//The shards are plain tars whatever the source is
+	fn := plainName(outputName(filenames[0]))
+	if opts.FromDir {
+		fn += ".tar"
+	}
+
+	//Volumes are cut as they are written rather than planned, their plans are
+	//only known once they are
+	var plans []Plan
+	var plan *Manifest
+	if planPath := opts.ImportPlan + opts.Resume; planPath != "" {
+		if plan, err = ReadManifest(planPath); err != nil {
+			return nil, err
+		}
+		if plans, err = plansFromManifest(plan, data); err != nil {
+			return nil, fmt.Errorf("Plan %s does not match the sources, got error %w", planPath, err)
+		}
+	} else if !opts.MultiVolume {
+		if plans, err = buildPlans(data, opts); err != nil {
+			return nil, err
+		}
+		if err := checkPlanned(data, plans); err != nil {
+			return nil, err
+		}
+		start := previous.nextIndex(opts.IndexStart)
+		for i := range plans {
+			plans[i].Index = start + i
+		}
+	}
+	oversize := oversizeMembers(plans)
+	if len(oversize) > 0 && opts.StrictSize {
+		return nil, newOversizeError(oversize, opts)
+	}
+	if opts.ExportPlan != "" {
+		plan := newManifest(filenames, fn, plans, nil, opts)
+		if opts.SourceHash {
+			plan.SourceDigests = sourceDigests(sources)
+		}
+		plan.AverageFill = plan.averageFill()
+		plan.Census = census.byName()
+		plan.Partial = isCut(sources)
+		return &Result{Oversize: oversize, Census: census, Partial: plan.Partial, CaseCollisions: collisions}, writeManifest(opts.ExportPlan, plan, opts.ManifestFormat)
+	}
+	if len(plans) > 0 {
+		//Plans are numbered consecutively, so the last one tells how many there
+		//are in the set, including any from before an append
+		opts.total = plans[len(plans)-1].Index + 1 - opts.IndexStart
+	}
+	todo := plans
+	var kept []ShardResult
+	if opts.Resume != "" {
+		todo, kept = resumePlans(plan, plans, existing)
+	}
+	//Every shard is looked at, even those a resume keeps, so the manifest
+	//records the links in all of them
+	if opts.DedupeAcrossShards {
+		if opts.dedupe, err = findDuplicates(sources, plans, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Layout == LayoutContainer {
+		dir, err := os.MkdirTemp(opts.TmpDir, "tarlayer-shards-")
+		if err != nil {
+			return nil, fmt.Errorf("Could not create directory for the shards, got error %w", err)
+		}
+		defer os.RemoveAll(dir)
+		opts.outDir = dir
+	}
+	if opts.CheckFreeSpace {
+		need := plannedBytes(todo)
+		if opts.MultiVolume {
+			//Volumes aren't planned, but hold the members much as one shard would
+			need = plannedBytes([]Plan{{Pool: data}})
+		}
+		if err := checkFreeSpace(opts.outDir, need, opts); err != nil {
+			return nil, err
+		}
+		if opts.Layout == LayoutContainer {
+			if err := checkFreeSpace(".", need, opts); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if opts.VerifyMembers {
+		if err := verifyMembers(sources, planned, opts); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkInterrupted(opts); err != nil {
+		return nil, err
+	}
+	var result *Result
+	start := time.Now()
+	if opts.MultiVolume {
+		result, plans, err = writeVolumes(sources, fn, data, opts)
+	} else if chooseStrategy(sources, todo, opts) == StrategyTwoPass {
+		result, err = writeOrderedTars(sources, fn, &todo, opts)
+	} else {
+		result, err = createNewTars(sources, fn, &todo, existing, opts)
+	}
+	if result != nil {
+		result.Oversize = oversize
+		result.Census = census
+		result.Partial = isCut(sources)
+		result.Excluded = excluded
+		result.CaseCollisions = collisions
+		result.Elapsed = time.Since(start)
+	}
+	if err == nil && opts.Layout == LayoutContainer {
+		err = writeContainer(containerName(fn), opts.outDir, result.Shards)
+	}
+	if err == nil && opts.Checksums != "" {
+		err = writeChecksums(opts.Checksums, append(append(previous.shardResults(), kept...), result.Shards...))
+	}
+	//An interrupted split still records how far it got, so it can be resumed
+	interrupted := errors.Is(err, ErrInterrupted) && opts.Layout != LayoutContainer
+	if (err != nil && !interrupted) || opts.Manifest == "" {
+		return result, err
+	}
+	manifest := newManifest(filenames, fn, plans, append(kept, result.Shards...), opts)
+	if opts.SourceHash {
+		manifest.SourceDigests = sourceDigests(sources)
+	}
+	if opts.Layout == LayoutContainer {
+		manifest.Container = containerName(fn)
+	}
+	manifest.Shards = append(previous.Shards, manifest.Shards...)
+	manifest.AverageFill = manifest.averageFill()
+	manifest.Census = census.byName()
+	manifest.Partial = result.Partial
+	manifest.Interrupted = interrupted
+	manifest.Labels = opts.Labels
+	manifest.SplitFiles = append(previous.SplitFiles, splitFileList(opts.splitFiles)...)
+	for name, target := range previous.Duplicates {
+		if manifest.Duplicates == nil {
+			manifest.Duplicates = make(map[string]string)
+		}
+		manifest.Duplicates[name] = target
+	}
+	manifest.Run = &RunStats{
+		Bytes:          result.Bytes(),
+		ElapsedSeconds: result.Elapsed.Seconds(),
+		BytesPerSecond: result.Throughput(),
+	}
+	if err := writeManifest(opts.Manifest, manifest, opts.ManifestFormat); err != nil {
+		return result, err
+	}
+	return result, err
+}
+
+// isCut reports whether Limit left out members of any of sources
+func isCut(sources []source) bool {
+	for _, source := range sources {
+		if source.cut {
+			return true
+		}
+	}
+	return false
+}
+
+// chooseStrategy settles StrategyAuto for copying the members of plans from
+// sources
+func chooseStrategy(sources []source, plans []Plan, opts Options) Strategy {
+	if opts.Strategy != StrategyAuto {
+		return opts.Strategy
+	}
+	if opts.Order == OrderName {
+		return StrategyTwoPass
+	}
+	//With a single shard, streaming the sources writes it straight through
+	//with no seeking, and has no more files open than writing it alone
+	if len(plans) == 1 {
+		return StrategySinglePass
+	}
+	for _, source := range sources {
+		if _, ok := source.r.(io.ReaderAt); !ok || source.gzipped {
+			return StrategySinglePass
+		}
+	}
+	for _, plan := range plans {
+		for _, member := range plan.Pool {
+			if member.Offset < 0 {
+				return StrategySinglePass
+			}
+		}
+	}
+	return StrategyTwoPass
+}
+
+// List returns the members of the tars at filenames, as they would be planned
+// by SplitAll but without writing anything
+func List(filenames []string, opts Options) (NameAndSizes, error) {
+	//Planning never needs a second read, so there is no point decompressing
+	opts.Order = OrderSource
+	opts.Strategy = StrategySinglePass
+	sources, cleanup, err := openSources(filenames, opts)
+	defer cleanup()
+	if err != nil {
+		return nil, err
+	}
+	opts.SourceHash = false
+	data, _, err := scanSources(sources, opts)
+	return data, err
+}
+
+// SplitStream runs SplitAll in the background, sending each shard on the
+// returned channel as soon as it is complete so it can be put to use while the
+// rest are written. Shards arrive in the order they complete, which isn't
+// always index order. The channel must be read until it is closed, when the
+// split ends, after which the error channel delivers how it ended
+func SplitStream(filenames []string, opts Options) (<-chan ShardResult, <-chan error) {
+	shards := make(chan ShardResult)
+	errc := make(chan error, 1)
+	opts.onShard = func(shard ShardResult) {
+		shards <- shard
+	}
+	go func() {
+		_, err := SplitAll(filenames, opts)
+		close(shards)
+		errc <- err
+		close(errc)
+	}()
+	return shards, errc
+}
+
+// prepareSource gets filename ready to be read as many times as the write
+// needs, returning the path to read and a cleanup that must always be called
+func prepareSource(filename string, opts Options) (string, func(), error) {
+	switch {
+	case opts.FromDir:
+		return archiveDir(filename, opts)
+	case isURL(filename):
+		return fetchSource(filename, opts)
+	}
+	return bufferSource(filename, opts.TmpDir)
+}
+
+// openSources prepares and opens every source, see prepareSource, opening
+// each only once. A source read at any offset is decompressed first when it
+// is gzipped, see decompressSource. The returned cleanup closes and removes
+// what was opened and must always be called
+func openSources(filenames []string, opts Options) ([]source, func(), error) {
+	var cleanups []func()
+	cleanup := func() {
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+	}
+	sources := make([]source, len(filenames))
+	for i, filename := range filenames {
+		path, cleanupPath, err := prepareSource(filename, opts)
+		cleanups = append(cleanups, cleanupPath)
+		if err != nil {
+			return nil, cleanup, err
+		}
+		original, err := openSource(path)
+		if err != nil {
+			return nil, cleanup, err
+		}
+		cleanups = append(cleanups, func() { original.Close() })
+		file := original
+		//A tar made from a directory is already plain
+		if !opts.FromDir && (opts.Order == OrderName || opts.Strategy == StrategyTwoPass || opts.HardlinkCopies) {
+			var cleanupPlain func()
+			file, cleanupPlain, err = decompressSource(original, opts.TmpDir)
+			cleanups = append(cleanups, cleanupPlain)
+			if err != nil {
+				return nil, cleanup, err
+			}
+		}
+		r, err := loadSource(file, opts)
+		if err != nil {
+			return nil, cleanup, fmt.Errorf("Could not read %s into memory, got error %w", filename, err)
+		}
+		if sources[i], err = newSource(r, file.Name()); err != nil {
+			return nil, cleanup, err
+		}
+		sources[i].name = filename
+		if opts.SourceHash && file != original {
+			//The scan reads a decompressed copy, so hash the source itself
+			if sources[i].digest, err = fileDigest(path); err != nil {
+				return nil, cleanup, fmt.Errorf("Could not compute digest of %s, got error %w", filename, err)
+			}
+		}
+	}
+	return sources, cleanup, nil
+}
+
+// scanSources lists the members of every source, noting which source each
+// came from, and counts their entries by Typeflag. With SourceHash set it also
+// computes the digest of each source that doesn't have one yet, and with
+// Limit it stops after that many members in all
+func scanSources(sources []source, opts Options) (NameAndSizes, Census, error) {
+	var data NameAndSizes
+	census := make(Census)
+	scanned := 0
+	found := make(map[string]int)
+	for i, source := range sources {
+		var digest hash.Hash
+		if opts.SourceHash && source.digest == "" {
+			digest = sha256.New()
+		}
+		left := -1
+		if opts.Limit > 0 {
+			left = opts.Limit - scanned
+		}
+		members, cut, err := generateSlice(source, digest, census, left, opts.Concatenated)
+		if err != nil {
+			return nil, nil, err
+		}
+		scanned += len(members)
+		sources[i].cut, sources[i].members = cut, len(members)
+		if digest != nil {
+			sources[i].digest = formatDigest(digest)
+		}
+		for _, member := range members {
+			if first, ok := found[member.Name]; ok {
+				//Layers commonly share parent directories, keep the first
+				if member.IsDir() {
+					continue
+				}
+				return nil, nil, &DuplicateMemberError{Name: member.Name, Source: sources[first].name, Other: source.name}
+			}
+			found[member.Name] = i
+			member.Source = i
+			data = append(data, member)
+		}
+	}
+	return data, census, nil
+}
+
+// sortForPacking puts data in the order members are packed in, by PackLess
+// or biggest first, unless NoSort is set
+func sortForPacking(data NameAndSizes, opts Options) {
+	switch {
+	case opts.NoSort:
+		//Packed in the order they were scanned
+	case opts.PackLess == nil:
+		sort.Sort(sort.Reverse(data))
+	default:
+		sort.SliceStable(data, func(i, j int) bool {
+			return opts.PackLess(data[i], data[j])
+		})
+	}
+}
+
+// buildPlans plans the members, sorted biggest first, into shards
+func buildPlans(data NameAndSizes, opts Options) ([]Plan, error) {
+	var held map[string]NameAndSizes
+	if opts.WhiteoutAware {
+		data, held = holdWhiteouts(data)
+	}
+	var plans []Plan
+	var err error
+	if len(opts.Pin) > 0 {
+		plans, err = buildPinnedPlan(data, opts)
+	} else {
+		plans, err = packPlans(data, opts)
+	}
+	if err == nil && held != nil {
+		placeWhiteouts(plans, held)
+	}
+	return plans, err
+}
+
+// packPlans plans the members into shards by NumShards or the targets
+func packPlans(data NameAndSizes, opts Options) ([]Plan, error) {
+	if opts.NumShards > 0 {
+		return buildBalancedPlan(data, opts.NumShards)
+	}
+	targets, err := planTargets(opts)
+	if err != nil {
+		return nil, err
+	}
+	if plan, ok := planOneShard(data, targets[0]); ok {
+		return []Plan{plan}, nil
+	}
+	var plans []Plan
+	if opts.AffinityDepth > 0 {
+		plans, err = buildAffinityPlan(data, targets, opts.AffinityDepth)
+	} else {
+		plans, err = buildTieredPlan(data, targets)
+	}
+	if err != nil || opts.MinShardSize <= 0 {
+		return plans, err
+	}
+	return mergeSmallShards(plans, opts.MinShardSize, opts.OverfillTolerance), nil
+}
+
+// checkPacking makes sure the options packPlans goes by are valid, before any
+// members are scanned, as a split that fits in one shard never looks at them
+func checkPacking(opts Options) error {
+	if opts.AffinityDepth < 0 {
+		return fmt.Errorf("Affinity depth can't be negative, got %v", opts.AffinityDepth)
+	}
+	if opts.MinShardSize > 0 && opts.OverfillTolerance < 0 {
+		return invalidTargetf("Overfill tolerance can't be negative, got %v", opts.OverfillTolerance)
+	}
+	return nil
+}
+
+// planOneShard plans all of data into a single shard when it fits in target,
+// as planning it any other way would, without going through the packing
+func planOneShard(data NameAndSizes, target int64) (Plan, bool) {
+	if len(data) == 0 {
+		return Plan{}, false
+	}
+	var total int64
+	for _, member := range data {
+		//Compared against the room left so the sum can't wrap around
+		if member.Size > target-total {
+			return Plan{}, false
+		}
+		total += member.Size
+	}
+	return Plan{Pool: data, Target: target}, true
+}
+
+// planTargets are the uncompressed sizes the shards are planned up to, one for
+// each of Targets or else just for TargetSize
+func planTargets(opts Options) ([]int64, error) {
+	sizes := opts.Targets
+	if len(sizes) == 0 {
+		sizes = []int64{opts.TargetSize}
+	}
+	targets := make([]int64, len(sizes))
+	for i, size := range sizes {
+		if err := ValidateTargetSize(size); err != nil {
+			return nil, err
+		}
+		target, err := planTarget(size, opts)
+		if err != nil {
+			return nil, err
+		}
+		targets[i] = target
+	}
+	return targets, nil
+}
+
+// planTarget is the uncompressed size shards with a target of size are
+// planned up to
+func planTarget(size int64, opts Options) (int64, error) {
+	if opts.OverheadBytes < 0 {
+		return 0, invalidTargetf("Overhead can't be negative, got %v", opts.OverheadBytes)
+	}
+	if opts.OverheadBytes >= size {
+		return 0, invalidTargetf("Overhead of %v bytes must be less than the target size of %v", opts.OverheadBytes, size)
+	}
+	size -= opts.OverheadBytes
+	if opts.CompressionRatio == 0 {
+		return size, nil
+	}
+	if opts.CompressionRatio < 0 || math.IsNaN(opts.CompressionRatio) || math.IsInf(opts.CompressionRatio, 0) {
+		return 0, invalidTargetf("Compression ratio must be positive, got %v", opts.CompressionRatio)
+	}
+	target := float64(size) / opts.CompressionRatio
+	if target >= math.MaxInt64 {
+		return math.MaxInt64, nil
+	}
+	return int64(target), nil
+}
+
+// openFile opens a file to read. It is a variable so it can be swapped out
+var openFile = os.Open
+
+// openSource opens the source tar, explaining what went wrong when it can't
+func openSource(filename string) (*os.File, error) {
+	file, err := openFile(filename)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("Source tar %s does not exist, check the path is correct relative to the current directory: %w", filename, err)
+	case errors.Is(err, os.ErrPermission):
+		return nil, fmt.Errorf("Source tar %s is not readable, check its permissions: %w", filename, err)
+	case err != nil:
+		return nil, fmt.Errorf("Could not open source tar %s: %w", filename, err)
+	}
+	return file, nil
+}
+
+// generateSlice lists the members of src, counting every entry it holds in
+// census. It stops after limit members unless limit is negative, reporting
+// whether any were left. When digest is set every byte of the source is also
+// written to it, which means reading past data that would otherwise be skipped.
+// With concatenated set it reads on past the first trailer, see tarStream
+func generateSlice(src source, digest hash.Hash, census Census, limit int, concatenated bool) (NameAndSizes, bool, error) {
+
+	tarreader, closeSource, err := src.streamTo(digest)
+	if err != nil {
+		return NameAndSizes{}, false, err
+	}
+	defer closeSource()
+	filename := src.name
+
+	position := newPositionReader(tarreader)
+	tr := newTarStream(position, concatenated)
+	var info NameAndSizes
+	if fi, ok := src.r.(interface{ Stat() (os.FileInfo, error) }); ok {
+		if stat, err := fi.Stat(); err == nil {
+			info = make(NameAndSizes, 0, estimateMembers(stat.Size()))
+		}
+	} else if sized, ok := src.r.(interface{ Size() int64 }); ok {
+		info = make(NameAndSizes, 0, estimateMembers(sized.Size()))
+	}
+	var offset int64
+	//Shards are routed by name, so a repeated name would send both copies to
+	//one writer and drop the other's planned place
+	seen := make(map[string]int)
+
+	var last string
+	archive := 0
+	for {
+		if len(info) == limit {
+			//Only cut short when there was something more to read
+			_, err := tr.Next()
+			cut := err != io.EOF
+			if digest != nil {
+				if _, err := io.Copy(io.Discard, position); err != nil {
+					return NameAndSizes{}, false, err
+				}
+			}
+			return info, cut, nil
+		}
+		header, err := tr.Next()
+		switch {
+		case err == io.EOF:
+			if digest != nil {
+				//The digest covers the whole source, anything after the trailer too
+				if _, err := io.Copy(io.Discard, position); err != nil {
+					return NameAndSizes{}, false, err
+				}
+			}
+			return info, false, nil
+
+		case err != nil:
+			return NameAndSizes{}, false, &SourceError{Source: filename, Member: last, After: true, Offset: position.pos, Err: err}
+
+		case header == nil:
+			continue
+		}
+		last = header.Name
+		if tr.archive != archive {
+			//A new tar, after the trailer and any padding of the last
+			archive, offset = tr.archive, tr.start
+		}
+		if isLongLink(header) {
+			offset = nextOffset(header, offset, position.pos)
+			continue
+		}
+		census[header.Typeflag]++
+		if first, ok := seen[header.Name]; ok {
+			//Concatenated tars commonly share parent directories, like ./,
+			//keep the first. Copying passes over the others too
+			if header.Typeflag == tar.TypeDir {
+				offset = nextOffset(header, offset, position.pos)
+				continue
+			}
+			return NameAndSizes{}, false, &DuplicateMemberError{Name: header.Name, Source: filename, Other: filename, First: first, Second: len(info)}
+		}
+		seen[header.Name] = len(info)
+		info = append(info, NameAndSize{Name: header.Name, Size: header.Size, Offset: offset, Typeflag: header.Typeflag, Linkname: header.Linkname, Xattrs: xattrs(header)})
+		offset = nextOffset(header, offset, position.pos)
+	}
+}
+
+// nextOffset works out where the header following header starts. offset is
+// where header itself started and pos is where its data starts. Sparse files
+// don't store their data as is, so everything after one is unknown, -1
+func nextOffset(header *tar.Header, offset, pos int64) int64 {
+	if offset < 0 || header.Typeflag == tar.TypeGNUSparse {
+		return -1
+	}
+	for key := range header.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return -1
+		}
+	}
+	size := header.Size
+	switch header.Typeflag {
+	case tar.TypeLink, tar.TypeSymlink, tar.TypeChar, tar.TypeBlock, tar.TypeDir, tar.TypeFifo, tar.TypeXGlobalHeader:
+		//No data follows these whatever their size says
+		size = 0
+	}
+	return (pos + size + blockSize - 1) / blockSize * blockSize
+}
@@ -0,0 +1,384 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// entryKinds are members of every kind generateSlice lists
+var entryKinds = []testMember{
+	{Name: "dir/", Typeflag: tar.TypeDir},
+	{Name: "dir/file", Body: "some data"},
+	{Name: "dir/empty"},
+	{Name: "dir/symlink", Typeflag: tar.TypeSymlink, Linkname: "file"},
+	{Name: "dir/hardlink", Typeflag: tar.TypeLink, Linkname: "dir/file"},
+	{Name: "dir/char", Typeflag: tar.TypeChar},
+	{Name: "dir/block", Typeflag: tar.TypeBlock},
+	{Name: "dir/fifo", Typeflag: tar.TypeFifo},
+}
+
+func TestGenerateSliceSizes(t *testing.T) {
+	data := makeTar(t, entryKinds...)
+	members, _, err := generateSlice(source{r: bytes.NewReader(data), name: "in.tar"}, nil, make(Census), -1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != len(entryKinds) {
+		t.Fatalf("Expected %v members, got %v", len(entryKinds), len(members))
+	}
+	tr := tar.NewReader(bytes.NewReader(data))
+	for i := 0; ; i++ {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := header.FileInfo().Size(); members[i].Name != header.Name || members[i].Size != size {
+			t.Errorf("Expected %s with %v bytes as FileInfo gives, got %s with %v", header.Name, size, members[i].Name, members[i].Size)
+		}
+	}
+}
+
+// concatenated is two tars rooted at ./ one after the other, as tar -A or cat
+// leaves them, the second ending with extra
+func concatenated(t *testing.T, extra ...testMember) []byte {
+	first := makeTar(t, testMember{Name: "./", Typeflag: tar.TypeDir}, testMember{Name: "./a", Body: "first"})
+	second := makeTar(t, append([]testMember{{Name: "./", Typeflag: tar.TypeDir}, {Name: "./b", Body: "second"}}, extra...)...)
+	return append(first, second...)
+}
+
+func TestConcatenatedSharedDirs(t *testing.T) {
+	data := concatenated(t)
+	for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+		t.Run(name, func(t *testing.T) {
+			opts := testOptions(t)
+			opts.Concatenated = true
+			opts.Strategy = strategy
+			result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries := readShards(t, result)
+			if entries["./a"].Body != "first" || entries["./b"].Body != "second" {
+				t.Errorf("Expected members of both tars, got %v", entries)
+			}
+			if result.Census[tar.TypeDir] != 2 {
+				t.Errorf("Expected both ./ entries counted, got %v", result.Census[tar.TypeDir])
+			}
+		})
+	}
+}
+
+func TestConcatenatedRepeatedFile(t *testing.T) {
+	data := concatenated(t, testMember{Name: "./a", Body: "again"})
+	opts := testOptions(t)
+	opts.Concatenated = true
+	_, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+	if !errors.Is(err, ErrDuplicateMember) {
+		t.Errorf("Expected a repeated file to be an error, got %v", err)
+	}
+}
+
+func TestBadInputReturnsError(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		target int64
+		want   error
+	}{
+		{"not a tar", bytes.Repeat([]byte("not a tar "), 200), 1 << 20, nil},
+		{"truncated", makeTar(t, testMember{Name: "a", Body: strings.Repeat("x", 4096)})[:2048], 1 << 20, nil},
+		{"zero target", makeTar(t, testMember{Name: "a", Body: "x"}), 0, ErrInvalidTarget},
+		{"negative target", makeTar(t, testMember{Name: "a", Body: "x"}), -1, ErrInvalidTarget},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := testOptions(t)
+			opts.TargetSize = test.target
+			_, err := SplitReader(bytes.NewReader(test.data), "in.tar", opts)
+			if err == nil {
+				t.Fatalf("Expected an error")
+			}
+			if test.want != nil && !errors.Is(err, test.want) {
+				t.Errorf("Expected %v, got %v", test.want, err)
+			}
+		})
+	}
+	if _, err := buildTarPlan(NameAndSizes{{Name: "a", Size: 1}}, 0); !errors.Is(err, ErrInvalidTarget) {
+		t.Errorf("Expected planning to a zero target to fail with %v, got %v", ErrInvalidTarget, err)
+	}
+}
+
+func TestDuplicateMember(t *testing.T) {
+	dir := t.TempDir()
+	repeated := writeFile(t, dir, "repeated.tar", makeTar(t, testMember{Name: "a", Body: "first"}, testMember{Name: "b"}, testMember{Name: "a", Body: "second"}))
+	one := writeFile(t, dir, "one.tar", makeTar(t, testMember{Name: "d/", Typeflag: tar.TypeDir}, testMember{Name: "d/a", Body: "one"}))
+	other := writeFile(t, dir, "other.tar", makeTar(t, testMember{Name: "d/", Typeflag: tar.TypeDir}, testMember{Name: "d/b", Body: "other"}))
+	same := writeFile(t, dir, "same.tar", makeTar(t, testMember{Name: "d/", Typeflag: tar.TypeDir}, testMember{Name: "d/a", Body: "same"}))
+	tests := []struct {
+		name      string
+		filenames []string
+		err       *DuplicateMemberError
+	}{
+		{"in one tar", []string{repeated}, &DuplicateMemberError{Name: "a", Source: repeated, Other: repeated, First: 0, Second: 2}},
+		{"across tars", []string{one, same}, &DuplicateMemberError{Name: "d/a", Source: one, Other: same}},
+		{"shared directory", []string{one, other}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := testOptions(t)
+			result, err := SplitAll(test.filenames, opts)
+			if test.err == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if entries := readShards(t, result); entries["d/a"].Body != "one" || entries["d/b"].Body != "other" {
+					t.Errorf("Expected the members of both tars, got %v", entries)
+				}
+				return
+			}
+			var dup *DuplicateMemberError
+			if !errors.As(err, &dup) || !errors.Is(err, ErrDuplicateMember) {
+				t.Fatalf("Expected a DuplicateMemberError, got %v", err)
+			}
+			if *dup != *test.err {
+				t.Errorf("Expected %+v, got %+v", *test.err, *dup)
+			}
+			if entries, _ := os.ReadDir(opts.outDir); len(entries) > 0 {
+				t.Errorf("Expected nothing written, got %v files", len(entries))
+			}
+		})
+	}
+}
+
+func TestSplitAllBalanced(t *testing.T) {
+	dir := t.TempDir()
+	var first, second []testMember
+	for i := 0; i < 6; i++ {
+		first = append(first, testMember{Name: fmt.Sprintf("one/f%v", i), Body: strings.Repeat("1", 1000*(i+1))})
+		second = append(second, testMember{Name: fmt.Sprintf("two/f%v", i), Body: strings.Repeat("2", 1000*(6-i))})
+	}
+	filenames := []string{writeFile(t, dir, "layer-1.tar", makeTar(t, first...)), writeFile(t, dir, "layer-2.tar", makeTar(t, second...))}
+	for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+		t.Run(name, func(t *testing.T) {
+			opts := testOptions(t)
+			opts.NumShards = 3
+			opts.Strategy = strategy
+			result, err := SplitAll(filenames, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Shards) != 3 {
+				t.Fatalf("Expected 3 shards, got %v", len(result.Shards))
+			}
+			entries := readShards(t, result)
+			for _, member := range append(first, second...) {
+				if entries[member.Name].Body != member.Body {
+					t.Errorf("Expected %s copied from its own source, got %v bytes", member.Name, len(entries[member.Name].Body))
+				}
+			}
+			//The 42000 bytes split three ways
+			for _, shard := range result.Shards {
+				if shard.Planned < 12000 || shard.Planned > 16000 {
+					t.Errorf("Expected shard %v balanced near 14000 bytes, got %v", shard.Index, shard.Planned)
+				}
+				if !strings.HasSuffix(shard.File, "-layer-1.tar") {
+					t.Errorf("Expected shards named after the first source, got %s", shard.File)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitStream(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "in.tar", genTar(t, 12, 1000))
+	for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+		t.Run(name, func(t *testing.T) {
+			opts := testOptions(t)
+			opts.TargetSize = 4096
+			opts.Strategy = strategy
+			shards, errc := SplitStream([]string{path}, opts)
+			seen := make(map[int]bool)
+			members := 0
+			for shard := range shards {
+				if seen[shard.Index] {
+					t.Errorf("Expected shard %v sent once", shard.Index)
+				}
+				seen[shard.Index] = true
+				//Each shard is whole by the time it is sent
+				entries := readTar(t, shard.File)
+				if len(entries) != shard.Members {
+					t.Errorf("Expected shard %v to hold %v members when sent, got %v", shard.Index, shard.Members, len(entries))
+				}
+				members += len(entries)
+				if strategy == StrategyTwoPass && shard.Index != len(seen)-1 {
+					t.Errorf("Expected shard %v sent in index order, got it %v", shard.Index, len(seen))
+				}
+			}
+			if err := <-errc; err != nil {
+				t.Fatal(err)
+			}
+			if len(seen) < 2 || members != 12 {
+				t.Errorf("Expected all 12 members over several shards, got %v over %v", members, len(seen))
+			}
+		})
+	}
+
+	shards, errc := SplitStream([]string{filepath.Join(dir, "missing.tar")}, testOptions(t))
+	for shard := range shards {
+		t.Errorf("Expected no shards from a missing source, got %v", shard.Index)
+	}
+	if err := <-errc; !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected the missing source to end the stream, got %v", err)
+	}
+}
+
+func TestRawLongLinkEntries(t *testing.T) {
+	long := strings.Repeat("long/", 30) + "file"
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	//A producer writing the long name as a regular ././@LongLink entry, which
+	//archive/tar passes through rather than merging
+	raw := long + "\x00"
+	tw.WriteHeader(&tar.Header{Name: longLinkName, Typeflag: tar.TypeReg, Size: int64(len(raw)), Format: tar.FormatGNU})
+	io.WriteString(tw, raw)
+	tw.WriteHeader(&tar.Header{Name: "truncated", Typeflag: tar.TypeReg, Size: 4, Mode: 0644})
+	io.WriteString(tw, "data")
+	//A proper GNU long name, which is merged
+	tw.WriteHeader(&tar.Header{Name: long + "2", Typeflag: tar.TypeReg, Size: 5, Mode: 0644, Format: tar.FormatGNU})
+	io.WriteString(tw, "other")
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+		t.Run(name, func(t *testing.T) {
+			opts := testOptions(t)
+			opts.Strategy = strategy
+			result, err := SplitReader(bytes.NewReader(buf.Bytes()), "in.tar", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries := readShards(t, result)
+			if _, ok := entries[longLinkName]; ok {
+				t.Errorf("Expected no %s entry in the shards", longLinkName)
+			}
+			if len(entries) != 2 || entries["truncated"].Body != "data" || entries[long+"2"].Body != "other" {
+				t.Errorf("Expected only the two real members, got %v", entries)
+			}
+			if result.Census[tar.TypeReg] != 2 {
+				t.Errorf("Expected 2 regular files counted, got %v", result.Census[tar.TypeReg])
+			}
+		})
+	}
+}
+
+func TestRunStats(t *testing.T) {
+	opts := testOptions(t)
+	opts.TargetSize = 4096
+	opts.Manifest = filepath.Join(t.TempDir(), "manifest.json")
+	result, err := SplitReader(bytes.NewReader(genTar(t, 10, 1000)), "in.tar", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, shard := range result.Shards {
+		info, err := os.Stat(shard.File)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += info.Size()
+	}
+	if len(result.Shards) < 2 || result.Bytes() != total {
+		t.Errorf("Expected %v bytes over several shards, got %v over %v", total, result.Bytes(), len(result.Shards))
+	}
+	if result.Elapsed <= 0 || result.Throughput() != float64(total)/result.Elapsed.Seconds() {
+		t.Errorf("Expected the throughput of %v bytes in %v, got %v", total, result.Elapsed, result.Throughput())
+	}
+	manifest, err := ReadManifest(opts.Manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Run == nil || manifest.Run.Bytes != total || manifest.Run.ElapsedSeconds != result.Elapsed.Seconds() {
+		t.Errorf("Expected the manifest to record %v bytes in %v, got %+v", total, result.Elapsed, manifest.Run)
+	}
+	if (&Result{}).Throughput() != 0 {
+		t.Error("Expected no throughput when nothing was timed")
+	}
+}
+
+func TestLimit(t *testing.T) {
+	dir := t.TempDir()
+	first := writeFile(t, dir, "first.tar", genTar(t, 30, 600))
+	second := writeFile(t, dir, "second.tar.gz", gzipBytes(t, makeTar(t, testMember{Name: "z/one", Body: "1"}, testMember{Name: "z/two", Body: "2"})))
+	for _, test := range []struct {
+		limit     int
+		filenames []string
+		want      int
+		partial   bool
+	}{
+		{10, []string{first}, 10, true},
+		{1, []string{first}, 1, true},
+		{30, []string{first}, 30, false},
+		{0, []string{first}, 30, false},
+		{31, []string{first, second}, 31, true},
+		{100, []string{first, second}, 32, false},
+	} {
+		for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+			opts := testOptions(t)
+			opts.TargetSize = 4096
+			opts.TmpDir = t.TempDir()
+			opts.Strategy = strategy
+			opts.Limit = test.limit
+			opts.Manifest = filepath.Join(t.TempDir(), "manifest.json")
+			result, err := SplitAll(test.filenames, opts)
+			if err != nil {
+				t.Fatalf("Limit %v %s: %v", test.limit, name, err)
+			}
+			entries := readShards(t, result)
+			if len(entries) != test.want || result.Partial != test.partial {
+				t.Errorf("Limit %v %s: expected %v members, partial %v, got %v, partial %v", test.limit, name, test.want, test.partial, len(entries), result.Partial)
+			}
+			for i := 0; i < test.want && i < 30; i++ {
+				if _, ok := entries[fmt.Sprintf("d%04d/f%07d", i/100, i)]; !ok {
+					t.Errorf("Limit %v %s: expected member %v of the first source split", test.limit, name, i)
+				}
+			}
+			manifest, err := ReadManifest(opts.Manifest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if manifest.Partial != test.partial {
+				t.Errorf("Limit %v %s: expected the manifest partial %v", test.limit, name, test.partial)
+			}
+		}
+	}
+
+	opts := testOptions(t)
+	opts.Limit = -1
+	if _, err := Split(first, opts); err == nil {
+		t.Error("Expected a negative limit refused")
+	}
+}
@@ -0,0 +1,59 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+)
+
+// MemberTransform changes the content of a regular member as it is copied
+// into its shard, like recompressing it or stripping debug symbols. It is
+// given the member's header and data and returns the data to write instead,
+// along with its header, or nil to keep header. The header returned must
+// declare exactly as many bytes as the data returned holds
+type MemberTransform func(header *tar.Header, r io.Reader) (io.Reader, *tar.Header, error)
+
+// transformMember runs opts.MemberTransform over a regular member, returning
+// the header and data to write in its place and whether it ran at all. The
+// parts of a split file are left alone, as they aren't whole members
+func transformMember(header *tar.Header, r io.Reader, opts Options) (*tar.Header, io.Reader, bool, error) {
+	if opts.MemberTransform == nil || header.Typeflag != tar.TypeReg {
+		return header, r, false, nil
+	}
+	if _, ok := header.PAXRecords[recordPartOf]; ok {
+		return header, r, false, nil
+	}
+	data, transformed, err := opts.MemberTransform(header, r)
+	if err != nil {
+		return nil, nil, true, fmt.Errorf("Could not transform %s, got error %w", header.Name, err)
+	}
+	if data == nil {
+		return nil, nil, true, fmt.Errorf("Could not transform %s, the transform returned no data", header.Name)
+	}
+	if transformed == nil {
+		transformed = header
+	}
+	return transformed, data, true, nil
+}
+
+// checkTransformed makes sure r, the data a transform returned for the
+// member header describes, holds nothing past what the header declares
+func checkTransformed(header *tar.Header, r io.Reader) error {
+	var b [1]byte
+	if n, _ := io.ReadFull(r, b[:]); n > 0 {
+		return fmt.Errorf("Transform of %s returned more than the %v bytes its header declares", header.Name, header.Size)
+	}
+	return nil
+}
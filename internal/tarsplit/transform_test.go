@@ -0,0 +1,135 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// upperTransform uppercases the content of every member it is given,
+// keeping its size
+func upperTransform(header *tar.Header, r io.Reader) (io.Reader, *tar.Header, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bytes.NewReader(bytes.ToUpper(data)), nil, nil
+}
+
+func TestMemberTransform(t *testing.T) {
+	members := []testMember{
+		{Name: "a.txt", Body: strings.Repeat("alpha ", 300)},
+		{Name: "b.txt", Body: strings.Repeat("bravo ", 500)},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "a.txt"},
+		{Name: "c.txt", Body: "charlie"},
+	}
+	plain := makeTar(t, members...)
+	sources := map[string][]byte{"in.tar": plain, "in.tar.gz": gzipBytes(t, plain)}
+	for file, data := range sources {
+		for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+			var seen []string
+			opts := testOptions(t)
+			opts.TargetSize = 4096
+			opts.Strategy = strategy
+			opts.MemberTransform = func(header *tar.Header, r io.Reader) (io.Reader, *tar.Header, error) {
+				seen = append(seen, header.Name)
+				return upperTransform(header, r)
+			}
+			result, err := Split(writeFile(t, t.TempDir(), file, data), opts)
+			if err != nil {
+				t.Fatalf("%s %s: %v", file, name, err)
+			}
+			entries := readShards(t, result)
+			for _, member := range members {
+				entry := entries[member.Name]
+				if member.Typeflag != 0 {
+					continue
+				}
+				if want := strings.ToUpper(member.Body); entry.Body != want {
+					t.Errorf("%s %s: expected %s uppercased, got %q", file, name, member.Name, entry.Body)
+				}
+			}
+			//Not given the symlink, which has no content to change
+			if len(seen) != 3 {
+				t.Errorf("%s %s: expected the transform run on the 3 regular members, got %v", file, name, seen)
+			}
+		}
+	}
+}
+
+func TestMemberTransformResize(t *testing.T) {
+	data := makeTar(t,
+		testMember{Name: "a", Body: "hello"},
+		testMember{Name: "b", Body: "world"},
+	)
+	//Twice as long, with the header saying so
+	double := func(header *tar.Header, r io.Reader) (io.Reader, *tar.Header, error) {
+		body, err := io.ReadAll(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		resized := *header
+		resized.Size = int64(2 * len(body))
+		return bytes.NewReader(bytes.Repeat(body, 2)), &resized, nil
+	}
+	for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+		opts := testOptions(t)
+		opts.Strategy = strategy
+		opts.MemberTransform = double
+		result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		entries := readShards(t, result)
+		if entries["a"].Body != "hellohello" || entries["a"].Size != 10 || entries["b"].Body != "worldworld" {
+			t.Errorf("%s: expected the members doubled, got %q and %q", name, entries["a"].Body, entries["b"].Body)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		transform MemberTransform
+		want      string
+	}{
+		{"longer than declared", func(header *tar.Header, r io.Reader) (io.Reader, *tar.Header, error) {
+			return strings.NewReader("far more than five bytes"), nil, nil
+		}, "returned more than the 5 bytes"},
+		{"shorter than declared", func(header *tar.Header, r io.Reader) (io.Reader, *tar.Header, error) {
+			resized := *header
+			resized.Size = 100
+			return r, &resized, nil
+		}, ""},
+		{"no data", func(header *tar.Header, r io.Reader) (io.Reader, *tar.Header, error) {
+			return nil, nil, nil
+		}, "returned no data"},
+		{"failed", func(header *tar.Header, r io.Reader) (io.Reader, *tar.Header, error) {
+			return nil, nil, errors.New("out of ideas")
+		}, "Could not transform a, got error out of ideas"},
+	}
+	for _, test := range tests {
+		for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+			opts := testOptions(t)
+			opts.Strategy = strategy
+			opts.MemberTransform = test.transform
+			_, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("%s %s: expected an error containing %q, got %v", test.name, name, test.want, err)
+			}
+		}
+	}
+}
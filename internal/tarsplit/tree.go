@@ -0,0 +1,87 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"sort"
+	"strings"
+)
+
+// TreeNode is a file or directory in the Tree of a tar's members
+type TreeNode struct {
+	// Name is the last element of the path, ending in / for a directory
+	Name string
+	// Size is the member's size, or for a directory the total of everything
+	// under it
+	Size int64
+	// Members is 1 for a file, or for a directory how many files are under it
+	Members  int
+	Children []*TreeNode
+}
+
+// IsDir reports whether the node is a directory
+func (n *TreeNode) IsDir() bool {
+	return strings.HasSuffix(n.Name, "/")
+}
+
+// Tree arranges the members of data as the directory tree their names make,
+// under a root named ./. Directories are included whether or not the tar has
+// entries for them, and children are sorted by name
+func Tree(data NameAndSizes) *TreeNode {
+	root := &TreeNode{Name: "./"}
+	dirs := map[string]*TreeNode{"": root}
+	for _, member := range data {
+		name := strings.TrimPrefix(strings.TrimLeft(member.Name, "/"), "./")
+		isDir := strings.HasSuffix(name, "/")
+		elems := strings.Split(strings.Trim(name, "/"), "/")
+		if elems[0] == "" {
+			//The root directory itself
+			continue
+		}
+		parent, path := root, ""
+		for i, elem := range elems {
+			path += elem + "/"
+			if i == len(elems)-1 && !isDir {
+				break
+			}
+			dir := dirs[path]
+			if dir == nil {
+				dir = &TreeNode{Name: elem + "/"}
+				dirs[path] = dir
+				parent.Children = append(parent.Children, dir)
+			}
+			parent = dir
+		}
+		if !isDir {
+			parent.Children = append(parent.Children, &TreeNode{Name: elems[len(elems)-1], Size: member.Size, Members: 1})
+		}
+	}
+	root.total()
+	return root
+}
+
+// total adds up the sizes and members under a directory and sorts its
+// children
+func (n *TreeNode) total() {
+	if !n.IsDir() {
+		return
+	}
+	sort.Slice(n.Children, func(i, j int) bool {
+		return n.Children[i].Name < n.Children[j].Name
+	})
+	for _, child := range n.Children {
+		child.total()
+		n.Size += child.Size
+		n.Members += child.Members
+	}
+}
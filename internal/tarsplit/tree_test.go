@@ -0,0 +1,70 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// flattenTree lists node and everything under it, a line each with the
+// depth, name, size and member count
+func flattenTree(node *TreeNode, depth int) []string {
+	lines := []string{fmt.Sprintf("%v %s %v %v", depth, node.Name, node.Size, node.Members)}
+	for _, child := range node.Children {
+		lines = append(lines, flattenTree(child, depth+1)...)
+	}
+	return lines
+}
+
+func TestTree(t *testing.T) {
+	data := NameAndSizes{
+		{Name: "./"},
+		{Name: "./usr/", Typeflag: '5'},
+		{Name: "./usr/bin/zsh", Size: 700},
+		{Name: "./usr/bin/bash", Size: 1000},
+		{Name: "./usr/lib/libc.so", Size: 2000},
+		//No entries for etc/ or etc/ssl/
+		{Name: "etc/ssl/certs.pem", Size: 300},
+		{Name: "etc/hosts", Size: 20},
+		{Name: "/README", Size: 5},
+		{Name: "empty/", Typeflag: '5'},
+	}
+	want := []string{
+		"0 ./ 4025 6",
+		"1 README 5 1",
+		"1 empty/ 0 0",
+		"1 etc/ 320 2",
+		"2 hosts 20 1",
+		"2 ssl/ 300 1",
+		"3 certs.pem 300 1",
+		"1 usr/ 3700 3",
+		"2 bin/ 1700 2",
+		"3 bash 1000 1",
+		"3 zsh 700 1",
+		"2 lib/ 2000 1",
+		"3 libc.so 2000 1",
+	}
+	tree := Tree(data)
+	if got := flattenTree(tree, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected the tree\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+	if !tree.IsDir() || tree.Children[0].IsDir() {
+		t.Error("Expected only directories to end in a slash")
+	}
+	if got := flattenTree(Tree(nil), 0); !reflect.DeepEqual(got, []string{"0 ./ 0 0"}) {
+		t.Errorf("Expected an empty tar to be a bare root, got %q", got)
+	}
+}
@@ -0,0 +1,84 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MembersChangedError is returned with VerifyMembers when the sources no
+// longer hold the members they were planned from
+type MembersChangedError struct {
+	// Missing were planned but are no longer in the sources
+	Missing []string
+	// Unplanned are in the sources but weren't when they were planned
+	Unplanned []string
+	// Resized are still in the sources with a different size
+	Resized []string
+}
+
+func (e *MembersChangedError) Error() string {
+	var parts []string
+	if len(e.Missing) > 0 {
+		parts = append(parts, "missing "+strings.Join(e.Missing, ", "))
+	}
+	if len(e.Unplanned) > 0 {
+		parts = append(parts, "not planned "+strings.Join(e.Unplanned, ", "))
+	}
+	if len(e.Resized) > 0 {
+		parts = append(parts, "resized "+strings.Join(e.Resized, ", "))
+	}
+	return fmt.Sprintf("Sources changed since they were planned, %s", strings.Join(parts, "; "))
+}
+
+func (e *MembersChangedError) Is(target error) bool {
+	return target == ErrMembersChanged
+}
+
+// verifyMembers reads the headers of sources again and compares them with
+// planned, the members scanned when planning, before anything is copied
+func verifyMembers(sources []source, planned NameAndSizes, opts Options) error {
+	//The sources' digests were taken while planning
+	opts.SourceHash = false
+	found, _, err := scanSources(sources, opts)
+	if err != nil {
+		return err
+	}
+	sizes := make(map[string]int64, len(planned))
+	for _, member := range planned {
+		sizes[member.Name] = member.Size
+	}
+	changed := &MembersChangedError{}
+	for _, member := range found {
+		size, ok := sizes[member.Name]
+		switch {
+		case !ok:
+			changed.Unplanned = append(changed.Unplanned, member.Name)
+		case size != member.Size:
+			changed.Resized = append(changed.Resized, member.Name)
+		}
+		delete(sizes, member.Name)
+	}
+	for name := range sizes {
+		changed.Missing = append(changed.Missing, name)
+	}
+	if len(changed.Missing)+len(changed.Unplanned)+len(changed.Resized) == 0 {
+		return nil
+	}
+	sort.Strings(changed.Missing)
+	sort.Strings(changed.Unplanned)
+	sort.Strings(changed.Resized)
+	return changed
+}
@@ -0,0 +1,72 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVerifyMembers(t *testing.T) {
+	planned := makeTar(t,
+		testMember{Name: "a", Body: strings.Repeat("a", 1500)},
+		testMember{Name: "b", Body: strings.Repeat("b", 1500)},
+		testMember{Name: "c", Body: strings.Repeat("c", 1500)},
+	)
+	//a grew, b went and new came
+	changed := makeTar(t,
+		testMember{Name: "a", Body: strings.Repeat("a", 2000)},
+		testMember{Name: "c", Body: strings.Repeat("c", 1500)},
+		testMember{Name: "new", Body: strings.Repeat("n", 1500)},
+	)
+	for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+		opts := testOptions(t)
+		opts.TargetSize = 4096
+		opts.Strategy = strategy
+		opts.VerifyMembers = true
+		//Past the block sniffed for compression, so only planning sees planned
+		src := &changingSource{Reader: bytes.NewReader(planned), after: 4 * blockSize, other: changed}
+		_, err := SplitReader(src, "in.tar", opts)
+		var changedErr *MembersChangedError
+		if !errors.As(err, &changedErr) || !errors.Is(err, ErrMembersChanged) {
+			t.Fatalf("%s: expected a MembersChangedError, got %v", name, err)
+		}
+		want := &MembersChangedError{Missing: []string{"b"}, Unplanned: []string{"new"}, Resized: []string{"a"}}
+		if !reflect.DeepEqual(changedErr, want) {
+			t.Errorf("%s: expected %+v, got %+v", name, want, changedErr)
+		}
+		if !strings.Contains(err.Error(), "missing b; not planned new; resized a") {
+			t.Errorf("%s: expected every change named, got %v", name, err)
+		}
+		if files, _ := os.ReadDir(opts.outDir); len(files) != 0 {
+			t.Errorf("%s: expected nothing written, got %v files", name, len(files))
+		}
+
+		//Unchanged sources split as they would without the check
+		opts = testOptions(t)
+		opts.TargetSize = 4096
+		opts.Strategy = strategy
+		opts.VerifyMembers = true
+		result, err := SplitReader(bytes.NewReader(planned), "in.tar", opts)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if entries := readShards(t, result); len(entries) != 3 {
+			t.Errorf("%s: expected all 3 members split, got %v", name, len(entries))
+		}
+	}
+}
@@ -0,0 +1,330 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// typeMultiVolume is the GNU typeflag of the header starting a volume with the
+// rest of a member the volume before ran out of room for
+const typeMultiVolume = 'M'
+
+// checkVolumes makes sure the split can be written as a multi-volume set
+func checkVolumes(opts Options) error {
+	switch {
+	case opts.NumShards > 0 || len(opts.Targets) > 0:
+		return fmt.Errorf("Multi-volume output needs a single target size for the volumes")
+	case opts.Format != tar.FormatUnknown && opts.Format != tar.FormatGNU:
+		return fmt.Errorf("Multi-volume output is a GNU tar extension, it can't be written as %v", opts.Format)
+	case opts.Resume != "" || opts.ImportPlan != "" || opts.ExportPlan != "" || opts.AppendTo != "" || len(opts.Pin) > 0:
+		return fmt.Errorf("Multi-volume output is cut as it is written, it can't be planned, resumed or appended to")
+	case opts.Layout == LayoutContainer:
+		return fmt.Errorf("Multi-volume output can't be written with a container layout")
+	case opts.SplitLargeFiles || opts.HardlinkCopies || opts.EmbedIndex || opts.GlobalRecords || len(opts.Labels) > 0:
+		return fmt.Errorf("Multi-volume output can't split large files, copy hardlinks, embed an index or carry global records")
+	case opts.SkipErrors || opts.RecordSize > 0:
+		return fmt.Errorf("Multi-volume output can't skip failed members or pad to a record size")
+	case opts.Strategy == StrategyTwoPass || opts.Order == OrderName:
+		return fmt.Errorf("Multi-volume output is written in one pass in source order")
+	}
+	if opts.TargetSize/blockSize*blockSize < MinTargetSize {
+		return invalidTargetf("Volumes must hold at least %v bytes, got %v", MinTargetSize, opts.TargetSize)
+	}
+	return nil
+}
+
+// volumeWriter cuts the tar written to it into volumes of at most size bytes,
+// as GNU tar --multi-volume does. A volume ends when it is full, without a
+// trailer, and when that leaves a member's data unfinished the next volume
+// starts with a continuation header for the rest of it
+type volumeWriter struct {
+	size int64
+	fn   string
+	opts Options
+	t    *tally
+	// plans are the volumes started so far, with the members whose header is
+	// in each, and s the one being written
+	plans   []Plan
+	s       *shard
+	written int64
+	// held are headers kept back until they are complete, so a volume ends
+	// before a header rather than in the middle of one
+	held    bytes.Buffer
+	holding bool
+	// name, total and left are the member whose data is being written
+	name  string
+	total int64
+	left  int64
+}
+
+func newVolumeWriter(fn string, opts Options, t *tally) *volumeWriter {
+	return &volumeWriter{size: opts.TargetSize / blockSize * blockSize, fn: fn, opts: opts, t: t}
+}
+
+func (v *volumeWriter) Write(p []byte) (int, error) {
+	if v.holding {
+		return v.held.Write(p)
+	}
+	n := 0
+	for len(p) > 0 {
+		if v.written == v.size {
+			if err := v.next(); err != nil {
+				return n, err
+			}
+		}
+		chunk := p
+		if int64(len(chunk)) > v.size-v.written {
+			chunk = chunk[:v.size-v.written]
+		}
+		m, err := v.s.out.Write(chunk)
+		n += m
+		v.written += int64(m)
+		if v.left -= int64(m); v.left < 0 {
+			//What followed the data was padding
+			v.left = 0
+		}
+		if err != nil {
+			return n, err
+		}
+		p = p[m:]
+	}
+	return n, nil
+}
+
+// hold keeps back what is written from now on, until place
+func (v *volumeWriter) hold() {
+	v.holding = true
+	v.held.Reset()
+}
+
+// place writes out what was held back, in the volume being written if it fits
+// and otherwise in the next one
+func (v *volumeWriter) place() error {
+	v.holding = false
+	if int64(v.held.Len()) > v.size {
+		return fmt.Errorf("Volumes of %v bytes are too small for a header of %v", v.size, v.held.Len())
+	}
+	if v.s == nil || v.written+int64(v.held.Len()) > v.size {
+		if err := v.next(); err != nil {
+			return err
+		}
+	}
+	_, err := v.Write(v.held.Bytes())
+	return err
+}
+
+// next finishes the volume being written and starts another
+func (v *volumeWriter) next() error {
+	if err := v.finish(); err != nil {
+		return err
+	}
+	plan := Plan{Index: v.opts.IndexStart + len(v.plans), Target: v.size}
+	s, err := newShard(plan.Index, v.fn, v.opts)
+	if err != nil {
+		return err
+	}
+	v.plans = append(v.plans, plan)
+	v.s = s
+	v.written = 0
+	if v.opts.OnShardStart != nil {
+		v.opts.OnShardStart(plan.Index, v.size)
+	}
+	if v.left == 0 {
+		return nil
+	}
+	header, err := continuationHeader(v.name, v.total-v.left, v.left)
+	if err != nil {
+		return err
+	}
+	//Written straight to the file, it isn't any of the member's data
+	_, err = v.s.out.Write(header)
+	v.written += int64(len(header))
+	return err
+}
+
+// finish completes the volume being written, if any
+func (v *volumeWriter) finish() error {
+	if v.s == nil {
+		return nil
+	}
+	plan := v.plans[len(v.plans)-1]
+	v.s.members = len(plan.Pool)
+	v.s.planned = plan.Size()
+	v.s.fill = float64(v.written) / float64(v.size)
+	if err := v.s.finish(v.opts, v.t); err != nil {
+		return err
+	}
+	v.s = nil
+	return nil
+}
+
+// abort removes the unfinished volume after an error
+func (v *volumeWriter) abort() {
+	if v.s != nil {
+		v.s.abort()
+	}
+}
+
+// continuationHeader is the GNU header starting a volume with the last left
+// bytes of the member name, after the offset bytes of it the volumes before
+// hold. Like GNU tar it has room for only the first 100 bytes of the name
+func continuationHeader(name string, offset, left int64) ([]byte, error) {
+	block := make([]byte, blockSize)
+	copy(block[:100], name)
+	for _, field := range []struct {
+		b     []byte
+		value int64
+	}{{block[124:136], left}, {block[369:381], offset}} {
+		digits := strconv.FormatInt(field.value, 8)
+		if len(digits) >= len(field.b) {
+			return nil, fmt.Errorf("Could not continue %s on the next volume, %v bytes is too many for its header", name, field.value)
+		}
+		copy(field.b, strings.Repeat("0", len(field.b)-1-len(digits))+digits)
+	}
+	block[156] = typeMultiVolume
+	copy(block[257:265], "ustar  \x00")
+	//The checksum is taken with its own field as spaces
+	copy(block[148:156], "        ")
+	sum := 0
+	for _, b := range block {
+		sum += int(b)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", sum))
+	return block, nil
+}
+
+// writeVolumes streams the sources once, writing the members of data in
+// source order into a multi-volume set of tars that GNU tar can read back
+// with --multi-volume, given every volume in turn
+func writeVolumes(sources []source, fn string, data NameAndSizes, opts Options) (*Result, []Plan, error) {
+	t := newTally([]Plan{{Pool: data}}, opts)
+	owners := make(map[string]int, len(data))
+	for _, member := range data {
+		owners[member.Name] = member.Source
+	}
+	v := newVolumeWriter(fn, opts, t)
+	defer v.abort()
+	tw := tar.NewWriter(v)
+
+	for i, src := range sources {
+		if err := writeVolumeSource(v, tw, src, i, owners, opts, t); err != nil {
+			return t.result(), v.plans, err
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return t.result(), v.plans, err
+	}
+	//The trailer is held back like a header, so the last volume has all of it
+	v.hold()
+	if err := tw.Close(); err != nil {
+		return t.result(), v.plans, fmt.Errorf("Could not write trailer for the last volume, got error %w", err)
+	}
+	if err := v.place(); err != nil {
+		return t.result(), v.plans, err
+	}
+	err := v.finish()
+	return t.result(), v.plans, err
+}
+
+// writeVolumeSource streams source number i into the volumes, copying the
+// members it owns
+func writeVolumeSource(v *volumeWriter, tw *tar.Writer, src source, i int, owners map[string]int, opts Options, t *tally) error {
+	genericReader, closeSource, err := src.stream()
+	if err != nil {
+		return err
+	}
+	defer closeSource()
+
+	position := newPositionReader(genericReader)
+	tarReader := newTarStream(position, opts.Concatenated)
+
+	var last string
+	copied := 0
+	dirs := make(map[string]bool)
+	for {
+		if src.cut && copied == src.members {
+			return nil
+		}
+		if err := checkInterrupted(opts); err != nil {
+			return err
+		}
+		header, err := tarReader.Next()
+		switch {
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return &SourceError{Source: src.name, Member: last, After: true, Offset: position.pos, Err: err}
+		case header == nil:
+			continue
+		}
+		last = header.Name
+		if isLongLink(header) || isRepeatedDir(header, dirs) {
+			continue
+		}
+		copied++
+		if owner, ok := owners[header.Name]; !ok || owner != i {
+			continue
+		}
+		if !isCarried(header.Typeflag) && !t.keep[header.Name] {
+			t.skipped[header.Typeflag]++
+			continue
+		}
+		if err := writeVolumeMember(v, tw, header, tarReader, opts, t); err != nil {
+			return &SourceError{Source: src.name, Member: header.Name, Offset: position.pos, Err: err}
+		}
+	}
+}
+
+// writeVolumeMember writes header and the member's data from r into the
+// volumes, as copyMember does into a shard
+func writeVolumeMember(v *volumeWriter, tw *tar.Writer, header *tar.Header, r io.Reader, opts Options, t *tally) error {
+	header, r, transformed, err := transformMember(header, r, opts)
+	if err != nil {
+		return err
+	}
+	header.Format = tar.FormatGNU
+	dropFieldRecords(header)
+	rewriteHeader(header, opts, t)
+	t.startMember(header.Name)
+	//Padding out the member before goes in with its data, not the header
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	v.hold()
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("Could not write header for %s as %v, got error %s", header.Name, header.Format, err.Error())
+	}
+	if err := v.place(); err != nil {
+		return err
+	}
+	plan := &v.plans[len(v.plans)-1]
+	plan.Pool = append(plan.Pool, NameAndSize{Name: header.Name, Size: header.Size, Offset: -1, Typeflag: header.Typeflag})
+	v.name, v.total, v.left = header.Name, header.Size, header.Size
+	n, err := io.CopyBuffer(tw, &progressReader{io.LimitReader(r, header.Size), t}, t.buf)
+	if err != nil {
+		return fmt.Errorf("Could not copy %s after %v of %v bytes, got error %w", header.Name, n, header.Size, err)
+	}
+	if n != header.Size {
+		return fmt.Errorf("Member %s declares %v bytes but only %v could be read, the source may be truncated", header.Name, header.Size, n)
+	}
+	if transformed {
+		return checkTransformed(header, r)
+	}
+	return nil
+}
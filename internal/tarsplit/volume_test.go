@@ -0,0 +1,151 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// octalField reads a numeric field of a raw tar header
+func octalField(tb testing.TB, field []byte) int64 {
+	tb.Helper()
+	value, err := strconv.ParseInt(strings.TrimRight(string(field), " \x00"), 8, 64)
+	if err != nil {
+		tb.Fatalf("Could not read header field %q, got error %v", field, err)
+	}
+	return value
+}
+
+// cutMember reads the tar stream as far as it goes, returning the member whose
+// data it ends partway into, and how much of the data it holds, or "" when it
+// ends between members
+func cutMember(tb testing.TB, stream []byte) (string, int64, int64) {
+	tb.Helper()
+	tr := tar.NewReader(bytes.NewReader(stream))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			return "", 0, 0
+		}
+		if err != nil {
+			tb.Fatalf("Could not read the volumes so far, got error %v", err)
+		}
+		n, err := io.Copy(io.Discard, tr)
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return header.Name, n, header.Size
+		}
+		if err != nil {
+			tb.Fatalf("Could not read %s from the volumes so far, got error %v", header.Name, err)
+		}
+	}
+}
+
+func TestMultiVolume(t *testing.T) {
+	var members []testMember
+	for i, size := range []int{100, 5000, 300, 9000, 0, 1200, 2600} {
+		members = append(members, testMember{Name: fmt.Sprintf("f%v", i), Body: strings.Repeat(string(rune('a'+i)), size)})
+	}
+	members = append(members, testMember{Name: strings.Repeat("long/", 30) + "name", Body: strings.Repeat("l", 3000)})
+	opts := testOptions(t)
+	opts.TargetSize = 4096
+	opts.MultiVolume = true
+	result, err := SplitReader(bytes.NewReader(makeTar(t, members...)), "in.tar", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards := append([]ShardResult(nil), result.Shards...)
+	sort.Slice(shards, func(i, j int) bool { return shards[i].Index < shards[j].Index })
+	if len(shards) < 5 {
+		t.Fatalf("Expected several volumes, got %v", len(shards))
+	}
+
+	var stream []byte
+	continued := 0
+	for i, shard := range shards {
+		volume, err := os.ReadFile(shard.File)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(volume) > 4096 || len(volume)%blockSize != 0 {
+			t.Errorf("Expected volume %v whole blocks within the target, got %v bytes", i, len(volume))
+		}
+		name, have, size := cutMember(t, stream)
+		if i > 0 && name != "" {
+			//The member the volume before ran out of room for goes on
+			header := volume[:blockSize]
+			if header[156] != typeMultiVolume {
+				t.Fatalf("Expected volume %v to continue %s, got type %q", i, name, header[156])
+			}
+			if got := strings.TrimRight(string(header[:100]), "\x00"); got != name[:min(len(name), 100)] {
+				t.Errorf("Expected volume %v to continue %s, got %s", i, name, got)
+			}
+			if left, offset := octalField(t, header[124:136]), octalField(t, header[369:381]); left != size-have || offset != have {
+				t.Errorf("Expected volume %v to continue %s with %v bytes from %v, got %v from %v", i, name, size-have, have, left, offset)
+			}
+			continued++
+			volume = volume[blockSize:]
+		} else if i > 0 && volume[156] == typeMultiVolume {
+			t.Errorf("Expected volume %v to start with a member of its own", i)
+		}
+		if i < len(shards)-1 && bytes.HasSuffix(volume, make([]byte, 2*blockSize)) && len(volume) > 0 {
+			t.Errorf("Expected only the last volume to end with the trailer")
+		}
+		stream = append(stream, volume...)
+	}
+	if continued == 0 {
+		t.Error("Expected some member to be continued on the next volume")
+	}
+	//Without the continuation headers the volumes are the one tar
+	entries := readTar(t, writeFile(t, t.TempDir(), "joined.tar", stream))
+	if len(entries) != len(members) {
+		t.Fatalf("Expected %v members in the volumes, got %v", len(members), len(entries))
+	}
+	for i, entry := range entries {
+		if entry.Name != members[i].Name || entry.Body != members[i].Body {
+			t.Errorf("Expected member %v to be %s as it was, got %s", i, members[i].Name, entry.Name)
+		}
+	}
+
+	//GNU tar reads them as a multi-volume set, when it is around to ask
+	out, err := exec.Command("tar", "--version").Output()
+	if err != nil || !strings.Contains(string(out), "GNU tar") {
+		return
+	}
+	args := []string{"-t", "-M"}
+	for _, shard := range shards {
+		args = append(args, "-f", shard.File)
+	}
+	//It warns that a continued name over 100 bytes was cut short, as it
+	//writes them that way itself
+	listed, err := exec.Command("tar", args...).Output()
+	if err != nil {
+		t.Fatalf("Expected GNU tar to list the volumes, got error %v", err)
+	}
+	var want strings.Builder
+	for _, member := range members {
+		fmt.Fprintln(&want, member.Name)
+	}
+	if string(listed) != want.String() {
+		t.Errorf("Expected GNU tar to list\n%s\ngot\n%s", want.String(), listed)
+	}
+}
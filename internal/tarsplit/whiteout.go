@@ -0,0 +1,81 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"path"
+	"strings"
+)
+
+// The overlayfs conventions container layers follow, a whiteout named
+// .wh.<name> deletes <name> from the layers below and an opaque marker
+// hides everything below in the directory it is in
+const (
+	whiteoutPrefix = ".wh."
+	opaqueMarker   = ".wh..wh..opq"
+)
+
+// whiteoutTarget is the name of what the member name whites out, or for an
+// opaque marker the directory it makes opaque. ok is false when name isn't a
+// whiteout
+func whiteoutTarget(name string) (target string, ok bool) {
+	dir, base := path.Split(name)
+	switch {
+	case base == opaqueMarker:
+		return dir, true
+	case strings.HasPrefix(base, whiteoutPrefix) && len(base) > len(whiteoutPrefix):
+		return dir + strings.TrimPrefix(base, whiteoutPrefix), true
+	}
+	return "", false
+}
+
+// holdWhiteouts takes out of data every whiteout whose target is a member of
+// data too, returning the rest and the whiteouts held back by the name of
+// that member. A target that is a directory is its own entry, whose name ends
+// in a slash
+func holdWhiteouts(data NameAndSizes) (NameAndSizes, map[string]NameAndSizes) {
+	names := make(map[string]bool, len(data))
+	for _, member := range data {
+		if _, ok := whiteoutTarget(member.Name); !ok {
+			names[member.Name] = true
+		}
+	}
+	held := make(map[string]NameAndSizes)
+	var rest NameAndSizes
+	for _, member := range data {
+		target, ok := whiteoutTarget(member.Name)
+		switch {
+		case ok && names[target]:
+			held[target] = append(held[target], member)
+		case ok && names[strings.TrimSuffix(target, "/")+"/"]:
+			target = strings.TrimSuffix(target, "/") + "/"
+			held[target] = append(held[target], member)
+		default:
+			rest = append(rest, member)
+		}
+	}
+	return rest, held
+}
+
+// placeWhiteouts adds the whiteouts held back by holdWhiteouts to the plans
+// holding their targets
+func placeWhiteouts(plans []Plan, held map[string]NameAndSizes) {
+	for i := range plans {
+		//A copy, as a plan's pool can share its array with another's
+		pool := append(NameAndSizes(nil), plans[i].Pool...)
+		for _, member := range plans[i].Pool {
+			pool = append(pool, held[member.Name]...)
+		}
+		plans[i].Pool = pool
+	}
+}
@@ -0,0 +1,145 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// shardOf is the index of the shard each member of result landed in
+func shardOf(t *testing.T, result *Result) map[string]int {
+	t.Helper()
+	shards := make(map[string]int)
+	for _, shard := range result.Shards {
+		for _, entry := range readTar(t, shard.File) {
+			shards[entry.Name] = shard.Index
+		}
+	}
+	return shards
+}
+
+func TestWhiteoutAware(t *testing.T) {
+	var members []testMember
+	for i := 0; i < 8; i++ {
+		members = append(members, testMember{Name: fmt.Sprintf("usr/f%v", i), Body: strings.Repeat("x", 1500)})
+	}
+	//The whiteouts come last, as a layer's often do, so packing in order
+	//would leave them together in the last shard
+	for i := 0; i < 8; i += 2 {
+		members = append(members, testMember{Name: fmt.Sprintf("usr/.wh.f%v", i)})
+	}
+	members = append(members, testMember{Name: "usr/.wh.gone"})
+	data := makeTar(t, members...)
+
+	for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+		opts := testOptions(t)
+		opts.TargetSize = 4096
+		opts.Strategy = strategy
+		opts.WhiteoutAware = true
+		result, err := SplitReader(bytes.NewReader(data), "layer.tar", opts)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(result.Shards) < 3 {
+			t.Errorf("%s: expected the layer over several shards, got %v", name, len(result.Shards))
+		}
+		shards := shardOf(t, result)
+		if len(shards) != len(members) {
+			t.Errorf("%s: expected all %v members split, got %v", name, len(members), len(shards))
+		}
+		for i := 0; i < 8; i += 2 {
+			whiteout, target := fmt.Sprintf("usr/.wh.f%v", i), fmt.Sprintf("usr/f%v", i)
+			if shards[whiteout] != shards[target] {
+				t.Errorf("%s: expected %s in shard %v with %s, got %v", name, whiteout, shards[target], target, shards[whiteout])
+			}
+		}
+		//Deletes nothing in the sources, so it is packed as any other member
+		if _, ok := shards["usr/.wh.gone"]; !ok {
+			t.Errorf("%s: expected the whiteout with no target split too", name)
+		}
+	}
+
+	//Without the flag the whiteouts are packed where they fit
+	opts := testOptions(t)
+	opts.TargetSize = 4096
+	result, err := SplitReader(bytes.NewReader(data), "layer.tar", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards := shardOf(t, result)
+	apart := 0
+	for i := 0; i < 8; i += 2 {
+		if shards[fmt.Sprintf("usr/.wh.f%v", i)] != shards[fmt.Sprintf("usr/f%v", i)] {
+			apart++
+		}
+	}
+	if apart == 0 {
+		t.Error("Expected some whiteouts apart from their targets without --whiteout-aware")
+	}
+}
+
+func TestWhiteoutAwareDirectories(t *testing.T) {
+	//Directories aren't written to the shards, so these are checked in the
+	//plans
+	data := NameAndSizes{
+		{Name: "opt/", Typeflag: '5'},
+		{Name: "opt/a", Size: 3000},
+		{Name: "srv/", Typeflag: '5'},
+		{Name: "srv/b", Size: 3000},
+		{Name: "big", Size: 3000},
+		{Name: "opt/.wh..wh..opq"},
+		{Name: ".wh.srv"},
+	}
+	opts := Options{TargetSize: 4096, WhiteoutAware: true}
+	members := append(NameAndSizes(nil), data...)
+	sortForPacking(members, opts)
+	plans, err := buildPlans(members, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEveryMember(t, data, plans)
+	planOf := make(map[string]int)
+	for i, plan := range plans {
+		for _, member := range plan.Pool {
+			planOf[member.Name] = i
+		}
+	}
+	if planOf["opt/.wh..wh..opq"] != planOf["opt/"] {
+		t.Errorf("Expected the opaque marker in plan %v with opt/, got %v", planOf["opt/"], planOf["opt/.wh..wh..opq"])
+	}
+	if planOf[".wh.srv"] != planOf["srv/"] {
+		t.Errorf("Expected .wh.srv in plan %v with srv/, got %v", planOf["srv/"], planOf[".wh.srv"])
+	}
+}
+
+func TestWhiteoutTarget(t *testing.T) {
+	tests := map[string]string{
+		"usr/.wh.bin":      "usr/bin",
+		".wh.etc":          "etc",
+		"opt/.wh..wh..opq": "opt/",
+		".wh..wh..opq":     "",
+	}
+	for name, want := range tests {
+		if target, ok := whiteoutTarget(name); !ok || target != want {
+			t.Errorf("Expected %s to white out %q, got %q, %v", name, want, target, ok)
+		}
+	}
+	for _, name := range []string{"usr/bin", "usr/.wh.", "usr/x.wh.y"} {
+		if target, ok := whiteoutTarget(name); ok {
+			t.Errorf("Expected %s not a whiteout, got one of %q", name, target)
+		}
+	}
+}
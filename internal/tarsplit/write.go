@@ -0,0 +1,652 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// createNewTars streams each source once, copying each member into the shard
+// planned for it. Members in existing were split before and are passed over
+func createNewTars(sources []source, fn string, plans *[]Plan, existing map[string]bool, opts Options) (*Result, error) {
+
+	t := newTally(*plans, opts)
+	//Create a map to define pointer for each name
+	filenamePtrMap := make(map[string]*shard)
+	//and which source each name is taken from, when splitting several
+	owners := make(map[string]int)
+
+	//Every shard gets its writer closed explicitly, even one that never receives
+	//a header, so that each output always ends with a valid tar trailer. Most
+	//are closed as soon as their last member is in, the rest at the end
+	shards := make([]*shard, 0, len(*plans))
+	defer func() {
+		for _, s := range shards {
+			if !s.closed {
+				s.abort()
+			}
+		}
+	}()
+
+	for _, plan := range *plans {
+		s, err := openShard(plan, fn, sources, opts, t)
+		if err != nil {
+			return t.result(), err
+		}
+		shards = append(shards, s)
+		for _, fn := range plan.Pool {
+			filenamePtrMap[fn.Name] = s
+			owners[fn.Name] = fn.Source
+		}
+	}
+
+	for i, source := range sources {
+		if err := copySource(source, i, filenamePtrMap, owners, existing, opts, t); err != nil {
+			return t.result(), err
+		}
+	}
+	for _, s := range shards {
+		if s.closed {
+			continue
+		}
+		if err := s.close(opts, t); err != nil {
+			return t.result(), err
+		}
+	}
+	return t.result(), nil
+}
+
+// copySource streams source number i, copying the members it owns to their
+// shards
+func copySource(src source, i int, filenamePtrMap map[string]*shard, owners map[string]int, existing map[string]bool, opts Options, t *tally) error {
+	genericReader, closeSource, err := src.stream()
+	if err != nil {
+		return err
+	}
+	defer closeSource()
+
+	position := newPositionReader(genericReader)
+	tarReader := newTarStream(position, opts.Concatenated)
+
+	var last string
+	copied := 0
+	dirs := make(map[string]bool)
+	for {
+		if src.cut && copied == src.members {
+			return nil
+		}
+		if err := checkInterrupted(opts); err != nil {
+			return err
+		}
+		header, err := tarReader.Next()
+		switch {
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return &SourceError{Source: src.name, Member: last, After: true, Offset: position.pos, Err: err}
+		case header == nil:
+			continue
+		}
+		last = header.Name
+		if isLongLink(header) || isRepeatedDir(header, dirs) {
+			continue
+		}
+		copied++
+		if file := opts.splitFiles[header.Name]; file != nil {
+			if err := writeParts(filenamePtrMap, header, tarReader, file, opts, t); err != nil {
+				return &SourceError{Source: src.name, Member: header.Name, Offset: position.pos, Err: err}
+			}
+			continue
+		}
+		mw := filenamePtrMap[header.Name]
+		if mw == nil && existing[header.Name] {
+			continue
+		}
+		var data io.Reader = tarReader
+		if target, ok := opts.linkTargets[header.Name]; ok && mw != nil {
+			if header, data, err = linkCopy(header, target, src.r.(io.ReaderAt)); err != nil {
+				return &SourceError{Source: src.name, Member: last, Offset: position.pos, Err: err}
+			}
+		}
+		if owner, ok := owners[header.Name]; ok && owner != i {
+			//A directory another source already provides
+			continue
+		}
+		if isCarried(header.Typeflag) && mw == nil {
+			return &UnknownMemberError{Name: header.Name, Source: src.name}
+		}
+		if err := writeMember(mw, header, data, opts, t); err != nil {
+			return &SourceError{Source: src.name, Member: header.Name, Offset: position.pos, Err: err}
+		}
+		if mw == nil {
+			continue
+		}
+		if mw.remaining--; mw.remaining == 0 {
+			if err := mw.close(opts, t); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// isRepeatedDir reports whether header is a directory already in dirs, a
+// repeat generateSlice passed over, noting it in dirs otherwise
+func isRepeatedDir(header *tar.Header, dirs map[string]bool) bool {
+	if header.Typeflag != tar.TypeDir {
+		return false
+	}
+	if dirs[header.Name] {
+		return true
+	}
+	dirs[header.Name] = true
+	return false
+}
+
+// writeOrderedTars writes the shards one at a time, each with its members in
+// source order or sorted by name. Rather than streaming the sources once it
+// jumps to every member's recorded offset, so sources must be uncompressed
+// io.ReaderAts
+func writeOrderedTars(sources []source, fn string, plans *[]Plan, opts Options) (*Result, error) {
+	t := newTally(*plans, opts)
+
+	readers, err := readersAt(sources, "Writing shards one at a time")
+	if err != nil {
+		return t.result(), err
+	}
+	parts := partsByName(opts.splitFiles)
+
+	for _, plan := range *plans {
+		members := append(NameAndSizes(nil), plan.Pool...)
+		if opts.Order == OrderName {
+			sort.Sort(byName(members))
+		} else {
+			sort.Sort(bySource(members))
+		}
+
+		if err := checkInterrupted(opts); err != nil {
+			return t.result(), err
+		}
+		s, err := openShard(plan, fn, sources, opts, t)
+		if err != nil {
+			return t.result(), err
+		}
+		for _, member := range members {
+			if p, ok := parts[member.Name]; ok {
+				err = writePartAt(s, readers[member.Source], member.Offset, p, opts, t)
+			} else if target, ok := opts.linkTargets[member.Name]; ok {
+				err = writeLinkCopyAt(s, readers[member.Source], member, target, opts, t)
+			} else {
+				err = writeMemberAt(s, readers[member.Source], member, opts, t)
+			}
+			if err != nil {
+				s.abort()
+				return t.result(), err
+			}
+		}
+		if err := s.close(opts, t); err != nil {
+			s.abort()
+			return t.result(), err
+		}
+	}
+	return t.result(), nil
+}
+
+// readersAt are sources as io.ReaderAts, failing when any isn't a plain tar
+// that can be read at any offset, as what needs
+func readersAt(sources []source, what string) ([]io.ReaderAt, error) {
+	readers := make([]io.ReaderAt, len(sources))
+	for i, source := range sources {
+		r, ok := source.r.(io.ReaderAt)
+		if !ok || source.gzipped {
+			return nil, fmt.Errorf("%s needs to read %s out of order, it must be a plain tar that can be read at any offset", what, source.name)
+		}
+		readers[i] = r
+	}
+	return readers, nil
+}
+
+// writeMemberAt reads the member starting at its offset in source and copies
+// it into s
+func writeMemberAt(s *shard, source io.ReaderAt, member NameAndSize, opts Options, t *tally) error {
+	if member.Offset < 0 {
+		return t.fail(member.Name, fmt.Errorf("Could not locate %s, it follows a sparse file so its offset is unknown", member.Name), opts)
+	}
+	tr := tar.NewReader(io.NewSectionReader(source, member.Offset, math.MaxInt64-member.Offset))
+	header, err := tr.Next()
+	if err != nil {
+		return t.fail(member.Name, fmt.Errorf("Could not read %s at offset %v, got error %w", member.Name, member.Offset, err), opts)
+	}
+	if header.Name != member.Name {
+		return t.fail(member.Name, fmt.Errorf("Expected %s at offset %v but found %s, has the source changed?", member.Name, member.Offset, header.Name), opts)
+	}
+	return writeMember(s, header, tr, opts, t)
+}
+
+// writeMember copies one member into s, or counts it as skipped when it is a
+// kind of entry that isn't carried over. With SkipErrors a member that fails
+// to copy is cut back out of the shard and noted rather than ending the split
+func writeMember(s *shard, header *tar.Header, r io.Reader, opts Options, t *tally) error {
+	if !isCarried(header.Typeflag) && !t.keep[header.Name] {
+		t.skipped[header.Typeflag]++
+		return nil
+	}
+	if !opts.SkipErrors {
+		return copyMember(s.tw, header, r, opts, t)
+	}
+	offset, err := s.mark()
+	if err != nil {
+		return err
+	}
+	if err := copyMember(s.tw, header, r, opts, t); err != nil {
+		if err := s.rewind(offset); err != nil {
+			return err
+		}
+		return t.fail(header.Name, err, opts)
+	}
+	return nil
+}
+
+// isCarried reports whether entries of kind flag are copied into the shards.
+// Device nodes have no data, but their header is copied as is so they keep
+// their Devmajor and Devminor
+func isCarried(flag byte) bool {
+	switch flag {
+	case tar.TypeReg, tar.TypeChar, tar.TypeBlock:
+		return true
+	}
+	return false
+}
+
+// longLinkName is the name GNU tar gives the entries holding a long name or
+// link target for the header after
+const longLinkName = "././@LongLink"
+
+// isLongLink reports whether header is a GNU long name entry that archive/tar
+// passed through rather than merging, as it does when a producer writes one
+// with a typeflag other than L or K. It is not a member of its own
+func isLongLink(header *tar.Header) bool {
+	return header.Name == longLinkName || header.Typeflag == tar.TypeGNULongName || header.Typeflag == tar.TypeGNULongLink
+}
+
+// copyMember writes header and the member's data from r into tw, through
+// MemberTransform when it is set
+func copyMember(tw *tar.Writer, header *tar.Header, r io.Reader, opts Options, t *tally) error {
+	if target, ok := opts.dedupe.link(header.Name); ok && header.Typeflag == tar.TypeReg {
+		//Its data is left unread, but still counts as done
+		t.progress.Done += header.Size
+		header = dedupeHeader(header, target)
+	}
+	header, r, transformed, err := transformMember(header, r, opts)
+	if err != nil {
+		return err
+	}
+	if opts.Format != tar.FormatUnknown {
+		header.Format = opts.Format
+		if opts.Format != tar.FormatPAX {
+			dropFieldRecords(header)
+		}
+	}
+	rewriteHeader(header, opts, t)
+	t.startMember(header.Name)
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("Could not write header for %s as %v, got error %s", header.Name, header.Format, err.Error())
+	}
+	//Never copy more than the header declared, and notice when the body is
+	//shorter, so a malformed member can't leave a corrupt shard behind
+	n, err := io.CopyBuffer(tw, &progressReader{io.LimitReader(r, header.Size), t}, t.buf)
+	if err != nil {
+		return fmt.Errorf("Could not copy %s after %v of %v bytes, got error %w", header.Name, n, header.Size, err)
+	}
+	if n != header.Size {
+		return fmt.Errorf("Member %s declares %v bytes but only %v could be read, the source may be truncated", header.Name, header.Size, n)
+	}
+	if transformed {
+		return checkTransformed(header, r)
+	}
+	return nil
+}
+
+// fieldRecords are the PAX records archive/tar reads into the header's own
+// fields, and writes back from them in whatever format is asked for
+var fieldRecords = []string{"path", "linkpath", "size", "uid", "gid", "uname", "gname", "mtime", "atime", "ctime"}
+
+// dropFieldRecords removes the records of header its fields already hold, so
+// a member read from a PAX tar, like one with a long name, can be written as
+// GNU or USTAR when its fields fit. Any other record still keeps it PAX
+func dropFieldRecords(header *tar.Header) {
+	for _, key := range fieldRecords {
+		delete(header.PAXRecords, key)
+	}
+}
+
+// rewriteHeader changes header as opts asks before it is written to a shard,
+// noting any times clamped in t
+func rewriteHeader(header *tar.Header, opts Options, t *tally) {
+	if !opts.ModTime.IsZero() {
+		header.ModTime = opts.ModTime
+		//Only times the source recorded, so a ustar member stays ustar
+		if !header.AccessTime.IsZero() {
+			header.AccessTime = opts.ModTime
+		}
+		if !header.ChangeTime.IsZero() {
+			header.ChangeTime = opts.ModTime
+		}
+		for _, key := range []string{"mtime", "atime", "ctime"} {
+			delete(header.PAXRecords, key)
+		}
+	}
+	if opts.ClampMTime {
+		if from, ok := clampTimes(header); ok {
+			t.clamped = append(t.clamped, ClampedTime{Name: header.Name, From: from, To: header.ModTime})
+		}
+	}
+	rewriteOwner(header, opts)
+}
+
+// shard is one output tar being written
+type shard struct {
+	index int
+	// file is the shard's file, or with Options.Destination dest, and out
+	// whichever of the two it is written to, through sum when there is one
+	file *os.File
+	dest *destWriter
+	out  io.Writer
+	tw   *tar.Writer
+	// sum is the digest of what was written to file, when the digest is
+	// wanted, and marked its state as of the last mark
+	sum    hash.Hash
+	marked []byte
+	// members is how many members were planned for the shard, remaining how
+	// many of those are still to be written
+	members   int
+	remaining int
+	closed    bool
+	// planned and fill are the plan's member data and fill ratio
+	planned int64
+	fill    float64
+}
+
+// newShard creates the file for shard i of the source named fn, or starts it
+// in opts.Destination when that is set
+func newShard(i int, fn string, opts Options) (*shard, error) {
+	if opts.Destination != nil {
+		dest, err := createDestShard(i, fn, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &shard{index: i, dest: dest, out: dest}, nil
+	}
+	file, err := createShard(i, fn, opts)
+	if err != nil {
+		return nil, err
+	}
+	s := &shard{index: i, file: file, out: file}
+	//Hashed on the way out rather than read back once written
+	if opts.Naming == NameDigest || opts.Checksums != "" {
+		s.sum = sha256.New()
+		s.out = io.MultiWriter(file, s.sum)
+	}
+	return s, nil
+}
+
+// openShard creates the file for plan and starts its tar
+func openShard(plan Plan, fn string, sources []source, opts Options, t *tally) (*shard, error) {
+	s, err := newShard(plan.Index, fn, opts)
+	if err != nil {
+		return nil, err
+	}
+	s.tw = tar.NewWriter(s.out)
+	s.members, s.remaining = len(plan.Pool), len(plan.Pool)
+	s.planned, s.fill = plan.Size(), fillRatio(plan)
+	if opts.OnShardStart != nil {
+		opts.OnShardStart(plan.Index, s.planned)
+	}
+	if opts.GlobalRecords || len(opts.Labels) > 0 {
+		info := ShardInfo{Index: plan.Index, Total: opts.total, Source: fn, Labels: opts.Labels}
+		if opts.SourceHash {
+			info.SourceDigests = sourceDigests(sources)
+		}
+		if err := writeGlobalRecords(s.tw, info); err != nil {
+			s.abort()
+			return nil, err
+		}
+	}
+	if opts.EmbedIndex {
+		if err := writeIndex(s.tw, indexMembers(plan, t.keep, opts), opts); err != nil {
+			s.abort()
+			return nil, fmt.Errorf("Could not write index for tarball %v, got error %w", plan.Index, err)
+		}
+	}
+	return s, nil
+}
+
+// mark pads out the last member written and returns where the next starts
+func (s *shard) mark() (int64, error) {
+	if err := s.tw.Flush(); err != nil {
+		return 0, err
+	}
+	if s.sum != nil {
+		state, err := s.sum.(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return 0, err
+		}
+		s.marked = state
+	}
+	return s.file.Seek(0, io.SeekCurrent)
+}
+
+// rewind cuts the shard back to offset, from mark, dropping anything written
+// since from the file and its digest
+func (s *shard) rewind(offset int64) error {
+	if err := s.file.Truncate(offset); err != nil {
+		return fmt.Errorf("Could not cut failed member out of tarball %v, got error %w", s.index, err)
+	}
+	if _, err := s.file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("Could not cut failed member out of tarball %v, got error %w", s.index, err)
+	}
+	if s.sum != nil {
+		if err := s.sum.(encoding.BinaryUnmarshaler).UnmarshalBinary(s.marked); err != nil {
+			return fmt.Errorf("Could not cut failed member out of the digest of tarball %v, got error %w", s.index, err)
+		}
+	}
+	s.tw = tar.NewWriter(s.out)
+	return nil
+}
+
+// close finishes the shard, writing its trailer and flushing it to disk, and
+// reports it complete to the tally. The trailer tar.Writer writes is two zero
+// blocks and nothing more, so with RecordSize it is padded out from there
+func (s *shard) close(opts Options, t *tally) error {
+	if err := s.tw.Close(); err != nil {
+		return fmt.Errorf("Could not write trailer for tarball %v, got error %w", s.index, err)
+	}
+	if opts.RecordSize > 0 {
+		if err := s.pad(opts.RecordSize); err != nil {
+			return fmt.Errorf("Could not pad tarball %v to a whole record, got error %w", s.index, err)
+		}
+	}
+	return s.finish(opts, t)
+}
+
+// finish flushes the shard's file to disk, gives it its real name and reports
+// it complete to the tally
+func (s *shard) finish(opts Options, t *tally) error {
+	if s.dest != nil {
+		return s.finishDest(opts, t)
+	}
+	if err := withRetry(opts, s.file.Sync); err != nil {
+		return fmt.Errorf("Could not flush tarball %v, got error %w", s.index, err)
+	}
+	fi, err := s.file.Stat()
+	if err != nil {
+		return fmt.Errorf("Could not stat tarball %v, got error %w", s.index, err)
+	}
+	result := ShardResult{
+		Index:   s.index,
+		File:    strings.TrimSuffix(s.file.Name(), tmpSuffix),
+		Members: s.members,
+		Size:    fi.Size(),
+		Planned: s.planned,
+		Fill:    s.fill,
+	}
+	if s.sum != nil {
+		result.Digest = formatDigest(s.sum)
+	}
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("Could not close tarball %v, got error %w", s.index, err)
+	}
+	s.closed = true
+	if opts.Naming == NameDigest {
+		result.File = filepath.Join(filepath.Dir(result.File), digestName(result.Digest))
+	}
+	//Only now does the shard get its real name, so nothing watching for it
+	//ever sees it half written
+	err = withRetry(opts, func() error {
+		return os.Rename(s.file.Name(), result.File)
+	})
+	if err != nil {
+		os.Remove(s.file.Name())
+		return fmt.Errorf("Could not rename tarball %v to %s, got error %w", s.index, result.File, err)
+	}
+	t.finish(result)
+	return nil
+}
+
+// abort gives up on the shard after an error, closing and removing its
+// unfinished file
+func (s *shard) abort() {
+	if s.dest != nil {
+		//Nothing can be removed from a destination, so it is left unfinished
+		s.dest.w.Close()
+		return
+	}
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
+
+// pad writes zeros after the trailer up to a multiple of recordSize bytes
+func (s *shard) pad(recordSize int) error {
+	var end int64
+	if s.dest != nil {
+		end = s.dest.n
+	} else {
+		var err error
+		if end, err = s.file.Seek(0, io.SeekCurrent); err != nil {
+			return err
+		}
+	}
+	padding := (int64(recordSize) - end%int64(recordSize)) % int64(recordSize)
+	_, err := s.out.Write(make([]byte, padding))
+	return err
+}
+
+// readDigest computes the digest of everything left to read from r
+func readDigest(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return formatDigest(h), nil
+}
+
+// formatDigest writes out the SHA-256 digest in h as sha256:<hex>
+func formatDigest(h hash.Hash) string {
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// tmpSuffix marks a shard still being written, it is renamed without it once
+// complete
+const tmpSuffix = ".tmp"
+
+// createShard creates the file for shard i of the source named fn, under a
+// temporary name ending in tmpSuffix
+func createShard(i int, fn string, opts Options) (*os.File, error) {
+	path := filepath.Join(opts.outDir, shardName(i, fn, opts)) + tmpSuffix
+	var file *os.File
+	err := withRetry(opts, func() error {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		var err error
+		file, err = os.Create(path)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Could not create tarball file %s, got error %w", path, err)
+	}
+	return file, nil
+}
+
+// shardName is the file shard i of the source named fn is written to, relative
+// to where the shards go
+func shardName(i int, fn string, opts Options) string {
+	if opts.Layout == LayoutSubdir {
+		width := opts.IndexWidth
+		if width < subdirWidth {
+			width = subdirWidth
+		}
+		return filepath.Join(fmt.Sprintf("shard-%0*d", width, i), fn)
+	}
+	return fmt.Sprintf("%0*d-%s", opts.IndexWidth, i, fn)
+}
+
+// digestName is the file a shard with content digest is renamed to
+func digestName(digest string) string {
+	return digest + ".tar"
+}
+
+// withRetry runs op until it succeeds, fails with an error that is not worth
+// retrying, or runs out of attempts
+func withRetry(opts Options, op func() error) error {
+	backoff := opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= opts.Retries || !isRetryable(err) {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// isRetryable reports whether err looks transient, like a hiccup on a network
+// filesystem. Running out of space or lacking permission will not fix itself
+func isRetryable(err error) bool {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		switch errno {
+		case syscall.EAGAIN, syscall.EINTR, syscall.EBUSY, syscall.ETIMEDOUT:
+			return true
+		}
+		return false
+	}
+	var timeout interface{ Timeout() bool }
+	if errors.As(err, &timeout) {
+		return timeout.Timeout()
+	}
+	return false
+}
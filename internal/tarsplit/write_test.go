@@ -0,0 +1,802 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestTinyCopyBuffer(t *testing.T) {
+	var members []testMember
+	for i := 0; i < 20; i++ {
+		members = append(members, testMember{Name: fmt.Sprintf("f%02d", i), Body: strings.Repeat(fmt.Sprint(i), 100*i+1)})
+	}
+	data := makeTar(t, members...)
+	for _, size := range []int{1, 7, 512} {
+		for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+			t.Run(fmt.Sprintf("%v/%s", size, name), func(t *testing.T) {
+				opts := testOptions(t)
+				opts.TargetSize = 4096
+				opts.CopyBuffer = size
+				opts.Strategy = strategy
+				result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				entries := readShards(t, result)
+				for _, member := range members {
+					if entries[member.Name].Body != member.Body {
+						t.Errorf("Expected %s copied whole, got %v of %v bytes", member.Name, len(entries[member.Name].Body), len(member.Body))
+					}
+				}
+			})
+		}
+	}
+}
+
+// trackingDest is a Destination counting how many of its shards are open at
+// once, failing the shard numbered failCreate, from one, to be created or
+// failWrite to be written
+type trackingDest struct {
+	created, open, maxOpen int
+	failCreate, failWrite  int
+}
+
+func (d *trackingDest) Create(name string) (io.WriteCloser, error) {
+	d.created++
+	if d.created == d.failCreate {
+		return nil, errors.New("tracked failure")
+	}
+	d.open++
+	if d.open > d.maxOpen {
+		d.maxOpen = d.open
+	}
+	return &trackedShard{dest: d, fail: d.created == d.failWrite}, nil
+}
+
+// trackedShard is a shard created by a trackingDest
+type trackedShard struct {
+	dest   *trackingDest
+	fail   bool
+	closed bool
+}
+
+func (s *trackedShard) Write(b []byte) (int, error) {
+	if s.fail {
+		return 0, errors.New("tracked failure")
+	}
+	return len(b), nil
+}
+
+func (s *trackedShard) Close() error {
+	if s.closed {
+		return errors.New("shard closed twice")
+	}
+	s.closed = true
+	s.dest.open--
+	return nil
+}
+
+func TestShardsClosed(t *testing.T) {
+	var members []testMember
+	for i := 0; i < 20; i++ {
+		members = append(members, testMember{Name: fmt.Sprintf("f%02d", i), Body: strings.Repeat("x", 1000)})
+	}
+	data := makeTar(t, members...)
+	for _, test := range []struct {
+		name                  string
+		strategy              Strategy
+		failCreate, failWrite int
+		maxOpen               int
+	}{
+		{name: "single-pass", strategy: StrategySinglePass, maxOpen: 5},
+		{name: "two-pass", strategy: StrategyTwoPass, maxOpen: 1},
+		{name: "single-pass create fails", strategy: StrategySinglePass, failCreate: 3, maxOpen: 5},
+		{name: "two-pass create fails", strategy: StrategyTwoPass, failCreate: 3, maxOpen: 1},
+		{name: "single-pass write fails", strategy: StrategySinglePass, failWrite: 3, maxOpen: 5},
+		{name: "two-pass write fails", strategy: StrategyTwoPass, failWrite: 3, maxOpen: 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dest := &trackingDest{failCreate: test.failCreate, failWrite: test.failWrite}
+			opts := testOptions(t)
+			opts.TargetSize = 4096
+			opts.Strategy = test.strategy
+			opts.Destination = dest
+			_, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+			if fails := test.failCreate+test.failWrite > 0; fails != (err != nil) {
+				t.Fatalf("Expected failure %v, got error %v", fails, err)
+			}
+			if dest.open != 0 {
+				t.Errorf("Expected every shard closed, got %v still open", dest.open)
+			}
+			if dest.maxOpen > test.maxOpen {
+				t.Errorf("Expected at most %v shards open at once, got %v", test.maxOpen, dest.maxOpen)
+			}
+		})
+	}
+}
+
+func TestDirectoryOnlyShard(t *testing.T) {
+	data := makeTar(t,
+		testMember{Name: "a/", Typeflag: tar.TypeDir},
+		testMember{Name: "a/b/", Typeflag: tar.TypeDir},
+		testMember{Name: "c/", Typeflag: tar.TypeDir},
+	)
+	for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+		t.Run(name, func(t *testing.T) {
+			opts := testOptions(t)
+			opts.Strategy = strategy
+			result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Shards) != 1 {
+				t.Fatalf("Expected one shard, got %v", len(result.Shards))
+			}
+			shard, err := os.ReadFile(result.Shards[0].File)
+			if err != nil {
+				t.Fatal(err)
+			}
+			//Only the trailer, two zero blocks
+			if !bytes.Equal(shard, make([]byte, 2*blockSize)) {
+				t.Errorf("Expected the shard to be a bare trailer, got %v bytes", len(shard))
+			}
+			if entries := readTar(t, result.Shards[0].File); len(entries) != 0 {
+				t.Errorf("Expected no entries in the shard, got %v", len(entries))
+			}
+			if skipped := result.Skipped[tar.TypeDir]; skipped != 3 {
+				t.Errorf("Expected 3 directories skipped, got %v", skipped)
+			}
+		})
+	}
+}
+
+// timeoutError is an error that says it timed out, like a net.Error
+type timeoutError struct{}
+
+func (timeoutError) Error() string { return "i/o timeout" }
+func (timeoutError) Timeout() bool { return true }
+
+func TestWithRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		failures int
+		attempts int
+		fails    bool
+	}{
+		{"succeeds first time", nil, 0, 1, false},
+		{"transient then succeeds", syscall.EAGAIN, 2, 3, false},
+		{"wrapped transient", &os.PathError{Op: "open", Path: "0-in.tar", Err: syscall.EINTR}, 3, 4, false},
+		{"timeout", timeoutError{}, 1, 2, false},
+		{"runs out of retries", syscall.EBUSY, 10, 4, true},
+		{"no space", syscall.ENOSPC, 1, 1, true},
+		{"no permission", &os.PathError{Op: "open", Path: "0-in.tar", Err: syscall.EACCES}, 1, 1, true},
+		{"other error", errors.New("broken"), 1, 1, true},
+	}
+	for _, test := range tests {
+		attempts := 0
+		err := withRetry(Options{Retries: 3, RetryBackoff: time.Nanosecond}, func() error {
+			attempts++
+			if attempts <= test.failures {
+				return test.err
+			}
+			return nil
+		})
+		if (err != nil) != test.fails {
+			t.Errorf("%s: expected failure %v, got error %v", test.name, test.fails, err)
+		}
+		if attempts != test.attempts {
+			t.Errorf("%s: expected %v attempts, got %v", test.name, test.attempts, attempts)
+		}
+	}
+}
+
+func TestTarFormat(t *testing.T) {
+	long := strings.Repeat("long", 30)
+	tests := []struct {
+		name   string
+		format tar.Format
+		member string
+		want   tar.Format
+		err    string
+	}{
+		{"ustar", tar.FormatUSTAR, "a/b", tar.FormatUSTAR, ""},
+		{"gnu", tar.FormatGNU, "a/b", tar.FormatGNU, ""},
+		//A PAX header needing no records reads back as USTAR
+		{"pax", tar.FormatPAX, "a/b", tar.FormatUSTAR, ""},
+		{"pax long name", tar.FormatPAX, long, tar.FormatPAX, ""},
+		{"gnu long name", tar.FormatGNU, long, tar.FormatGNU, ""},
+		{"ustar long name", tar.FormatUSTAR, long, 0, "Could not write header for " + long + " as USTAR"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := testOptions(t)
+			opts.Format = test.format
+			data := makeTar(t, testMember{Name: test.member, Body: "body"})
+			result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("Expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			entry := readShards(t, result)[test.member]
+			if entry.Header == nil {
+				t.Fatalf("Expected %s in the shards", test.member)
+			}
+			if entry.Format != test.want {
+				t.Errorf("Expected %s written as %v, got %v", test.member, test.want, entry.Format)
+			}
+		})
+	}
+}
+
+func TestOrderName(t *testing.T) {
+	var members []testMember
+	for _, name := range []string{"m", "c", "x", "a", "q", "f", "z", "b", "k", "d"} {
+		members = append(members, testMember{Name: name, Body: strings.Repeat(name, 700*(1+len(members)%3))})
+	}
+	data := makeTar(t, members...)
+	//The shards by index, as single-pass may finish them in any order
+	shardNames := func(result *Result) map[int][]string {
+		names := make(map[int][]string)
+		for _, shard := range result.Shards {
+			for _, entry := range readTar(t, shard.File) {
+				names[shard.Index] = append(names[shard.Index], entry.Name)
+			}
+		}
+		return names
+	}
+	dir := t.TempDir()
+	//A gzipped source is decompressed to be read out of order
+	for name, source := range map[string]string{"tar": writeFile(t, dir, "in.tar", data), "gzipped": writeFile(t, dir, "in.tar.gz", gzipBytes(t, data))} {
+		t.Run(name, func(t *testing.T) {
+			opts := testOptions(t)
+			opts.TargetSize = 3000
+			bySource, err := Split(source, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts = testOptions(t)
+			opts.TargetSize = 3000
+			opts.Order = OrderName
+			byName, err := Split(source, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, got := shardNames(bySource), shardNames(byName)
+			if len(got) < 2 || len(got) != len(want) {
+				t.Fatalf("Expected the same %v shards either way, got %v", len(want), len(got))
+			}
+			for i := range want {
+				if !sort.StringsAreSorted(got[i]) {
+					t.Errorf("Expected shard %v in name order, got %v", i, got[i])
+				}
+				sorted := append([]string(nil), want[i]...)
+				sort.Strings(sorted)
+				if strings.Join(got[i], ",") != strings.Join(sorted, ",") {
+					t.Errorf("Expected shard %v to hold %v, got %v", i, sorted, got[i])
+				}
+			}
+		})
+	}
+	opts := testOptions(t)
+	opts.Order = OrderName
+	opts.Strategy = StrategySinglePass
+	if _, err := SplitReader(bytes.NewReader(data), "in.tar", opts); err == nil {
+		t.Error("Expected sorting by name to need the two-pass strategy")
+	}
+}
+
+func TestCopyMemberSizeMismatch(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		err  string
+	}{
+		{"exact", strings.Repeat("a", 2000), ""},
+		{"short", strings.Repeat("a", 100), "Member short declares 2000 bytes but only 100 could be read"},
+		//The tar writer would fail on the extra bytes if they were copied
+		{"long", strings.Repeat("a", 3000), ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := testOptions(t)
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			header := &tar.Header{Name: test.name, Typeflag: tar.TypeReg, Size: 2000, Mode: 0644}
+			err := copyMember(tw, header, strings.NewReader(test.body), opts, newTally(nil, opts))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("Expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			tr := tar.NewReader(&buf)
+			if _, err := tr.Next(); err != nil {
+				t.Fatal(err)
+			}
+			if body, err := io.ReadAll(tr); err != nil || len(body) != 2000 {
+				t.Errorf("Expected the 2000 bytes declared, got %v and error %v", len(body), err)
+			}
+		})
+	}
+}
+
+func TestTruncatedMember(t *testing.T) {
+	data := makeTar(t, testMember{Name: "a", Body: "a"}, testMember{Name: "short", Body: strings.Repeat("s", 2000)})
+	//The body of short stops 100 bytes in
+	data = data[:3*blockSize+100]
+	for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+		t.Run(name, func(t *testing.T) {
+			opts := testOptions(t)
+			opts.Strategy = strategy
+			_, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+			if !errors.Is(err, io.ErrUnexpectedEOF) || !strings.Contains(err.Error(), "short") {
+				t.Fatalf("Expected an unexpected EOF naming short, got %v", err)
+			}
+			if entries, _ := os.ReadDir(opts.outDir); len(entries) > 0 {
+				t.Errorf("Expected no shards written, got %v files", len(entries))
+			}
+		})
+	}
+}
+
+// failingReaderAt fails reads reaching into the bytes from bad up to good, as
+// if that part of the source were lost after it was scanned
+type failingReaderAt struct {
+	*bytes.Reader
+	bad, good int64
+}
+
+func (r *failingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) <= r.bad || off >= r.good {
+		return r.Reader.ReadAt(p, off)
+	}
+	if off >= r.bad {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n, _ := r.Reader.ReadAt(p[:r.bad-off], off)
+	return n, io.ErrUnexpectedEOF
+}
+
+func TestSkipErrors(t *testing.T) {
+	members := []testMember{{Name: "a", Body: "first"}, {Name: "b", Body: strings.Repeat("b", 3000)}, {Name: "c", Body: "last"}}
+	data := makeTar(t, members...)
+	//From partway into the body of b to the header of c
+	source := &failingReaderAt{Reader: bytes.NewReader(data), bad: 3*blockSize + 1000, good: 9 * blockSize}
+	opts := testOptions(t)
+	opts.Strategy = StrategyTwoPass
+	if _, err := SplitReader(source, "in.tar", opts); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Expected the split to fail on b without skipping errors, got %v", err)
+	}
+
+	opts = testOptions(t)
+	opts.Strategy = StrategyTwoPass
+	opts.SkipErrors = true
+	result, err := SplitReader(source, "in.tar", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Failed) != 1 || result.Failed[0].Name != "b" || !errors.Is(result.Failed[0].Err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected only b to fail, got %v", result.Failed)
+	}
+	entries := readShards(t, result)
+	if _, ok := entries["b"]; ok {
+		t.Error("Expected no part of b left in the shards")
+	}
+	if entries["a"].Body != "first" || entries["c"].Body != "last" {
+		t.Errorf("Expected the members either side of b copied, got %v", entries)
+	}
+}
+
+func TestDeviceNumbers(t *testing.T) {
+	data := makeTar(t,
+		testMember{Name: "dev/null", Typeflag: tar.TypeChar, Devmajor: 1, Devminor: 3},
+		testMember{Name: "dev/sda1", Typeflag: tar.TypeBlock, Devmajor: 8, Devminor: 1},
+		testMember{Name: "file", Body: "data"},
+	)
+	for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+		t.Run(name, func(t *testing.T) {
+			opts := testOptions(t)
+			opts.Strategy = strategy
+			result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Skipped) > 0 {
+				t.Errorf("Expected device nodes carried over, got %v", result.Skipped)
+			}
+			entries := readShards(t, result)
+			for _, want := range []struct {
+				name               string
+				typeflag           byte
+				devmajor, devminor int64
+			}{{"dev/null", tar.TypeChar, 1, 3}, {"dev/sda1", tar.TypeBlock, 8, 1}} {
+				got, ok := entries[want.name]
+				if !ok {
+					t.Errorf("Expected %s in the shards", want.name)
+					continue
+				}
+				if got.Typeflag != want.typeflag || got.Devmajor != want.devmajor || got.Devminor != want.devminor {
+					t.Errorf("Expected %s to be %c %v:%v, got %c %v:%v", want.name, want.typeflag, want.devmajor, want.devminor, got.Typeflag, got.Devmajor, got.Devminor)
+				}
+			}
+		})
+	}
+}
+
+func TestNameDigest(t *testing.T) {
+	opts := testOptions(t)
+	opts.TargetSize = 4096
+	opts.Naming = NameDigest
+	opts.Manifest = filepath.Join(t.TempDir(), "manifest.json")
+	result, err := SplitReader(bytes.NewReader(genTar(t, 8, 1000)), "in.tar", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Shards) < 2 {
+		t.Fatalf("Expected several shards, got %v", len(result.Shards))
+	}
+	files := make(map[string]string)
+	for _, shard := range result.Shards {
+		data, err := os.ReadFile(shard.File)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+		if shard.Digest != digest {
+			t.Errorf("Expected shard %v to have digest %s, got %s", shard.Index, digest, shard.Digest)
+		}
+		if want := digest + ".tar"; filepath.Base(shard.File) != want {
+			t.Errorf("Expected shard %v named %s, got %s", shard.Index, want, filepath.Base(shard.File))
+		}
+		files[filepath.Base(shard.File)] = digest
+	}
+	manifest, err := ReadManifest(opts.Manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, shard := range manifest.Shards {
+		if digest, ok := files[filepath.Base(shard.File)]; !ok || shard.Digest != digest {
+			t.Errorf("Expected the manifest to map %s to its digest %s, got %s", shard.File, digest, shard.Digest)
+		}
+	}
+}
+
+func TestRecordSize(t *testing.T) {
+	data := genTar(t, 9, 1000)
+	for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+		for _, record := range []int{0, blockSize, 10240} {
+			opts := testOptions(t)
+			opts.TargetSize = 4096
+			opts.Strategy = strategy
+			opts.RecordSize = record
+			result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Shards) < 2 {
+				t.Fatalf("Expected several shards, got %v", len(result.Shards))
+			}
+			for _, shard := range result.Shards {
+				want := record
+				if want == 0 {
+					want = blockSize
+				}
+				if shard.Size%int64(want) != 0 {
+					t.Errorf("Expected %s shard %v padded to a multiple of %v, got %v bytes", name, shard.Index, want, shard.Size)
+				}
+				//The padding is more zeros after the trailer, still a valid tar
+				if entries := readTar(t, shard.File); len(entries) != shard.Members {
+					t.Errorf("Expected %s shard %v to read back %v members, got %v", name, shard.Index, shard.Members, len(entries))
+				}
+			}
+		}
+	}
+	opts := testOptions(t)
+	opts.RecordSize = 1000
+	if _, err := SplitReader(bytes.NewReader(data), "in.tar", opts); err == nil {
+		t.Error("Expected a record size that isn't a multiple of the block size to be an error")
+	}
+}
+
+func TestIndexStartAndWidth(t *testing.T) {
+	data := genTar(t, 6, 1000)
+	for _, test := range []struct {
+		start, width int
+		want         []string
+	}{
+		{0, 0, []string{"0-in.tar", "1-in.tar", "2-in.tar"}},
+		{1, 4, []string{"0001-in.tar", "0002-in.tar", "0003-in.tar"}},
+		//A width too narrow for the index is no limit
+		{98, 1, []string{"98-in.tar", "99-in.tar", "100-in.tar"}},
+	} {
+		opts := testOptions(t)
+		opts.TargetSize = 2048
+		opts.IndexStart = test.start
+		opts.IndexWidth = test.width
+		result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, shard := range result.Shards {
+			got = append(got, filepath.Base(shard.File))
+		}
+		sort.Slice(got, func(i, j int) bool {
+			return len(got[i]) < len(got[j]) || len(got[i]) == len(got[j]) && got[i] < got[j]
+		})
+		if strings.Join(got, " ") != strings.Join(test.want, " ") {
+			t.Errorf("Expected start %v and width %v to name the shards %v, got %v", test.start, test.width, test.want, got)
+		}
+	}
+	opts := testOptions(t)
+	opts.IndexStart = -1
+	if _, err := SplitReader(bytes.NewReader(data), "in.tar", opts); err == nil {
+		t.Error("Expected a negative index start to be an error")
+	}
+}
+
+func TestModTime(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i, header := range []*tar.Header{
+		{Name: "ustar", ModTime: time.Unix(1000, 0)},
+		{Name: "pax", ModTime: time.Unix(2000, 500), AccessTime: time.Unix(3000, 0), ChangeTime: time.Unix(4000, 0), Format: tar.FormatPAX},
+		{Name: "gnu", ModTime: time.Unix(5000, 0), AccessTime: time.Unix(6000, 0), Format: tar.FormatGNU},
+	} {
+		header.Typeflag, header.Size, header.Mode = tar.TypeReg, int64(1000*(i+1)), 0644
+		tw.WriteHeader(header)
+		tw.Write(make([]byte, header.Size))
+	}
+	tw.Close()
+	epoch := time.Unix(1700000000, 0)
+	var shards []map[int][]byte
+	for _, strategy := range []Strategy{StrategySinglePass, StrategyTwoPass} {
+		opts := testOptions(t)
+		opts.TargetSize = 2048
+		opts.Strategy = strategy
+		opts.ModTime = epoch
+		result, err := SplitReader(bytes.NewReader(buf.Bytes()), "in.tar", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, entry := range readShards(t, result) {
+			if !entry.ModTime.Equal(epoch) {
+				t.Errorf("Expected %s modified at %v, got %v", name, epoch, entry.ModTime)
+			}
+			for kind, got := range map[string]time.Time{"access": entry.AccessTime, "change": entry.ChangeTime} {
+				if !got.IsZero() && !got.Equal(epoch) {
+					t.Errorf("Expected the %s time of %s at %v, got %v", kind, name, epoch, got)
+				}
+			}
+			if name == "ustar" && entry.Format != tar.FormatUSTAR {
+				t.Errorf("Expected ustar to stay USTAR, got %v", entry.Format)
+			}
+		}
+		written := make(map[int][]byte)
+		for _, shard := range result.Shards {
+			if written[shard.Index], err = os.ReadFile(shard.File); err != nil {
+				t.Fatal(err)
+			}
+		}
+		shards = append(shards, written)
+	}
+	//Either strategy writes the same shards
+	for i, data := range shards[0] {
+		if !bytes.Equal(data, shards[1][i]) {
+			t.Errorf("Expected shard %v the same whichever strategy wrote it", i)
+		}
+	}
+}
+
+func TestShardHooks(t *testing.T) {
+	data := genTar(t, 40, 1000)
+	for name, strategy := range map[string]Strategy{"single-pass": StrategySinglePass, "two-pass": StrategyTwoPass} {
+		opts := testOptions(t)
+		opts.TargetSize = 8192
+		opts.Strategy = strategy
+		var started []int
+		planned := make(map[int]int64)
+		var finished []ShardResult
+		opts.OnShardStart = func(index int, size int64) {
+			started = append(started, index)
+			planned[index] = size
+		}
+		opts.OnShardFinish = func(shard ShardResult) {
+			if _, ok := planned[shard.Index]; !ok {
+				t.Errorf("%s: expected shard %v started before it finished", name, shard.Index)
+			}
+			info, err := os.Stat(shard.File)
+			if err != nil {
+				t.Errorf("%s: expected shard %v written when it finished, got error %v", name, shard.Index, err)
+			} else if info.Size() != shard.Size {
+				t.Errorf("%s: expected shard %v of %v bytes, got %v", name, shard.Index, info.Size(), shard.Size)
+			}
+			finished = append(finished, shard)
+		}
+		result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Shards) < 3 || len(started) != len(result.Shards) || len(finished) != len(result.Shards) {
+			t.Fatalf("%s: expected every one of %v shards started and finished, got %v and %v", name, len(result.Shards), len(started), len(finished))
+		}
+		for i := range started {
+			if started[i] != result.Shards[0].Index+i || finished[i].Index != started[i] {
+				t.Errorf("%s: expected the hooks to fire in index order, got starts %v", name, started)
+				break
+			}
+		}
+		for _, shard := range finished {
+			if planned[shard.Index] != shard.Planned {
+				t.Errorf("%s: expected shard %v started with the %v bytes it finished with, got %v", name, shard.Index, shard.Planned, planned[shard.Index])
+			}
+		}
+	}
+}
+
+func TestStrategies(t *testing.T) {
+	dir := t.TempDir()
+	plain := writeFile(t, dir, "plain.tar", genTar(t, 30, 700))
+	gzipped := writeFile(t, dir, "gzipped.tar.gz", gzipBytes(t, makeTar(t,
+		testMember{Name: "dir/", Typeflag: tar.TypeDir},
+		testMember{Name: "dir/big", Body: strings.Repeat("b", 5000)},
+		testMember{Name: "dir/small", Body: "small"},
+		testMember{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "dir/small"},
+	)))
+	for _, filenames := range [][]string{{plain}, {gzipped}, {plain, gzipped}} {
+		var shards []map[int][]byte
+		for _, strategy := range []Strategy{StrategyAuto, StrategySinglePass, StrategyTwoPass} {
+			opts := testOptions(t)
+			opts.TargetSize = 4096
+			opts.TmpDir = t.TempDir()
+			opts.Strategy = strategy
+			result, err := SplitAll(filenames, opts)
+			if err != nil {
+				t.Fatalf("%v with strategy %v: %v", filenames, strategy, err)
+			}
+			written := make(map[int][]byte)
+			for _, shard := range result.Shards {
+				if written[shard.Index], err = os.ReadFile(shard.File); err != nil {
+					t.Fatal(err)
+				}
+			}
+			shards = append(shards, written)
+		}
+		for _, other := range shards[1:] {
+			if len(other) != len(shards[0]) {
+				t.Errorf("Expected %v split into the same shards by every strategy, got %v and %v", filenames, len(shards[0]), len(other))
+				continue
+			}
+			for i, data := range shards[0] {
+				if !bytes.Equal(data, other[i]) {
+					t.Errorf("Expected shard %v of %v the same whichever strategy wrote it", i, filenames)
+				}
+			}
+		}
+	}
+}
+
+// streamOnly is a source that can be seeked but not read at an offset
+type streamOnly struct {
+	io.ReadSeeker
+}
+
+func TestChooseStrategy(t *testing.T) {
+	seekable := source{r: bytes.NewReader(nil)}
+	stream := source{r: streamOnly{bytes.NewReader(nil)}}
+	gzipped := source{r: bytes.NewReader(nil), gzipped: true}
+	two := []Plan{{Pool: NameAndSizes{{Name: "a"}}}, {Pool: NameAndSizes{{Name: "b", Offset: 512}}}}
+	one := two[:1]
+	unknown := []Plan{two[0], {Pool: NameAndSizes{{Name: "b", Offset: -1}}}}
+	for _, test := range []struct {
+		name     string
+		sources  []source
+		plans    []Plan
+		strategy Strategy
+		order    Order
+		want     Strategy
+	}{
+		{"seekable", []source{seekable}, two, StrategyAuto, OrderSource, StrategyTwoPass},
+		{"stream", []source{seekable, stream}, two, StrategyAuto, OrderSource, StrategySinglePass},
+		{"gzipped", []source{gzipped}, two, StrategyAuto, OrderSource, StrategySinglePass},
+		{"one shard", []source{seekable}, one, StrategyAuto, OrderSource, StrategySinglePass},
+		{"unknown offset", []source{seekable}, unknown, StrategyAuto, OrderSource, StrategySinglePass},
+		{"by name", []source{gzipped}, one, StrategyAuto, OrderName, StrategyTwoPass},
+		{"single-pass asked for", []source{seekable}, two, StrategySinglePass, OrderSource, StrategySinglePass},
+		{"two-pass asked for", []source{gzipped}, one, StrategyTwoPass, OrderSource, StrategyTwoPass},
+	} {
+		if got := chooseStrategy(test.sources, test.plans, Options{Strategy: test.strategy, Order: test.order}); got != test.want {
+			t.Errorf("%s: expected strategy %v, got %v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestShardsRenamedWhenComplete(t *testing.T) {
+	data := genTar(t, 20, 1000)
+	opts := testOptions(t)
+	opts.TargetSize = 4096
+	opts.Strategy = StrategySinglePass
+	opts.OnShardStart = func(index int, planned int64) {
+		final := filepath.Join(opts.outDir, shardName(index, "in.tar", opts))
+		if _, err := os.Stat(final); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected no %s while it is written, got error %v", final, err)
+		}
+		if _, err := os.Stat(final + tmpSuffix); err != nil {
+			t.Errorf("Expected %s written under a temporary name, got error %v", final, err)
+		}
+	}
+	opts.OnShardFinish = func(shard ShardResult) {
+		if strings.HasSuffix(shard.File, tmpSuffix) {
+			t.Errorf("Expected shard %v finished under its real name, got %s", shard.Index, shard.File)
+		}
+		if _, err := os.Stat(shard.File + tmpSuffix); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected the temporary file of shard %v gone once finished, got error %v", shard.Index, err)
+		}
+	}
+	result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := os.ReadDir(opts.outDir); len(files) != len(result.Shards) {
+		t.Errorf("Expected only the %v shards written, got %v files", len(result.Shards), len(files))
+	}
+
+	//Whole when planned, cut partway into the last member when copied
+	source := &changingSource{Reader: bytes.NewReader(data), after: int64(len(data)) - 1, other: data[:len(data)-3*blockSize]}
+	opts.outDir = t.TempDir()
+	opts.OnShardStart, opts.OnShardFinish = nil, nil
+	if _, err := SplitReader(source, "in.tar", opts); err == nil {
+		t.Fatal("Expected the split to fail on the cut member")
+	}
+	files, err := os.ReadDir(opts.outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//Shards whose members all came before the cut are complete, the one
+	//waiting on the cut member is never given its real name
+	if len(files) >= len(result.Shards) {
+		t.Errorf("Expected the shard holding the cut member missing, got %v files", len(files))
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), tmpSuffix) {
+			t.Errorf("Expected no half written shard left, got %s", file.Name())
+			continue
+		}
+		readTar(t, filepath.Join(opts.outDir, file.Name()))
+	}
+}
@@ -0,0 +1,58 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"sort"
+	"strings"
+)
+
+// xattrPrefix starts the names of the PAX records holding extended attributes,
+// system.posix_acl_access and system.posix_acl_default among them
+const xattrPrefix = "SCHILY.xattr."
+
+// xattrs lists the extended attributes header carries, sorted, or nil when it
+// has none
+func xattrs(header *tar.Header) []string {
+	var names []string
+	for key := range header.PAXRecords {
+		if strings.HasPrefix(key, xattrPrefix) {
+			names = append(names, strings.TrimPrefix(key, xattrPrefix))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkXattrs makes sure Format can hold the extended attributes of data
+func checkXattrs(data NameAndSizes, opts Options) error {
+	if opts.Format != tar.FormatUSTAR && opts.Format != tar.FormatGNU {
+		return nil
+	}
+	var err *XattrError
+	for _, member := range data {
+		if len(member.Xattrs) == 0 {
+			continue
+		}
+		if err == nil {
+			err = &XattrError{Name: member.Name, Xattrs: member.Xattrs, Format: opts.Format.String()}
+			continue
+		}
+		err.Others++
+	}
+	if err != nil {
+		return err
+	}
+	return nil
+}
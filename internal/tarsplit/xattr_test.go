@@ -0,0 +1,84 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarsplit
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestPreserveXattrs(t *testing.T) {
+	//A POSIX ACL as setfacl stores it: version 2, then user::rw- group::r-- other::r--
+	acl := string([]byte{2, 0, 0, 0, 1, 0, 6, 0, 0xff, 0xff, 0xff, 0xff, 4, 0, 4, 0, 0xff, 0xff, 0xff, 0xff, 0x20, 0, 4, 0, 0xff, 0xff, 0xff, 0xff})
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, header := range []*tar.Header{
+		{Name: "plain", Size: 5},
+		{Name: "acl", Size: 5, PAXRecords: map[string]string{"SCHILY.xattr.system.posix_acl_access": acl, "SCHILY.xattr.user.comment": "kept"}},
+		{Name: "caps", Size: 5, PAXRecords: map[string]string{"SCHILY.xattr.security.capability": "\x01\x00\x00\x02"}},
+	} {
+		header.Typeflag, header.Mode = tar.TypeReg, 0644
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte("12345"))
+	}
+	tw.Close()
+	data := buf.Bytes()
+
+	for _, format := range []tar.Format{tar.FormatUSTAR, tar.FormatGNU} {
+		opts := testOptions(t)
+		opts.Format = format
+		opts.PreserveXattrs = true
+		_, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+		var xattrErr *XattrError
+		if !errors.As(err, &xattrErr) || !errors.Is(err, ErrXattrsLost) {
+			t.Fatalf("Expected an XattrError as %v, got %v", format, err)
+		}
+		want := &XattrError{Name: "acl", Xattrs: []string{"system.posix_acl_access", "user.comment"}, Format: format.String(), Others: 1}
+		if !reflect.DeepEqual(xattrErr, want) {
+			t.Errorf("Expected %+v, got %+v", want, xattrErr)
+		}
+		if files, _ := os.ReadDir(opts.outDir); len(files) != 0 {
+			t.Errorf("Expected nothing written as %v, got %v files", format, len(files))
+		}
+	}
+
+	for _, format := range []tar.Format{tar.FormatUnknown, tar.FormatPAX} {
+		opts := testOptions(t)
+		opts.Format = format
+		opts.PreserveXattrs = true
+		result, err := SplitReader(bytes.NewReader(data), "in.tar", opts)
+		if err != nil {
+			t.Fatalf("Expected the xattrs kept as %v, got error %v", format, err)
+		}
+		entries := readShards(t, result)
+		if got := entries["acl"].PAXRecords["SCHILY.xattr.system.posix_acl_access"]; got != acl {
+			t.Errorf("Expected the ACL kept as %v, got %q", format, got)
+		}
+		if got := entries["caps"].PAXRecords["SCHILY.xattr.security.capability"]; got != "\x01\x00\x00\x02" {
+			t.Errorf("Expected the capability kept as %v, got %q", format, got)
+		}
+	}
+
+	//Without the check the members carrying xattrs fail as they are copied
+	opts := testOptions(t)
+	opts.Format = tar.FormatUSTAR
+	if _, err := SplitReader(bytes.NewReader(data), "in.tar", opts); err == nil || errors.Is(err, ErrXattrsLost) {
+		t.Errorf("Expected copying as USTAR to fail on the xattrs, got %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarplan_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/CondeNast/resplit-tar/tarplan"
+	"sort"
+)
+
+func Example() {
+	members := tarplan.NameAndSizes{{Name: "a", Size: 10}, {Name: "b", Size: 5}, {Name: "c", Size: 20}}
+	sort.Sort(sort.Reverse(members))
+	plan := tarplan.Plan{Pool: members, Target: 1 << 20}
+	for _, member := range plan.Pool {
+		fmt.Println(member.Name, member.Size)
+	}
+	fmt.Println(plan.Size())
+	// Output:
+	// c 20
+	// a 10
+	// b 5
+	// 35
+}
+
+// A shard of a manifest read back with encoding/json
+func ExampleNameAndSizes() {
+	var shard struct {
+		Members tarplan.NameAndSizes `json:"members"`
+	}
+	manifest := `{"members": [{"name": "etc/hosts", "size": 120}, {"name": "bin/sh", "size": 1024}]}`
+	if err := json.Unmarshal([]byte(manifest), &shard); err != nil {
+		fmt.Println(err)
+		return
+	}
+	sort.Sort(shard.Members)
+	fmt.Println(shard.Members[0].Name, shard.Members[0].IsDir())
+	// Output: etc/hosts false
+}
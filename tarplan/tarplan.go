@@ -0,0 +1,82 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tarplan holds the types describing how the members of a tar are
+// planned into shards, for tools built on the planning output, like a
+// manifest or exported plan read back with encoding/json. NameAndSizes
+// implements sort.Interface, so members sort by size with the sort package
+package tarplan
+
+import (
+	"archive/tar"
+	"strings"
+)
+
+// NameAndSize is one member of a source tar
+type NameAndSize struct {
+	// Name is the member's path in the tar
+	Name string `json:"name"`
+	// Size is the member's data size, as its header declares
+	Size int64 `json:"size"`
+	// Offset is where the member's header starts in the uncompressed tar, or
+	// -1 when it can't be known
+	Offset int64 `json:"-"`
+	// Source is which of the sources split together the member is from
+	Source int `json:"-"`
+	// Typeflag is the kind of entry the member is
+	Typeflag byte `json:"-"`
+	// Linkname is what a hardlink or symlink member points to
+	Linkname string `json:"-"`
+	// Xattrs names the extended attributes the member carries in PAX
+	// records, POSIX ACLs among them
+	Xattrs []string `json:"-"`
+}
+
+// NameAndSizes is a list of members. It sorts by Size, smallest first
+type NameAndSizes []NameAndSize
+
+// IsDir reports whether the member is a directory, going by its name
+func (m NameAndSize) IsDir() bool {
+	return strings.HasSuffix(m.Name, "/")
+}
+
+func (s NameAndSizes) Len() int {
+	return len(s)
+}
+
+func (s NameAndSizes) Less(i, j int) bool {
+	return s[i].Size < s[j].Size
+}
+
+func (s NameAndSizes) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// Plan is the members planned into one shard
+type Plan struct {
+	Pool   NameAndSizes
+	Writer *tar.Writer
+	// Index numbers the shard the plan is written to
+	Index int
+	// Target is the size the plan was filled up to, 0 when it wasn't planned
+	// by size
+	Target int64
+}
+
+// Size is the member data planned into the shard
+func (p Plan) Size() int64 {
+	var size int64
+	for _, member := range p.Pool {
+		size += member.Size
+	}
+	return size
+}